@@ -24,11 +24,13 @@ import (
 	_ "github.com/wader/fq/format/flac"
 	_ "github.com/wader/fq/format/gif"
 	_ "github.com/wader/fq/format/gzip"
+	_ "github.com/wader/fq/format/http"
 	_ "github.com/wader/fq/format/icc"
 	_ "github.com/wader/fq/format/id3"
 	_ "github.com/wader/fq/format/inet"
 	_ "github.com/wader/fq/format/jpeg"
 	_ "github.com/wader/fq/format/json"
+	_ "github.com/wader/fq/format/lz4"
 	_ "github.com/wader/fq/format/markdown"
 	_ "github.com/wader/fq/format/math"
 	_ "github.com/wader/fq/format/matroska"
@@ -39,12 +41,14 @@ import (
 	_ "github.com/wader/fq/format/ogg"
 	_ "github.com/wader/fq/format/opus"
 	_ "github.com/wader/fq/format/pcap"
+	_ "github.com/wader/fq/format/pe"
 	_ "github.com/wader/fq/format/png"
 	_ "github.com/wader/fq/format/postgres"
 	_ "github.com/wader/fq/format/prores"
 	_ "github.com/wader/fq/format/protobuf"
 	_ "github.com/wader/fq/format/riff"
 	_ "github.com/wader/fq/format/rtmp"
+	_ "github.com/wader/fq/format/snappy"
 	_ "github.com/wader/fq/format/tar"
 	_ "github.com/wader/fq/format/text"
 	_ "github.com/wader/fq/format/tiff"
@@ -56,6 +60,8 @@ import (
 	_ "github.com/wader/fq/format/wasm"
 	_ "github.com/wader/fq/format/webp"
 	_ "github.com/wader/fq/format/xml"
+	_ "github.com/wader/fq/format/xz"
 	_ "github.com/wader/fq/format/yaml"
 	_ "github.com/wader/fq/format/zip"
+	_ "github.com/wader/fq/format/zstd"
 )
@@ -17,6 +17,9 @@ import (
 //go:embed macho.md
 var machoFS embed.FS
 
+var xmlGroup decode.Group
+var asn1BerGroup decode.Group
+
 func init() {
 	interp.RegisterFormat(
 		format.MachO,
@@ -24,6 +27,10 @@ func init() {
 			Description: "Mach-O macOS executable",
 			Groups:      []*decode.Group{format.Probe},
 			DecodeFn:    machoDecode,
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.XML}, Out: &xmlGroup},
+				{Groups: []*decode.Group{format.ASN1_BER}, Out: &asn1BerGroup},
+			},
 		})
 	interp.RegisterFS(machoFS)
 }
@@ -281,6 +288,8 @@ const (
 	LC_VERSION_MIN_WATCHOS      = 0x30
 	LC_NOTE                     = 0x31 // not implemented
 	LC_BUILD_VERSION            = 0x32
+	LC_DYLD_EXPORTS_TRIE        = 0x80000033
+	LC_DYLD_CHAINED_FIXUPS      = 0x80000034
 )
 
 var loadCommands = scalar.UintMapSymStr{
@@ -336,6 +345,8 @@ var loadCommands = scalar.UintMapSymStr{
 	LC_VERSION_MIN_WATCHOS:      "version_min_watchos",
 	LC_NOTE:                     "note",
 	LC_BUILD_VERSION:            "build_version",
+	LC_DYLD_EXPORTS_TRIE:        "dyld_exports_trie",
+	LC_DYLD_CHAINED_FIXUPS:      "dyld_chained_fixups",
 }
 
 var sectionTypes = scalar.UintMapSymStr{
@@ -403,6 +414,8 @@ func machoDecode(d *decode.D) any {
 		}
 	})
 	loadCommandsNext := d.Pos()
+	dylibs := machoDylibNames(d, ncmds)
+	var segments []machoSegment
 	d.FieldArray("load_commands", func(d *decode.D) {
 		for i := uint64(0); i < ncmds; i++ {
 			d.FieldStruct("load_command", func(d *decode.D) {
@@ -425,13 +438,14 @@ func machoDecode(d *decode.D) any {
 					LC_SEGMENT_64:
 					// nsect := (cmdsize - uint64(archBits)) / uint64(archBits)
 
+					var segName string
 					var vmaddr int64
 					var fileoff int64
 
 					var nsects uint64
 					d.FieldStruct("segment_command", func(d *decode.D) {
 						d.FieldValueSint("arch_bits", int64(archBits))
-						d.FieldUTF8NullFixedLen("segname", 16) // OPCODE_DECODER segname==__TEXT
+						segName = d.FieldUTF8NullFixedLen("segname", 16) // OPCODE_DECODER segname==__TEXT
 						if archBits == 32 {
 							vmaddr = int64(d.FieldU32("vmaddr", scalar.UintHex))
 							d.FieldU32("vmsize")
@@ -448,6 +462,7 @@ func machoDecode(d *decode.D) any {
 						nsects = d.FieldU32("nsects")
 						d.FieldStruct("flags", parseSegmentFlags)
 					})
+					segments = append(segments, machoSegment{name: segName, fileoff: fileoff})
 					d.FieldArray("sections", func(d *decode.D) {
 						for i := uint64(0); i < nsects; i++ {
 							d.FieldStruct("section", func(d *decode.D) {
@@ -685,10 +700,25 @@ func machoDecode(d *decode.D) any {
 					LC_FUNCTION_STARTS,
 					LC_DATA_IN_CODE,
 					LC_DYLIB_CODE_SIGN_DRS,
-					LC_LINKER_OPTIMIZATION_HINT:
+					LC_LINKER_OPTIMIZATION_HINT,
+					LC_DYLD_EXPORTS_TRIE,
+					LC_DYLD_CHAINED_FIXUPS:
 					d.FieldStruct("linkedit_data", func(d *decode.D) {
-						d.FieldU32("off")
-						d.FieldU32("size")
+						off := d.FieldU32("off")
+						size := d.FieldU32("size")
+						if size == 0 {
+							return
+						}
+						d.RangeFn(int64(off)*8, int64(size)*8, func(d *decode.D) {
+							switch cmd {
+							case LC_CODE_SIGNATURE:
+								d.FieldStruct("code_signature", codeSignatureDecode)
+							case LC_DYLD_EXPORTS_TRIE:
+								d.FieldStruct("exports_trie", func(d *decode.D) { exportsTrieDecode(d, dylibs) })
+							case LC_DYLD_CHAINED_FIXUPS:
+								d.FieldStruct("chained_fixups", func(d *decode.D) { chainedFixupsDecode(d, segments, dylibs) })
+							}
+						})
 					})
 				case LC_VERSION_MIN_IPHONEOS,
 					LC_VERSION_MIN_MACOSX,
@@ -707,8 +737,13 @@ func machoDecode(d *decode.D) any {
 						d.FieldU32("weak_bind_size")
 						d.FieldU32("lazy_bind_off", scalar.UintHex)
 						d.FieldU32("lazy_bind_size")
-						d.FieldU32("export_off", scalar.UintHex)
-						d.FieldU32("export_size")
+						exportOff := d.FieldU32("export_off", scalar.UintHex)
+						exportSize := d.FieldU32("export_size")
+						if exportSize > 0 {
+							d.RangeFn(int64(exportOff)*8, int64(exportSize)*8, func(d *decode.D) {
+								d.FieldStruct("exports_trie", func(d *decode.D) { exportsTrieDecode(d, dylibs) })
+							})
+						}
 					})
 				case LC_MAIN:
 					d.FieldStruct("entrypoint", func(d *decode.D) {
@@ -756,6 +791,36 @@ func machoDecode(d *decode.D) any {
 	return nil
 }
 
+// machoDylibNames returns names of libraries in load order, used to map library ordinals
+func machoDylibNames(d *decode.D, ncmds uint64) []string {
+	var dylibs []string
+	start := d.Pos()
+	pos := start
+	for i := uint64(0); i < ncmds; i++ {
+		d.SeekAbs(pos)
+		cmd := d.U32()
+		cmdSize := d.U32()
+		if cmdSize == 0 {
+			break
+		}
+		switch cmd {
+		case LC_LOAD_DYLIB,
+			LC_LOAD_WEAK_DYLIB,
+			LC_REEXPORT_DYLIB,
+			LC_LOAD_UPWARD_DYLIB,
+			LC_LAZY_LOAD_DYLIB:
+			offset := d.U32()
+			if offset < cmdSize {
+				bs := d.BytesRange(pos+int64(offset)*8, int(cmdSize-offset))
+				dylibs = append(dylibs, strIndexNull(0, string(bs)+"\x00"))
+			}
+		}
+		pos += int64(cmdSize) * 8
+	}
+	d.SeekAbs(start)
+	return dylibs
+}
+
 func parseMachHeaderFlags(d *decode.D) {
 	d.FieldRawLen("reserved", 6)
 	d.FieldBool("app_extension_safe")
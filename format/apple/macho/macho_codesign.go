@@ -0,0 +1,361 @@
+package macho
+
+// https://github.com/apple-oss-distributions/xnu/blob/main/osfmk/kern/cs_blobs.h
+// https://github.com/apple-oss-distributions/Security/blob/main/OSX/libsecurity_codesigning/lib/codedirectory.h
+
+import (
+	"crypto/sha1" //nolint:gosec // used for code directory hashes
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+const (
+	CSMAGIC_REQUIREMENT                = 0xfade_0c00
+	CSMAGIC_REQUIREMENTS               = 0xfade_0c01
+	CSMAGIC_CODEDIRECTORY              = 0xfade_0c02
+	CSMAGIC_EMBEDDED_SIGNATURE         = 0xfade_0cc0
+	CSMAGIC_EMBEDDED_SIGNATURE_OLD     = 0xfade_0b02
+	CSMAGIC_EMBEDDED_ENTITLEMENTS      = 0xfade_7171
+	CSMAGIC_EMBEDDED_DER_ENTITLEMENTS  = 0xfade_7172
+	CSMAGIC_DETACHED_SIGNATURE         = 0xfade_0cc1
+	CSMAGIC_BLOBWRAPPER                = 0xfade_0b01
+	CSMAGIC_EMBEDDED_LAUNCH_CONSTRAINT = 0xfade_8181
+)
+
+var csMagicNames = scalar.UintMapSymStr{
+	CSMAGIC_REQUIREMENT:                "requirement",
+	CSMAGIC_REQUIREMENTS:               "requirements",
+	CSMAGIC_CODEDIRECTORY:              "code_directory",
+	CSMAGIC_EMBEDDED_SIGNATURE:         "embedded_signature",
+	CSMAGIC_EMBEDDED_SIGNATURE_OLD:     "embedded_signature_old",
+	CSMAGIC_EMBEDDED_ENTITLEMENTS:      "embedded_entitlements",
+	CSMAGIC_EMBEDDED_DER_ENTITLEMENTS:  "embedded_der_entitlements",
+	CSMAGIC_DETACHED_SIGNATURE:         "detached_signature",
+	CSMAGIC_BLOBWRAPPER:                "blob_wrapper",
+	CSMAGIC_EMBEDDED_LAUNCH_CONSTRAINT: "embedded_launch_constraint",
+}
+
+const (
+	CSSLOT_CODEDIRECTORY = 0
+	CSSLOT_SIGNATURESLOT = 0x10000
+)
+
+var csSlotNames = scalar.UintMapSymStr{
+	CSSLOT_CODEDIRECTORY: "code_directory",
+	1:                    "info",
+	2:                    "requirements",
+	3:                    "resource_dir",
+	4:                    "application",
+	5:                    "entitlements",
+	6:                    "rep_specific",
+	7:                    "der_entitlements",
+	8:                    "launch_constraint_self",
+	9:                    "launch_constraint_parent",
+	10:                   "launch_constraint_responsible",
+	11:                   "library_constraint",
+	0x1000:               "alternate_code_directory_0",
+	0x1001:               "alternate_code_directory_1",
+	0x1002:               "alternate_code_directory_2",
+	0x1003:               "alternate_code_directory_3",
+	0x1004:               "alternate_code_directory_4",
+	CSSLOT_SIGNATURESLOT: "signature",
+	0x10001:              "identification",
+	0x10002:              "ticket",
+}
+
+var csRequirementTypeNames = scalar.UintMapSymStr{
+	1: "host",
+	2: "guest",
+	3: "designated",
+	4: "library",
+	5: "plugin",
+}
+
+var csRequirementKindNames = scalar.UintMapSymStr{
+	1: "expression",
+}
+
+const (
+	CS_HASHTYPE_SHA1             = 1
+	CS_HASHTYPE_SHA256           = 2
+	CS_HASHTYPE_SHA256_TRUNCATED = 3
+	CS_HASHTYPE_SHA384           = 4
+	CS_HASHTYPE_SHA512           = 5
+)
+
+var csHashTypeNames = scalar.UintMapSymStr{
+	CS_HASHTYPE_SHA1:             "sha1",
+	CS_HASHTYPE_SHA256:           "sha256",
+	CS_HASHTYPE_SHA256_TRUNCATED: "sha256_truncated",
+	CS_HASHTYPE_SHA384:           "sha384",
+	CS_HASHTYPE_SHA512:           "sha512",
+}
+
+// bit index to name, used for synthetic flag fields
+var csCodeDirectoryFlagNames = map[int]string{
+	0:  "valid",
+	1:  "adhoc",
+	2:  "get_task_allow",
+	3:  "installer",
+	4:  "forced_lv",
+	5:  "invalid_allowed",
+	8:  "hard",
+	9:  "kill",
+	10: "check_expiration",
+	11: "restrict",
+	12: "enforcement",
+	13: "require_lv",
+	14: "entitlements_validated",
+	15: "nvram_unrestricted",
+	16: "runtime",
+	17: "linker_signed",
+}
+
+var csExecSegFlagNames = map[int]string{
+	0: "main_binary",
+	4: "allow_unsigned",
+	5: "debugger",
+	6: "jit",
+	7: "skip_lv",
+	8: "can_load_cdhash",
+	9: "can_exec_cdhash",
+}
+
+// special slots are hashes of other blobs in the super blob, slot -n is the hash of blob type n
+var csSpecialSlotMapper = scalar.SintFn(func(s scalar.Sint) (scalar.Sint, error) {
+	if s.Actual < 0 {
+		if n, ok := csSlotNames[uint64(-s.Actual)]; ok {
+			s.Sym = n
+		}
+	}
+	return s, nil
+})
+
+func csHash(hashType uint64) hash.Hash {
+	switch hashType {
+	case CS_HASHTYPE_SHA1:
+		return sha1.New() //nolint:gosec // used for code directory hashes
+	case CS_HASHTYPE_SHA256, CS_HASHTYPE_SHA256_TRUNCATED:
+		return sha256.New()
+	case CS_HASHTYPE_SHA384:
+		return sha512.New384()
+	case CS_HASHTYPE_SHA512:
+		return sha512.New()
+	default:
+		return nil
+	}
+}
+
+func csFieldFlags(d *decode.D, name string, nBits int, names map[int]string) {
+	d.FieldStruct(name, func(d *decode.D) {
+		v := d.FieldU("value", nBits, scalar.UintHex)
+		for i := 0; i < nBits; i++ {
+			if n, ok := names[i]; ok {
+				d.FieldValueBool(n, v&(1<<i) != 0)
+			}
+		}
+	})
+}
+
+// length of blob or requirement at pos, length is after magic
+func csBlobLength(d *decode.D, pos int64) int64 {
+	return int64(binary.BigEndian.Uint32(d.BytesRange(pos+32, 4)))
+}
+
+// codeSignatureDecode decodes an embedded signature super blob, blobs are always big endian
+func codeSignatureDecode(d *decode.D) {
+	d.Endian = decode.BigEndian
+
+	sigStart := d.Pos()
+	type blobIndex struct {
+		typ    uint64
+		offset int64
+	}
+	var index []blobIndex
+
+	d.FieldU32("magic", csMagicNames, scalar.UintHex, d.UintAssert(CSMAGIC_EMBEDDED_SIGNATURE))
+	d.FieldU32("length")
+	count := d.FieldU32("count")
+	d.FieldArray("index", func(d *decode.D) {
+		for i := uint64(0); i < count; i++ {
+			d.FieldStruct("blob_index", func(d *decode.D) {
+				typ := d.FieldU32("type", csSlotNames, scalar.UintHex)
+				offset := d.FieldU32("offset", scalar.UintHex)
+				index = append(index, blobIndex{typ: typ, offset: int64(offset)})
+			})
+		}
+	})
+
+	// blob bytes by slot type, used to verify special slot hashes
+	blobs := map[uint64][]byte{}
+	for _, bi := range index {
+		blobStart := sigStart + bi.offset*8
+		if bs, err := d.TryBytesRange(blobStart, int(csBlobLength(d, blobStart))); err == nil {
+			blobs[bi.typ] = bs
+		}
+	}
+
+	d.FieldArray("blobs", func(d *decode.D) {
+		for _, bi := range index {
+			blobStart := sigStart + bi.offset*8
+			d.RangeFn(blobStart, csBlobLength(d, blobStart)*8, func(d *decode.D) {
+				d.FieldStruct("blob", func(d *decode.D) { codeSignatureBlobDecode(d, blobs) })
+			})
+		}
+	})
+}
+
+func codeSignatureBlobDecode(d *decode.D, blobs map[uint64][]byte) {
+	blobStart := d.Pos()
+	magic := d.FieldU32("magic", csMagicNames, scalar.UintHex)
+	length := d.FieldU32("length")
+	if length < 8 {
+		d.Fatalf("invalid blob length %d", length)
+	}
+	dataLen := int64(length-8) * 8
+
+	switch magic {
+	case CSMAGIC_CODEDIRECTORY:
+		codeDirectoryDecode(d, blobStart, blobs)
+	case CSMAGIC_REQUIREMENTS:
+		count := d.FieldU32("count")
+		var offsets []int64
+		d.FieldArray("index", func(d *decode.D) {
+			for i := uint64(0); i < count; i++ {
+				d.FieldStruct("requirement_index", func(d *decode.D) {
+					d.FieldU32("type", csRequirementTypeNames)
+					offsets = append(offsets, int64(d.FieldU32("offset", scalar.UintHex)))
+				})
+			}
+		})
+		d.FieldArray("requirements", func(d *decode.D) {
+			for _, offset := range offsets {
+				reqStart := blobStart + offset*8
+				d.RangeFn(reqStart, csBlobLength(d, reqStart)*8, func(d *decode.D) {
+					d.FieldStruct("requirement", func(d *decode.D) {
+						d.FieldU32("magic", csMagicNames, scalar.UintHex, d.UintAssert(CSMAGIC_REQUIREMENT))
+						d.FieldU32("length")
+						d.FieldU32("kind", csRequirementKindNames)
+						d.FieldRawLen("expression", d.BitsLeft())
+					})
+				})
+			}
+		})
+	case CSMAGIC_EMBEDDED_ENTITLEMENTS:
+		d.FieldFormatOrRawLen("entitlements", dataLen, &xmlGroup, nil)
+	case CSMAGIC_EMBEDDED_DER_ENTITLEMENTS:
+		d.FieldFormatOrRawLen("entitlements", dataLen, &asn1BerGroup, nil)
+	case CSMAGIC_BLOBWRAPPER:
+		// CMS signature, empty for ad-hoc signatures
+		if dataLen > 0 {
+			d.FieldFormatOrRawLen("signature", dataLen, &asn1BerGroup, nil)
+		}
+	default:
+		d.FieldRawLen("data", dataLen)
+	}
+}
+
+func codeDirectoryDecode(d *decode.D, blobStart int64, blobs map[uint64][]byte) {
+	version := d.FieldU32("version", scalar.UintHex)
+	csFieldFlags(d, "flags", 32, csCodeDirectoryFlagNames)
+	hashOffset := d.FieldU32("hash_offset", scalar.UintHex)
+	identOffset := d.FieldU32("ident_offset", scalar.UintHex)
+	nSpecialSlots := d.FieldU32("n_special_slots")
+	nCodeSlots := d.FieldU32("n_code_slots")
+	codeLimit := d.FieldU32("code_limit")
+	hashSize := d.FieldU8("hash_size")
+	hashType := d.FieldU8("hash_type", csHashTypeNames)
+	d.FieldU8("platform")
+	pageSizeLog2 := d.FieldU8("page_size", scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+		if s.Actual != 0 {
+			s.Description = "log2"
+		}
+		return s, nil
+	}))
+	d.FieldU32("spare2")
+	var teamOffset uint64
+	if version >= 0x20100 {
+		d.FieldU32("scatter_offset", scalar.UintHex)
+	}
+	if version >= 0x20200 {
+		teamOffset = d.FieldU32("team_offset", scalar.UintHex)
+	}
+	if version >= 0x20300 {
+		d.FieldU32("spare3")
+		if codeLimit64 := d.FieldU64("code_limit64"); codeLimit64 != 0 {
+			codeLimit = codeLimit64
+		}
+	}
+	if version >= 0x20400 {
+		d.FieldU64("exec_seg_base", scalar.UintHex)
+		d.FieldU64("exec_seg_limit")
+		csFieldFlags(d, "exec_seg_flags", 64, csExecSegFlagNames)
+	}
+	if version >= 0x20500 {
+		d.FieldU32("runtime", scalar.UintHex)
+		d.FieldU32("pre_encrypt_offset", scalar.UintHex)
+	}
+	if version >= 0x20600 {
+		d.FieldU8("linkage_hash_type", csHashTypeNames)
+		d.FieldU8("linkage_application_type")
+		d.FieldU16("linkage_application_sub_type")
+		d.FieldU32("linkage_offset", scalar.UintHex)
+		d.FieldU32("linkage_size")
+	}
+
+	d.SeekAbs(blobStart + int64(identOffset)*8)
+	d.FieldUTF8Null("identifier")
+	if teamOffset != 0 {
+		d.SeekAbs(blobStart + int64(teamOffset)*8)
+		d.FieldUTF8Null("team_identifier")
+	}
+
+	h := csHash(hashType)
+	if h != nil && int(hashSize) > h.Size() {
+		d.FieldValueStr("hash_error", fmt.Sprintf("hash_size %d larger than digest size %d", hashSize, h.Size()))
+		h = nil
+	}
+	slotHash := func(bs []byte) []byte {
+		h.Reset()
+		h.Write(bs)
+		return h.Sum(nil)[0:hashSize]
+	}
+
+	d.SeekAbs(blobStart + (int64(hashOffset)-int64(nSpecialSlots*hashSize))*8)
+	d.FieldArray("special_slots", func(d *decode.D) {
+		for i := -int64(nSpecialSlots); i < 0; i++ {
+			d.FieldStruct("slot", func(d *decode.D) {
+				d.FieldValueSint("index", i, csSpecialSlotMapper)
+				bs, ok := blobs[uint64(-i)]
+				if h != nil && ok {
+					d.FieldRawLen("hash", int64(hashSize)*8, d.ValidateBitBuf(slotHash(bs)), scalar.RawHex)
+				} else {
+					d.FieldRawLen("hash", int64(hashSize)*8, scalar.RawHex)
+				}
+			})
+		}
+	})
+
+	pageSize := int64(1) << pageSizeLog2
+	d.FieldArray("code_slots", func(d *decode.D) {
+		for i := int64(0); i < int64(nCodeSlots); i++ {
+			pageStart := i * pageSize
+			pageLen := pageSize
+			if pageSizeLog2 == 0 || pageStart+pageLen > int64(codeLimit) {
+				pageLen = int64(codeLimit) - pageStart
+			}
+			bs, err := d.TryBytesRange(pageStart*8, int(pageLen))
+			if h != nil && err == nil {
+				d.FieldRawLen("hash", int64(hashSize)*8, d.ValidateBitBuf(slotHash(bs)), scalar.RawHex)
+			} else {
+				d.FieldRawLen("hash", int64(hashSize)*8, scalar.RawHex)
+			}
+		}
+	})
+}
@@ -0,0 +1,496 @@
+package macho
+
+// https://github.com/apple-oss-distributions/dyld/blob/main/include/mach-o/fixup-chains.h
+// https://github.com/apple-oss-distributions/dyld/blob/main/common/MachOLayout.cpp
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+type machoSegment struct {
+	name    string
+	fileoff int64
+}
+
+// ordinal 0 is the image itself, negative ordinals are special lookups
+func libraryOrdinalSym(dylibs []string, ordinal int64) string {
+	switch {
+	case ordinal == 0:
+		return "self"
+	case ordinal == -1:
+		return "main_executable"
+	case ordinal == -2:
+		return "flat_lookup"
+	case ordinal == -3:
+		return "weak_lookup"
+	case ordinal > 0 && int(ordinal) <= len(dylibs):
+		return dylibs[ordinal-1]
+	default:
+		return ""
+	}
+}
+
+func librarySintOrdinalMapper(dylibs []string) scalar.SintFn {
+	return scalar.SintFn(func(s scalar.Sint) (scalar.Sint, error) {
+		s.Sym = libraryOrdinalSym(dylibs, s.Actual)
+		return s, nil
+	})
+}
+
+func libraryUintOrdinalMapper(dylibs []string) scalar.UintFn {
+	return scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+		s.Sym = libraryOrdinalSym(dylibs, int64(s.Actual))
+		return s, nil
+	})
+}
+
+const (
+	EXPORT_SYMBOL_FLAGS_KIND_MASK         = 0x03
+	EXPORT_SYMBOL_FLAGS_WEAK_DEFINITION   = 0x04
+	EXPORT_SYMBOL_FLAGS_REEXPORT          = 0x08
+	EXPORT_SYMBOL_FLAGS_STUB_AND_RESOLVER = 0x10
+	EXPORT_SYMBOL_FLAGS_STATIC_RESOLVER   = 0x20
+)
+
+var exportSymbolKindNames = scalar.UintMapSymStr{
+	0: "regular",
+	1: "thread_local",
+	2: "absolute",
+}
+
+// exportsTrieDecode decodes export trie nodes depth first, terminal nodes have a symbol
+// field with the full name built from the edges leading to the node
+func exportsTrieDecode(d *decode.D, dylibs []string) {
+	trieStart := d.Pos()
+	// each node has one parent, a node reached twice is either a loop or shared between
+	// edges which could make decoding exponential
+	visited := map[int64]bool{}
+
+	var nodeFn func(d *decode.D, offset int64, prefix string)
+	nodeFn = func(d *decode.D, offset int64, prefix string) {
+		if visited[offset] {
+			d.Fatalf("node %d already decoded", offset)
+		}
+		visited[offset] = true
+
+		type child struct {
+			edge   string
+			offset int64
+		}
+		var children []child
+
+		d.SeekAbs(trieStart + offset*8)
+		d.FieldStruct("node", func(d *decode.D) {
+			d.FieldValueUint("offset", uint64(offset), scalar.UintHex)
+			terminalSize := d.FieldULEB128("terminal_size")
+			if terminalSize > 0 {
+				d.FramedFn(int64(terminalSize)*8, func(d *decode.D) {
+					d.FieldValueStr("symbol", prefix)
+					var flags uint64
+					d.FieldStruct("flags", func(d *decode.D) {
+						flags = d.FieldULEB128("value", scalar.UintHex)
+						d.FieldValueUint("kind", flags&EXPORT_SYMBOL_FLAGS_KIND_MASK, exportSymbolKindNames)
+						d.FieldValueBool("weak_definition", flags&EXPORT_SYMBOL_FLAGS_WEAK_DEFINITION != 0)
+						d.FieldValueBool("reexport", flags&EXPORT_SYMBOL_FLAGS_REEXPORT != 0)
+						d.FieldValueBool("stub_and_resolver", flags&EXPORT_SYMBOL_FLAGS_STUB_AND_RESOLVER != 0)
+						d.FieldValueBool("static_resolver", flags&EXPORT_SYMBOL_FLAGS_STATIC_RESOLVER != 0)
+					})
+					switch {
+					case flags&EXPORT_SYMBOL_FLAGS_REEXPORT != 0:
+						d.FieldULEB128("library_ordinal", libraryUintOrdinalMapper(dylibs))
+						d.FieldUTF8Null("import_name")
+					default:
+						d.FieldULEB128("address", scalar.UintHex)
+						if flags&EXPORT_SYMBOL_FLAGS_STUB_AND_RESOLVER != 0 {
+							d.FieldULEB128("resolver", scalar.UintHex)
+						}
+					}
+					if d.BitsLeft() > 0 {
+						d.FieldRawLen("unknown", d.BitsLeft())
+					}
+				})
+			}
+			childCount := d.FieldU8("child_count")
+			d.FieldArray("children", func(d *decode.D) {
+				for i := uint64(0); i < childCount; i++ {
+					d.FieldStruct("child", func(d *decode.D) {
+						edge := d.FieldUTF8Null("edge")
+						childOffset := d.FieldULEB128("node_offset", scalar.UintHex)
+						children = append(children, child{edge: edge, offset: int64(childOffset)})
+					})
+				}
+			})
+		})
+
+		for _, c := range children {
+			nodeFn(d, c.offset, prefix+c.edge)
+		}
+	}
+
+	d.FieldArray("nodes", func(d *decode.D) {
+		nodeFn(d, 0, "")
+	})
+}
+
+const (
+	DYLD_CHAINED_PTR_ARM64E              = 1
+	DYLD_CHAINED_PTR_64                  = 2
+	DYLD_CHAINED_PTR_32                  = 3
+	DYLD_CHAINED_PTR_32_CACHE            = 4
+	DYLD_CHAINED_PTR_32_FIRMWARE         = 5
+	DYLD_CHAINED_PTR_64_OFFSET           = 6
+	DYLD_CHAINED_PTR_ARM64E_KERNEL       = 7
+	DYLD_CHAINED_PTR_64_KERNEL_CACHE     = 8
+	DYLD_CHAINED_PTR_ARM64E_USERLAND     = 9
+	DYLD_CHAINED_PTR_ARM64E_FIRMWARE     = 10
+	DYLD_CHAINED_PTR_X86_64_KERNEL_CACHE = 11
+	DYLD_CHAINED_PTR_ARM64E_USERLAND24   = 12
+	DYLD_CHAINED_PTR_ARM64E_SHARED_CACHE = 13
+)
+
+var chainedPointerFormatNames = scalar.UintMapSymStr{
+	DYLD_CHAINED_PTR_ARM64E:              "arm64e",
+	DYLD_CHAINED_PTR_64:                  "64",
+	DYLD_CHAINED_PTR_32:                  "32",
+	DYLD_CHAINED_PTR_32_CACHE:            "32_cache",
+	DYLD_CHAINED_PTR_32_FIRMWARE:         "32_firmware",
+	DYLD_CHAINED_PTR_64_OFFSET:           "64_offset",
+	DYLD_CHAINED_PTR_ARM64E_KERNEL:       "arm64e_kernel",
+	DYLD_CHAINED_PTR_64_KERNEL_CACHE:     "64_kernel_cache",
+	DYLD_CHAINED_PTR_ARM64E_USERLAND:     "arm64e_userland",
+	DYLD_CHAINED_PTR_ARM64E_FIRMWARE:     "arm64e_firmware",
+	DYLD_CHAINED_PTR_X86_64_KERNEL_CACHE: "x86_64_kernel_cache",
+	DYLD_CHAINED_PTR_ARM64E_USERLAND24:   "arm64e_userland24",
+	DYLD_CHAINED_PTR_ARM64E_SHARED_CACHE: "arm64e_shared_cache",
+}
+
+const (
+	DYLD_CHAINED_IMPORT          = 1
+	DYLD_CHAINED_IMPORT_ADDEND   = 2
+	DYLD_CHAINED_IMPORT_ADDEND64 = 3
+)
+
+var chainedImportFormatNames = scalar.UintMapSymStr{
+	DYLD_CHAINED_IMPORT:          "import",
+	DYLD_CHAINED_IMPORT_ADDEND:   "import_addend",
+	DYLD_CHAINED_IMPORT_ADDEND64: "import_addend64",
+}
+
+const (
+	DYLD_CHAINED_SYMBOL_UNCOMPRESSED = 0
+	DYLD_CHAINED_SYMBOL_ZLIB         = 1
+)
+
+var chainedSymbolFormatNames = scalar.UintMapSymStr{
+	DYLD_CHAINED_SYMBOL_UNCOMPRESSED: "uncompressed",
+	DYLD_CHAINED_SYMBOL_ZLIB:         "zlib",
+}
+
+const (
+	DYLD_CHAINED_PTR_START_NONE  = 0xffff
+	DYLD_CHAINED_PTR_START_MULTI = 0x8000
+	DYLD_CHAINED_PTR_START_LAST  = 0x8000
+)
+
+var chainedPageStartMapper = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	switch {
+	case s.Actual == DYLD_CHAINED_PTR_START_NONE:
+		s.Sym = "none"
+	case s.Actual&DYLD_CHAINED_PTR_START_MULTI != 0:
+		s.Description = "multi"
+	}
+	return s, nil
+})
+
+var chainedPointerKeyNames = scalar.UintMapSymStr{
+	0: "ia",
+	1: "ib",
+	2: "da",
+	3: "db",
+}
+
+type chainedImport struct {
+	name string
+}
+
+func signExtend(v uint64, nBits int) int64 {
+	shift := 64 - nBits
+	return int64(v<<shift) >> shift
+}
+
+// chainedPointerDecode decodes one pointer in a chain and returns byte offset to next pointer, 0 if last
+func chainedPointerDecode(d *decode.D, pointerFormat uint64, importsMapper scalar.UintFn) int64 {
+	var next uint64
+	var stride uint64
+
+	switch pointerFormat {
+	case DYLD_CHAINED_PTR_64,
+		DYLD_CHAINED_PTR_64_OFFSET:
+		v := d.FieldU64("pointer", scalar.UintHex)
+		bind := v>>63 != 0
+		next = (v >> 51) & 0xfff
+		stride = 4
+		d.FieldValueBool("bind", bind)
+		if bind {
+			d.FieldValueUint("ordinal", v&0xff_ffff, importsMapper)
+			d.FieldValueUint("addend", (v>>24)&0xff)
+		} else {
+			d.FieldValueUint("target", v&0xf_ffff_ffff, scalar.UintHex)
+			d.FieldValueUint("high8", (v>>36)&0xff, scalar.UintHex)
+		}
+	case DYLD_CHAINED_PTR_ARM64E,
+		DYLD_CHAINED_PTR_ARM64E_KERNEL,
+		DYLD_CHAINED_PTR_ARM64E_USERLAND,
+		DYLD_CHAINED_PTR_ARM64E_FIRMWARE,
+		DYLD_CHAINED_PTR_ARM64E_USERLAND24:
+		v := d.FieldU64("pointer", scalar.UintHex)
+		auth := v>>63 != 0
+		bind := (v>>62)&1 != 0
+		next = (v >> 51) & 0x7ff
+		stride = 8
+		if pointerFormat == DYLD_CHAINED_PTR_ARM64E_KERNEL || pointerFormat == DYLD_CHAINED_PTR_ARM64E_FIRMWARE {
+			stride = 4
+		}
+		d.FieldValueBool("auth", auth)
+		d.FieldValueBool("bind", bind)
+		if bind {
+			ordinalMask := uint64(0xffff)
+			if pointerFormat == DYLD_CHAINED_PTR_ARM64E_USERLAND24 {
+				ordinalMask = 0xff_ffff
+			}
+			d.FieldValueUint("ordinal", v&ordinalMask, importsMapper)
+			if !auth {
+				d.FieldValueSint("addend", signExtend((v>>32)&0x7_ffff, 19))
+			}
+		} else {
+			if auth {
+				d.FieldValueUint("target", v&0xffff_ffff, scalar.UintHex)
+			} else {
+				d.FieldValueUint("target", v&0x7ff_ffff_ffff, scalar.UintHex)
+				d.FieldValueUint("high8", (v>>43)&0xff, scalar.UintHex)
+			}
+		}
+		if auth {
+			d.FieldValueUint("diversity", (v>>32)&0xffff, scalar.UintHex)
+			d.FieldValueBool("addr_div", (v>>48)&1 != 0)
+			d.FieldValueUint("key", (v>>49)&0x3, chainedPointerKeyNames)
+		}
+	case DYLD_CHAINED_PTR_32:
+		v := d.FieldU32("pointer", scalar.UintHex)
+		bind := v>>31 != 0
+		next = (v >> 26) & 0x1f
+		stride = 4
+		d.FieldValueBool("bind", bind)
+		if bind {
+			d.FieldValueUint("ordinal", v&0xf_ffff, importsMapper)
+			d.FieldValueUint("addend", (v>>20)&0x3f)
+		} else {
+			d.FieldValueUint("target", v&0x3ff_ffff, scalar.UintHex)
+		}
+	}
+	d.FieldValueUint("next", next)
+
+	return int64(next * stride)
+}
+
+func chainedFixupsSupportedPointerFormat(pointerFormat uint64) bool {
+	switch pointerFormat {
+	case DYLD_CHAINED_PTR_64,
+		DYLD_CHAINED_PTR_64_OFFSET,
+		DYLD_CHAINED_PTR_ARM64E,
+		DYLD_CHAINED_PTR_ARM64E_KERNEL,
+		DYLD_CHAINED_PTR_ARM64E_USERLAND,
+		DYLD_CHAINED_PTR_ARM64E_FIRMWARE,
+		DYLD_CHAINED_PTR_ARM64E_USERLAND24,
+		DYLD_CHAINED_PTR_32:
+		return true
+	default:
+		return false
+	}
+}
+
+// chainedFixupsDecode decodes the chained fixups header, starts, imports and symbols and also
+// follows the pointer chains in each segment page
+func chainedFixupsDecode(d *decode.D, segments []machoSegment, dylibs []string) {
+	start := d.Pos()
+	end := d.Len()
+
+	var startsOffset uint64
+	var importsOffset uint64
+	var symbolsOffset uint64
+	var importsCount uint64
+	var importsFormat uint64
+	var symbolsFormat uint64
+	d.FieldStruct("header", func(d *decode.D) {
+		d.FieldU32("fixups_version")
+		startsOffset = d.FieldU32("starts_offset", scalar.UintHex)
+		importsOffset = d.FieldU32("imports_offset", scalar.UintHex)
+		symbolsOffset = d.FieldU32("symbols_offset", scalar.UintHex)
+		importsCount = d.FieldU32("imports_count")
+		importsFormat = d.FieldU32("imports_format", chainedImportFormatNames)
+		symbolsFormat = d.FieldU32("symbols_format", chainedSymbolFormatNames)
+	})
+
+	symbolsStart := start + int64(symbolsOffset)*8
+	var symbols []byte
+	d.RangeFn(symbolsStart, end-symbolsStart, func(d *decode.D) {
+		symbols = d.BytesLen(int(d.BitsLeft() / 8))
+	})
+	if symbolsFormat == DYLD_CHAINED_SYMBOL_ZLIB {
+		zr, err := zlib.NewReader(bytes.NewReader(symbols))
+		if err != nil {
+			d.Fatalf("symbols: %s", err)
+		}
+		if symbols, err = io.ReadAll(zr); err != nil {
+			d.Fatalf("symbols: %s", err)
+		}
+	}
+	symbolsTable := strTable(symbols)
+
+	var imports []chainedImport
+	d.SeekAbs(start + int64(importsOffset)*8)
+	d.FieldArray("imports", func(d *decode.D) {
+		for i := uint64(0); i < importsCount; i++ {
+			d.FieldStruct("import", func(d *decode.D) {
+				var v uint64
+				var libOrdinal int64
+				var weakImport bool
+				var nameOffset uint64
+				switch importsFormat {
+				case DYLD_CHAINED_IMPORT,
+					DYLD_CHAINED_IMPORT_ADDEND:
+					v = d.FieldU32("value", scalar.UintHex)
+					libOrdinal = int64(v & 0xff)
+					// ordinals above 0xf0 are special negative ordinals
+					if libOrdinal > 0xf0 {
+						libOrdinal = signExtend(uint64(libOrdinal), 8)
+					}
+					weakImport = (v>>8)&1 != 0
+					nameOffset = v >> 9
+				case DYLD_CHAINED_IMPORT_ADDEND64:
+					v = d.FieldU64("value", scalar.UintHex)
+					libOrdinal = int64(v & 0xffff)
+					if libOrdinal > 0xfff0 {
+						libOrdinal = signExtend(uint64(libOrdinal), 16)
+					}
+					weakImport = (v>>16)&1 != 0
+					nameOffset = v >> 32
+				default:
+					d.Fatalf("unknown imports format %d", importsFormat)
+				}
+				d.FieldValueSint("lib_ordinal", libOrdinal, librarySintOrdinalMapper(dylibs))
+				d.FieldValueBool("weak_import", weakImport)
+				d.FieldValueUint("name_offset", nameOffset, symbolsTable)
+				switch importsFormat {
+				case DYLD_CHAINED_IMPORT_ADDEND:
+					d.FieldS32("addend")
+				case DYLD_CHAINED_IMPORT_ADDEND64:
+					d.FieldS64("addend")
+				}
+				imports = append(imports, chainedImport{name: strIndexNull(int(nameOffset), string(symbols))})
+			})
+		}
+	})
+	importsMapper := scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+		if s.Actual < uint64(len(imports)) {
+			s.Sym = imports[s.Actual].name
+		}
+		return s, nil
+	})
+
+	d.RangeFn(symbolsStart, end-symbolsStart, func(d *decode.D) {
+		d.FieldRawLen("symbols", d.BitsLeft())
+	})
+
+	startsStart := start + int64(startsOffset)*8
+	d.SeekAbs(startsStart)
+	d.FieldStruct("starts_in_image", func(d *decode.D) {
+		segCount := d.FieldU32("seg_count")
+		var segInfoOffsets []uint64
+		d.FieldArray("seg_info_offsets", func(d *decode.D) {
+			for i := uint64(0); i < segCount; i++ {
+				segInfoOffsets = append(segInfoOffsets, d.FieldU32("seg_info_offset", scalar.UintHex))
+			}
+		})
+
+		d.FieldArray("segments", func(d *decode.D) {
+			for segIndex, segInfoOffset := range segInfoOffsets {
+				// zero offset means segment has no fixups
+				if segInfoOffset == 0 {
+					continue
+				}
+				d.SeekAbs(startsStart + int64(segInfoOffset)*8)
+				d.FieldStruct("segment", func(d *decode.D) {
+					if segIndex < len(segments) {
+						d.FieldValueStr("name", segments[segIndex].name)
+					}
+					size := d.FieldU32("size")
+					pageSize := d.FieldU16("page_size", scalar.UintHex)
+					pointerFormat := d.FieldU16("pointer_format", chainedPointerFormatNames)
+					d.FieldU64("segment_offset", scalar.UintHex)
+					d.FieldU32("max_valid_pointer", scalar.UintHex)
+					pageCount := d.FieldU16("page_count")
+					var pageStarts []uint64
+					d.FieldArray("page_starts", func(d *decode.D) {
+						for i := uint64(0); i < pageCount; i++ {
+							pageStarts = append(pageStarts, d.FieldU16("page_start", chainedPageStartMapper))
+						}
+					})
+					// extra starts for pages with multiple chains, only used by 32 bit formats
+					var chainStarts []uint64
+					if chainStartsCount := (int64(size) - 22 - int64(pageCount)*2) / 2; chainStartsCount > 0 {
+						d.FieldArray("chain_starts", func(d *decode.D) {
+							for i := int64(0); i < chainStartsCount; i++ {
+								chainStarts = append(chainStarts, d.FieldU16("chain_start", scalar.UintHex))
+							}
+						})
+					}
+
+					if segIndex >= len(segments) || !chainedFixupsSupportedPointerFormat(pointerFormat) {
+						return
+					}
+					segFileoff := segments[segIndex].fileoff
+
+					d.FieldArray("fixups", func(d *decode.D) {
+						chainFn := func(pageIndex int, offset uint64) {
+							pos := (segFileoff + int64(pageIndex)*int64(pageSize) + int64(offset)) * 8
+							for {
+								d.SeekAbs(pos)
+								var nextOffset int64
+								d.FieldStruct("fixup", func(d *decode.D) {
+									d.FieldValueUint("page", uint64(pageIndex))
+									nextOffset = chainedPointerDecode(d, pointerFormat, importsMapper)
+								})
+								if nextOffset == 0 {
+									break
+								}
+								pos += nextOffset * 8
+							}
+						}
+
+						for pageIndex, pageStart := range pageStarts {
+							switch {
+							case pageStart == DYLD_CHAINED_PTR_START_NONE:
+							case pageStart&DYLD_CHAINED_PTR_START_MULTI != 0:
+								// index is into page starts with chain starts appended
+								for i := int(pageStart&^DYLD_CHAINED_PTR_START_MULTI) - len(pageStarts); i >= 0 && i < len(chainStarts); i++ {
+									chainFn(pageIndex, chainStarts[i]&^DYLD_CHAINED_PTR_START_LAST)
+									if chainStarts[i]&DYLD_CHAINED_PTR_START_LAST != 0 {
+										break
+									}
+								}
+							default:
+								chainFn(pageIndex, pageStart)
+							}
+						}
+					})
+				})
+			}
+		})
+	})
+}
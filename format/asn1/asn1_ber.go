@@ -126,8 +126,9 @@ var universalTypeMap = scalar.UintMapSymStr{
 }
 
 const (
-	lengthIndefinite = 0
-	lengthEndMarker  = 0x00_00
+	lengthIndefiniteForm = 0b1000_0000
+	lengthIndefinite     = 0
+	lengthEndMarker      = 0x00_00
 )
 
 const (
@@ -185,12 +186,18 @@ func decodeASN1BERValue(d *decode.D, bib *bitio.Buffer, sb *strings.Builder, par
 		tag = d.FieldUintFn("tag", decodeTagNumber)
 	}
 
-	length := d.FieldUintFn("length", decodeLength, lengthMap)
+	// zero and indefinite length are both decoded as 0, only the latter has the 0x80 length byte
+	indefinite := d.BitsLeft() >= 8 && d.PeekUintBits(8) == lengthIndefiniteForm
+	var length uint64
+	if indefinite {
+		length = d.FieldUintFn("length", decodeLength, lengthMap)
+	} else {
+		length = d.FieldUintFn("length", decodeLength)
+	}
 	var l int64
-	switch length {
-	case lengthIndefinite:
-		// null has zero length byte
-		if !(class == classUniversal && tag == universalTypeNull) && form == formPrimitive {
+	switch {
+	case indefinite:
+		if form == formPrimitive {
 			d.Fatalf("primitive with indefinite length")
 		}
 		l = d.BitsLeft()
@@ -203,7 +210,7 @@ func decodeASN1BERValue(d *decode.D, bib *bitio.Buffer, sb *strings.Builder, par
 		case form == formConstructed || tag == universalTypeSequence || tag == universalTypeSet:
 			d.FieldArray("constructed", func(d *decode.D) {
 				for !d.End() {
-					if length == lengthIndefinite && d.PeekUintBits(16) == lengthEndMarker {
+					if indefinite && d.PeekUintBits(16) == lengthEndMarker {
 						break
 					}
 
@@ -230,7 +237,7 @@ func decodeASN1BERValue(d *decode.D, bib *bitio.Buffer, sb *strings.Builder, par
 				}
 			})
 
-			if length == lengthIndefinite {
+			if indefinite {
 				d.FieldU16("end_marker")
 			}
 			if form == formConstructed {
@@ -260,7 +267,8 @@ func decodeASN1BERValue(d *decode.D, bib *bitio.Buffer, sb *strings.Builder, par
 				}
 			}
 		case class == classUniversal && tag == universalTypeEndOfContent:
-			// nop
+			// only valid as end marker of an indefinite length value which is consumed by the parent
+			d.Fatalf("unexpected end of content")
 		case class == classUniversal && tag == universalTypeBoolean:
 			d.FieldU8("value", scalar.UintRangeToScalar{
 				{Range: [2]uint64{0, 0}, S: scalar.Uint{Sym: false}},
@@ -0,0 +1,1413 @@
+package elf
+
+// https://dwarfstd.org/doc/DWARF5.pdf
+// https://dwarfstd.org/doc/DWARF4.pdf
+// https://refspecs.linuxbase.org/elf/gabi4+/ch4.sheader.html#compression_header
+
+// TODO: .debug_frame, .debug_loc, .debug_ranges, .debug_rnglists etc
+// TODO: apply relocations for ET_REL files, string offsets etc are usually relocated
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+const SHF_COMPRESSED = 0x800
+
+const (
+	ELFCOMPRESS_ZLIB = 1
+	ELFCOMPRESS_ZSTD = 2
+)
+
+var compressionTypeNames = scalar.UintMapSymStr{
+	ELFCOMPRESS_ZLIB: "zlib",
+	ELFCOMPRESS_ZSTD: "zstd",
+}
+
+const (
+	DEBUG_ABBREV   = ".debug_abbrev"
+	DEBUG_ADDR     = ".debug_addr"
+	DEBUG_ARANGES  = ".debug_aranges"
+	DEBUG_INFO     = ".debug_info"
+	DEBUG_LINE     = ".debug_line"
+	DEBUG_LINE_STR = ".debug_line_str"
+	DEBUG_STR      = ".debug_str"
+	DEBUG_STR_OFFS = ".debug_str_offsets"
+	DEBUG_TYPES    = ".debug_types"
+)
+
+var dwTagNames = scalar.UintMapSymStr{
+	0x01:   "array_type",
+	0x02:   "class_type",
+	0x03:   "entry_point",
+	0x04:   "enumeration_type",
+	0x05:   "formal_parameter",
+	0x08:   "imported_declaration",
+	0x0a:   "label",
+	0x0b:   "lexical_block",
+	0x0d:   "member",
+	0x0f:   "pointer_type",
+	0x10:   "reference_type",
+	0x11:   "compile_unit",
+	0x12:   "string_type",
+	0x13:   "structure_type",
+	0x15:   "subroutine_type",
+	0x16:   "typedef",
+	0x17:   "union_type",
+	0x18:   "unspecified_parameters",
+	0x19:   "variant",
+	0x1a:   "common_block",
+	0x1b:   "common_inclusion",
+	0x1c:   "inheritance",
+	0x1d:   "inlined_subroutine",
+	0x1e:   "module",
+	0x1f:   "ptr_to_member_type",
+	0x20:   "set_type",
+	0x21:   "subrange_type",
+	0x22:   "with_stmt",
+	0x23:   "access_declaration",
+	0x24:   "base_type",
+	0x25:   "catch_block",
+	0x26:   "const_type",
+	0x27:   "constant",
+	0x28:   "enumerator",
+	0x29:   "file_type",
+	0x2a:   "friend",
+	0x2b:   "namelist",
+	0x2c:   "namelist_item",
+	0x2d:   "packed_type",
+	0x2e:   "subprogram",
+	0x2f:   "template_type_parameter",
+	0x30:   "template_value_parameter",
+	0x31:   "thrown_type",
+	0x32:   "try_block",
+	0x33:   "variant_part",
+	0x34:   "variable",
+	0x35:   "volatile_type",
+	0x36:   "dwarf_procedure",
+	0x37:   "restrict_type",
+	0x38:   "interface_type",
+	0x39:   "namespace",
+	0x3a:   "imported_module",
+	0x3b:   "unspecified_type",
+	0x3c:   "partial_unit",
+	0x3d:   "imported_unit",
+	0x3f:   "condition",
+	0x40:   "shared_type",
+	0x41:   "type_unit",
+	0x42:   "rvalue_reference_type",
+	0x43:   "template_alias",
+	0x44:   "coarray_type",
+	0x45:   "generic_subrange",
+	0x46:   "dynamic_type",
+	0x47:   "atomic_type",
+	0x48:   "call_site",
+	0x49:   "call_site_parameter",
+	0x4a:   "skeleton_unit",
+	0x4b:   "immutable_type",
+	0x4106: "gnu_template_template_param",
+	0x4107: "gnu_template_parameter_pack",
+	0x4108: "gnu_formal_parameter_pack",
+	0x4109: "gnu_call_site",
+	0x410a: "gnu_call_site_parameter",
+}
+
+const (
+	DW_AT_str_offsets_base = 0x72
+	DW_AT_addr_base        = 0x73
+	DW_AT_GNU_addr_base    = 0x2133
+)
+
+var dwAttributeNames = scalar.UintMapSymStr{
+	0x01:   "sibling",
+	0x02:   "location",
+	0x03:   "name",
+	0x09:   "ordering",
+	0x0b:   "byte_size",
+	0x0c:   "bit_offset",
+	0x0d:   "bit_size",
+	0x10:   "stmt_list",
+	0x11:   "low_pc",
+	0x12:   "high_pc",
+	0x13:   "language",
+	0x15:   "discr",
+	0x16:   "discr_value",
+	0x17:   "visibility",
+	0x18:   "import",
+	0x19:   "string_length",
+	0x1a:   "common_reference",
+	0x1b:   "comp_dir",
+	0x1c:   "const_value",
+	0x1d:   "containing_type",
+	0x1e:   "default_value",
+	0x20:   "inline",
+	0x21:   "is_optional",
+	0x22:   "lower_bound",
+	0x25:   "producer",
+	0x27:   "prototyped",
+	0x2a:   "return_addr",
+	0x2c:   "start_scope",
+	0x2e:   "bit_stride",
+	0x2f:   "upper_bound",
+	0x31:   "abstract_origin",
+	0x32:   "accessibility",
+	0x33:   "address_class",
+	0x34:   "artificial",
+	0x35:   "base_types",
+	0x36:   "calling_convention",
+	0x37:   "count",
+	0x38:   "data_member_location",
+	0x39:   "decl_column",
+	0x3a:   "decl_file",
+	0x3b:   "decl_line",
+	0x3c:   "declaration",
+	0x3d:   "discr_list",
+	0x3e:   "encoding",
+	0x3f:   "external",
+	0x40:   "frame_base",
+	0x41:   "friend",
+	0x42:   "identifier_case",
+	0x43:   "macro_info",
+	0x44:   "namelist_item",
+	0x45:   "priority",
+	0x46:   "segment",
+	0x47:   "specification",
+	0x48:   "static_link",
+	0x49:   "type",
+	0x4a:   "use_location",
+	0x4b:   "variable_parameter",
+	0x4c:   "virtuality",
+	0x4d:   "vtable_elem_location",
+	0x4e:   "allocated",
+	0x4f:   "associated",
+	0x50:   "data_location",
+	0x51:   "byte_stride",
+	0x52:   "entry_pc",
+	0x53:   "use_utf8",
+	0x54:   "extension",
+	0x55:   "ranges",
+	0x56:   "trampoline",
+	0x57:   "call_column",
+	0x58:   "call_file",
+	0x59:   "call_line",
+	0x5a:   "description",
+	0x5b:   "binary_scale",
+	0x5c:   "decimal_scale",
+	0x5d:   "small",
+	0x5e:   "decimal_sign",
+	0x5f:   "digit_count",
+	0x60:   "picture_string",
+	0x61:   "mutable",
+	0x62:   "threads_scaled",
+	0x63:   "explicit",
+	0x64:   "object_pointer",
+	0x65:   "endianity",
+	0x66:   "elemental",
+	0x67:   "pure",
+	0x68:   "recursive",
+	0x69:   "signature",
+	0x6a:   "main_subprogram",
+	0x6b:   "data_bit_offset",
+	0x6c:   "const_expr",
+	0x6d:   "enum_class",
+	0x6e:   "linkage_name",
+	0x6f:   "string_length_bit_size",
+	0x70:   "string_length_byte_size",
+	0x71:   "rank",
+	0x72:   "str_offsets_base",
+	0x73:   "addr_base",
+	0x74:   "rnglists_base",
+	0x76:   "dwo_name",
+	0x77:   "reference",
+	0x78:   "rvalue_reference",
+	0x79:   "macros",
+	0x7a:   "call_all_calls",
+	0x7b:   "call_all_source_calls",
+	0x7c:   "call_all_tail_calls",
+	0x7d:   "call_return_pc",
+	0x7e:   "call_value",
+	0x7f:   "call_origin",
+	0x80:   "call_parameter",
+	0x81:   "call_pc",
+	0x82:   "call_tail_call",
+	0x83:   "call_target",
+	0x84:   "call_target_clobbered",
+	0x85:   "call_data_location",
+	0x86:   "call_data_value",
+	0x87:   "noreturn",
+	0x88:   "alignment",
+	0x89:   "export_symbols",
+	0x8a:   "deleted",
+	0x8b:   "defaulted",
+	0x8c:   "loclists_base",
+	0x2007: "mips_linkage_name",
+	0x2107: "gnu_vector",
+	0x210f: "gnu_odr_signature",
+	0x2110: "gnu_template_name",
+	0x2111: "gnu_call_site_value",
+	0x2112: "gnu_call_site_data_value",
+	0x2113: "gnu_call_site_target",
+	0x2114: "gnu_call_site_target_clobbered",
+	0x2115: "gnu_tail_call",
+	0x2116: "gnu_all_tail_call_sites",
+	0x2117: "gnu_all_call_sites",
+	0x2118: "gnu_all_source_call_sites",
+	0x2119: "gnu_macros",
+	0x211a: "gnu_deleted",
+	0x2130: "gnu_dwo_name",
+	0x2131: "gnu_dwo_id",
+	0x2132: "gnu_ranges_base",
+	0x2133: "gnu_addr_base",
+	0x2134: "gnu_pubnames",
+	0x2135: "gnu_pubtypes",
+	0x2136: "gnu_discriminator",
+	0x2137: "gnu_locviews",
+	0x2138: "gnu_entry_view",
+}
+
+const (
+	DW_FORM_addr           = 0x01
+	DW_FORM_block2         = 0x03
+	DW_FORM_block4         = 0x04
+	DW_FORM_data2          = 0x05
+	DW_FORM_data4          = 0x06
+	DW_FORM_data8          = 0x07
+	DW_FORM_string         = 0x08
+	DW_FORM_block          = 0x09
+	DW_FORM_block1         = 0x0a
+	DW_FORM_data1          = 0x0b
+	DW_FORM_flag           = 0x0c
+	DW_FORM_sdata          = 0x0d
+	DW_FORM_strp           = 0x0e
+	DW_FORM_udata          = 0x0f
+	DW_FORM_ref_addr       = 0x10
+	DW_FORM_ref1           = 0x11
+	DW_FORM_ref2           = 0x12
+	DW_FORM_ref4           = 0x13
+	DW_FORM_ref8           = 0x14
+	DW_FORM_ref_udata      = 0x15
+	DW_FORM_indirect       = 0x16
+	DW_FORM_sec_offset     = 0x17
+	DW_FORM_exprloc        = 0x18
+	DW_FORM_flag_present   = 0x19
+	DW_FORM_strx           = 0x1a
+	DW_FORM_addrx          = 0x1b
+	DW_FORM_ref_sup4       = 0x1c
+	DW_FORM_strp_sup       = 0x1d
+	DW_FORM_data16         = 0x1e
+	DW_FORM_line_strp      = 0x1f
+	DW_FORM_ref_sig8       = 0x20
+	DW_FORM_implicit_const = 0x21
+	DW_FORM_loclistx       = 0x22
+	DW_FORM_rnglistx       = 0x23
+	DW_FORM_ref_sup8       = 0x24
+	DW_FORM_strx1          = 0x25
+	DW_FORM_strx2          = 0x26
+	DW_FORM_strx3          = 0x27
+	DW_FORM_strx4          = 0x28
+	DW_FORM_addrx1         = 0x29
+	DW_FORM_addrx2         = 0x2a
+	DW_FORM_addrx3         = 0x2b
+	DW_FORM_addrx4         = 0x2c
+	DW_FORM_GNU_addr_index = 0x1f01
+	DW_FORM_GNU_str_index  = 0x1f02
+	DW_FORM_GNU_ref_alt    = 0x1f20
+	DW_FORM_GNU_strp_alt   = 0x1f21
+)
+
+var dwFormNames = scalar.UintMapSymStr{
+	DW_FORM_addr:           "addr",
+	DW_FORM_block2:         "block2",
+	DW_FORM_block4:         "block4",
+	DW_FORM_data2:          "data2",
+	DW_FORM_data4:          "data4",
+	DW_FORM_data8:          "data8",
+	DW_FORM_string:         "string",
+	DW_FORM_block:          "block",
+	DW_FORM_block1:         "block1",
+	DW_FORM_data1:          "data1",
+	DW_FORM_flag:           "flag",
+	DW_FORM_sdata:          "sdata",
+	DW_FORM_strp:           "strp",
+	DW_FORM_udata:          "udata",
+	DW_FORM_ref_addr:       "ref_addr",
+	DW_FORM_ref1:           "ref1",
+	DW_FORM_ref2:           "ref2",
+	DW_FORM_ref4:           "ref4",
+	DW_FORM_ref8:           "ref8",
+	DW_FORM_ref_udata:      "ref_udata",
+	DW_FORM_indirect:       "indirect",
+	DW_FORM_sec_offset:     "sec_offset",
+	DW_FORM_exprloc:        "exprloc",
+	DW_FORM_flag_present:   "flag_present",
+	DW_FORM_strx:           "strx",
+	DW_FORM_addrx:          "addrx",
+	DW_FORM_ref_sup4:       "ref_sup4",
+	DW_FORM_strp_sup:       "strp_sup",
+	DW_FORM_data16:         "data16",
+	DW_FORM_line_strp:      "line_strp",
+	DW_FORM_ref_sig8:       "ref_sig8",
+	DW_FORM_implicit_const: "implicit_const",
+	DW_FORM_loclistx:       "loclistx",
+	DW_FORM_rnglistx:       "rnglistx",
+	DW_FORM_ref_sup8:       "ref_sup8",
+	DW_FORM_strx1:          "strx1",
+	DW_FORM_strx2:          "strx2",
+	DW_FORM_strx3:          "strx3",
+	DW_FORM_strx4:          "strx4",
+	DW_FORM_addrx1:         "addrx1",
+	DW_FORM_addrx2:         "addrx2",
+	DW_FORM_addrx3:         "addrx3",
+	DW_FORM_addrx4:         "addrx4",
+	DW_FORM_GNU_addr_index: "gnu_addr_index",
+	DW_FORM_GNU_str_index:  "gnu_str_index",
+	DW_FORM_GNU_ref_alt:    "gnu_ref_alt",
+	DW_FORM_GNU_strp_alt:   "gnu_strp_alt",
+}
+
+var dwChildrenNames = scalar.UintMapSymBool{
+	0: false,
+	1: true,
+}
+
+const (
+	DW_UT_compile       = 0x01
+	DW_UT_type          = 0x02
+	DW_UT_partial       = 0x03
+	DW_UT_skeleton      = 0x04
+	DW_UT_split_compile = 0x05
+	DW_UT_split_type    = 0x06
+)
+
+var dwUnitTypeNames = scalar.UintMapSymStr{
+	DW_UT_compile:       "compile",
+	DW_UT_type:          "type",
+	DW_UT_partial:       "partial",
+	DW_UT_skeleton:      "skeleton",
+	DW_UT_split_compile: "split_compile",
+	DW_UT_split_type:    "split_type",
+}
+
+const (
+	DW_LNS_copy               = 0x01
+	DW_LNS_advance_pc         = 0x02
+	DW_LNS_advance_line       = 0x03
+	DW_LNS_set_file           = 0x04
+	DW_LNS_set_column         = 0x05
+	DW_LNS_negate_stmt        = 0x06
+	DW_LNS_set_basic_block    = 0x07
+	DW_LNS_const_add_pc       = 0x08
+	DW_LNS_fixed_advance_pc   = 0x09
+	DW_LNS_set_prologue_end   = 0x0a
+	DW_LNS_set_epilogue_begin = 0x0b
+	DW_LNS_set_isa            = 0x0c
+)
+
+var dwLineStandardOpcodeNames = scalar.UintMapSymStr{
+	0x00:                      "extended",
+	DW_LNS_copy:               "copy",
+	DW_LNS_advance_pc:         "advance_pc",
+	DW_LNS_advance_line:       "advance_line",
+	DW_LNS_set_file:           "set_file",
+	DW_LNS_set_column:         "set_column",
+	DW_LNS_negate_stmt:        "negate_stmt",
+	DW_LNS_set_basic_block:    "set_basic_block",
+	DW_LNS_const_add_pc:       "const_add_pc",
+	DW_LNS_fixed_advance_pc:   "fixed_advance_pc",
+	DW_LNS_set_prologue_end:   "set_prologue_end",
+	DW_LNS_set_epilogue_begin: "set_epilogue_begin",
+	DW_LNS_set_isa:            "set_isa",
+}
+
+const (
+	DW_LNE_end_sequence      = 0x01
+	DW_LNE_set_address       = 0x02
+	DW_LNE_define_file       = 0x03
+	DW_LNE_set_discriminator = 0x04
+)
+
+var dwLineExtendedOpcodeNames = scalar.UintMapSymStr{
+	DW_LNE_end_sequence:      "end_sequence",
+	DW_LNE_set_address:       "set_address",
+	DW_LNE_define_file:       "define_file",
+	DW_LNE_set_discriminator: "set_discriminator",
+}
+
+const (
+	DW_LNCT_path            = 0x1
+	DW_LNCT_directory_index = 0x2
+	DW_LNCT_timestamp       = 0x3
+	DW_LNCT_size            = 0x4
+	DW_LNCT_MD5             = 0x5
+)
+
+var dwLineContentTypeNames = scalar.UintMapSymStr{
+	DW_LNCT_path:            "path",
+	DW_LNCT_directory_index: "directory_index",
+	DW_LNCT_timestamp:       "timestamp",
+	DW_LNCT_size:            "size",
+	DW_LNCT_MD5:             "md5",
+	0x2001:                  "llvm_source",
+}
+
+const maxDWARFSectionSize = 1_000_000_000
+
+// dwarfSectionName returns .debug_* name for .debug_* and .zdebug_* sections
+func dwarfSectionName(name string) (string, bool) {
+	switch {
+	case strings.HasPrefix(name, ".debug_"):
+		return name, true
+	case strings.HasPrefix(name, ".zdebug_"):
+		return "." + name[2:], true
+	default:
+		return "", false
+	}
+}
+
+type dwarfAttrSpec struct {
+	attr          uint64
+	form          uint64
+	implicitConst int64
+}
+
+type dwarfAbbrev struct {
+	tag      uint64
+	children bool
+	attrs    []dwarfAttrSpec
+}
+
+type dwarfContext struct {
+	// uncompressed section content by .debug_* name
+	sections map[string][]byte
+	// abbreviation tables by .debug_abbrev offset
+	abbrevTables map[uint64]map[uint64]dwarfAbbrev
+	order        binary.ByteOrder
+	// address size of the ELF class, line programs before version 5 has no address size
+	addressSize int
+}
+
+// dwarfUnit is information from a unit header needed to decode its content
+type dwarfUnit struct {
+	version        int
+	offsetSize     int
+	addressSize    int
+	strOffsetsBase uint64
+	addrBase       uint64
+}
+
+// dwarfBuf reads raw section content, used to read things needed before they are decoded
+type dwarfBuf struct {
+	bs  []byte
+	pos int
+}
+
+func (b *dwarfBuf) u8() (uint64, bool) {
+	if b.pos >= len(b.bs) {
+		return 0, false
+	}
+	b.pos++
+	return uint64(b.bs[b.pos-1]), true
+}
+
+func (b *dwarfBuf) leb128() (uint64, int, bool) {
+	var v uint64
+	var shift int
+	for {
+		c, ok := b.u8()
+		if !ok {
+			return 0, 0, false
+		}
+		if shift < 64 {
+			v |= uint64(c&0x7f) << shift
+		}
+		shift += 7
+		if c&0x80 == 0 {
+			return v, shift, true
+		}
+	}
+}
+
+func (b *dwarfBuf) uleb128() (uint64, bool) {
+	v, _, ok := b.leb128()
+	return v, ok
+}
+
+func (b *dwarfBuf) sleb128() (int64, bool) {
+	v, shift, ok := b.leb128()
+	if !ok {
+		return 0, false
+	}
+	if shift < 64 && v&(1<<(shift-1)) != 0 {
+		v |= ^uint64(0) << shift
+	}
+	return int64(v), true
+}
+
+func (dc *dwarfContext) uintAt(section string, offset uint64, size int) (uint64, bool) {
+	bs := dc.sections[section]
+	if offset+uint64(size) > uint64(len(bs)) || offset+uint64(size) < offset {
+		return 0, false
+	}
+	bs = bs[offset : offset+uint64(size)]
+	switch size {
+	case 1:
+		return uint64(bs[0]), true
+	case 2:
+		return uint64(dc.order.Uint16(bs)), true
+	case 4:
+		return uint64(dc.order.Uint32(bs)), true
+	case 8:
+		return dc.order.Uint64(bs), true
+	default:
+		return 0, false
+	}
+}
+
+func (dc *dwarfContext) abbrevTable(offset uint64) (map[uint64]dwarfAbbrev, bool) {
+	if t, ok := dc.abbrevTables[offset]; ok {
+		return t, true
+	}
+	bs := dc.sections[DEBUG_ABBREV]
+	if offset >= uint64(len(bs)) {
+		return nil, false
+	}
+
+	t := map[uint64]dwarfAbbrev{}
+	b := &dwarfBuf{bs: bs, pos: int(offset)}
+	for {
+		code, ok := b.uleb128()
+		if !ok {
+			return nil, false
+		}
+		if code == 0 {
+			break
+		}
+		var a dwarfAbbrev
+		var children uint64
+		a.tag, _ = b.uleb128()
+		children, _ = b.u8()
+		a.children = children != 0
+		for {
+			var s dwarfAttrSpec
+			s.attr, _ = b.uleb128()
+			s.form, ok = b.uleb128()
+			if !ok {
+				return nil, false
+			}
+			if s.attr == 0 && s.form == 0 {
+				break
+			}
+			if s.form == DW_FORM_implicit_const {
+				s.implicitConst, _ = b.sleb128()
+			}
+			a.attrs = append(a.attrs, s)
+		}
+		t[code] = a
+	}
+	dc.abbrevTables[offset] = t
+
+	return t, true
+}
+
+func (dc *dwarfContext) strx(u *dwarfUnit, index uint64) (string, bool) {
+	offset, ok := dc.uintAt(DEBUG_STR_OFFS, u.strOffsetsBase+index*uint64(u.offsetSize), u.offsetSize)
+	if !ok {
+		return "", false
+	}
+	return dc.str(DEBUG_STR, offset)
+}
+
+func (dc *dwarfContext) str(section string, offset uint64) (string, bool) {
+	s := dc.sections[section]
+	if offset >= uint64(len(s)) {
+		return "", false
+	}
+	return strIndexNull(int(offset), string(s)), true
+}
+
+func (dc *dwarfContext) addrx(u *dwarfUnit, index uint64) (uint64, bool) {
+	return dc.uintAt(DEBUG_ADDR, u.addrBase+index*uint64(u.addressSize), u.addressSize)
+}
+
+func (dc *dwarfContext) strMapper(section string) scalar.UintFn {
+	return func(s scalar.Uint) (scalar.Uint, error) {
+		if str, ok := dc.str(section, s.Actual); ok {
+			s.Sym = str
+		}
+		return s, nil
+	}
+}
+
+func (dc *dwarfContext) strxMapper(u *dwarfUnit) scalar.UintFn {
+	return func(s scalar.Uint) (scalar.Uint, error) {
+		if str, ok := dc.strx(u, s.Actual); ok {
+			s.Sym = str
+		}
+		return s, nil
+	}
+}
+
+func (dc *dwarfContext) addrxMapper(u *dwarfUnit) scalar.UintFn {
+	return func(s scalar.Uint) (scalar.Uint, error) {
+		if addr, ok := dc.addrx(u, s.Actual); ok {
+			s.Sym = addr
+		}
+		return s, nil
+	}
+}
+
+// elfReadDWARFSections reads and uncompress .debug_* sections as they reference each other
+func elfReadDWARFSections(d *decode.D, ec *elfContext) {
+	dc := &dwarfContext{
+		sections:     map[string][]byte{},
+		abbrevTables: map[uint64]map[uint64]dwarfAbbrev{},
+		order:        binary.LittleEndian,
+		addressSize:  ec.archBits / 8,
+	}
+	if ec.endian == decode.BigEndian {
+		dc.order = binary.BigEndian
+	}
+	ec.dwarf = dc
+
+	for _, sh := range ec.sections {
+		shName := strIndexNull(sh.name, ec.strTabMap[STRTAB_SHSTRTAB])
+		name, ok := dwarfSectionName(shName)
+		if !ok || sh.typ == SHT_NOBITS || sh.size/8 > maxDWARFSectionSize {
+			continue
+		}
+		bs, err := d.TryBytesRange(sh.offset, int(sh.size/8))
+		if err != nil {
+			continue
+		}
+		switch {
+		case sh.flags&SHF_COMPRESSED != 0:
+			d.SeekAbs(sh.offset)
+			chType := d.U32()
+			headerSize := 12
+			if ec.archBits == 64 {
+				headerSize = 24
+			}
+			if chType != ELFCOMPRESS_ZLIB || len(bs) < headerSize {
+				continue
+			}
+			bs, err = dwarfUncompress(bs[headerSize:])
+		case name != shName:
+			if len(bs) < 12 || string(bs[0:4]) != "ZLIB" {
+				continue
+			}
+			bs, err = dwarfUncompress(bs[12:])
+		}
+		if err != nil {
+			continue
+		}
+		dc.sections[name] = bs
+	}
+}
+
+func dwarfUncompress(bs []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(io.LimitReader(zr, maxDWARFSectionSize))
+}
+
+// decodes unit_length and returns length in bytes and offset size for 32 or 64 bit DWARF
+func dwarfFieldUnitLength(d *decode.D) (int64, int) {
+	length := d.FieldU32("unit_length", scalar.UintMapDescription{0xffff_ffff: "64-bit DWARF"})
+	switch {
+	case length == 0xffff_ffff:
+		return int64(d.FieldU64("unit_length64")), 8
+	case length >= 0xffff_fff0:
+		d.Fatalf("reserved unit length %x", length)
+	}
+	return int64(length), 4
+}
+
+func dwarfDecodeStrings(d *decode.D, dc *dwarfContext) {
+	for !d.End() {
+		d.FieldUTF8Null("string")
+	}
+}
+
+func dwarfDecodeAbbrevTables(d *decode.D, dc *dwarfContext) {
+	for !d.End() {
+		d.FieldStruct("abbreviation_table", func(d *decode.D) {
+			d.FieldValueUint("offset", uint64(d.Pos())/8, scalar.UintHex)
+			d.FieldArray("abbreviations", func(d *decode.D) {
+				for !d.End() {
+					var code uint64
+					d.FieldStruct("abbreviation", func(d *decode.D) {
+						code = d.FieldULEB128("code")
+						if code == 0 {
+							return
+						}
+						d.FieldULEB128("tag", dwTagNames, scalar.UintHex)
+						d.FieldU8("children", dwChildrenNames)
+						d.FieldArray("attributes", func(d *decode.D) {
+							for !d.End() {
+								var attr, form uint64
+								d.FieldStruct("attribute", func(d *decode.D) {
+									attr = d.FieldULEB128("attribute", dwAttributeNames, scalar.UintHex)
+									form = d.FieldULEB128("form", dwFormNames, scalar.UintHex)
+									if form == DW_FORM_implicit_const {
+										d.FieldSLEB128("implicit_const")
+									}
+								})
+								if attr == 0 && form == 0 {
+									break
+								}
+							}
+						})
+					})
+					if code == 0 {
+						break
+					}
+				}
+			})
+		})
+	}
+}
+
+type dwarfValue struct {
+	u uint64
+	s string
+}
+
+// decodes attribute value based on form
+func dwarfFieldFormValue(d *decode.D, dc *dwarfContext, u *dwarfUnit, form uint64, implicitConst int64) dwarfValue {
+	var v dwarfValue
+
+	blockFn := func(lengthBits int) {
+		var length uint64
+		if lengthBits == 0 {
+			length = d.FieldULEB128("length")
+		} else {
+			length = d.FieldU("length", lengthBits)
+		}
+		d.FieldRawLen("value", int64(length)*8)
+	}
+
+	switch form {
+	case DW_FORM_addr:
+		v.u = d.FieldU("value", u.addressSize*8, scalar.UintHex)
+	case DW_FORM_data1, DW_FORM_flag:
+		v.u = d.FieldU8("value")
+	case DW_FORM_data2:
+		v.u = d.FieldU16("value")
+	case DW_FORM_data4:
+		v.u = d.FieldU32("value")
+	case DW_FORM_data8:
+		v.u = d.FieldU64("value")
+	case DW_FORM_data16:
+		d.FieldRawLen("value", 16*8)
+	case DW_FORM_sdata:
+		v.u = uint64(d.FieldSLEB128("value"))
+	case DW_FORM_udata:
+		v.u = d.FieldULEB128("value")
+	case DW_FORM_string:
+		v.s = d.FieldUTF8Null("value")
+	case DW_FORM_strp:
+		v.u = d.FieldU("value", u.offsetSize*8, dc.strMapper(DEBUG_STR))
+		v.s, _ = dc.str(DEBUG_STR, v.u)
+	case DW_FORM_line_strp:
+		v.u = d.FieldU("value", u.offsetSize*8, dc.strMapper(DEBUG_LINE_STR))
+		v.s, _ = dc.str(DEBUG_LINE_STR, v.u)
+	case DW_FORM_strp_sup, DW_FORM_GNU_strp_alt:
+		v.u = d.FieldU("value", u.offsetSize*8, scalar.UintHex)
+	case DW_FORM_strx, DW_FORM_GNU_str_index:
+		v.u = d.FieldULEB128("value", dc.strxMapper(u))
+		v.s, _ = dc.strx(u, v.u)
+	case DW_FORM_strx1, DW_FORM_strx2, DW_FORM_strx3, DW_FORM_strx4:
+		v.u = d.FieldU("value", int(form-DW_FORM_strx1+1)*8, dc.strxMapper(u))
+		v.s, _ = dc.strx(u, v.u)
+	case DW_FORM_addrx, DW_FORM_GNU_addr_index:
+		v.u = d.FieldULEB128("value", dc.addrxMapper(u))
+	case DW_FORM_addrx1, DW_FORM_addrx2, DW_FORM_addrx3, DW_FORM_addrx4:
+		v.u = d.FieldU("value", int(form-DW_FORM_addrx1+1)*8, dc.addrxMapper(u))
+	case DW_FORM_ref1:
+		v.u = d.FieldU8("value", scalar.UintHex)
+	case DW_FORM_ref2:
+		v.u = d.FieldU16("value", scalar.UintHex)
+	case DW_FORM_ref4, DW_FORM_ref_sup4:
+		v.u = d.FieldU32("value", scalar.UintHex)
+	case DW_FORM_ref8, DW_FORM_ref_sup8, DW_FORM_ref_sig8:
+		v.u = d.FieldU64("value", scalar.UintHex)
+	case DW_FORM_ref_udata:
+		v.u = d.FieldULEB128("value", scalar.UintHex)
+	case DW_FORM_ref_addr:
+		// address size in version 2
+		if u.version == 2 {
+			v.u = d.FieldU("value", u.addressSize*8, scalar.UintHex)
+		} else {
+			v.u = d.FieldU("value", u.offsetSize*8, scalar.UintHex)
+		}
+	case DW_FORM_sec_offset, DW_FORM_GNU_ref_alt:
+		v.u = d.FieldU("value", u.offsetSize*8, scalar.UintHex)
+	case DW_FORM_loclistx, DW_FORM_rnglistx:
+		v.u = d.FieldULEB128("value")
+	case DW_FORM_flag_present:
+		v.u = 1
+		d.FieldValueBool("value", true)
+	case DW_FORM_implicit_const:
+		v.u = uint64(implicitConst)
+		d.FieldValueSint("value", implicitConst)
+	case DW_FORM_block1:
+		blockFn(8)
+	case DW_FORM_block2:
+		blockFn(16)
+	case DW_FORM_block4:
+		blockFn(32)
+	case DW_FORM_block, DW_FORM_exprloc:
+		blockFn(0)
+	case DW_FORM_indirect:
+		form = d.FieldULEB128("indirect_form", dwFormNames, scalar.UintHex)
+		return dwarfFieldFormValue(d, dc, u, form, implicitConst)
+	default:
+		d.Fatalf("unknown form %x", form)
+	}
+
+	return v
+}
+
+// reads attribute value without decoding fields, used to find attributes needed before decoding
+func dwarfReadFormValue(d *decode.D, u *dwarfUnit, form uint64, implicitConst int64) uint64 {
+	switch form {
+	case DW_FORM_addr:
+		return d.U(u.addressSize * 8)
+	case DW_FORM_data1, DW_FORM_flag, DW_FORM_ref1, DW_FORM_strx1, DW_FORM_addrx1:
+		return d.U8()
+	case DW_FORM_data2, DW_FORM_ref2, DW_FORM_strx2, DW_FORM_addrx2:
+		return d.U16()
+	case DW_FORM_strx3, DW_FORM_addrx3:
+		return d.U24()
+	case DW_FORM_data4, DW_FORM_ref4, DW_FORM_ref_sup4, DW_FORM_strx4, DW_FORM_addrx4:
+		return d.U32()
+	case DW_FORM_data8, DW_FORM_ref8, DW_FORM_ref_sup8, DW_FORM_ref_sig8:
+		return d.U64()
+	case DW_FORM_data16:
+		d.SeekRel(16 * 8)
+	case DW_FORM_sdata:
+		return uint64(d.SLEB128())
+	case DW_FORM_udata, DW_FORM_ref_udata, DW_FORM_strx, DW_FORM_addrx, DW_FORM_loclistx,
+		DW_FORM_rnglistx, DW_FORM_GNU_addr_index, DW_FORM_GNU_str_index:
+		return d.ULEB128()
+	case DW_FORM_string:
+		d.UTF8Null()
+	case DW_FORM_strp, DW_FORM_line_strp, DW_FORM_strp_sup, DW_FORM_sec_offset,
+		DW_FORM_GNU_ref_alt, DW_FORM_GNU_strp_alt:
+		return d.U(u.offsetSize * 8)
+	case DW_FORM_ref_addr:
+		if u.version == 2 {
+			return d.U(u.addressSize * 8)
+		}
+		return d.U(u.offsetSize * 8)
+	case DW_FORM_flag_present:
+		return 1
+	case DW_FORM_implicit_const:
+		return uint64(implicitConst)
+	case DW_FORM_block1:
+		d.SeekRel(int64(d.U8()) * 8)
+	case DW_FORM_block2:
+		d.SeekRel(int64(d.U16()) * 8)
+	case DW_FORM_block4:
+		d.SeekRel(int64(d.U32()) * 8)
+	case DW_FORM_block, DW_FORM_exprloc:
+		d.SeekRel(int64(d.ULEB128()) * 8)
+	case DW_FORM_indirect:
+		return dwarfReadFormValue(d, u, d.ULEB128(), implicitConst)
+	default:
+		d.Fatalf("unknown form %x", form)
+	}
+	return 0
+}
+
+// strx and addrx forms depend on base attributes in the unit DIE that can come after
+// attributes using them so read them first
+func dwarfReadUnitBases(d *decode.D, u *dwarfUnit, abbrevs map[uint64]dwarfAbbrev) {
+	// default to first contribution that starts after a header
+	u.strOffsetsBase = uint64(u.offsetSize * 2)
+	u.addrBase = uint64(u.offsetSize * 2)
+	if u.version < 5 {
+		u.strOffsetsBase = 0
+		u.addrBase = 0
+	}
+
+	pos := d.Pos()
+	defer d.SeekAbs(pos)
+	a, ok := abbrevs[d.ULEB128()]
+	if !ok {
+		return
+	}
+	for _, s := range a.attrs {
+		v := dwarfReadFormValue(d, u, s.form, s.implicitConst)
+		switch s.attr {
+		case DW_AT_str_offsets_base:
+			u.strOffsetsBase = v
+		case DW_AT_addr_base, DW_AT_GNU_addr_base:
+			u.addrBase = v
+		}
+	}
+}
+
+func dwarfDecodeDIEs(d *decode.D, dc *dwarfContext, u *dwarfUnit, abbrevs map[uint64]dwarfAbbrev, topLevel bool) {
+	for !d.End() {
+		var code uint64
+		d.FieldStruct("die", func(d *decode.D) {
+			code = d.FieldULEB128("abbrev_code")
+			if code == 0 {
+				return
+			}
+			a, ok := abbrevs[code]
+			if !ok {
+				d.Fatalf("unknown abbreviation code %d", code)
+			}
+			d.FieldValueUint("tag", a.tag, dwTagNames, scalar.UintHex)
+			d.FieldArray("attributes", func(d *decode.D) {
+				for _, s := range a.attrs {
+					d.FieldStruct("attribute", func(d *decode.D) {
+						d.FieldValueUint("attribute", s.attr, dwAttributeNames, scalar.UintHex)
+						d.FieldValueUint("form", s.form, dwFormNames, scalar.UintHex)
+						dwarfFieldFormValue(d, dc, u, s.form, s.implicitConst)
+					})
+				}
+			})
+			if a.children {
+				d.FieldArray("children", func(d *decode.D) {
+					dwarfDecodeDIEs(d, dc, u, abbrevs, false)
+				})
+			}
+		})
+		// null entry ends list of siblings
+		if code == 0 && !topLevel {
+			return
+		}
+	}
+}
+
+func dwarfDecodeUnits(d *decode.D, dc *dwarfContext, isTypes bool) {
+	for !d.End() {
+		d.FieldStruct("compile_unit", func(d *decode.D) {
+			d.FieldValueUint("offset", uint64(d.Pos())/8, scalar.UintHex)
+			unitLength, offsetSize := dwarfFieldUnitLength(d)
+			d.FramedFn(unitLength*8, func(d *decode.D) {
+				u := &dwarfUnit{offsetSize: offsetSize}
+				u.version = int(d.FieldU16("version"))
+				var abbrevOffset uint64
+				switch {
+				case u.version >= 2 && u.version <= 4:
+					abbrevOffset = d.FieldU("debug_abbrev_offset", offsetSize*8, scalar.UintHex)
+					u.addressSize = int(d.FieldU8("address_size"))
+					if isTypes {
+						d.FieldU64("type_signature", scalar.UintHex)
+						d.FieldU("type_offset", offsetSize*8, scalar.UintHex)
+					}
+				case u.version == 5:
+					unitType := d.FieldU8("unit_type", dwUnitTypeNames)
+					u.addressSize = int(d.FieldU8("address_size"))
+					abbrevOffset = d.FieldU("debug_abbrev_offset", offsetSize*8, scalar.UintHex)
+					switch unitType {
+					case DW_UT_skeleton, DW_UT_split_compile:
+						d.FieldU64("dwo_id", scalar.UintHex)
+					case DW_UT_type, DW_UT_split_type:
+						d.FieldU64("type_signature", scalar.UintHex)
+						d.FieldU("type_offset", offsetSize*8, scalar.UintHex)
+					}
+				default:
+					d.Fatalf("unsupported version %d", u.version)
+				}
+				switch u.addressSize {
+				case 1, 2, 4, 8:
+				default:
+					d.Fatalf("unsupported address size %d", u.addressSize)
+				}
+
+				abbrevs, ok := dc.abbrevTable(abbrevOffset)
+				if !ok {
+					d.FieldRawLen("dies", d.BitsLeft())
+					return
+				}
+				dwarfReadUnitBases(d, u, abbrevs)
+				d.FieldArray("dies", func(d *decode.D) {
+					dwarfDecodeDIEs(d, dc, u, abbrevs, true)
+				})
+			})
+		})
+	}
+}
+
+func dwarfDecodeInfo(d *decode.D, dc *dwarfContext) {
+	dwarfDecodeUnits(d, dc, false)
+}
+
+func dwarfDecodeTypes(d *decode.D, dc *dwarfContext) {
+	dwarfDecodeUnits(d, dc, true)
+}
+
+func dwarfDecodeAddressRanges(d *decode.D, dc *dwarfContext) {
+	for !d.End() {
+		d.FieldStruct("address_range", func(d *decode.D) {
+			start := d.Pos()
+			unitLength, offsetSize := dwarfFieldUnitLength(d)
+			d.FramedFn(unitLength*8, func(d *decode.D) {
+				d.FieldU16("version")
+				d.FieldU("debug_info_offset", offsetSize*8, scalar.UintHex)
+				addressSize := int(d.FieldU8("address_size"))
+				segmentSize := int(d.FieldU8("segment_selector_size"))
+				switch addressSize {
+				case 1, 2, 4, 8:
+				default:
+					d.Fatalf("unsupported address size %d", addressSize)
+				}
+				// tuples are aligned to tuple size from start of set
+				tupleSize := int64(segmentSize+2*addressSize) * 8
+				if padding := (tupleSize - (d.Pos()-start)%tupleSize) % tupleSize; padding > 0 {
+					d.FieldRawLen("padding", padding)
+				}
+				d.FieldArray("tuples", func(d *decode.D) {
+					for d.BitsLeft() >= tupleSize {
+						var address, length uint64
+						d.FieldStruct("tuple", func(d *decode.D) {
+							if segmentSize > 0 {
+								d.FieldU("segment_selector", segmentSize*8)
+							}
+							address = d.FieldU("address", addressSize*8, scalar.UintHex)
+							length = d.FieldU("length", addressSize*8)
+						})
+						if address == 0 && length == 0 {
+							break
+						}
+					}
+				})
+				if d.BitsLeft() > 0 {
+					d.FieldRawLen("padding", d.BitsLeft())
+				}
+			})
+		})
+	}
+}
+
+// decodes a v5 directory or file name entry format and entries
+func dwarfFieldEntries(d *decode.D, dc *dwarfContext, u *dwarfUnit, formatName string, entriesName string, entryName string, fn func(path string, dirIndex uint64)) {
+	type entryFormat struct {
+		contentType uint64
+		form        uint64
+	}
+	var formats []entryFormat
+	formatCount := d.FieldU8(formatName + "_count")
+	d.FieldArray(formatName, func(d *decode.D) {
+		for i := uint64(0); i < formatCount; i++ {
+			d.FieldStruct("format", func(d *decode.D) {
+				contentType := d.FieldULEB128("content_type", dwLineContentTypeNames, scalar.UintHex)
+				form := d.FieldULEB128("form", dwFormNames, scalar.UintHex)
+				formats = append(formats, entryFormat{contentType: contentType, form: form})
+			})
+		}
+	})
+	count := d.FieldULEB128(entriesName + "_count")
+	d.FieldArray(entriesName, func(d *decode.D) {
+		for i := uint64(0); i < count; i++ {
+			d.FieldStruct(entryName, func(d *decode.D) {
+				var path string
+				var dirIndex uint64
+				for _, f := range formats {
+					name := "unknown"
+					if s, ok := dwLineContentTypeNames[f.contentType]; ok {
+						name = s
+					}
+					d.FieldStruct(name, func(d *decode.D) {
+						v := dwarfFieldFormValue(d, dc, u, f.form, 0)
+						switch f.contentType {
+						case DW_LNCT_path:
+							path = v.s
+						case DW_LNCT_directory_index:
+							dirIndex = v.u
+						}
+					})
+				}
+				fn(path, dirIndex)
+			})
+		}
+	})
+}
+
+func dwarfJoinPath(dir string, name string) string {
+	if dir == "" || strings.HasPrefix(name, "/") {
+		return name
+	}
+	return dir + "/" + name
+}
+
+func dwarfDecodeLinePrograms(d *decode.D, dc *dwarfContext) {
+	for !d.End() {
+		d.FieldStruct("line_program", func(d *decode.D) {
+			d.FieldValueUint("offset", uint64(d.Pos())/8, scalar.UintHex)
+			unitLength, offsetSize := dwarfFieldUnitLength(d)
+			d.FramedFn(unitLength*8, func(d *decode.D) {
+				dwarfDecodeLineProgram(d, dc, offsetSize)
+			})
+		})
+	}
+}
+
+func dwarfDecodeLineProgram(d *decode.D, dc *dwarfContext, offsetSize int) {
+	u := &dwarfUnit{offsetSize: offsetSize, addressSize: dc.addressSize}
+	u.version = int(d.FieldU16("version"))
+	if u.version < 2 || u.version > 5 {
+		d.Fatalf("unsupported version %d", u.version)
+	}
+	if u.version >= 5 {
+		u.addressSize = int(d.FieldU8("address_size"))
+		d.FieldU8("segment_selector_size")
+	}
+	headerLength := d.FieldU("header_length", offsetSize*8)
+	programStart := d.Pos() + int64(headerLength)*8
+	minInstLength := d.FieldU8("minimum_instruction_length")
+	if u.version >= 4 {
+		d.FieldU8("maximum_operations_per_instruction")
+	}
+	d.FieldU8("default_is_stmt")
+	lineBase := d.FieldS8("line_base")
+	lineRange := d.FieldU8("line_range")
+	if lineRange == 0 {
+		d.Fatalf("line_range is zero")
+	}
+	opcodeBase := d.FieldU8("opcode_base")
+	var standardOpcodeLengths []uint64
+	d.FieldArray("standard_opcode_lengths", func(d *decode.D) {
+		for i := uint64(1); i < opcodeBase; i++ {
+			standardOpcodeLengths = append(standardOpcodeLengths, d.FieldU8("length"))
+		}
+	})
+
+	// file and directory indexes as used by the program, zero is current
+	// directory and one is first file before version 5
+	dirs := scalar.UintMapSymStr{}
+	files := scalar.UintMapSymStr{}
+	addFile := func(path string, dirIndex uint64) {
+		index := uint64(len(files))
+		if u.version < 5 {
+			index++
+		}
+		files[index] = dwarfJoinPath(dirs[dirIndex], path)
+	}
+
+	if u.version >= 5 {
+		dwarfFieldEntries(d, dc, u, "directory_entry_format", "directories", "directory", func(path string, _ uint64) {
+			dirs[uint64(len(dirs))] = path
+		})
+		dwarfFieldEntries(d, dc, u, "file_name_entry_format", "file_names", "file_name", addFile)
+	} else {
+		d.FieldArray("include_directories", func(d *decode.D) {
+			for d.PeekUintBits(8) != 0 {
+				dirs[uint64(len(dirs))+1] = d.FieldUTF8Null("include_directory")
+			}
+		})
+		d.FieldU8("include_directories_terminator")
+		d.FieldArray("file_names", func(d *decode.D) {
+			for d.PeekUintBits(8) != 0 {
+				d.FieldStruct("file_name", func(d *decode.D) {
+					path := d.FieldUTF8Null("name")
+					dirIndex := d.FieldULEB128("directory_index", dirs)
+					d.FieldULEB128("modification_time")
+					d.FieldULEB128("length")
+					addFile(path, dirIndex)
+				})
+			}
+		})
+		d.FieldU8("file_names_terminator")
+	}
+	if d.Pos() < programStart {
+		d.FieldRawLen("unknown_header", programStart-d.Pos())
+	}
+	d.SeekAbs(programStart)
+
+	// state machine registers, op_index is ignored as VLIW is not supported
+	var address uint64
+	var file uint64
+	var line int64
+	var column uint64
+	resetRegisters := func() {
+		address = 0
+		file = 1
+		line = 1
+		column = 0
+	}
+	resetRegisters()
+
+	// fields for a new row in the line number matrix
+	rowFields := func(d *decode.D) {
+		d.FieldValueUint("address", address, scalar.UintHex)
+		d.FieldValueUint("file", file, files)
+		d.FieldValueUint("line", uint64(line))
+		d.FieldValueUint("column", column)
+	}
+	advanceAddress := func(operationAdvance uint64) {
+		address += operationAdvance * minInstLength
+	}
+	opcodeNames := scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+		if s.Actual >= opcodeBase {
+			s.Sym = "special"
+			return s, nil
+		}
+		return dwLineStandardOpcodeNames.MapUint(s)
+	})
+
+	d.FieldArray("opcodes", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("opcode", func(d *decode.D) {
+				opcode := d.FieldU8("opcode", opcodeNames)
+				switch {
+				case opcode >= opcodeBase:
+					adjustedOpcode := opcode - opcodeBase
+					advanceAddress(adjustedOpcode / lineRange)
+					line += lineBase + int64(adjustedOpcode%lineRange)
+					rowFields(d)
+				case opcode == 0:
+					length := d.FieldULEB128("length")
+					if length == 0 {
+						return
+					}
+					d.FramedFn(int64(length)*8, func(d *decode.D) {
+						extendedOpcode := d.FieldU8("extended_opcode", dwLineExtendedOpcodeNames)
+						switch extendedOpcode {
+						case DW_LNE_end_sequence:
+							rowFields(d)
+							resetRegisters()
+						case DW_LNE_set_address:
+							if d.BitsLeft() != int64(u.addressSize)*8 {
+								d.FieldRawLen("data", d.BitsLeft())
+								return
+							}
+							address = d.FieldU("address", u.addressSize*8, scalar.UintHex)
+						case DW_LNE_define_file:
+							path := d.FieldUTF8Null("name")
+							dirIndex := d.FieldULEB128("directory_index", dirs)
+							d.FieldULEB128("modification_time")
+							d.FieldULEB128("length")
+							addFile(path, dirIndex)
+						case DW_LNE_set_discriminator:
+							d.FieldULEB128("discriminator")
+						default:
+							d.FieldRawLen("data", d.BitsLeft())
+						}
+					})
+				case opcode == DW_LNS_copy:
+					rowFields(d)
+				case opcode == DW_LNS_advance_pc:
+					advanceAddress(d.FieldULEB128("advance"))
+				case opcode == DW_LNS_advance_line:
+					line += d.FieldSLEB128("advance")
+				case opcode == DW_LNS_set_file:
+					file = d.FieldULEB128("file", files)
+				case opcode == DW_LNS_set_column:
+					column = d.FieldULEB128("column")
+				case opcode == DW_LNS_const_add_pc:
+					advanceAddress((255 - opcodeBase) / lineRange)
+				case opcode == DW_LNS_fixed_advance_pc:
+					address += d.FieldU16("advance")
+				case opcode == DW_LNS_set_isa:
+					d.FieldULEB128("isa")
+				case opcode == DW_LNS_negate_stmt,
+					opcode == DW_LNS_set_basic_block,
+					opcode == DW_LNS_set_prologue_end,
+					opcode == DW_LNS_set_epilogue_begin:
+				default:
+					// unknown standard opcode, skip operands
+					d.FieldArray("operands", func(d *decode.D) {
+						for i := uint64(0); i < standardOpcodeLengths[opcode-1]; i++ {
+							d.FieldULEB128("operand")
+						}
+					})
+				}
+			})
+		}
+	})
+}
+
+type dwarfSectionDecoder struct {
+	arrayName string
+	fn        func(d *decode.D, dc *dwarfContext)
+}
+
+var dwarfSectionDecoders = map[string]dwarfSectionDecoder{
+	DEBUG_ABBREV:   {"abbreviation_tables", dwarfDecodeAbbrevTables},
+	DEBUG_ARANGES:  {"address_ranges", dwarfDecodeAddressRanges},
+	DEBUG_INFO:     {"compile_units", dwarfDecodeInfo},
+	DEBUG_LINE:     {"line_programs", dwarfDecodeLinePrograms},
+	DEBUG_LINE_STR: {"strings", dwarfDecodeStrings},
+	DEBUG_STR:      {"strings", dwarfDecodeStrings},
+	DEBUG_TYPES:    {"compile_units", dwarfDecodeTypes},
+}
+
+// decodes section data as a format so that a broken section is added as raw bits
+// instead of failing the whole ELF
+func dwarfFieldSectionData(d *decode.D, ec elfContext, sd dwarfSectionDecoder) {
+	dv, _, _ := d.TryFieldFormatLen(sd.arrayName, d.BitsLeft(), &decode.Group{Formats: []*decode.Format{{
+		Name:      "dwarf",
+		RootArray: true,
+		DecodeFn: func(d *decode.D) any {
+			d.Endian = ec.endian
+			sd.fn(d, ec.dwarf)
+			return nil
+		},
+	}}}, nil)
+	if dv == nil {
+		d.FieldRawLen("data", d.BitsLeft())
+	}
+}
+
+func elfDecodeDWARFSection(d *decode.D, ec elfContext, sh sectionHeader, name string, size int64) {
+	dwarfName, _ := dwarfSectionName(name)
+	sd, hasDecoder := dwarfSectionDecoders[dwarfName]
+
+	uncompressedFn := func(d *decode.D) {
+		bs, ok := ec.dwarf.sections[dwarfName]
+		if !ok {
+			return
+		}
+		br := bitio.NewBitReader(bs, -1)
+		if !hasDecoder {
+			d.FieldRootBitBuf("uncompressed", br)
+			return
+		}
+		d.FieldStructRootBitBufFn("uncompressed", br, func(d *decode.D) {
+			dwarfFieldSectionData(d, ec, sd)
+		})
+	}
+
+	d.FramedFn(size, func(d *decode.D) {
+		switch {
+		case sh.flags&SHF_COMPRESSED != 0:
+			d.FieldStruct("compression_header", func(d *decode.D) {
+				d.FieldU32("type", compressionTypeNames)
+				if ec.archBits == 64 {
+					d.FieldU32("reserved")
+				}
+				d.FieldU("size", ec.archBits)
+				d.FieldU("addralign", ec.archBits)
+			})
+			d.FieldRawLen("compressed", d.BitsLeft())
+			uncompressedFn(d)
+		case name != dwarfName:
+			// .zdebug_* sections has a "ZLIB" magic and big endian size
+			d.FieldStruct("compression_header", func(d *decode.D) {
+				d.FieldUTF8("magic", 4, d.StrAssert("ZLIB"))
+				d.FieldU64BE("size")
+			})
+			d.FieldRawLen("compressed", d.BitsLeft())
+			uncompressedFn(d)
+		case hasDecoder:
+			dwarfFieldSectionData(d, ec, sd)
+		default:
+			d.FieldRawLen("data", d.BitsLeft())
+		}
+	})
+}
@@ -5,9 +5,8 @@ package elf
 // https://github.com/torvalds/linux/blob/master/include/uapi/linux/elf.h
 // https://sourceware.org/git/?p=binutils-gdb.git;a=blob;f=include/elf/external.h;hb=HEAD
 
-// TODO: dwarf
-
 import (
+	"embed"
 	"strings"
 
 	"github.com/wader/fq/format"
@@ -16,6 +15,9 @@ import (
 	"github.com/wader/fq/pkg/scalar"
 )
 
+//go:embed elf.md
+var elfFS embed.FS
+
 func init() {
 	interp.RegisterFormat(
 		format.ELF,
@@ -24,6 +26,7 @@ func init() {
 			Groups:      []*decode.Group{format.Probe},
 			DecodeFn:    elfDecode,
 		})
+	interp.RegisterFS(elfFS)
 }
 
 const (
@@ -83,15 +86,18 @@ var typeNames = scalar.UintRangeToScalar{
 }
 
 const (
+	EM_386    = 0x03
+	EM_ARM    = 0x28
 	EM_X86_64 = 0x3e
 	EM_ARM64  = 0xb7
+	EM_RISCV  = 0xf3
 )
 
 var machineNames = scalar.UintMap{
 	0x00:      {Description: "No specific instruction set"},
 	0x01:      {Sym: "we_32100", Description: "AT&T WE 32100"},
 	0x02:      {Sym: "sparc", Description: "SPARC"},
-	0x03:      {Sym: "x86", Description: "x86"},
+	EM_386:    {Sym: "x86", Description: "x86"},
 	0x04:      {Sym: "m68k", Description: "Motorola 68000 (M68k)"},
 	0x05:      {Sym: "m88k", Description: "Motorola 88000 (M88k)"},
 	0x06:      {Sym: "intel_mcu", Description: "Intel MCU"},
@@ -110,7 +116,7 @@ var machineNames = scalar.UintMap{
 	0x25:      {Sym: "fr20", Description: "Fujitsu FR20"},
 	0x26:      {Sym: "trw_rh_32", Description: "TRW RH-32"},
 	0x27:      {Sym: "motorola_rce", Description: "Motorola RCE"},
-	0x28:      {Sym: "arm", Description: "ARM (up to ARMv7/Aarch32)"},
+	EM_ARM:    {Sym: "arm", Description: "ARM (up to ARMv7/Aarch32)"},
 	0x29:      {Sym: "alpha", Description: "Digital Alpha"},
 	0x2a:      {Sym: "superh", Description: "SuperH"},
 	0x2b:      {Sym: "sparc_v9", Description: "SPARC Version 9"},
@@ -135,7 +141,7 @@ var machineNames = scalar.UintMap{
 	EM_X86_64: {Sym: "x86_64", Description: "AMD x86-64"},
 	0x8c:      {Sym: "tms320C6000", Description: "TMS320C6000 Family"},
 	EM_ARM64:  {Sym: "arm64", Description: "ARM 64-bits (ARMv8/Aarch64)"},
-	0xf3:      {Sym: "risc_v", Description: "RISC-V"},
+	EM_RISCV:  {Sym: "risc_v", Description: "RISC-V"},
 	0xf7:      {Sym: "bpf", Description: "Berkeley Packet Filter"},
 	0x101:     {Sym: "wdc_65C816", Description: "WDC 65C816"},
 }
@@ -323,6 +329,9 @@ const (
 	SHT_GROUP         = 0x11
 	SHT_SYMTAB_SHNDX  = 0x12
 	SHT_GNU_HASH      = 0x6ffffff6
+	SHT_GNU_VERDEF    = 0x6ffffffd
+	SHT_GNU_VERNEED   = 0x6ffffffe
+	SHT_GNU_VERSYM    = 0x6fffffff
 )
 
 var sectionHeaderTypeMap = scalar.UintMap{
@@ -344,6 +353,9 @@ var sectionHeaderTypeMap = scalar.UintMap{
 	SHT_GROUP:         {Sym: "group", Description: "Section group"},
 	SHT_SYMTAB_SHNDX:  {Sym: "symtab_shndx", Description: ""},
 	SHT_GNU_HASH:      {Sym: "gnu_hash", Description: "GNU symbol hash table"},
+	SHT_GNU_VERDEF:    {Sym: "gnu_verdef", Description: "GNU symbol version definitions"},
+	SHT_GNU_VERNEED:   {Sym: "gnu_verneed", Description: "GNU symbol version requirements"},
+	SHT_GNU_VERSYM:    {Sym: "gnu_versym", Description: "GNU symbol version table"},
 }
 
 const (
@@ -667,8 +679,12 @@ type sectionHeader struct {
 	entSize int64
 	name    int
 	typ     int
+	flags   uint64
+	link    int
+	info    int
 	dc      dynamicContext // if SHT_DYNAMIC
-	symbols []symbol
+	symbols []symbol       // if SHT_SYMTAB or SHT_DYNSYM
+	strTab  string         // if SHT_STRTAB
 }
 
 const maxStrTabSize = 100_000_000
@@ -689,23 +705,23 @@ func elfReadSectionHeaders(d *decode.D, ec *elfContext) {
 		case 32:
 			sh.name = int(d.U32())
 			sh.typ = int(d.U32())
-			d.U32()                      // flags
-			sh.addr = int64(d.U32() * 8) // addr
+			sh.flags = d.U32()
+			sh.addr = int64(d.U32() * 8)
 			sh.offset = int64(d.U32()) * 8
 			sh.size = int64(d.U32()) * 8
-			d.U32() // link
-			d.U32() // info
+			sh.link = int(d.U32())
+			sh.info = int(d.U32())
 			d.U32() // addralign
 			sh.entSize = int64(d.U32()) * 8
 		case 64:
 			sh.name = int(d.U32())
 			sh.typ = int(d.U32())
-			d.U64()                      // addr
-			sh.addr = int64(d.U64() * 8) // flags
+			sh.flags = d.U64()
+			sh.addr = int64(d.U64() * 8)
 			sh.offset = int64(d.U64()) * 8
 			sh.size = int64(d.U64()) * 8
-			d.U32() // link
-			d.U32() // info
+			sh.link = int(d.U32())
+			sh.info = int(d.U32())
 			d.U64() // addralign
 			sh.entSize = int64(d.U64()) * 8
 		default:
@@ -716,9 +732,11 @@ func elfReadSectionHeaders(d *decode.D, ec *elfContext) {
 		case SHT_DYNAMIC:
 			d.SeekAbs(sh.offset)
 			sh.dc = elfReadDynamicTags(d, ec)
-		case SHT_SYMTAB:
-			d.SeekAbs(sh.offset)
-			sh.symbols = elfReadSymbolTable(d, ec, sh)
+		case SHT_SYMTAB, SHT_DYNSYM:
+			if sh.entSize > 0 {
+				d.SeekAbs(sh.offset)
+				sh.symbols = elfReadSymbolTable(d, ec, sh)
+			}
 		}
 
 		ec.sections = append(ec.sections, sh)
@@ -748,12 +766,41 @@ func elfReadSectionHeaders(d *decode.D, ec *elfContext) {
 		shStr := ec.sections[ec.shStrNdx]
 		shStrTab = readStrTab(d, shStr.offset, shStr.size/8)
 
-		for _, sh := range ec.sections {
+		for i := range ec.sections {
+			sh := &ec.sections[i]
 			if sh.typ != SHT_STRTAB {
 				continue
 			}
-			ec.strTabMap[strIndexNull(sh.name, shStrTab)] = readStrTab(d, sh.offset, sh.size/8)
+			sh.strTab = readStrTab(d, sh.offset, sh.size/8)
+			ec.strTabMap[strIndexNull(sh.name, shStrTab)] = sh.strTab
+		}
+	}
+
+	ec.versionNames = elfReadVersionNames(d, ec)
+}
+
+// linkedStrTab returns string table linked to by a section, ex: symbol table names
+func (ec *elfContext) linkedStrTab(sh sectionHeader) string {
+	if sh.link < 0 || sh.link >= len(ec.sections) {
+		return ""
+	}
+	return ec.sections[sh.link].strTab
+}
+
+// symbolNames maps symbol index to name for symbol table section with index
+func (ec *elfContext) symbolNames(symTabIndex int) scalar.UintFn {
+	return func(s scalar.Uint) (scalar.Uint, error) {
+		if symTabIndex < 0 || symTabIndex >= len(ec.sections) {
+			return s, nil
+		}
+		symTab := ec.sections[symTabIndex]
+		if s.Actual >= uint64(len(symTab.symbols)) {
+			return s, nil
 		}
+		if name := strIndexNull(int(symTab.symbols[s.Actual].name), ec.linkedStrTab(symTab)); name != "" {
+			s.Sym = name
+		}
+		return s, nil
 	}
 }
 
@@ -773,8 +820,10 @@ type elfContext struct {
 
 	shStrNdx int
 
-	sections  []sectionHeader
-	strTabMap map[string]string
+	sections     []sectionHeader
+	strTabMap    map[string]string
+	versionNames map[uint64]string
+	dwarf        *dwarfContext
 }
 
 func (ec *elfContext) sectionIndexByAddr(addr int64) (int, bool) {
@@ -846,6 +895,375 @@ func elfDecodeHeader(d *decode.D, ec *elfContext) {
 	ec.shStrNdx = int(shStrNdx)
 }
 
+const (
+	NT_GNU_ABI_TAG         = 1
+	NT_GNU_HWCAP           = 2
+	NT_GNU_BUILD_ID        = 3
+	NT_GNU_GOLD_VERSION    = 4
+	NT_GNU_PROPERTY_TYPE_0 = 5
+)
+
+var gnuNoteNames = scalar.UintMap{
+	NT_GNU_ABI_TAG:         {Sym: "gnu_abi_tag", Description: "ABI version tag"},
+	NT_GNU_HWCAP:           {Sym: "gnu_hwcap", Description: "Hardware capabilities"},
+	NT_GNU_BUILD_ID:        {Sym: "gnu_build_id", Description: "Unique build ID"},
+	NT_GNU_GOLD_VERSION:    {Sym: "gnu_gold_version", Description: "Gold linker version"},
+	NT_GNU_PROPERTY_TYPE_0: {Sym: "gnu_property_type_0", Description: "Program properties"},
+}
+
+const (
+	NT_GO_BUILD_ID = 4
+)
+
+var goNoteNames = scalar.UintMap{
+	NT_GO_BUILD_ID: {Sym: "go_build_id", Description: "Go build ID"},
+}
+
+var gnuABITagOSNames = scalar.UintMapSymStr{
+	0: "linux",
+	1: "hurd",
+	2: "solaris",
+	3: "freebsd",
+}
+
+const (
+	GNU_PROPERTY_STACK_SIZE            = 1
+	GNU_PROPERTY_NO_COPY_ON_PROTECTED  = 2
+	GNU_PROPERTY_1_NEEDED              = 0xb0008000
+	GNU_PROPERTY_X86_FEATURE_1_AND     = 0xc0000002
+	GNU_PROPERTY_X86_FEATURE_2_NEEDED  = 0xc0008001
+	GNU_PROPERTY_X86_ISA_1_NEEDED      = 0xc0008002
+	GNU_PROPERTY_X86_FEATURE_2_USED    = 0xc0010001
+	GNU_PROPERTY_X86_ISA_1_USED        = 0xc0010002
+	GNU_PROPERTY_AARCH64_FEATURE_1_AND = 0xc0000000
+)
+
+var gnuPropertyTypeNames = scalar.UintMapSymStr{
+	GNU_PROPERTY_STACK_SIZE:           "stack_size",
+	GNU_PROPERTY_NO_COPY_ON_PROTECTED: "no_copy_on_protected",
+	GNU_PROPERTY_1_NEEDED:             "1_needed",
+}
+
+// processor specific properties share type values
+var gnuPropertyMachineTypeNames = map[int]scalar.UintMapSymStr{
+	EM_386: {
+		GNU_PROPERTY_X86_FEATURE_1_AND:    "x86_feature_1_and",
+		GNU_PROPERTY_X86_FEATURE_2_NEEDED: "x86_feature_2_needed",
+		GNU_PROPERTY_X86_ISA_1_NEEDED:     "x86_isa_1_needed",
+		GNU_PROPERTY_X86_FEATURE_2_USED:   "x86_feature_2_used",
+		GNU_PROPERTY_X86_ISA_1_USED:       "x86_isa_1_used",
+	},
+	EM_X86_64: {
+		GNU_PROPERTY_X86_FEATURE_1_AND:    "x86_feature_1_and",
+		GNU_PROPERTY_X86_FEATURE_2_NEEDED: "x86_feature_2_needed",
+		GNU_PROPERTY_X86_ISA_1_NEEDED:     "x86_isa_1_needed",
+		GNU_PROPERTY_X86_FEATURE_2_USED:   "x86_feature_2_used",
+		GNU_PROPERTY_X86_ISA_1_USED:       "x86_isa_1_used",
+	},
+	EM_ARM64: {
+		GNU_PROPERTY_AARCH64_FEATURE_1_AND: "aarch64_feature_1_and",
+	},
+}
+
+// bit names for properties that are bit masks
+var gnuPropertyBitNames = map[uint64][]string{
+	GNU_PROPERTY_1_NEEDED:             {"indirect_extern_access"},
+	GNU_PROPERTY_X86_FEATURE_1_AND:    {"ibt", "shstk", "lam_u48", "lam_u57"},
+	GNU_PROPERTY_X86_ISA_1_NEEDED:     {"baseline", "v2", "v3", "v4"},
+	GNU_PROPERTY_X86_ISA_1_USED:       {"baseline", "v2", "v3", "v4"},
+	GNU_PROPERTY_X86_FEATURE_2_NEEDED: {"x86", "x87", "mmx", "xmm", "ymm", "zmm", "fxsr", "xsave", "xsaveopt", "xsavec", "tmm", "mask"},
+	GNU_PROPERTY_X86_FEATURE_2_USED:   {"x86", "x87", "mmx", "xmm", "ymm", "zmm", "fxsr", "xsave", "xsaveopt", "xsavec", "tmm", "mask"},
+}
+
+var gnuPropertyAArch64BitNames = []string{"bti", "pac", "gcs"}
+
+func elfDecodeGNUProperties(d *decode.D, ec elfContext) {
+	typeNames := scalar.UintMapSymStr{}
+	for k, v := range gnuPropertyTypeNames {
+		typeNames[k] = v
+	}
+	for k, v := range gnuPropertyMachineTypeNames[ec.machine] {
+		typeNames[k] = v
+	}
+
+	d.FieldArray("properties", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("property", func(d *decode.D) {
+				typ := d.FieldU32("type", typeNames, scalar.UintHex)
+				dataSz := d.FieldU32("datasz")
+				d.FramedFn(int64(dataSz)*8, func(d *decode.D) {
+					if dataSz != 4 {
+						d.FieldRawLen("data", d.BitsLeft())
+						return
+					}
+					bitNames := gnuPropertyBitNames[typ]
+					if ec.machine == EM_ARM64 && typ == GNU_PROPERTY_AARCH64_FEATURE_1_AND {
+						bitNames = gnuPropertyAArch64BitNames
+					} else if _, ok := typeNames[typ]; !ok {
+						bitNames = nil
+					}
+					v := d.FieldU32("data", scalar.UintHex)
+					for i, n := range bitNames {
+						d.FieldValueBool(n, v&(1<<i) != 0)
+					}
+				})
+				// properties are aligned to address size
+				if align := d.AlignBits(ec.archBits); align != 0 {
+					d.FieldRawLen("padding", int64(align))
+				}
+			})
+		}
+	})
+}
+
+func elfDecodeNoteDesc(d *decode.D, ec elfContext, name string, typ uint64) {
+	switch {
+	case name == "GNU" && typ == NT_GNU_ABI_TAG:
+		d.FieldStruct("desc", func(d *decode.D) {
+			d.FieldU32("os", gnuABITagOSNames)
+			d.FieldU32("major")
+			d.FieldU32("minor")
+			d.FieldU32("subminor")
+		})
+	case name == "GNU" && typ == NT_GNU_BUILD_ID:
+		d.FieldStruct("desc", func(d *decode.D) {
+			d.FieldRawLen("build_id", d.BitsLeft())
+		})
+	case name == "GNU" && typ == NT_GNU_GOLD_VERSION:
+		d.FieldStruct("desc", func(d *decode.D) {
+			d.FieldUTF8NullFixedLen("version", int(d.BitsLeft()/8))
+		})
+	case name == "GNU" && typ == NT_GNU_PROPERTY_TYPE_0:
+		d.FieldStruct("desc", func(d *decode.D) {
+			elfDecodeGNUProperties(d, ec)
+		})
+	case name == "Go" && typ == NT_GO_BUILD_ID:
+		d.FieldStruct("desc", func(d *decode.D) {
+			d.FieldUTF8NullFixedLen("build_id", int(d.BitsLeft()/8))
+		})
+	default:
+		d.FieldRawLen("desc", d.BitsLeft())
+	}
+}
+
+func elfDecodeNotes(d *decode.D, ec elfContext) {
+	d.FieldArray("notes", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("note", func(d *decode.D) {
+				// elf manpage says this is 32 or 64 bit but it seems it is always 32
+				// and that is also what readelf external.h says
+				nameSz := d.FieldU32("n_namesz")
+				descSz := d.FieldU32("n_descsz")
+				// name is after type and decides meaning of type
+				name := strIndexNull(0, string(d.BytesRange(d.Pos()+32, int(nameSz))))
+				var typ uint64
+				switch {
+				case ec.typ == ET_CORE:
+					typ = d.FieldU32("n_type", coreNoteNames, scalar.UintHex)
+				case name == "GNU":
+					typ = d.FieldU32("n_type", gnuNoteNames, scalar.UintHex)
+				case name == "Go":
+					typ = d.FieldU32("n_type", goNoteNames, scalar.UintHex)
+				default:
+					typ = d.FieldU32("n_type", scalar.UintHex)
+				}
+				d.FieldUTF8NullFixedLen("name", int(nameSz))
+				nameAlign := d.AlignBits(4 * 8)
+				if nameAlign != 0 {
+					d.FieldRawLen("name_align", int64(nameAlign))
+				}
+				d.FramedFn(int64(descSz)*8, func(d *decode.D) {
+					elfDecodeNoteDesc(d, ec, name, typ)
+				})
+				descAlign := d.AlignBits(4 * 8)
+				if descAlign != 0 {
+					d.FieldRawLen("decs_align", int64(descAlign))
+				}
+			})
+		}
+	})
+}
+
+const (
+	VER_FLG_BASE = 0x1
+	VER_FLG_WEAK = 0x2
+)
+
+var versionFlagNames = scalar.UintMapSymStr{
+	VER_FLG_BASE: "base",
+	VER_FLG_WEAK: "weak",
+}
+
+const (
+	VER_NDX_LOCAL  = 0
+	VER_NDX_GLOBAL = 1
+	VERSYM_HIDDEN  = 0x8000
+)
+
+// elfReadVersionNames reads version index to name from version definitions and requirements
+func elfReadVersionNames(d *decode.D, ec *elfContext) map[uint64]string {
+	names := map[uint64]string{}
+
+	for _, sh := range ec.sections {
+		strTab := ec.linkedStrTab(sh)
+		switch sh.typ {
+		case SHT_GNU_VERDEF:
+			offset := sh.offset
+			for i := 0; i < sh.info && offset < sh.offset+sh.size; i++ {
+				d.SeekAbs(offset)
+				d.U16() // version
+				d.U16() // flags
+				index := d.U16()
+				count := d.U16()
+				d.U32() // hash
+				aux := d.U32()
+				next := d.U32()
+				if count > 0 {
+					d.SeekAbs(offset + int64(aux)*8)
+					names[index] = strIndexNull(int(d.U32()), strTab)
+				}
+				if next == 0 {
+					break
+				}
+				offset += int64(next) * 8
+			}
+		case SHT_GNU_VERNEED:
+			offset := sh.offset
+			for i := 0; i < sh.info && offset < sh.offset+sh.size; i++ {
+				d.SeekAbs(offset)
+				d.U16() // version
+				count := d.U16()
+				d.U32() // file
+				aux := d.U32()
+				next := d.U32()
+				auxOffset := offset + int64(aux)*8
+				for j := uint64(0); j < count; j++ {
+					d.SeekAbs(auxOffset)
+					d.U32() // hash
+					d.U16() // flags
+					other := d.U16()
+					names[other] = strIndexNull(int(d.U32()), strTab)
+					auxNext := d.U32()
+					if auxNext == 0 {
+						break
+					}
+					auxOffset += int64(auxNext) * 8
+				}
+				if next == 0 {
+					break
+				}
+				offset += int64(next) * 8
+			}
+		}
+	}
+
+	return names
+}
+
+func (ec *elfContext) versionNameMapper() scalar.UintFn {
+	return func(s scalar.Uint) (scalar.Uint, error) {
+		switch index := s.Actual &^ VERSYM_HIDDEN; index {
+		case VER_NDX_LOCAL:
+			s.Sym = "local"
+		case VER_NDX_GLOBAL:
+			s.Sym = "global"
+		default:
+			if name, ok := ec.versionNames[index]; ok {
+				s.Sym = name
+			}
+		}
+		if s.Actual&VERSYM_HIDDEN != 0 {
+			s.Description = "hidden"
+		}
+		return s, nil
+	}
+}
+
+func elfDecodeVersionSymbols(d *decode.D, ec elfContext, sh sectionHeader, size int64) {
+	symbolNames := ec.symbolNames(sh.link)
+	for i := int64(0); i < size/16; i++ {
+		d.FieldStruct("symbol_version", func(d *decode.D) {
+			d.FieldValueUint("symbol", uint64(i), symbolNames)
+			d.FieldU16("version", ec.versionNameMapper())
+		})
+	}
+}
+
+func elfDecodeVersionDefinitions(d *decode.D, ec elfContext, sh sectionHeader) {
+	strTab := strTable(ec.linkedStrTab(sh))
+	offset := sh.offset
+	for i := 0; i < sh.info && offset < sh.offset+sh.size; i++ {
+		var next uint64
+		d.SeekAbs(offset)
+		d.FieldStruct("version_definition", func(d *decode.D) {
+			d.FieldU16("version")
+			d.FieldU16("flags", versionFlagNames)
+			d.FieldU16("index")
+			count := d.FieldU16("count")
+			d.FieldU32("hash", scalar.UintHex)
+			aux := d.FieldU32("aux")
+			next = d.FieldU32("next")
+			d.FieldArray("auxiliaries", func(d *decode.D) {
+				auxOffset := offset + int64(aux)*8
+				for j := uint64(0); j < count; j++ {
+					var auxNext uint64
+					d.SeekAbs(auxOffset)
+					d.FieldStruct("auxiliary", func(d *decode.D) {
+						d.FieldU32("name", strTab)
+						auxNext = d.FieldU32("next")
+					})
+					if auxNext == 0 {
+						break
+					}
+					auxOffset += int64(auxNext) * 8
+				}
+			})
+		})
+		if next == 0 {
+			break
+		}
+		offset += int64(next) * 8
+	}
+}
+
+func elfDecodeVersionRequirements(d *decode.D, ec elfContext, sh sectionHeader) {
+	strTab := strTable(ec.linkedStrTab(sh))
+	offset := sh.offset
+	for i := 0; i < sh.info && offset < sh.offset+sh.size; i++ {
+		var next uint64
+		d.SeekAbs(offset)
+		d.FieldStruct("version_requirement", func(d *decode.D) {
+			d.FieldU16("version")
+			count := d.FieldU16("count")
+			d.FieldU32("file", strTab)
+			aux := d.FieldU32("aux")
+			next = d.FieldU32("next")
+			d.FieldArray("auxiliaries", func(d *decode.D) {
+				auxOffset := offset + int64(aux)*8
+				for j := uint64(0); j < count; j++ {
+					var auxNext uint64
+					d.SeekAbs(auxOffset)
+					d.FieldStruct("auxiliary", func(d *decode.D) {
+						d.FieldU32("hash", scalar.UintHex)
+						d.FieldU16("flags", versionFlagNames)
+						d.FieldU16("other", ec.versionNameMapper())
+						d.FieldU32("name", strTab)
+						auxNext = d.FieldU32("next")
+					})
+					if auxNext == 0 {
+						break
+					}
+					auxOffset += int64(auxNext) * 8
+				}
+			})
+		})
+		if next == 0 {
+			break
+		}
+		offset += int64(next) * 8
+	}
+}
+
 func elfDecodeProgramHeader(d *decode.D, ec elfContext) {
 	pFlags := func(d *decode.D) {
 		d.FieldStruct("flags", func(d *decode.D) {
@@ -892,31 +1310,7 @@ func elfDecodeProgramHeader(d *decode.D, ec elfContext) {
 	d.RangeFn(int64(offset*8), int64(size*8), func(d *decode.D) {
 		switch {
 		case typ == PT_NOTE:
-			d.FieldArray("notes", func(d *decode.D) {
-				for !d.End() {
-					d.FieldStruct("note", func(d *decode.D) {
-						// elf manpage says this is 32 or 64 bit but it seems it is always 32
-						// and that is also what readelf external.h says
-						nameSz := d.FieldU32("n_namesz")
-						descSz := d.FieldU32("n_descsz")
-						if ec.typ == ET_CORE {
-							d.FieldU32("n_type", coreNoteNames, scalar.UintHex)
-						} else {
-							d.FieldU32("n_type", scalar.UintHex)
-						}
-						d.FieldUTF8NullFixedLen("name", int(nameSz))
-						nameAlign := d.AlignBits(4 * 8)
-						if nameAlign != 0 {
-							d.FieldRawLen("name_align", int64(nameAlign))
-						}
-						d.FieldRawLen("desc", int64(descSz)*8)
-						descAlign := d.AlignBits(4 * 8)
-						if descAlign != 0 {
-							d.FieldRawLen("decs_align", int64(descAlign))
-						}
-					})
-				}
-			})
+			elfDecodeNotes(d, ec)
 		default:
 			d.FieldRawLen("data", d.BitsLeft())
 		}
@@ -1061,6 +1455,11 @@ func elfDecodeSectionHeader(d *decode.D, ec elfContext, sh sectionHeader) {
 	}
 
 	d.SeekAbs(offset)
+	if name := strIndexNull(sh.name, ec.strTabMap[STRTAB_SHSTRTAB]); strings.HasPrefix(name, ".debug_") || strings.HasPrefix(name, ".zdebug_") {
+		elfDecodeDWARFSection(d, ec, sh, name, size)
+		return
+	}
+
 	switch typ {
 	case SHT_STRTAB:
 		d.FieldUTF8("string", int(size/8))
@@ -1086,6 +1485,26 @@ func elfDecodeSectionHeader(d *decode.D, ec elfContext, sh sectionHeader) {
 		d.FieldStruct("gnu_hash", func(d *decode.D) {
 			elfDecodeGNUHash(d, ec, size, ec.strTabMap[STRTAB_DYNSTR])
 		})
+	case SHT_REL, SHT_RELA:
+		d.FieldArray("relocations", func(d *decode.D) {
+			elfDecodeRelocations(d, ec, sh, typ == SHT_RELA, size, entSize)
+		})
+	case SHT_NOTE:
+		d.FramedFn(size, func(d *decode.D) {
+			elfDecodeNotes(d, ec)
+		})
+	case SHT_GNU_VERSYM:
+		d.FieldArray("symbol_versions", func(d *decode.D) {
+			elfDecodeVersionSymbols(d, ec, sh, size)
+		})
+	case SHT_GNU_VERDEF:
+		d.FieldArray("version_definitions", func(d *decode.D) {
+			elfDecodeVersionDefinitions(d, ec, sh)
+		})
+	case SHT_GNU_VERNEED:
+		d.FieldArray("version_requirements", func(d *decode.D) {
+			elfDecodeVersionRequirements(d, ec, sh)
+		})
 	default:
 		d.FieldRawLen("data", size)
 	}
@@ -1107,6 +1526,7 @@ func elfDecode(d *decode.D) any {
 	d.Endian = ec.endian
 	// a first pass to find all sections and string table information etc
 	elfReadSectionHeaders(d, &ec)
+	elfReadDWARFSections(d, &ec)
 	d.FieldArray("program_headers", func(d *decode.D) {
 		elfDecodeProgramHeaders(d, ec)
 	})
@@ -0,0 +1,4 @@
+int symver_old(void) { return 1; }
+int symver_new(void) { return 2; }
+__asm__(".symver symver_old,symver@VERS_1");
+__asm__(".symver symver_new,symver@@VERS_2");
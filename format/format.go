@@ -65,6 +65,7 @@ var (
 	Apev2               = &decode.Group{Name: "apev2"}
 	Apple_Bookmark      = &decode.Group{Name: "apple_bookmark"}
 	AR                  = &decode.Group{Name: "ar"}
+	ARP                 = &decode.Group{Name: "arp"}
 	ASN1_BER            = &decode.Group{Name: "asn1_ber"}
 	AV1_CCR             = &decode.Group{Name: "av1_ccr"}
 	AV1_Frame           = &decode.Group{Name: "av1_frame"}
@@ -102,7 +103,10 @@ var (
 	FLAC_Picture        = &decode.Group{Name: "flac_picture"}
 	FLAC_Streaminfo     = &decode.Group{Name: "flac_streaminfo"}
 	FLV                 = &decode.Group{Name: "flv"}
+	GENEVE              = &decode.Group{Name: "geneve"}
 	GIF                 = &decode.Group{Name: "gif"}
+	GRE_Packet          = &decode.Group{Name: "gre_packet"}
+	GRPC                = &decode.Group{Name: "grpc"}
 	Gzip                = &decode.Group{Name: "gzip"}
 	HEVC_Annexb         = &decode.Group{Name: "hevc_annexb"}
 	HEVC_AU             = &decode.Group{Name: "hevc_au"}
@@ -112,6 +116,8 @@ var (
 	HEVC_SPS            = &decode.Group{Name: "hevc_sps"}
 	HEVC_VPS            = &decode.Group{Name: "hevc_vps"}
 	HTML                = &decode.Group{Name: "html"}
+	HTTP                = &decode.Group{Name: "http"}
+	HTTP2               = &decode.Group{Name: "http2"}
 	ICC_Profile         = &decode.Group{Name: "icc_profile"}
 	ICMP                = &decode.Group{Name: "icmp"}
 	ICMPv6              = &decode.Group{Name: "icmpv6"}
@@ -123,6 +129,9 @@ var (
 	JPEG                = &decode.Group{Name: "jpeg"}
 	JSON                = &decode.Group{Name: "json"}
 	JSONL               = &decode.Group{Name: "jsonl"}
+	LLDP                = &decode.Group{Name: "lldp"}
+	LZ4                 = &decode.Group{Name: "lz4"}
+	LZMA                = &decode.Group{Name: "lzma"}
 	MachO               = &decode.Group{Name: "macho"}
 	MachO_Fat           = &decode.Group{Name: "macho_fat"}
 	Markdown            = &decode.Group{Name: "markdown"}
@@ -138,12 +147,14 @@ var (
 	MPEG_PES_Packet     = &decode.Group{Name: "mpeg_pes_packet"}
 	MPEG_SPU            = &decode.Group{Name: "mpeg_spu"}
 	MPEG_TS             = &decode.Group{Name: "mpeg_ts"}
+	MPLS_Packet         = &decode.Group{Name: "mpls_packet"}
 	MsgPack             = &decode.Group{Name: "msgpack"}
 	Ogg                 = &decode.Group{Name: "ogg"}
 	Ogg_Page            = &decode.Group{Name: "ogg_page"}
 	Opus_Packet         = &decode.Group{Name: "opus_packet"}
 	PCAP                = &decode.Group{Name: "pcap"}
 	PCAPNG              = &decode.Group{Name: "pcapng"}
+	PE                  = &decode.Group{Name: "pe"}
 	Pg_BTree            = &decode.Group{Name: "pg_btree"}
 	Pg_Control          = &decode.Group{Name: "pg_control"}
 	Pg_Heap             = &decode.Group{Name: "pg_heap"}
@@ -155,6 +166,8 @@ var (
 	RTMP                = &decode.Group{Name: "rtmp"}
 	SLL_Packet          = &decode.Group{Name: "sll_packet"}
 	SLL2_Packet         = &decode.Group{Name: "sll2_packet"}
+	Snappy              = &decode.Group{Name: "snappy"}
+	STP                 = &decode.Group{Name: "stp"}
 	TAR                 = &decode.Group{Name: "tar"}
 	TCP_Segment         = &decode.Group{Name: "tcp_segment"}
 	TIFF                = &decode.Group{Name: "tiff"}
@@ -162,18 +175,22 @@ var (
 	TOML                = &decode.Group{Name: "toml"}
 	Tzif                = &decode.Group{Name: "tzif"}
 	UDP_Datagram        = &decode.Group{Name: "udp_datagram"}
+	VLAN_Tag            = &decode.Group{Name: "vlan_tag"}
 	Vorbis_Comment      = &decode.Group{Name: "vorbis_comment"}
 	Vorbis_Packet       = &decode.Group{Name: "vorbis_packet"}
 	VP8_Frame           = &decode.Group{Name: "vp8_frame"}
 	VP9_CFM             = &decode.Group{Name: "vp9_cfm"}
 	VP9_Frame           = &decode.Group{Name: "vp9_frame"}
 	VPX_CCR             = &decode.Group{Name: "vpx_ccr"}
+	VXLAN               = &decode.Group{Name: "vxlan"}
 	WASM                = &decode.Group{Name: "wasm"}
 	WAV                 = &decode.Group{Name: "wav"}
 	WebP                = &decode.Group{Name: "webp"}
 	XML                 = &decode.Group{Name: "xml"}
+	XZ                  = &decode.Group{Name: "xz"}
 	YAML                = &decode.Group{Name: "yaml"}
 	Zip                 = &decode.Group{Name: "zip"}
+	Zstd                = &decode.Group{Name: "zstd"}
 )
 
 // below are data types used to communicate between formats <FormatName>In/Out
@@ -239,7 +256,17 @@ type Ogg_Page_Out struct {
 }
 
 type Protobuf_In struct {
-	Message ProtoBufMessage
+	Message       ProtoBufMessage
+	Proto         string `doc:"Proto schema source"`
+	DescriptorSet string `doc:"FileDescriptorSet schema, ex: protoc -o output"`
+	MessageName   string `doc:"Name of message to decode"`
+}
+
+type GRPC_In struct {
+	Proto         string `doc:"Proto schema source"`
+	DescriptorSet string `doc:"FileDescriptorSet schema, ex: protoc -o output"`
+	Method        string `doc:"Method path, ex: /package.Service/Method"`
+	IsResponse    bool   `doc:"Messages are method responses"`
 }
 
 type Matroska_In struct {
@@ -285,6 +312,10 @@ type INET_Packet_In struct {
 
 type IP_Packet_In struct {
 	Protocol int
+	// pseudo header used for transport checksums, addresses are nil if not known
+	SourceAddress      []byte
+	DestinationAddress []byte
+	Length             int
 }
 
 type UDP_Payload_In struct {
@@ -314,6 +345,7 @@ type TCP_Stream_In struct {
 	SkippedBytes    uint64
 	SourcePort      int
 	DestinationPort int
+	Keylog          string // NSS key log content found in capture, ex: pcapng decryption secrets block
 }
 
 type TCP_Stream_Out struct {
@@ -350,6 +382,10 @@ type Zip_In struct {
 	Uncompress bool `doc:"Uncompress and probe files"`
 }
 
+type PNG_In struct {
+	DecodeImageData bool `doc:"Uncompress and decode image data scanlines"`
+}
+
 type XML_In struct {
 	Seq             bool   `doc:"Use seq attribute to preserve element order"`
 	Array           bool   `doc:"Decode as nested arrays"`
@@ -4,18 +4,22 @@ package gif
 // https://en.wikipedia.org/wiki/GIF
 // https://web.archive.org/web/20160304075538/http://qalle.net/gif89a.php#graphiccontrolextension
 
-// TODO: local color map
 // TODO: bit depth done correct?
 
 import (
 	"bytes"
+	"compress/lzw"
+	"io"
 
 	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
 	"github.com/wader/fq/pkg/scalar"
 )
 
+var xmlGroup decode.Group
+
 func init() {
 	interp.RegisterFormat(
 		format.GIF,
@@ -23,6 +27,9 @@ func init() {
 			Description: "Graphics Interchange Format",
 			Groups:      []*decode.Group{format.Probe, format.Image},
 			DecodeFn:    gifDecode,
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.XML}, Out: &xmlGroup},
+			},
 		})
 }
 
@@ -40,6 +47,19 @@ var extensionNames = scalar.UintMapSymStr{
 	extensionApplication:      "Application",
 }
 
+var disposalMethodNames = scalar.UintMapSymStr{
+	0: "unspecified",
+	1: "do_not_dispose",
+	2: "restore_to_background",
+	3: "restore_to_previous",
+}
+
+// XMP data is not stored in sub-blocks, a "magic trailer" makes it look like
+// sub-blocks to decoders that don't know about it
+var xmpMagicTrailerStart = []byte{0x01, 0xff, 0xfe}
+
+const xmpMagicTrailerLen = 257
+
 func fieldColorMap(d *decode.D, name string, bitDepth int) {
 	d.FieldArray(name, func(d *decode.D) {
 		for i := 0; i < 1<<bitDepth; i++ {
@@ -52,6 +72,130 @@ func fieldColorMap(d *decode.D, name string, bitDepth int) {
 	})
 }
 
+// fieldSubBlocks decodes data sub-blocks and block terminator, returns concatenated data
+func fieldSubBlocks(d *decode.D, name string, fn func(d *decode.D, byteCount int)) []byte {
+	dataBytes := &bytes.Buffer{}
+
+	d.FieldArray(name, func(d *decode.D) {
+		for d.PeekUintBits(8) != 0 {
+			d.FieldStruct("sub_block", func(d *decode.D) {
+				byteCount := int(d.FieldU8("byte_count"))
+				dataBytes.Write(d.BytesRange(d.Pos(), byteCount))
+				if fn != nil {
+					d.FramedFn(int64(byteCount)*8, func(d *decode.D) { fn(d, byteCount) })
+				} else {
+					d.FieldRawLen("data", int64(byteCount)*8)
+				}
+			})
+		}
+	})
+	d.FieldU8("terminator", d.UintAssert(0))
+
+	return dataBytes.Bytes()
+}
+
+func decodeExtension(d *decode.D) {
+	d.FieldU8("introducer")
+	functionCode := d.FieldU8("function_code", extensionNames, scalar.UintHex)
+
+	switch functionCode {
+	case extensionGraphicalControl:
+		d.FieldU8("byte_count", d.UintAssert(4))
+		d.FieldU3("reserved")
+		d.FieldU3("disposal_method", disposalMethodNames)
+		d.FieldBool("user_input")
+		d.FieldBool("transparent_color")
+		d.FieldU16("delay_time", scalar.UintDescription("Hundredths of a second"))
+		d.FieldU8("transparent_color_index")
+		d.FieldU8("terminator", d.UintAssert(0))
+	case extensionPlainText:
+		d.FieldU8("byte_count", d.UintAssert(12))
+		d.FieldU16("text_grid_left")
+		d.FieldU16("text_grid_top")
+		d.FieldU16("text_grid_width")
+		d.FieldU16("text_grid_height")
+		d.FieldU8("character_cell_width")
+		d.FieldU8("character_cell_height")
+		d.FieldU8("text_foreground_color_index")
+		d.FieldU8("text_background_color_index")
+		fieldSubBlocks(d, "sub_blocks", func(d *decode.D, byteCount int) {
+			d.FieldUTF8("text", byteCount)
+		})
+	case extensionComment:
+		comment := fieldSubBlocks(d, "sub_blocks", func(d *decode.D, byteCount int) {
+			d.FieldUTF8("text", byteCount)
+		})
+		d.FieldValueStr("comment", string(comment))
+	case extensionApplication:
+		d.FieldU8("byte_count", d.UintAssert(11))
+		identifier := d.FieldUTF8("identifier", 8)
+		authenticationCode := d.FieldUTF8("authentication_code", 3)
+
+		switch {
+		case (identifier == "NETSCAPE" && authenticationCode == "2.0") ||
+			(identifier == "ANIMEXTS" && authenticationCode == "1.0"):
+			fieldSubBlocks(d, "sub_blocks", func(d *decode.D, byteCount int) {
+				id := d.FieldU8("sub_block_id", scalar.UintMapSymStr{1: "loop", 2: "buffer"})
+				switch {
+				case id == 1 && byteCount == 3:
+					d.FieldU16("loop_count", scalar.UintMapDescription{0: "Infinite"})
+				case id == 2 && byteCount == 5:
+					d.FieldU32("buffer_size")
+				default:
+					d.FieldRawLen("data", d.BitsLeft())
+				}
+			})
+		case identifier == "XMP Data" && authenticationCode == "XMP":
+			xmpLen := int64(bytes.Index(d.PeekBytes(int(d.BitsLeft()/8)), xmpMagicTrailerStart))
+			if xmpLen < 0 {
+				fieldSubBlocks(d, "sub_blocks", nil)
+				return
+			}
+			d.FieldFormatOrRawLen("xmp", xmpLen*8, &xmlGroup, nil)
+			d.FieldRawLen("magic_trailer", xmpMagicTrailerLen*8)
+			d.FieldU8("terminator", d.UintAssert(0))
+		default:
+			fieldSubBlocks(d, "sub_blocks", nil)
+		}
+	default:
+		fieldSubBlocks(d, "sub_blocks", nil)
+	}
+}
+
+func decodeImage(d *decode.D) {
+	d.FieldU8("separator_character")
+	d.FieldU16("left")
+	d.FieldU16("top")
+	width := d.FieldU16("width")
+	height := d.FieldU16("height")
+
+	localFollows := d.FieldBool("local_color_map_follows")
+	d.FieldBool("image_interlaced")
+	d.FieldBool("sort")
+	d.FieldU2("reserved")
+	localBitDepth := d.FieldUintFn("bit_depth", func(d *decode.D) uint64 { return d.U3() + 1 })
+
+	if localFollows {
+		fieldColorMap(d, "local_color_map", int(localBitDepth))
+	}
+
+	codeSize := d.FieldU8("code_size")
+	compressed := fieldSubBlocks(d, "sub_blocks", nil)
+
+	// lzw package only supports 2-8 bit literals, same as image/gif
+	if codeSize < 2 || codeSize > 8 {
+		return
+	}
+	lr := lzw.NewReader(bytes.NewReader(compressed), lzw.LSB, int(codeSize))
+	defer lr.Close()
+	// some encoders don't end with a end of information code, use what was decoded
+	pixels, _ := io.ReadAll(io.LimitReader(lr, int64(width*height)))
+	if len(pixels) == 0 {
+		return
+	}
+	d.FieldRootBitBuf("pixels", bitio.NewBitReader(pixels, -1))
+}
+
 func gifDecode(d *decode.D) any {
 	d.Endian = decode.LittleEndian
 
@@ -77,73 +221,9 @@ func gifDecode(d *decode.D) any {
 			case ';':
 				break blocks
 			case '!': /* "!" */
-				d.FieldStruct("extension_block", func(d *decode.D) {
-					d.FieldU8("introducer")
-					functionCode := d.FieldU8("function_code", extensionNames, scalar.UintHex)
-
-					dataBytes := &bytes.Buffer{}
-
-					d.FieldArray("func_data_bytes", func(d *decode.D) {
-						seenTerminator := false
-						for !seenTerminator {
-
-							d.FieldStruct("func_data_byte", func(d *decode.D) {
-								byteCount := d.FieldU8("byte_count")
-								b := d.FieldRawLen("data", int64(byteCount*8))
-								if d.PeekUintBits(8) == 0 {
-									d.FieldU8("terminator")
-									seenTerminator = true
-								}
-								d.CopyBits(dataBytes, d.CloneReadSeeker(b))
-							})
-						}
-					})
-
-					_ = functionCode
-
-					// TODO: need a FieldStructBitBuf or something
-					// switch functionCode {
-					// case extensionGraphicalControl:
-					// 	d.FieldFormatBitBuf(
-					// 		"graphics_control",
-					// 		bitio.NewReader(dataBytes.Bytes(), -1),
-					// 	)
-
-					// }
-				})
+				d.FieldStruct("extension_block", decodeExtension)
 			case ',':
-				d.FieldStruct("image", func(d *decode.D) {
-					d.FieldU8("separator_character")
-					d.FieldU16("left")
-					d.FieldU16("top")
-					d.FieldU16("width")
-					d.FieldU16("height")
-
-					localFollows := d.FieldBool("local_color_map_follows")
-					d.FieldBool("image_interlaced")
-					d.FieldU3("zero")
-					d.FieldUintFn("bit_depth", func(d *decode.D) uint64 { return d.U3() + 1 })
-					d.FieldU8("code_size")
-
-					if localFollows {
-						fieldColorMap(d, "local_color_map", int(bitDepth))
-					}
-
-					d.FieldArray("image_bytes", func(d *decode.D) {
-						seenTerminator := false
-						for !seenTerminator {
-
-							d.FieldStruct("func_data_byte", func(d *decode.D) {
-								byteCount := d.FieldU8("byte_count")
-								d.FieldRawLen("data", int64(byteCount*8))
-								if d.PeekUintBits(8) == 0 {
-									d.FieldU8("terminator")
-									seenTerminator = true
-								}
-							})
-						}
-					})
-				})
+				d.FieldStruct("image", decodeImage)
 			default:
 				d.Fatalf("unknown block")
 			}
@@ -1,16 +1,17 @@
 package gz
 
 // https://tools.ietf.org/html/rfc1952
-// TODO: test name, comment etc
-// TODO: verify isize?
+// https://samtools.github.io/hts-specs/SAMv1.pdf BGZF
 
 import (
+	"bytes"
 	"compress/flate"
 	"hash/crc32"
 	"io"
 	"time"
 
 	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
 	"github.com/wader/fq/pkg/scalar"
@@ -33,6 +34,8 @@ func init() {
 
 const deflateMethod = 8
 
+var identification = []byte("\x1f\x8b")
+
 var compressionMethodNames = scalar.UintMapSymStr{
 	deflateMethod: "deflate",
 }
@@ -59,22 +62,58 @@ var deflateExtraFlagsNames = scalar.UintMapSymStr{
 	4: "fast",
 }
 
-func gzDecode(d *decode.D) any {
-	d.Endian = decode.LittleEndian
+const bgzfSubfieldID = "BC"
+
+var extraSubfieldIDNames = scalar.StrMapDescription{
+	"AC":           "Acorn RISC OS/BBC MOS file type information",
+	"Ap":           "Apollo file type information",
+	bgzfSubfieldID: "BGZF block size",
+	"cp":           "File compressed by cpio",
+	"GS":           "gzsig",
+	"KN":           "KeyNote assertion (RFC 2704)",
+	"Mc":           "Macintosh info (Type and Creator values)",
+	"RO":           "Acorn Risc OS file type information",
+}
+
+func gzDecodeExtraFields(d *decode.D) {
+	xLen := d.FieldU16("xlen")
+	d.FieldArray("extra_fields", func(d *decode.D) {
+		d.FramedFn(int64(xLen)*8, func(d *decode.D) {
+			for !d.End() {
+				d.FieldStruct("extra_field", func(d *decode.D) {
+					id := d.FieldUTF8("id", 2, extraSubfieldIDNames)
+					length := d.FieldU16("length")
+					switch {
+					case id == bgzfSubfieldID && length == 2:
+						// total block size minus 1
+						d.FieldU16("block_size", scalar.UintActualAdd(1))
+					default:
+						d.FieldRawLen("data", int64(length)*8)
+					}
+				})
+			}
+		})
+	})
+}
+
+// gzDecodeMember decodes one member and returns uncompressed data or nil if it could not be uncompressed
+func gzDecodeMember(d *decode.D) []byte {
+	memberStart := d.Pos()
 
-	d.FieldRawLen("identification", 2*8, d.AssertBitBuf([]byte("\x1f\x8b")))
+	d.FieldRawLen("identification", 2*8, d.AssertBitBuf(identification))
 	compressionMethod := d.FieldU8("compression_method", compressionMethodNames)
 	hasHeaderCRC := false
 	hasExtra := false
 	hasName := false
 	hasComment := false
 	d.FieldStruct("flags", func(d *decode.D) {
-		d.FieldBool("text")
-		hasHeaderCRC = d.FieldBool("header_crc")
-		hasExtra = d.FieldBool("extra")
-		hasName = d.FieldBool("name")
-		hasComment = d.FieldBool("comment")
+		// bit 0 is text, decoded from most significant bit
 		d.FieldU3("reserved")
+		hasComment = d.FieldBool("comment")
+		hasName = d.FieldBool("name")
+		hasExtra = d.FieldBool("extra")
+		hasHeaderCRC = d.FieldBool("header_crc")
+		d.FieldBool("text")
 	})
 	d.FieldU32("mtime", scalar.UintActualUnixTime(time.RFC3339))
 	switch compressionMethod {
@@ -85,9 +124,7 @@ func gzDecode(d *decode.D) any {
 	}
 	d.FieldU8("os", osNames)
 	if hasExtra {
-		// TODO:
-		xLen := d.FieldU16("xlen")
-		d.FieldRawLen("extra_fields", int64(xLen*8))
+		gzDecodeExtraFields(d)
 	}
 	if hasName {
 		d.FieldUTF8Null("name")
@@ -96,8 +133,10 @@ func gzDecode(d *decode.D) any {
 		d.FieldUTF8Null("comment")
 	}
 	if hasHeaderCRC {
-		// TODO: validate
-		d.FieldRawLen("header_crc", 16, scalar.RawHex)
+		// two least significant bytes of crc32 of header before the crc
+		headerCRC32W := crc32.NewIEEE()
+		d.Copy(headerCRC32W, bitio.NewIOReader(d.BitBufRange(memberStart, d.Pos()-memberStart)))
+		d.FieldU16("header_crc", d.UintValidate(uint64(headerCRC32W.Sum32()&0xffff)), scalar.UintHex)
 	}
 
 	var rFn func(r io.Reader) io.Reader
@@ -107,20 +146,58 @@ func gzDecode(d *decode.D) any {
 		// buffering and might read more than needed messing up knowing compressed size
 		rFn = func(r io.Reader) io.Reader { return flate.NewReader(r) }
 	}
+	if rFn == nil {
+		return nil
+	}
+
+	compressedBR, err := d.TryBitBufRange(d.Pos(), d.BitsLeft())
+	if err != nil {
+		return nil
+	}
+	compressedR := bitio.NewIOReadSeeker(compressedBR)
+	uncompressed, err := io.ReadAll(rFn(compressedR))
+	if err != nil {
+		return nil
+	}
+	compressedSize, err := compressedR.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+
+	d.FieldRawLen("compressed", compressedSize*8)
+	d.FieldU32("crc32", d.UintValidate(uint64(crc32.ChecksumIEEE(uncompressed))), scalar.UintHex)
+	// size of uncompressed data modulo 2^32
+	d.FieldU32("isize", d.UintValidate(uint64(len(uncompressed))&0xffff_ffff))
 
-	if rFn != nil {
-		readCompressedSize, uncompressedBR, dv, _, _ :=
-			d.TryFieldReaderRangeFormat("uncompressed", d.Pos(), d.BitsLeft(), rFn, &probeGroup, format.Probe_In{})
-		if uncompressedBR != nil {
-			if dv == nil {
-				d.FieldRootBitBuf("uncompressed", uncompressedBR)
+	return uncompressed
+}
+
+func gzDecode(d *decode.D) any {
+	d.Endian = decode.LittleEndian
+
+	var uncompressed []byte
+	members := 0
+
+	d.FieldArray("members", func(d *decode.D) {
+		// first member is required, identification is asserted by member decode
+		for members == 0 || (d.BitsLeft() >= int64(len(identification))*8 && bytes.Equal(d.PeekBytes(len(identification)), identification)) {
+			var memberUncompressed []byte
+			d.FieldStruct("member", func(d *decode.D) {
+				memberUncompressed = gzDecodeMember(d)
+			})
+			if memberUncompressed == nil {
+				// unknown compression method or broken deflate stream, end of member unknown
+				break
 			}
-			d.FieldRawLen("compressed", readCompressedSize)
-			crc32W := crc32.NewIEEE()
-			// TODO: cleanup clone
-			d.CopyBits(crc32W, d.CloneReadSeeker(uncompressedBR))
-			d.FieldU32("crc32", d.UintValidateBytes(crc32W.Sum(nil)), scalar.UintHex)
-			d.FieldU32("isize")
+			uncompressed = append(uncompressed, memberUncompressed...)
+			members++
+		}
+	})
+
+	if members > 0 {
+		uncompressedBR := bitio.NewBitReader(uncompressed, -1)
+		if dv, _, _ := d.TryFieldFormatBitBuf("uncompressed", uncompressedBR, &probeGroup, format.Probe_In{}); dv == nil {
+			d.FieldRootBitBuf("uncompressed", uncompressedBR)
 		}
 	}
 
@@ -0,0 +1,375 @@
+package http
+
+// https://datatracker.ietf.org/doc/html/rfc9112
+// https://datatracker.ietf.org/doc/html/rfc9110
+
+// TODO: HEAD and CONNECT responses, use request method from peer stream instead of looking for a status line
+// TODO: multipart bodies?
+// TODO: content-encoding br
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"embed"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed http.md
+var httpFS embed.FS
+
+var probeGroup decode.Group
+
+// limit uncompressed size of a body to not exhaust memory on decompression bombs
+const maxUncompressedLength = 64 * 1024 * 1024
+
+func init() {
+	interp.RegisterFormat(
+		format.HTTP,
+		&decode.Format{
+			Description: "Hypertext Transfer Protocol 1.x",
+			Groups:      []*decode.Group{format.TCP_Stream},
+			DecodeFn:    httpDecode,
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.Probe}, Out: &probeGroup},
+			},
+		})
+	interp.RegisterFS(httpFS)
+}
+
+// max length of a request, status or header line
+const maxLineLen = 64 * 1024
+
+var methods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"DELETE":  true,
+	"CONNECT": true,
+	"OPTIONS": true,
+	"TRACE":   true,
+	"PATCH":   true,
+}
+
+var versions = map[string]bool{
+	"HTTP/1.0": true,
+	"HTTP/1.1": true,
+}
+
+// peekLine returns next line including line ending or nil if there is no complete line
+func peekLine(d *decode.D) []byte {
+	bytesLeft := d.BitsLeft() / 8
+	if bytesLeft == 0 {
+		return nil
+	}
+	n := d.PeekFindByte('\n', mathex.Min(bytesLeft, maxLineLen))
+	if n == -1 {
+		return nil
+	}
+	return d.PeekBytes(int(n + 1))
+}
+
+func isRequestLine(line []byte) bool {
+	parts := strings.Split(strings.TrimRight(string(line), "\r\n"), " ")
+	return len(parts) == 3 && methods[parts[0]] && parts[1] != "" && versions[parts[2]]
+}
+
+func isStatusLine(line []byte) bool {
+	parts := strings.SplitN(strings.TrimRight(string(line), "\r\n"), " ", 3)
+	if len(parts) < 2 || !versions[parts[0]] || len(parts[1]) != 3 {
+		return false
+	}
+	_, err := strconv.Atoi(parts[1])
+	return err == nil
+}
+
+func decodeRequestLine(d *decode.D) {
+	line := string(peekLine(d))
+	methodLen := strings.IndexByte(line, ' ') + 1
+	d.FieldUTF8("method", methodLen, scalar.ActualTrimSpace)
+	uriLen := strings.IndexByte(line[methodLen:], ' ') + 1
+	d.FieldUTF8("uri", uriLen, scalar.ActualTrimSpace)
+	d.FieldUTF8("version", len(line)-methodLen-uriLen, scalar.ActualTrimSpace)
+}
+
+func decodeStatusLine(d *decode.D) uint64 {
+	line := string(peekLine(d))
+	versionLen := strings.IndexByte(line, ' ') + 1
+	d.FieldUTF8("version", versionLen, scalar.ActualTrimSpace)
+	statusCodeStr := d.FieldUTF8("status_code", 3, scalar.StrSymParseUint(10))
+	statusCode, _ := strconv.ParseUint(statusCodeStr, 10, 64)
+	d.FieldUTF8("reason", len(line)-versionLen-3, scalar.ActualTrimSpace)
+	return statusCode
+}
+
+// decodeHeaders decodes header fields until and including the empty line
+// returns a map with lower case names, repeated fields are joined with ", "
+func decodeHeaders(d *decode.D, name string) map[string]string {
+	headers := map[string]string{}
+	d.FieldArray(name, func(d *decode.D) {
+		for {
+			line := peekLine(d)
+			if line == nil {
+				d.Fatalf("incomplete header line")
+			}
+			if strings.TrimRight(string(line), "\r\n") == "" {
+				break
+			}
+			colonIndex := bytes.IndexByte(line, ':')
+			if colonIndex <= 0 {
+				d.Fatalf("invalid header line")
+			}
+			d.FieldStruct("header", func(d *decode.D) {
+				nameLen := colonIndex + 1
+				for nameLen < len(line) && (line[nameLen] == ' ' || line[nameLen] == '\t') {
+					nameLen++
+				}
+				name := d.FieldUTF8("name", nameLen, scalar.StrActualTrim(": \t"))
+				value := d.FieldUTF8("value", len(line)-nameLen, scalar.ActualTrimSpace)
+
+				key := strings.ToLower(name)
+				if v, ok := headers[key]; ok {
+					headers[key] = v + ", " + value
+				} else {
+					headers[key] = value
+				}
+			})
+		}
+	})
+	d.FieldUTF8(name+"_end", len(peekLine(d)))
+
+	return headers
+}
+
+// decodeChunks decodes chunked transfer coding and returns the concatenated chunk data
+// and if the last chunk was shorter than its size, ex: from a truncated capture
+func decodeChunks(d *decode.D) ([]byte, bool) {
+	chunksBuf := &bytes.Buffer{}
+	truncated := false
+	d.FieldArray("chunks", func(d *decode.D) {
+		for {
+			line := peekLine(d)
+			if line == nil {
+				d.Fatalf("incomplete chunk size line")
+			}
+			sizeStr := strings.TrimSpace(string(line))
+			// ignore chunk extensions
+			if i := strings.IndexByte(sizeStr, ';'); i != -1 {
+				sizeStr = strings.TrimSpace(sizeStr[0:i])
+			}
+			size, err := strconv.ParseUint(sizeStr, 16, 64)
+			if err != nil {
+				d.Fatalf("invalid chunk size %q", sizeStr)
+			}
+
+			d.FieldStruct("chunk", func(d *decode.D) {
+				d.FieldUTF8("size", len(line), scalar.ActualTrimSpace, scalar.TryStrSymParseUint(16))
+				if size == 0 {
+					return
+				}
+				// compare in bytes as size can overflow int and size*8 int64
+				if size > uint64(d.BitsLeft()/8) {
+					chunksBuf.Write(d.PeekBytes(int(d.BitsLeft() / 8)))
+					d.FieldRawLen("data", d.BitsLeft())
+					truncated = true
+					return
+				}
+				chunksBuf.Write(d.PeekBytes(int(size)))
+				d.FieldRawLen("data", int64(size)*8)
+				d.FieldUTF8("data_end", len(peekLine(d)))
+			})
+
+			if size == 0 || truncated {
+				break
+			}
+		}
+	})
+	if !truncated {
+		decodeHeaders(d, "trailers")
+	}
+
+	return chunksBuf.Bytes(), truncated
+}
+
+func contentEncodingReader(encoding string, r io.Reader) (io.Reader, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		// should be zlib wrapped but some servers sends raw deflate
+		bs, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if zr, err := zlib.NewReader(bytes.NewReader(bs)); err == nil {
+			return zr, nil
+		}
+		return flate.NewReader(bytes.NewReader(bs)), nil
+	default:
+		return nil, nil
+	}
+}
+
+// fieldUncompressed adds uncompressed body if content encoding is known
+func fieldUncompressed(d *decode.D, encoding string, bodyBR bitio.ReaderAtSeeker) {
+	r, err := contentEncodingReader(encoding, bitio.NewIOReader(d.CloneReadSeeker(bodyBR)))
+	if err != nil {
+		d.FieldValueStr("uncompressed_error", err.Error())
+		return
+	}
+	if r == nil {
+		return
+	}
+	uncompressed, err := io.ReadAll(io.LimitReader(r, maxUncompressedLength+1))
+	if err != nil {
+		d.FieldValueStr("uncompressed_error", err.Error())
+		return
+	}
+	if len(uncompressed) > maxUncompressedLength {
+		d.FieldValueStr("uncompressed_error", fmt.Sprintf("uncompressed length larger than %d bytes", maxUncompressedLength))
+		return
+	}
+	uncompressedBR := bitio.NewBitReader(uncompressed, -1)
+	if dv, _, _ := d.TryFieldFormatBitBuf("uncompressed", uncompressedBR, &probeGroup, format.Probe_In{}); dv == nil {
+		d.FieldRootBitBuf("uncompressed", uncompressedBR)
+	}
+}
+
+// fieldBodyLen adds body of nBits at current position
+func fieldBodyLen(d *decode.D, encoding string, nBits int64) {
+	if encoding == "" {
+		d.FieldFormatOrRawLen("body", nBits, &probeGroup, format.Probe_In{})
+		return
+	}
+	fieldUncompressed(d, encoding, d.FieldRawLen("body", nBits))
+}
+
+// fieldBodyBuf adds body that is not contiguous in the stream, ex: chunked
+func fieldBodyBuf(d *decode.D, encoding string, bodyBR bitio.ReaderAtSeeker) {
+	if encoding == "" {
+		if dv, _, _ := d.TryFieldFormatBitBuf("body", bodyBR, &probeGroup, format.Probe_In{}); dv == nil {
+			d.FieldRootBitBuf("body", bodyBR)
+		}
+		return
+	}
+	d.FieldRootBitBuf("body", bodyBR)
+	fieldUncompressed(d, encoding, bodyBR)
+}
+
+func decodeMessage(d *decode.D, isRequest bool) (upgraded bool) {
+	statusCode := uint64(0)
+	if isRequest {
+		decodeRequestLine(d)
+	} else {
+		statusCode = decodeStatusLine(d)
+	}
+
+	headers := decodeHeaders(d, "headers")
+
+	transferEncoding := strings.ToLower(headers["transfer-encoding"])
+	contentEncoding := strings.ToLower(strings.TrimSpace(headers["content-encoding"]))
+	if contentEncoding == "identity" {
+		contentEncoding = ""
+	}
+	contentLength, hasContentLength := headers["content-length"]
+
+	switch {
+	case !isRequest && (statusCode/100 == 1 || statusCode == 204 || statusCode == 304):
+		// no body
+		return statusCode == 101
+	case strings.HasSuffix(transferEncoding, "chunked"):
+		chunks, truncated := decodeChunks(d)
+		if len(chunks) > 0 {
+			fieldBodyBuf(d, contentEncoding, bitio.NewBitReader(chunks, -1))
+		}
+		if truncated {
+			d.FieldValueBool("body_truncated", true)
+		}
+	case hasContentLength:
+		n, err := strconv.ParseInt(strings.TrimSpace(contentLength), 10, 64)
+		if err != nil || n < 0 {
+			d.Fatalf("invalid content-length %q", contentLength)
+		}
+		if n == 0 {
+			return false
+		}
+		// responses to HEAD and CONNECT requests have no body even with content-length, request
+		// method is not known so assume no body if the next response starts directly after headers
+		if !isRequest && isStatusLine(peekLine(d)) {
+			return false
+		}
+		// captures and reassembled streams can end before content-length, compare
+		// in bytes as n*8 can overflow
+		if n > d.BitsLeft()/8 {
+			fieldBodyLen(d, contentEncoding, d.BitsLeft())
+			d.FieldValueBool("body_truncated", true)
+			return false
+		}
+		fieldBodyLen(d, contentEncoding, n*8)
+	case !isRequest:
+		// response without length ends when connection closes
+		if d.BitsLeft() > 0 {
+			fieldBodyLen(d, contentEncoding, d.BitsLeft())
+		}
+	}
+
+	return false
+}
+
+func httpDecode(d *decode.D) any {
+	isRequest := false
+
+	var tsi format.TCP_Stream_In
+	if d.ArgAs(&tsi) {
+		if !tsi.HasStart {
+			d.Fatalf("http requires start of byte stream")
+		}
+		isRequest = tsi.IsClient
+	} else {
+		isRequest = !bytes.HasPrefix(d.PeekBytes(int(mathex.Min(d.BitsLeft()/8, 5))), []byte("HTTP/"))
+	}
+
+	isStartLine := isStatusLine
+	arrayName, structName := "responses", "response"
+	if isRequest {
+		isStartLine = isRequestLine
+		arrayName, structName = "requests", "request"
+	}
+
+	// as we're in the tcp group we should at least decode one message
+	if !isStartLine(peekLine(d)) {
+		d.Fatalf("no http start line found")
+	}
+
+	d.FieldArray(arrayName, func(d *decode.D) {
+		for !d.End() {
+			if !isStartLine(peekLine(d)) {
+				break
+			}
+			upgraded := false
+			d.FieldStruct(structName, func(d *decode.D) {
+				upgraded = decodeMessage(d, isRequest)
+			})
+			if upgraded {
+				break
+			}
+		}
+	})
+	if d.BitsLeft() > 0 {
+		d.FieldRawLen("unknown", d.BitsLeft())
+	}
+
+	return nil
+}
@@ -0,0 +1,515 @@
+package http
+
+// https://datatracker.ietf.org/doc/html/rfc9113
+// https://datatracker.ietf.org/doc/html/rfc7541
+
+// TODO: server push streams are reassembled but not related to the request
+// TODO: header table size from peer settings, now allows any size update
+
+import (
+	"bytes"
+	"embed"
+	"strings"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+	"golang.org/x/net/http2/hpack"
+)
+
+//go:embed http2.md
+var http2FS embed.FS
+
+func init() {
+	interp.RegisterFormat(
+		format.HTTP2,
+		&decode.Format{
+			Description: "Hypertext Transfer Protocol 2",
+			Groups:      []*decode.Group{format.TCP_Stream},
+			DecodeFn:    http2Decode,
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.Probe}, Out: &probeGroup},
+			},
+		})
+	interp.RegisterFS(http2FS)
+}
+
+const clientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const frameHeaderLen = 9
+
+const (
+	frameTypeData         = 0x0
+	frameTypeHeaders      = 0x1
+	frameTypePriority     = 0x2
+	frameTypeRSTStream    = 0x3
+	frameTypeSettings     = 0x4
+	frameTypePushPromise  = 0x5
+	frameTypePing         = 0x6
+	frameTypeGoAway       = 0x7
+	frameTypeWindowUpdate = 0x8
+	frameTypeContinuation = 0x9
+	frameTypeAltSvc       = 0xa
+	frameTypeOrigin       = 0xc
+)
+
+var frameTypeNames = scalar.UintMapSymStr{
+	frameTypeData:         "data",
+	frameTypeHeaders:      "headers",
+	frameTypePriority:     "priority",
+	frameTypeRSTStream:    "rst_stream",
+	frameTypeSettings:     "settings",
+	frameTypePushPromise:  "push_promise",
+	frameTypePing:         "ping",
+	frameTypeGoAway:       "goaway",
+	frameTypeWindowUpdate: "window_update",
+	frameTypeContinuation: "continuation",
+	frameTypeAltSvc:       "altsvc",
+	frameTypeOrigin:       "origin",
+}
+
+const (
+	flagEndStream  = 0x1
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+var settingsNames = scalar.UintMapSymStr{
+	0x1: "header_table_size",
+	0x2: "enable_push",
+	0x3: "max_concurrent_streams",
+	0x4: "initial_window_size",
+	0x5: "max_frame_size",
+	0x6: "max_header_list_size",
+	0x8: "enable_connect_protocol",
+	0x9: "no_rfc7540_priorities",
+}
+
+var errorCodeNames = scalar.UintMapSymStr{
+	0x0: "no_error",
+	0x1: "protocol_error",
+	0x2: "internal_error",
+	0x3: "flow_control_error",
+	0x4: "settings_timeout",
+	0x5: "stream_closed",
+	0x6: "frame_size_error",
+	0x7: "refused_stream",
+	0x8: "cancel",
+	0x9: "compression_error",
+	0xa: "connect_error",
+	0xb: "enhance_your_calm",
+	0xc: "inadequate_security",
+	0xd: "http_1_1_required",
+}
+
+const (
+	representationIndexed                = "indexed"
+	representationLiteralIndexed         = "literal_with_incremental_indexing"
+	representationLiteralNotIndexed      = "literal_without_indexing"
+	representationLiteralNeverIndexed    = "literal_never_indexed"
+	representationDynamicTableSizeUpdate = "dynamic_table_size_update"
+)
+
+type http2Stream struct {
+	id      uint64
+	headers map[string]string
+	data    bytes.Buffer
+	hasData bool
+}
+
+type http2Ctx struct {
+	hpackDecoder *hpack.Decoder
+	// set to false if hpack state is broken, ex: missing start of stream
+	hpackOk bool
+
+	// header block spanning multiple frames
+	headerBlock         bytes.Buffer
+	headerBlockStreamID uint64
+
+	streams     map[uint64]*http2Stream
+	streamOrder []uint64
+}
+
+func (hc *http2Ctx) stream(id uint64) *http2Stream {
+	if s, ok := hc.streams[id]; ok {
+		return s
+	}
+	s := &http2Stream{id: id, headers: map[string]string{}}
+	hc.streams[id] = s
+	hc.streamOrder = append(hc.streamOrder, id)
+	return s
+}
+
+// hpackInt returns integer value and its length in bytes, -1 length if incomplete
+func hpackInt(bs []byte, prefixBits int) (uint64, int) {
+	if len(bs) == 0 {
+		return 0, -1
+	}
+	mask := uint64(1)<<prefixBits - 1
+	v := uint64(bs[0]) & mask
+	if v < mask {
+		return v, 1
+	}
+	m := 0
+	for i := 1; i < len(bs) && i < 10; i++ {
+		v += uint64(bs[i]&0x7f) << m
+		m += 7
+		if bs[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, -1
+}
+
+// hpackStringLen returns length in bytes of string literal, -1 if incomplete
+func hpackStringLen(bs []byte) int {
+	l, n := hpackInt(bs, 7)
+	if n == -1 || uint64(len(bs)-n) < l {
+		return -1
+	}
+	return n + int(l)
+}
+
+// hpackRepresentation returns representation type, index and length in bytes of next header field representation
+func hpackRepresentation(bs []byte) (string, uint64, int) {
+	var typ string
+	var prefixBits int
+	switch b := bs[0]; {
+	case b&0x80 != 0:
+		typ, prefixBits = representationIndexed, 7
+	case b&0xc0 == 0x40:
+		typ, prefixBits = representationLiteralIndexed, 6
+	case b&0xf0 == 0:
+		typ, prefixBits = representationLiteralNotIndexed, 4
+	case b&0xf0 == 0x10:
+		typ, prefixBits = representationLiteralNeverIndexed, 4
+	default:
+		typ, prefixBits = representationDynamicTableSizeUpdate, 5
+	}
+
+	index, n := hpackInt(bs, prefixBits)
+	if n == -1 {
+		return typ, 0, -1
+	}
+	switch typ {
+	case representationIndexed, representationDynamicTableSizeUpdate:
+		return typ, index, n
+	}
+	if index == 0 {
+		nameLen := hpackStringLen(bs[n:])
+		if nameLen == -1 {
+			return typ, 0, -1
+		}
+		n += nameLen
+	}
+	valueLen := hpackStringLen(bs[n:])
+	if valueLen == -1 {
+		return typ, 0, -1
+	}
+	return typ, index, n + valueLen
+}
+
+// decodeHeaderBlock decodes a complete header block until end of d
+func decodeHeaderBlock(d *decode.D, hc *http2Ctx, s *http2Stream) {
+	var emitted []hpack.HeaderField
+	hc.hpackDecoder.SetEmitFunc(func(hf hpack.HeaderField) { emitted = append(emitted, hf) })
+
+	// peek whole block once and advance, peeking the rest for each header is quadratic
+	bs := d.PeekBytes(int(d.BitsLeft() / 8))
+	for len(bs) > 0 {
+		typ, index, n := hpackRepresentation(bs)
+		if n == -1 || !hc.hpackOk {
+			d.FieldRawLen("unknown", d.BitsLeft())
+			break
+		}
+
+		emitted = emitted[:0]
+		if _, err := hc.hpackDecoder.Write(bs[0:n]); err != nil {
+			// dynamic table is now in unknown state
+			hc.hpackOk = false
+		}
+
+		d.FieldStruct("header", func(d *decode.D) {
+			d.FieldRawLen("data", int64(n)*8)
+			d.FieldValueStr("representation", typ)
+			if typ == representationIndexed || index != 0 {
+				d.FieldValueUint("index", index)
+			}
+			if typ == representationDynamicTableSizeUpdate {
+				d.FieldValueUint("max_size", index)
+			}
+			for _, hf := range emitted {
+				d.FieldValueStr("name", hf.Name)
+				d.FieldValueStr("value", hf.Value)
+
+				key := strings.ToLower(hf.Name)
+				if v, ok := s.headers[key]; ok {
+					s.headers[key] = v + ", " + hf.Value
+				} else {
+					s.headers[key] = hf.Value
+				}
+			}
+		})
+		bs = bs[n:]
+	}
+
+	if err := hc.hpackDecoder.Close(); err != nil {
+		hc.hpackOk = false
+	}
+}
+
+// fieldHeaderBlockFragment decodes header block fragment in place or buffers it if
+// it continues in following CONTINUATION frames
+func fieldHeaderBlockFragment(d *decode.D, hc *http2Ctx, streamID uint64, nBytes int64, isFirst bool, endHeaders bool) {
+	if isFirst && endHeaders {
+		d.FramedFn(nBytes*8, func(d *decode.D) {
+			d.FieldArray("headers", func(d *decode.D) {
+				decodeHeaderBlock(d, hc, hc.stream(streamID))
+			})
+		})
+		return
+	}
+
+	if isFirst {
+		hc.headerBlock.Reset()
+		hc.headerBlockStreamID = streamID
+	}
+	hc.headerBlock.Write(d.PeekBytes(int(nBytes)))
+	d.FieldRawLen("header_block_fragment", nBytes*8)
+	if endHeaders {
+		br := bitio.NewBitReader(append([]byte(nil), hc.headerBlock.Bytes()...), -1)
+		d.FieldArrayRootBitBufFn("headers", br, func(d *decode.D) {
+			decodeHeaderBlock(d, hc, hc.stream(hc.headerBlockStreamID))
+		})
+	}
+}
+
+// fieldPadded decodes optional pad length, fn and padding
+func fieldPadded(d *decode.D, padded bool, fn func(d *decode.D, nBytes int64)) {
+	padLength := uint64(0)
+	if padded {
+		padLength = d.FieldU8("pad_length")
+	}
+	contentLen := d.BitsLeft()/8 - int64(padLength)
+	if contentLen < 0 {
+		d.Fatalf("pad length %d larger than frame", padLength)
+	}
+	fn(d, contentLen)
+	if padLength > 0 {
+		d.FieldRawLen("padding", int64(padLength)*8)
+	}
+}
+
+// minimum length of frames with fixed fields, padding and priority fields are checked when decoded
+var frameMinLengths = map[uint64]uint64{
+	frameTypePriority:     5,
+	frameTypeRSTStream:    4,
+	frameTypePushPromise:  4,
+	frameTypePing:         8,
+	frameTypeGoAway:       8,
+	frameTypeWindowUpdate: 4,
+	frameTypeAltSvc:       2,
+}
+
+func decodeFrame(d *decode.D, hc *http2Ctx) {
+	length := d.FieldU24("length")
+	typ := d.FieldU8("type", frameTypeNames)
+	flags := d.PeekUintBits(8)
+	d.FieldStruct("flags", func(d *decode.D) {
+		switch typ {
+		case frameTypeData:
+			d.FieldU4("unused0")
+			d.FieldBool("padded")
+			d.FieldU2("unused1")
+			d.FieldBool("end_stream")
+		case frameTypeHeaders:
+			d.FieldU2("unused0")
+			d.FieldBool("priority")
+			d.FieldU1("unused1")
+			d.FieldBool("padded")
+			d.FieldBool("end_headers")
+			d.FieldU1("unused2")
+			d.FieldBool("end_stream")
+		case frameTypePushPromise:
+			d.FieldU4("unused0")
+			d.FieldBool("padded")
+			d.FieldBool("end_headers")
+			d.FieldU2("unused1")
+		case frameTypeContinuation:
+			d.FieldU5("unused0")
+			d.FieldBool("end_headers")
+			d.FieldU2("unused1")
+		case frameTypeSettings, frameTypePing:
+			d.FieldU7("unused")
+			d.FieldBool("ack")
+		default:
+			d.FieldU8("unused")
+		}
+	})
+	d.FieldU1("reserved")
+	streamID := d.FieldU31("stream_id")
+
+	if minLength, ok := frameMinLengths[typ]; ok && length < minLength {
+		d.Fatalf("%s frame length %d shorter than %d", frameTypeNames[typ], length, minLength)
+	}
+
+	d.FramedFn(int64(length)*8, func(d *decode.D) {
+		switch typ {
+		case frameTypeData:
+			fieldPadded(d, flags&flagPadded != 0, func(d *decode.D, nBytes int64) {
+				s := hc.stream(streamID)
+				s.data.Write(d.PeekBytes(int(nBytes)))
+				s.hasData = true
+				d.FieldRawLen("data", nBytes*8)
+			})
+		case frameTypeHeaders:
+			fieldPadded(d, flags&flagPadded != 0, func(d *decode.D, nBytes int64) {
+				if flags&flagPriority != 0 {
+					if nBytes < 5 {
+						d.Fatalf("headers frame content length %d shorter than priority fields", nBytes)
+					}
+					d.FieldBool("exclusive")
+					d.FieldU31("stream_dependency")
+					d.FieldU8("weight", scalar.UintActualAdd(1))
+					nBytes -= 5
+				}
+				fieldHeaderBlockFragment(d, hc, streamID, nBytes, true, flags&flagEndHeaders != 0)
+			})
+		case frameTypePriority:
+			d.FieldBool("exclusive")
+			d.FieldU31("stream_dependency")
+			d.FieldU8("weight", scalar.UintActualAdd(1))
+		case frameTypeRSTStream:
+			d.FieldU32("error_code", errorCodeNames)
+		case frameTypeSettings:
+			d.FieldArray("settings", func(d *decode.D) {
+				for !d.End() {
+					d.FieldStruct("setting", func(d *decode.D) {
+						d.FieldU16("identifier", settingsNames)
+						d.FieldU32("value")
+					})
+				}
+			})
+		case frameTypePushPromise:
+			fieldPadded(d, flags&flagPadded != 0, func(d *decode.D, nBytes int64) {
+				if nBytes < 4 {
+					d.Fatalf("push promise frame content length %d shorter than promised stream id", nBytes)
+				}
+				d.FieldU1("reserved1")
+				promisedStreamID := d.FieldU31("promised_stream_id")
+				fieldHeaderBlockFragment(d, hc, promisedStreamID, nBytes-4, true, flags&flagEndHeaders != 0)
+			})
+		case frameTypePing:
+			d.FieldRawLen("opaque_data", d.BitsLeft())
+		case frameTypeGoAway:
+			d.FieldU1("reserved1")
+			d.FieldU31("last_stream_id")
+			d.FieldU32("error_code", errorCodeNames)
+			if d.BitsLeft() > 0 {
+				d.FieldUTF8("additional_debug_data", int(d.BitsLeft()/8))
+			}
+		case frameTypeWindowUpdate:
+			d.FieldU1("reserved1")
+			d.FieldU31("window_size_increment")
+		case frameTypeContinuation:
+			fieldHeaderBlockFragment(d, hc, streamID, d.BitsLeft()/8, false, flags&flagEndHeaders != 0)
+		case frameTypeAltSvc:
+			originLen := d.FieldU16("origin_length")
+			d.FieldUTF8("origin", int(originLen))
+			d.FieldUTF8("alt_svc_field_value", int(d.BitsLeft()/8))
+		case frameTypeOrigin:
+			d.FieldArray("origin_entries", func(d *decode.D) {
+				for !d.End() {
+					d.FieldStruct("origin_entry", func(d *decode.D) {
+						originLen := d.FieldU16("origin_length")
+						d.FieldUTF8("origin", int(originLen))
+					})
+				}
+			})
+		default:
+			d.FieldRawLen("payload", d.BitsLeft())
+		}
+	})
+}
+
+func http2Decode(d *decode.D) any {
+	isClient := false
+
+	var tsi format.TCP_Stream_In
+	if d.ArgAs(&tsi) {
+		if !tsi.HasStart {
+			d.Fatalf("http2 requires start of byte stream")
+		}
+		isClient = tsi.IsClient
+	} else {
+		isClient = d.TryHasBytes([]byte(clientPreface))
+	}
+
+	if isClient {
+		d.FieldUTF8("preface", len(clientPreface), d.StrAssert(clientPreface))
+	}
+
+	// first frame must be a settings frame on stream zero
+	if d.BitsLeft() < frameHeaderLen*8 {
+		d.Fatalf("no http2 settings frame found")
+	}
+	firstFrame := d.PeekBytes(frameHeaderLen)
+	firstLength := int(firstFrame[0])<<16 | int(firstFrame[1])<<8 | int(firstFrame[2])
+	if firstFrame[3] != frameTypeSettings ||
+		firstLength%6 != 0 ||
+		!bytes.Equal(firstFrame[5:9], []byte{0, 0, 0, 0}) {
+		d.Fatalf("first frame is not a settings frame")
+	}
+
+	hc := &http2Ctx{
+		hpackDecoder: hpack.NewDecoder(4096, nil),
+		hpackOk:      true,
+		streams:      map[uint64]*http2Stream{},
+	}
+	// peer settings are not known so allow encoder to use any size
+	hc.hpackDecoder.SetAllowedMaxDynamicTableSize(1 << 31)
+
+	d.FieldArray("frames", func(d *decode.D) {
+		for !d.End() {
+			if d.BitsLeft() < frameHeaderLen*8 {
+				break
+			}
+			length := d.PeekUintBits(24)
+			if d.BitsLeft() < int64(frameHeaderLen+length)*8 {
+				break
+			}
+			d.FieldStruct("frame", func(d *decode.D) {
+				decodeFrame(d, hc)
+			})
+		}
+	})
+	if d.BitsLeft() > 0 {
+		d.FieldRawLen("unknown", d.BitsLeft())
+	}
+
+	d.FieldArray("streams", func(d *decode.D) {
+		for _, id := range hc.streamOrder {
+			s := hc.streams[id]
+			if id == 0 {
+				continue
+			}
+			d.FieldStruct("stream", func(d *decode.D) {
+				d.FieldValueUint("stream_id", s.id)
+				if !s.hasData {
+					return
+				}
+				contentEncoding := strings.ToLower(strings.TrimSpace(s.headers["content-encoding"]))
+				if contentEncoding == "identity" {
+					contentEncoding = ""
+				}
+				fieldBodyBuf(d, contentEncoding, bitio.NewBitReader(s.data.Bytes(), -1))
+			})
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package inet
+
+// https://datatracker.ietf.org/doc/html/rfc826
+// https://www.iana.org/assignments/arp-parameters/arp-parameters.xhtml
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+func init() {
+	interp.RegisterFormat(
+		format.ARP,
+		&decode.Format{
+			Description: "Address resolution protocol",
+			Groups:      []*decode.Group{format.INET_Packet},
+			DecodeFn:    decodeARP,
+		})
+}
+
+const (
+	arpHardwareTypeEthernet = 1
+)
+
+var arpHardwareTypeNames = scalar.UintMapSymStr{
+	arpHardwareTypeEthernet: "ethernet",
+	6:                       "ieee802",
+	15:                      "frame_relay",
+	16:                      "atm",
+	18:                      "fibre_channel",
+	20:                      "serial_line",
+	32:                      "infiniband",
+}
+
+var arpOpcodeNames = scalar.UintMapSymStr{
+	1: "request",
+	2: "reply",
+	3: "reverse_request",
+	4: "reverse_reply",
+	8: "inverse_request",
+	9: "inverse_reply",
+}
+
+func decodeARP(d *decode.D) any {
+	var ipi format.INET_Packet_In
+	if d.ArgAs(&ipi) && ipi.EtherType != format.EtherTypeARP {
+		d.Fatalf("incorrect ethertype %d", ipi.EtherType)
+	}
+
+	hardwareType := d.FieldU16("hardware_type", arpHardwareTypeNames)
+	protocolType := d.FieldU16("protocol_type", format.EtherTypeMap, scalar.UintHex)
+	hardwareSize := d.FieldU8("hardware_size")
+	protocolSize := d.FieldU8("protocol_size")
+	d.FieldU16("opcode", arpOpcodeNames)
+
+	fieldHardwareAddress := func(name string) {
+		if hardwareType == arpHardwareTypeEthernet && hardwareSize == 6 {
+			d.FieldU(name, 48, mapUToEtherSym, scalar.UintHex)
+		} else {
+			d.FieldRawLen(name, int64(hardwareSize)*8)
+		}
+	}
+	fieldProtocolAddress := func(name string) {
+		switch {
+		case protocolType == format.EtherTypeIPv4 && protocolSize == 4:
+			d.FieldU32(name, mapUToIPv4Sym, scalar.UintHex)
+		case protocolType == format.EtherTypeIPv6 && protocolSize == 16:
+			d.FieldRawLen(name, 128, mapUToIPv6Sym)
+		default:
+			d.FieldRawLen(name, int64(protocolSize)*8)
+		}
+	}
+
+	fieldHardwareAddress("sender_hardware_address")
+	fieldProtocolAddress("sender_protocol_address")
+	fieldHardwareAddress("target_hardware_address")
+	fieldProtocolAddress("target_protocol_address")
+
+	// ethernet frames are padded to minimum size
+	if d.BitsLeft() > 0 {
+		d.FieldRawLen("padding", d.BitsLeft())
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package inet
+
+import (
+	"encoding/binary"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/checksum"
+	"github.com/wader/fq/pkg/decode"
+)
+
+// transportChecksum returns the checksum of a TCP, UDP or ICMPv6 segment including the IP
+// pseudo header. The 16 bit checksum field at checksumStart is skipped.
+func transportChecksum(d *decode.D, ipi format.IP_Packet_In, checksumStart int64) []byte {
+	c := &checksum.IPv4{}
+	// ones' complement sum of 16 bit words so the IPv4 (zero, protocol, 16 bit length) and
+	// IPv6 (32 bit length, zeros, next header) pseudo headers sum to the same value
+	var pseudoHeader [6]byte
+	binary.BigEndian.PutUint32(pseudoHeader[0:4], uint32(ipi.Length))
+	pseudoHeader[5] = byte(ipi.Protocol)
+	_, _ = c.Write(ipi.SourceAddress)
+	_, _ = c.Write(ipi.DestinationAddress)
+	_, _ = c.Write(pseudoHeader[:])
+	checksumEnd := checksumStart + 16
+	d.Copy(c, bitio.NewIOReader(d.BitBufRange(0, checksumStart)))
+	d.Copy(c, bitio.NewIOReader(d.BitBufRange(checksumEnd, d.Len()-checksumEnd)))
+
+	return c.Sum(nil)
+}
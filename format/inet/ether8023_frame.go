@@ -7,12 +7,14 @@ import (
 	"fmt"
 
 	"github.com/wader/fq/format"
+	"github.com/wader/fq/internal/mathex"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 var ether8023FrameInetPacketGroup decode.Group
+var ether8023FrameSTPGroup decode.Group
 
 func init() {
 	interp.RegisterFormat(
@@ -22,6 +24,7 @@ func init() {
 			Groups:      []*decode.Group{format.Link_Frame},
 			Dependencies: []decode.Dependency{
 				{Groups: []*decode.Group{format.INET_Packet}, Out: &ether8023FrameInetPacketGroup},
+				{Groups: []*decode.Group{format.STP}, Out: &ether8023FrameSTPGroup},
 			},
 			DecodeFn: decodeEthernetFrame,
 		})
@@ -45,14 +48,90 @@ func decodeEthernetFrame(d *decode.D) any {
 
 	d.FieldU("destination", 48, mapUToEtherSym, scalar.UintHex)
 	d.FieldU("source", 48, mapUToEtherSym, scalar.UintHex)
-	etherType := d.FieldU16("ether_type", format.EtherTypeMap, scalar.UintHex)
+	// values up to 1500 are a 802.3 length followed by a 802.2 LLC header and values
+	// from 0x0600 an ether type
+	typeOrLength := d.PeekUintBits(16)
+	switch {
+	case typeOrLength >= etherTypeMin:
+		etherType := d.FieldU16("ether_type", format.EtherTypeMap, scalar.UintHex)
 
-	d.FieldFormatOrRawLen(
-		"payload",
-		d.BitsLeft(),
-		&ether8023FrameInetPacketGroup,
-		format.INET_Packet_In{EtherType: int(etherType)},
-	)
+		d.FieldFormatOrRawLen(
+			"payload",
+			d.BitsLeft(),
+			&ether8023FrameInetPacketGroup,
+			format.INET_Packet_In{EtherType: int(etherType)},
+		)
+
+		return nil
+	case typeOrLength <= etherMaxLength:
+		length := d.FieldU16("length")
+		// snaplen truncated captures can have less than length
+		d.FramedFn(mathex.Min(int64(length)*8, d.BitsLeft()), decodeLLC)
+	default:
+		// 1501-1535 is neither a length nor an ether type
+		d.FieldU16("type_or_length", scalar.UintHex)
+		d.FieldRawLen("payload", d.BitsLeft())
+		return nil
+	}
+
+	// ethernet frames are padded to minimum size
+	if d.BitsLeft() > 0 {
+		d.FieldRawLen("padding", d.BitsLeft())
+	}
 
 	return nil
 }
+
+const (
+	etherMaxLength = 1500
+	etherTypeMin   = 0x0600
+)
+
+const (
+	llcSAPSTP  = 0x42
+	llcSAPSNAP = 0xaa
+)
+
+// from https://en.wikipedia.org/wiki/IEEE_802.2
+var llcSAPNames = scalar.UintMapSymStr{
+	0x00:       "null",
+	0x06:       "ip",
+	llcSAPSTP:  "stp",
+	llcSAPSNAP: "snap",
+	0xe0:       "ipx",
+	0xf0:       "netbios",
+	0xfe:       "iso_network_layer",
+}
+
+func decodeLLC(d *decode.D) {
+	var dsap, ssap uint64
+	d.FieldStruct("llc", func(d *decode.D) {
+		dsap = d.FieldU8("dsap", llcSAPNames, scalar.UintHex)
+		ssap = d.FieldU8("ssap", llcSAPNames, scalar.UintHex)
+		// unnumbered format has one byte control field, information and supervisory has two
+		if d.PeekUintBits(8)&0b11 == 0b11 {
+			d.FieldU8("control", scalar.UintHex)
+		} else {
+			d.FieldU16("control", scalar.UintHex)
+		}
+	})
+
+	switch {
+	case dsap == llcSAPSTP && ssap == llcSAPSTP:
+		d.FieldFormatOrRawLen("payload", d.BitsLeft(), &ether8023FrameSTPGroup, nil)
+	case dsap == llcSAPSNAP && ssap == llcSAPSNAP:
+		var etherType uint64
+		d.FieldStruct("snap", func(d *decode.D) {
+			d.FieldU24("oui", scalar.UintHex)
+			etherType = d.FieldU16("protocol_id", format.EtherTypeMap, scalar.UintHex)
+		})
+		d.FieldFormatOrRawLen(
+			"payload",
+			d.BitsLeft(),
+			&ether8023FrameInetPacketGroup,
+			format.INET_Packet_In{EtherType: int(etherType)},
+		)
+	default:
+		d.FieldRawLen("payload", d.BitsLeft())
+	}
+}
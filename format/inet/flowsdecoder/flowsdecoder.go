@@ -7,6 +7,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/gopacket/gopacket"
 	"github.com/gopacket/gopacket/ip4defrag"
@@ -97,6 +98,35 @@ type IPV4Reassembled struct {
 	Datagram      []byte
 }
 
+type UDPEndpoint struct {
+	IP   net.IP
+	Port int
+}
+
+type UDPDatagram struct {
+	FromClient bool
+	Timestamp  time.Time
+	Payload    []byte
+}
+
+// UDPFlow is datagrams between two endpoints, client is the sender of the first datagram
+type UDPFlow struct {
+	Client    UDPEndpoint
+	Server    UDPEndpoint
+	Datagrams []UDPDatagram
+}
+
+type udpFlowKey struct {
+	net       gopacket.Flow
+	transport gopacket.Flow
+}
+
+type IPV6Reassembled struct {
+	SourceIP      net.IP
+	DestinationIP net.IP
+	Datagram      []byte
+}
+
 func (fd *Decoder) New(net, transport gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
 	fsmOptions := reassembly.TCPSimpleFSMOptions{
 		SupportMissingEstablishment: true,
@@ -150,8 +180,12 @@ type Decoder struct {
 
 	TCPConnections  []*TCPConnection
 	IPV4Reassembled []IPV4Reassembled
+	IPV6Reassembled []IPV6Reassembled
+	UDPFlows        []*UDPFlow
 
 	ipv4Defrag   *ip4defrag.IPv4Defragmenter
+	ipv6Defrag   *ipv6Defragmenter
+	udpFlows     map[udpFlowKey]*UDPFlow
 	tcpAssembler *reassembly.Assembler
 }
 
@@ -167,51 +201,88 @@ func New(options DecoderOptions) *Decoder {
 	tcpAssembler := reassembly.NewAssembler(streamPool)
 	flowDecoder.tcpAssembler = tcpAssembler
 	flowDecoder.ipv4Defrag = ip4defrag.NewIPv4Defragmenter()
+	flowDecoder.ipv6Defrag = newIPv6Defragmenter()
+	flowDecoder.udpFlows = map[udpFlowKey]*UDPFlow{}
 
 	return flowDecoder
 }
 
-func (fd *Decoder) EthernetFrame(bs []byte) error {
-	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeEthernet, gopacket.Lazy))
+func (fd *Decoder) EthernetFrame(bs []byte, ts time.Time) error {
+	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeEthernet, gopacket.Lazy), ts)
 }
 
-func (fd *Decoder) IPv4Packet(bs []byte) error {
-	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeIPv4, gopacket.Lazy))
+func (fd *Decoder) IPv4Packet(bs []byte, ts time.Time) error {
+	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeIPv4, gopacket.Lazy), ts)
 }
 
-func (fd *Decoder) IPv6Packet(bs []byte) error {
-	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeIPv6, gopacket.Lazy))
+func (fd *Decoder) IPv6Packet(bs []byte, ts time.Time) error {
+	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeIPv6, gopacket.Lazy), ts)
 }
 
-func (fd *Decoder) SLLPacket(bs []byte) error {
-	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeLinuxSLL, gopacket.Lazy))
+func (fd *Decoder) SLLPacket(bs []byte, ts time.Time) error {
+	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeLinuxSLL, gopacket.Lazy), ts)
 }
 
-func (fd *Decoder) SLL2Packet(bs []byte) error {
-	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeLinuxSLL2, gopacket.Lazy))
+func (fd *Decoder) SLL2Packet(bs []byte, ts time.Time) error {
+	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeLinuxSLL2, gopacket.Lazy), ts)
 }
 
-func (fd *Decoder) LoopbackFrame(bs []byte) error {
-	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeLoopback, gopacket.Lazy))
+func (fd *Decoder) LoopbackFrame(bs []byte, ts time.Time) error {
+	return fd.packet(gopacket.NewPacket(bs, layers.LayerTypeLoopback, gopacket.Lazy), ts)
 }
 
 // LinkTypeRAW IPv4 or Ipv6
-func (fd *Decoder) RAWIPFrame(bs []byte) error {
+func (fd *Decoder) RAWIPFrame(bs []byte, ts time.Time) error {
 	version := bs[0] >> 4
 	switch version {
 	case 4:
-		return fd.IPv4Packet(bs)
+		return fd.IPv4Packet(bs, ts)
 	case 6:
-		return fd.IPv6Packet(bs)
+		return fd.IPv6Packet(bs, ts)
 	}
 	return fmt.Errorf("invalid ip version %v", version)
 }
 
-func (fd *Decoder) packet(p gopacket.Packet) error {
+// innermostLayers returns the innermost network layer and the transport layer following it
+// so that tunneled traffic (GRE, VXLAN, GENEVE etc) is seen as the inner flows
+func innermostLayers(p gopacket.Packet) (gopacket.NetworkLayer, *layers.TCP, *layers.UDP) {
+	var network gopacket.NetworkLayer
+	var tcp *layers.TCP
+	var udp *layers.UDP
+	for _, l := range p.Layers() {
+		switch l := l.(type) {
+		case gopacket.NetworkLayer:
+			network, tcp, udp = l, nil, nil
+		case *layers.TCP:
+			tcp = l
+		case *layers.UDP:
+			udp = l
+		}
+	}
+	return network, tcp, udp
+}
+
+// layerData returns packet data starting at layer, layers are contiguous in the packet
+func layerData(p gopacket.Packet, layer gopacket.Layer) []byte {
+	data := p.Data()
+	offset := 0
+	for _, l := range p.Layers() {
+		if l == layer {
+			return data[offset:]
+		}
+		offset += len(l.LayerContents())
+		if offset > len(data) {
+			break
+		}
+	}
+	return nil
+}
+
+func (fd *Decoder) packet(p gopacket.Packet, ts time.Time) error {
 	// TODO: linkType
-	ip4Layer := p.Layer(layers.LayerTypeIPv4)
-	if ip4Layer != nil {
-		ip4, _ := ip4Layer.(*layers.IPv4)
+	network, _, _ := innermostLayers(p)
+
+	if ip4, ok := network.(*layers.IPv4); ok {
 		l := ip4.Length
 		newIPv4, err := fd.ipv4Defrag.DefragIPv4(ip4)
 		if err != nil {
@@ -251,15 +322,68 @@ func (fd *Decoder) packet(p gopacket.Packet) error {
 		}
 	}
 
-	tcp := p.Layer(layers.LayerTypeTCP)
+	if ip6, ok := network.(*layers.IPv6); ok {
+		// layer payload is not used as gopacket skips hop-by-hop options in it
+		datagram, err := fd.ipv6Defrag.defragIPv6(layerData(p, ip6))
+		if err != nil {
+			return err
+		} else if datagram != nil {
+			fd.IPV6Reassembled = append(fd.IPV6Reassembled, IPV6Reassembled{
+				SourceIP:      ip6.SrcIP,
+				DestinationIP: ip6.DstIP,
+				Datagram:      datagram,
+			})
+
+			// reassembled packet has no fragment header so it will be handled as a normal packet
+			return fd.IPv6Packet(datagram, ts)
+		}
+	}
+
+	// defragmentation might have added layers
+	network, tcp, udp := innermostLayers(p)
 	if tcp != nil {
-		tcp, _ := tcp.(*layers.TCP)
-		fd.tcpAssembler.Assemble(p.NetworkLayer().NetworkFlow(), tcp)
+		fd.tcpAssembler.Assemble(network.NetworkFlow(), tcp)
+	}
+	if udp != nil {
+		fd.udpDatagram(network.NetworkFlow(), udp, ts)
 	}
 
 	return nil
 }
 
+func (fd *Decoder) udpDatagram(net gopacket.Flow, udp *layers.UDP, ts time.Time) {
+	transport := udp.TransportFlow()
+	key := udpFlowKey{net: net, transport: transport}
+
+	fromClient := true
+	flow, ok := fd.udpFlows[key]
+	if !ok {
+		if flow, ok = fd.udpFlows[udpFlowKey{net: net.Reverse(), transport: transport.Reverse()}]; ok {
+			fromClient = false
+		}
+	}
+	if !ok {
+		flow = &UDPFlow{
+			Client: UDPEndpoint{
+				IP:   append([]byte(nil), net.Src().Raw()...),
+				Port: int(udp.SrcPort),
+			},
+			Server: UDPEndpoint{
+				IP:   append([]byte(nil), net.Dst().Raw()...),
+				Port: int(udp.DstPort),
+			},
+		}
+		fd.udpFlows[key] = flow
+		fd.UDPFlows = append(fd.UDPFlows, flow)
+	}
+
+	flow.Datagrams = append(flow.Datagrams, UDPDatagram{
+		FromClient: fromClient,
+		Timestamp:  ts,
+		Payload:    append([]byte(nil), udp.Payload...),
+	})
+}
+
 func (fd *Decoder) Flush() {
 	fd.tcpAssembler.FlushAll()
 }
@@ -0,0 +1,194 @@
+package flowsdecoder
+
+// https://datatracker.ietf.org/doc/html/rfc8200#section-4.5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const (
+	ipv6HeaderLen          = 40
+	ipv6NextHeaderHopByHop = 0
+	ipv6NextHeaderRouting  = 43
+	ipv6NextHeaderFragment = 44
+	ipv6NextHeaderDest     = 60
+	ipv6FragmentHeaderLen  = 8
+)
+
+// limits similar to gopacket ip4defrag so that hostile captures can't use unlimited memory
+const (
+	ipv6MaximumSize            = 65535 // payload length is 16 bit
+	ipv6MaximumFragmentListLen = 8192  // give up on a fragment set with more fragments than this
+	ipv6MaximumFragmentSets    = 1024  // drop oldest incomplete fragment set when more are seen
+)
+
+type ipv6FragmentKey struct {
+	source         [16]byte
+	destination    [16]byte
+	identification uint32
+}
+
+type ipv6Fragment struct {
+	offset int
+	data   []byte
+}
+
+func (f ipv6Fragment) end() int { return f.offset + len(f.data) }
+
+type ipv6Fragments struct {
+	// unfragmentable part from first fragment with next header already replaced
+	unfragmentable []byte
+	// sorted by offset and not overlapping
+	fragments []ipv6Fragment
+	// total length of fragmentable part, -1 until last fragment has been seen
+	length int
+}
+
+type ipv6Defragmenter struct {
+	fragments map[ipv6FragmentKey]*ipv6Fragments
+	// keys of incomplete fragment sets, oldest first
+	order []ipv6FragmentKey
+}
+
+func newIPv6Defragmenter() *ipv6Defragmenter {
+	return &ipv6Defragmenter{fragments: map[ipv6FragmentKey]*ipv6Fragments{}}
+}
+
+func (d *ipv6Defragmenter) flush(key ipv6FragmentKey) {
+	delete(d.fragments, key)
+	for i, k := range d.order {
+		if k == key {
+			d.order = append(d.order[:i], d.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// defragIPv6 returns the reassembled packet when bs is the last missing fragment,
+// nil if bs is not a fragment or more fragments are needed
+func (d *ipv6Defragmenter) defragIPv6(bs []byte) ([]byte, error) {
+	if len(bs) < ipv6HeaderLen {
+		return nil, nil
+	}
+	payloadLength := int(binary.BigEndian.Uint16(bs[4:6]))
+	if payloadLength == 0 {
+		// jumbogram, can't be fragmented
+		return nil, nil
+	}
+	end := ipv6HeaderLen + payloadLength
+	truncated := end > len(bs)
+	if !truncated {
+		bs = bs[:end]
+	}
+
+	// find fragment header, only hop-by-hop, routing and destination options can be before it
+	nextHeaderPos := 6
+	pos := ipv6HeaderLen
+	for bs[nextHeaderPos] != ipv6NextHeaderFragment {
+		switch bs[nextHeaderPos] {
+		case ipv6NextHeaderHopByHop, ipv6NextHeaderRouting, ipv6NextHeaderDest:
+		default:
+			return nil, nil
+		}
+		if pos+2 > len(bs) {
+			return nil, nil
+		}
+		nextHeaderPos = pos
+		pos += (int(bs[pos+1]) + 1) * 8
+	}
+	if truncated || pos+ipv6FragmentHeaderLen > len(bs) {
+		return nil, fmt.Errorf("ipv6 fragment truncated")
+	}
+
+	fragmentNextHeader := bs[pos]
+	offsetFlags := binary.BigEndian.Uint16(bs[pos+2 : pos+4])
+	offset := int(offsetFlags>>3) * 8
+	moreFragments := offsetFlags&1 == 1
+	data := bs[pos+ipv6FragmentHeaderLen:]
+
+	var key ipv6FragmentKey
+	copy(key.source[:], bs[8:24])
+	copy(key.destination[:], bs[24:40])
+	key.identification = binary.BigEndian.Uint32(bs[pos+4 : pos+8])
+
+	if offset+len(data) > ipv6MaximumSize {
+		d.flush(key)
+		return nil, fmt.Errorf("ipv6 fragment exceeds maximum size (%d > %d)", offset+len(data), ipv6MaximumSize)
+	}
+
+	f, ok := d.fragments[key]
+	if !ok {
+		if len(d.order) >= ipv6MaximumFragmentSets {
+			d.flush(d.order[0])
+		}
+		f = &ipv6Fragments{length: -1}
+		d.fragments[key] = f
+		d.order = append(d.order, key)
+	}
+
+	nf := ipv6Fragment{offset: offset, data: data}
+	i := sort.Search(len(f.fragments), func(i int) bool { return f.fragments[i].offset >= offset })
+	if i < len(f.fragments) && f.fragments[i].offset == offset && bytes.Equal(f.fragments[i].data, data) {
+		// exact duplicate, can be dropped, see rfc8200 section 4.5
+		return nil, nil
+	}
+	// overlapping fragments discard the whole set, see rfc5722
+	if (i > 0 && f.fragments[i-1].end() > offset) || (i < len(f.fragments) && nf.end() > f.fragments[i].offset) {
+		d.flush(key)
+		return nil, fmt.Errorf("ipv6 fragment at offset %d overlaps other fragment", offset)
+	}
+	if (f.length != -1 && nf.end() > f.length) || (!moreFragments && len(f.fragments) > 0 && f.fragments[len(f.fragments)-1].end() > nf.end()) {
+		d.flush(key)
+		return nil, fmt.Errorf("ipv6 fragment at offset %d beyond last fragment", offset)
+	}
+	if len(f.fragments) >= ipv6MaximumFragmentListLen {
+		d.flush(key)
+		return nil, fmt.Errorf("ipv6 fragment set hits its maximum size (%d) without success", ipv6MaximumFragmentListLen)
+	}
+
+	if offset == 0 {
+		f.unfragmentable = append([]byte(nil), bs[:pos]...)
+		f.unfragmentable[nextHeaderPos] = fragmentNextHeader
+	}
+	if !moreFragments {
+		f.length = nf.end()
+	}
+	nf.data = append([]byte(nil), data...)
+	f.fragments = append(f.fragments, ipv6Fragment{})
+	copy(f.fragments[i+1:], f.fragments[i:])
+	f.fragments[i] = nf
+
+	if f.unfragmentable == nil || f.length == -1 {
+		return nil, nil
+	}
+
+	// fragments don't overlap so the set is complete if there are no gaps
+	payloadLen := 0
+	for _, fr := range f.fragments {
+		if fr.offset != payloadLen {
+			return nil, nil
+		}
+		payloadLen = fr.end()
+	}
+	if payloadLen != f.length {
+		return nil, nil
+	}
+	d.flush(key)
+
+	// extension headers before the fragment header also counts in payload length
+	if len(f.unfragmentable)-ipv6HeaderLen+payloadLen > ipv6MaximumSize {
+		return nil, fmt.Errorf("ipv6 reassembled payload exceeds maximum size (%d > %d)", len(f.unfragmentable)-ipv6HeaderLen+payloadLen, ipv6MaximumSize)
+	}
+
+	packet := make([]byte, 0, len(f.unfragmentable)+payloadLen)
+	packet = append(packet, f.unfragmentable...)
+	for _, fr := range f.fragments {
+		packet = append(packet, fr.data...)
+	}
+	binary.BigEndian.PutUint16(packet[4:6], uint16(len(packet)-ipv6HeaderLen))
+
+	return packet, nil
+}
@@ -0,0 +1,86 @@
+package inet
+
+// https://datatracker.ietf.org/doc/html/rfc2784
+// https://datatracker.ietf.org/doc/html/rfc2890
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/checksum"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+var grePacketInetPacketGroup decode.Group
+var grePacketLinkFrameGroup decode.Group
+
+func init() {
+	interp.RegisterFormat(
+		format.GRE_Packet,
+		&decode.Format{
+			Description: "Generic routing encapsulation packet",
+			Groups:      []*decode.Group{format.IP_Packet},
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.INET_Packet}, Out: &grePacketInetPacketGroup},
+				{Groups: []*decode.Group{format.Link_Frame}, Out: &grePacketLinkFrameGroup},
+			},
+			DecodeFn: decodeGRE,
+		})
+}
+
+// fieldEtherTypePayload decodes payload as an ethernet frame for transparent ethernet bridging
+// otherwise as a packet of ether type
+func fieldEtherTypePayload(d *decode.D, etherType uint64, inetPacketGroup *decode.Group, linkFrameGroup *decode.Group) {
+	if etherType == format.EtherTypeTransparentEthernetBridging {
+		d.FieldFormatOrRawLen(
+			"payload",
+			d.BitsLeft(),
+			linkFrameGroup,
+			format.Link_Frame_In{Type: format.LinkTypeETHERNET},
+		)
+		return
+	}
+	d.FieldFormatOrRawLen(
+		"payload",
+		d.BitsLeft(),
+		inetPacketGroup,
+		format.INET_Packet_In{EtherType: int(etherType)},
+	)
+}
+
+func decodeGRE(d *decode.D) any {
+	var ipi format.IP_Packet_In
+	if d.ArgAs(&ipi) && ipi.Protocol != format.IPv4ProtocolGRE {
+		d.Fatalf("incorrect protocol %d", ipi.Protocol)
+	}
+
+	checksumPresent := d.FieldBool("checksum_present")
+	// deprecated in RFC 2784
+	d.FieldBool("routing_present")
+	keyPresent := d.FieldBool("key_present")
+	sequenceNumberPresent := d.FieldBool("sequence_number_present")
+	d.FieldU9("reserved0")
+	d.FieldU3("version")
+	protocolType := d.FieldU16("protocol_type", format.EtherTypeMap, scalar.UintHex)
+	if checksumPresent {
+		// checksum of header and payload with checksum field as zero
+		checksumStart := d.Pos()
+		checksumEnd := checksumStart + 16
+		greChecksum := &checksum.IPv4{}
+		d.Copy(greChecksum, bitio.NewIOReader(d.BitBufRange(0, checksumStart)))
+		d.Copy(greChecksum, bitio.NewIOReader(d.BitBufRange(checksumEnd, d.Len()-checksumEnd)))
+		d.FieldU16("checksum", d.UintValidateBytes(greChecksum.Sum(nil)), scalar.UintHex)
+		d.FieldU16("reserved1")
+	}
+	if keyPresent {
+		d.FieldU32("key", scalar.UintHex)
+	}
+	if sequenceNumberPresent {
+		d.FieldU32("sequence_number")
+	}
+
+	fieldEtherTypePayload(d, protocolType, &grePacketInetPacketGroup, &grePacketLinkFrameGroup)
+
+	return nil
+}
@@ -86,7 +86,12 @@ func decodeICMPv6(d *decode.D) any {
 
 	typ := d.FieldU8("type", icmpv6TypeMap)
 	d.FieldU8("code", icmpv6CodeMapMap[typ])
-	d.FieldU16("checksum")
+	checksumStart := d.Pos()
+	d.FieldU16("checksum", scalar.UintHex)
+	if ipi.SourceAddress != nil {
+		icmpv6Checksum := transportChecksum(d, ipi, checksumStart)
+		_ = d.FieldMustGet("checksum").TryUintScalarFn(d.UintValidateBytes(icmpv6Checksum), scalar.UintHex)
+	}
 	d.FieldRawLen("content", d.BitsLeft())
 
 	return nil
@@ -77,6 +77,7 @@ func decodeIPv4(d *decode.D) any {
 	checksumStart := d.Pos()
 	d.FieldU16("header_checksum", scalar.UintHex)
 	checksumEnd := d.Pos()
+	addressesStart := d.Pos()
 	d.FieldU32("source_ip", mapUToIPv4Sym, scalar.UintHex)
 	d.FieldU32("destination_ip", mapUToIPv4Sym, scalar.UintHex)
 	optionsLen := (int64(ihl) - 5) * 8 * 4
@@ -115,7 +116,12 @@ func decodeIPv4(d *decode.D) any {
 			"payload",
 			dataLen,
 			&ipv4IpPacketGroup,
-			format.IP_Packet_In{Protocol: int(protocol)},
+			format.IP_Packet_In{
+				Protocol:           int(protocol),
+				SourceAddress:      d.BytesRange(addressesStart, 4),
+				DestinationAddress: d.BytesRange(addressesStart+32, 4),
+				Length:             int(dataLen / 8),
+			},
 		)
 	}
 
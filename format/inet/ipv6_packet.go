@@ -103,6 +103,37 @@ var hopByHopTypeNames = scalar.UintMapSymStr{
 	0x31: "ioam",
 }
 
+const (
+	ipv6OptionPad1         = 0x00
+	ipv6OptionJumboPayload = 0xc2
+)
+
+const (
+	routingTypeSourceRoute    = 0
+	routingTypeType2          = 2
+	routingTypeSegmentRouting = 4
+)
+
+// from https://www.iana.org/assignments/ipv6-parameters/ipv6-parameters.xhtml#ipv6-parameters-3
+var routingTypeNames = scalar.UintMapSymStr{
+	routingTypeSourceRoute:    "source_route",
+	1:                         "nimrod",
+	routingTypeType2:          "type2",
+	3:                         "rpl_source_route",
+	routingTypeSegmentRouting: "segment_routing",
+}
+
+const (
+	srhTLVPad1 = 0
+)
+
+// from https://www.iana.org/assignments/ipv6-parameters/ipv6-parameters.xhtml#segment-routing-header-tlvs
+var srhTLVTypeNames = scalar.UintMapSymStr{
+	srhTLVPad1: "pad1",
+	4:          "padn",
+	5:          "hmac",
+}
+
 var mapUToIPv6Sym = scalar.BitBufFn(func(s scalar.BitBuf) (scalar.BitBuf, error) {
 	b := &bytes.Buffer{}
 	if _, err := bitioex.CopyBits(b, s.Actual); err != nil {
@@ -128,37 +159,54 @@ func decodeIPv6(d *decode.D) any {
 	dataLength := d.FieldU16("payload_length")
 	nextHeader := d.FieldU8("next_header", nextHeaderMap)
 	d.FieldU8("hop_limit")
+	addressesStart := d.Pos()
 	d.FieldRawLen("source_address", 128, mapUToIPv6Sym)
 	d.FieldRawLen("destination_address", 128, mapUToIPv6Sym)
 
+	var jumboPayloadLength uint64
+	fragmented := false
+	destinationAddress := d.BytesRange(addressesStart+128, 16)
+
 	extStart := d.Pos()
-	if isIpv6Option(nextHeader) {
-		// TODO: own format?
+	// encapsulating security payload has no readable next header so handle it as payload
+	if isIpv6Option(nextHeader) && nextHeader != nextHeaderEncapsulatingSecurityPayload {
 		d.FieldArray("extensions", func(d *decode.D) {
-			for isIpv6Option(nextHeader) {
+			for isIpv6Option(nextHeader) && nextHeader != nextHeaderEncapsulatingSecurityPayload {
 				d.FieldStruct("extension", func(d *decode.D) {
 					currentHeader := nextHeader
 					nextHeader = d.FieldU8("next_header", nextHeaderMap)
-					extLen := d.FieldU8("length")
-					// whole header not including the first 8 octets
-					extLen += 6
-
-					d.FramedFn(int64(extLen)*8, func(d *decode.D) {
-						switch currentHeader {
-						case nextHeaderHopByHop:
-							d.FieldArray("options", func(d *decode.D) {
-								for !d.End() {
-									d.FieldStruct("option", func(d *decode.D) {
-										d.FieldU8("type", hopByHopTypeNames)
-										l := d.FieldU8("len")
-										d.FieldRawLen("data", int64(l)*8)
-									})
+
+					switch currentHeader {
+					case nextHeaderFragment:
+						// fixed length and no length field
+						d.FieldU8("reserved0")
+						fragmentOffset := d.FieldU13("fragment_offset")
+						d.FieldU2("reserved1")
+						moreFragments := d.FieldBool("more_fragments")
+						d.FieldU32("identification")
+						fragmented = moreFragments || fragmentOffset > 0
+					case nextHeaderAuthentication:
+						// length in 4 octet units minus 2
+						extLen := d.FieldU8("length")
+						d.FieldRawLen("payload", (int64(extLen)*4+6)*8)
+					default:
+						// length in 8 octet units not including the first 8 octets
+						extLen := d.FieldU8("length")
+						d.FramedFn((int64(extLen)*8+6)*8, func(d *decode.D) {
+							switch currentHeader {
+							case nextHeaderHopByHop, nextHeaderDestination:
+								if l := decodeIPv6Options(d); l != 0 {
+									jumboPayloadLength = l
 								}
-							})
-						default:
-							d.FieldRawLen("payload", d.BitsLeft())
-						}
-					})
+							case nextHeaderRouting:
+								if a := decodeIPv6Routing(d); a != nil {
+									destinationAddress = a
+								}
+							default:
+								d.FieldRawLen("payload", d.BitsLeft())
+							}
+						})
+					}
 				})
 			}
 		})
@@ -166,16 +214,108 @@ func decodeIPv6(d *decode.D) any {
 	extEnd := d.Pos()
 	extLen := extEnd - extStart
 
-	// TODO: jumbo
+	if dataLength == 0 && jumboPayloadLength != 0 {
+		dataLength = jumboPayloadLength
+	}
+
 	// TODO: nextHeader 59 skip
 
 	payloadLen := int64(dataLength)*8 - extLen
-	d.FieldFormatOrRawLen(
-		"payload",
-		payloadLen,
-		&ipv4IpPacketGroup,
-		format.IP_Packet_In{Protocol: int(nextHeader)},
-	)
+	if fragmented {
+		d.FieldRawLen("payload", payloadLen)
+	} else {
+		d.FieldFormatOrRawLen(
+			"payload",
+			payloadLen,
+			&ipv6IpPacketGroup,
+			format.IP_Packet_In{
+				Protocol:           int(nextHeader),
+				SourceAddress:      d.BytesRange(addressesStart, 16),
+				DestinationAddress: destinationAddress,
+				Length:             int(payloadLen / 8),
+			},
+		)
+	}
 
 	return nil
 }
+
+// decodeIPv6Options decodes hop-by-hop or destination options and returns jumbo payload length if found
+func decodeIPv6Options(d *decode.D) uint64 {
+	var jumboPayloadLength uint64
+	d.FieldArray("options", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("option", func(d *decode.D) {
+				typ := d.FieldU8("type", hopByHopTypeNames)
+				// pad1 is a single octet without length
+				if typ == ipv6OptionPad1 {
+					return
+				}
+				l := d.FieldU8("len")
+				if typ == ipv6OptionJumboPayload && l == 4 {
+					jumboPayloadLength = d.FieldU32("jumbo_payload_length")
+					return
+				}
+				d.FieldRawLen("data", int64(l)*8)
+			})
+		}
+	})
+	return jumboPayloadLength
+}
+
+// decodeIPv6Routing returns the final destination address if there are segments left. It is
+// used instead of the destination address in transport checksum pseudo headers.
+func decodeIPv6Routing(d *decode.D) []byte {
+	var finalDestinationAddress []byte
+
+	routingType := d.FieldU8("routing_type", routingTypeNames)
+	segmentsLeft := d.FieldU8("segments_left")
+	switch routingType {
+	case routingTypeSourceRoute:
+		d.FieldU32("reserved")
+		d.FieldArray("addresses", func(d *decode.D) {
+			for !d.End() {
+				finalDestinationAddress = d.BytesRange(d.Pos(), 16)
+				d.FieldRawLen("address", 128, mapUToIPv6Sym)
+			}
+		})
+	case routingTypeType2:
+		d.FieldU32("reserved")
+		finalDestinationAddress = d.BytesRange(d.Pos(), 16)
+		d.FieldRawLen("home_address", 128, mapUToIPv6Sym)
+	case routingTypeSegmentRouting:
+		// https://datatracker.ietf.org/doc/html/rfc8754#section-2
+		lastEntry := d.FieldU8("last_entry")
+		d.FieldU8("flags")
+		d.FieldU16("tag")
+		// segment list is in reverse order, final segment first
+		finalDestinationAddress = d.BytesRange(d.Pos(), 16)
+		d.FieldArray("segments", func(d *decode.D) {
+			for i := uint64(0); i <= lastEntry; i++ {
+				d.FieldRawLen("segment", 128, mapUToIPv6Sym)
+			}
+		})
+		if !d.End() {
+			d.FieldArray("tlvs", func(d *decode.D) {
+				for !d.End() {
+					d.FieldStruct("tlv", func(d *decode.D) {
+						typ := d.FieldU8("type", srhTLVTypeNames)
+						// pad1 is a single octet without length
+						if typ == srhTLVPad1 {
+							return
+						}
+						l := d.FieldU8("length")
+						d.FieldRawLen("value", int64(l)*8)
+					})
+				}
+			})
+		}
+	default:
+		d.FieldRawLen("data", d.BitsLeft())
+	}
+
+	if segmentsLeft == 0 {
+		return nil
+	}
+	return finalDestinationAddress
+}
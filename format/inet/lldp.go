@@ -0,0 +1,206 @@
+package inet
+
+// https://standards.ieee.org/ieee/802.1AB/6047/
+// https://en.wikipedia.org/wiki/Link_Layer_Discovery_Protocol
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+func init() {
+	interp.RegisterFormat(
+		format.LLDP,
+		&decode.Format{
+			Description: "Link layer discovery protocol",
+			Groups:      []*decode.Group{format.INET_Packet},
+			DecodeFn:    decodeLLDP,
+		})
+}
+
+const (
+	lldpTLVEnd                  = 0
+	lldpTLVChassisID            = 1
+	lldpTLVPortID               = 2
+	lldpTLVTTL                  = 3
+	lldpTLVPortDescription      = 4
+	lldpTLVSystemName           = 5
+	lldpTLVSystemDescription    = 6
+	lldpTLVSystemCapabilities   = 7
+	lldpTLVManagementAddress    = 8
+	lldpTLVOrganizationSpecific = 127
+)
+
+var lldpTLVTypeNames = scalar.UintMapSymStr{
+	lldpTLVEnd:                  "end",
+	lldpTLVChassisID:            "chassis_id",
+	lldpTLVPortID:               "port_id",
+	lldpTLVTTL:                  "ttl",
+	lldpTLVPortDescription:      "port_description",
+	lldpTLVSystemName:           "system_name",
+	lldpTLVSystemDescription:    "system_description",
+	lldpTLVSystemCapabilities:   "system_capabilities",
+	lldpTLVManagementAddress:    "management_address",
+	lldpTLVOrganizationSpecific: "organization_specific",
+}
+
+const (
+	lldpChassisIDMACAddress     = 4
+	lldpChassisIDNetworkAddress = 5
+)
+
+var lldpChassisIDSubtypeNames = scalar.UintMapSymStr{
+	1:                           "chassis_component",
+	2:                           "interface_alias",
+	3:                           "port_component",
+	lldpChassisIDMACAddress:     "mac_address",
+	lldpChassisIDNetworkAddress: "network_address",
+	6:                           "interface_name",
+	7:                           "locally_assigned",
+}
+
+const (
+	lldpPortIDMACAddress     = 3
+	lldpPortIDNetworkAddress = 4
+)
+
+var lldpPortIDSubtypeNames = scalar.UintMapSymStr{
+	1:                        "interface_alias",
+	2:                        "port_component",
+	lldpPortIDMACAddress:     "mac_address",
+	lldpPortIDNetworkAddress: "network_address",
+	5:                        "interface_name",
+	6:                        "agent_circuit_id",
+	7:                        "locally_assigned",
+}
+
+const (
+	addressFamilyIPv4 = 1
+	addressFamilyIPv6 = 2
+)
+
+// from https://www.iana.org/assignments/address-family-numbers/address-family-numbers.xhtml
+var addressFamilyNames = scalar.UintMapSymStr{
+	addressFamilyIPv4: "ipv4",
+	addressFamilyIPv6: "ipv6",
+	6:                 "ieee802",
+}
+
+var lldpInterfaceSubtypeNames = scalar.UintMapSymStr{
+	1: "unknown",
+	2: "if_index",
+	3: "system_port_number",
+}
+
+var lldpOrganizationNames = scalar.UintMapSymStr{
+	0x00_80c2: "ieee_802_1",
+	0x00_120f: "ieee_802_3",
+	0x00_12bb: "tia_tr_41",
+}
+
+// address prefixed with address family
+func fieldLLDPNetworkAddress(d *decode.D, name string, nBytes int) {
+	d.FieldStruct(name, func(d *decode.D) {
+		family := d.FieldU8("family", addressFamilyNames)
+		addressBits := int64(nBytes-1) * 8
+		switch {
+		case family == addressFamilyIPv4 && addressBits == 32:
+			d.FieldU32("address", mapUToIPv4Sym, scalar.UintHex)
+		case family == addressFamilyIPv6 && addressBits == 128:
+			d.FieldRawLen("address", 128, mapUToIPv6Sym)
+		default:
+			d.FieldRawLen("address", addressBits)
+		}
+	})
+}
+
+func fieldLLDPID(d *decode.D, subtype uint64, macAddress uint64, networkAddress uint64) {
+	nBytes := int(d.BitsLeft() / 8)
+	switch {
+	case subtype == macAddress && nBytes == 6:
+		d.FieldU("id", 48, mapUToEtherSym, scalar.UintHex)
+	case subtype == networkAddress && nBytes > 1:
+		fieldLLDPNetworkAddress(d, "id", nBytes)
+	default:
+		d.FieldUTF8("id", nBytes)
+	}
+}
+
+func fieldLLDPCapabilities(d *decode.D, name string) {
+	d.FieldStruct(name, func(d *decode.D) {
+		d.FieldU5("reserved")
+		d.FieldBool("two_port_mac_relay")
+		d.FieldBool("s_vlan")
+		d.FieldBool("c_vlan")
+		d.FieldBool("station_only")
+		d.FieldBool("docsis_cable_device")
+		d.FieldBool("telephone")
+		d.FieldBool("router")
+		d.FieldBool("wlan_access_point")
+		d.FieldBool("bridge")
+		d.FieldBool("repeater")
+		d.FieldBool("other")
+	})
+}
+
+func decodeLLDP(d *decode.D) any {
+	var ipi format.INET_Packet_In
+	if d.ArgAs(&ipi) && ipi.EtherType != format.EtherTypeLLDP {
+		d.Fatalf("incorrect ethertype %d", ipi.EtherType)
+	}
+
+	seenEnd := false
+	d.FieldArray("tlvs", func(d *decode.D) {
+		for !seenEnd && d.BitsLeft() >= 16 {
+			d.FieldStruct("tlv", func(d *decode.D) {
+				typ := d.FieldU7("type", lldpTLVTypeNames)
+				length := d.FieldU9("length")
+
+				d.FramedFn(int64(length)*8, func(d *decode.D) {
+					switch typ {
+					case lldpTLVEnd:
+						seenEnd = true
+					case lldpTLVChassisID:
+						subtype := d.FieldU8("subtype", lldpChassisIDSubtypeNames)
+						fieldLLDPID(d, subtype, lldpChassisIDMACAddress, lldpChassisIDNetworkAddress)
+					case lldpTLVPortID:
+						subtype := d.FieldU8("subtype", lldpPortIDSubtypeNames)
+						fieldLLDPID(d, subtype, lldpPortIDMACAddress, lldpPortIDNetworkAddress)
+					case lldpTLVTTL:
+						d.FieldU16("ttl")
+					case lldpTLVPortDescription,
+						lldpTLVSystemName,
+						lldpTLVSystemDescription:
+						d.FieldUTF8("value", int(length))
+					case lldpTLVSystemCapabilities:
+						fieldLLDPCapabilities(d, "capabilities")
+						fieldLLDPCapabilities(d, "enabled_capabilities")
+					case lldpTLVManagementAddress:
+						// address length includes family
+						addressLength := d.FieldU8("address_length", d.UintAssertRange(1, 32))
+						fieldLLDPNetworkAddress(d, "address", int(addressLength))
+						d.FieldU8("interface_subtype", lldpInterfaceSubtypeNames)
+						d.FieldU32("interface_number")
+						oidLength := d.FieldU8("oid_length")
+						d.FieldRawLen("oid", int64(oidLength)*8)
+					case lldpTLVOrganizationSpecific:
+						d.FieldU24("oui", lldpOrganizationNames, scalar.UintHex)
+						d.FieldU8("subtype")
+						d.FieldRawLen("information", d.BitsLeft())
+					default:
+						d.FieldRawLen("value", d.BitsLeft())
+					}
+				})
+			})
+		}
+	})
+
+	// bytes after end tlv, usually ethernet padding
+	if d.BitsLeft() > 0 {
+		d.FieldRawLen("padding", d.BitsLeft())
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package inet
+
+// https://datatracker.ietf.org/doc/html/rfc3032
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+var mplsPacketInetPacketGroup decode.Group
+var mplsPacketLinkFrameGroup decode.Group
+
+func init() {
+	interp.RegisterFormat(
+		format.MPLS_Packet,
+		&decode.Format{
+			Description: "Multiprotocol label switching packet",
+			Groups:      []*decode.Group{format.INET_Packet},
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.INET_Packet}, Out: &mplsPacketInetPacketGroup},
+				{Groups: []*decode.Group{format.Link_Frame}, Out: &mplsPacketLinkFrameGroup},
+			},
+			DecodeFn: decodeMPLS,
+		})
+}
+
+// from https://www.iana.org/assignments/mpls-label-values/mpls-label-values.xhtml
+var mplsLabelNames = scalar.UintMapSymStr{
+	0:  "ipv4_explicit_null",
+	1:  "router_alert",
+	2:  "ipv6_explicit_null",
+	3:  "implicit_null",
+	7:  "entropy_label_indicator",
+	13: "gal",
+	14: "oam_alert",
+	15: "extension",
+}
+
+func decodeMPLS(d *decode.D) any {
+	var ipi format.INET_Packet_In
+	if d.ArgAs(&ipi) && ipi.EtherType != format.EtherTypeMPLSUnicast && ipi.EtherType != format.EtherTypeMPLSMulticast {
+		d.Fatalf("incorrect ethertype %d", ipi.EtherType)
+	}
+
+	bottomOfStack := false
+	d.FieldArray("label_stack", func(d *decode.D) {
+		for !bottomOfStack {
+			d.FieldStruct("entry", func(d *decode.D) {
+				d.FieldU20("label", mplsLabelNames)
+				d.FieldU3("traffic_class")
+				bottomOfStack = d.FieldBool("bottom_of_stack")
+				d.FieldU8("ttl")
+			})
+		}
+	})
+
+	if d.BitsLeft() < 4 {
+		d.FieldRawLen("payload", d.BitsLeft())
+		return nil
+	}
+
+	// payload type is not signaled so guess from ip version like most implementations and
+	// otherwise assume ethernet pseudowire without control word
+	switch d.PeekUintBits(4) {
+	case 4:
+		d.FieldFormatOrRawLen(
+			"payload",
+			d.BitsLeft(),
+			&mplsPacketInetPacketGroup,
+			format.INET_Packet_In{EtherType: format.EtherTypeIPv4},
+		)
+	case 6:
+		d.FieldFormatOrRawLen(
+			"payload",
+			d.BitsLeft(),
+			&mplsPacketInetPacketGroup,
+			format.INET_Packet_In{EtherType: format.EtherTypeIPv6},
+		)
+	default:
+		d.FieldFormatOrRawLen(
+			"payload",
+			d.BitsLeft(),
+			&mplsPacketLinkFrameGroup,
+			format.Link_Frame_In{Type: format.LinkTypeETHERNET},
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package inet
+
+// https://standards.ieee.org/ieee/802.1D/3387/
+// https://standards.ieee.org/ieee/802.1Q/6844/
+// https://en.wikipedia.org/wiki/Spanning_Tree_Protocol
+
+import (
+	"fmt"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+func init() {
+	interp.RegisterFormat(
+		format.STP,
+		&decode.Format{
+			Description: "Spanning tree protocol bridge protocol data unit",
+			DecodeFn:    decodeSTP,
+		})
+}
+
+const (
+	stpVersionSTP  = 0
+	stpVersionRSTP = 2
+	stpVersionMSTP = 3
+)
+
+var stpVersionNames = scalar.UintMapSymStr{
+	stpVersionSTP:  "stp",
+	stpVersionRSTP: "rstp",
+	stpVersionMSTP: "mstp",
+}
+
+const (
+	stpBPDUTypeConfiguration              = 0x00
+	stpBPDUTypeRST                        = 0x02
+	stpBPDUTypeTopologyChangeNotification = 0x80
+)
+
+var stpBPDUTypeNames = scalar.UintMapSymStr{
+	stpBPDUTypeConfiguration:              "configuration",
+	stpBPDUTypeRST:                        "rst",
+	stpBPDUTypeTopologyChangeNotification: "topology_change_notification",
+}
+
+var stpPortRoleNames = scalar.UintMapSymStr{
+	0: "unknown",
+	1: "alternate_or_backup",
+	2: "root",
+	3: "designated",
+}
+
+// timer values are in 1/256 seconds
+var stpTimeDescription = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	s.Description = fmt.Sprintf("%gs", float64(s.Actual)/256)
+	return s, nil
+})
+
+// priorities are the high bits of a 16 bit value
+var stpBridgePriorityDescription = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	s.Description = fmt.Sprintf("%d", s.Actual<<12)
+	return s, nil
+})
+
+var stpPortPriorityDescription = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	s.Description = fmt.Sprintf("%d", s.Actual<<4)
+	return s, nil
+})
+
+func fieldSTPFlags(d *decode.D) {
+	d.FieldStruct("flags", func(d *decode.D) {
+		d.FieldBool("topology_change_acknowledgment")
+		d.FieldBool("agreement")
+		d.FieldBool("forwarding")
+		d.FieldBool("learning")
+		d.FieldU2("port_role", stpPortRoleNames)
+		d.FieldBool("proposal")
+		d.FieldBool("topology_change")
+	})
+}
+
+func fieldSTPBridgeIdentifier(d *decode.D, name string) {
+	d.FieldStruct(name, func(d *decode.D) {
+		d.FieldU4("priority", stpBridgePriorityDescription)
+		d.FieldU12("system_id_extension")
+		d.FieldU("address", 48, mapUToEtherSym, scalar.UintHex)
+	})
+}
+
+func decodeMSTP(d *decode.D) {
+	d.FieldStruct("mst_configuration_identifier", func(d *decode.D) {
+		d.FieldU8("format_selector")
+		d.FieldUTF8NullFixedLen("name", 32)
+		d.FieldU16("revision_level")
+		d.FieldRawLen("digest", 16*8)
+	})
+	d.FieldU32("cist_internal_root_path_cost")
+	fieldSTPBridgeIdentifier(d, "cist_bridge_identifier")
+	d.FieldU8("cist_remaining_hops")
+	d.FieldArray("msti_configurations", func(d *decode.D) {
+		for d.BitsLeft() >= 16*8 {
+			d.FieldStruct("msti_configuration", func(d *decode.D) {
+				fieldSTPFlags(d)
+				fieldSTPBridgeIdentifier(d, "regional_root_identifier")
+				d.FieldU32("internal_root_path_cost")
+				d.FieldU4("bridge_priority")
+				d.FieldU4("reserved0")
+				d.FieldU4("port_priority")
+				d.FieldU4("reserved1")
+				d.FieldU8("remaining_hops")
+			})
+		}
+	})
+}
+
+func decodeSTP(d *decode.D) any {
+	d.FieldU16("protocol_identifier", d.UintAssert(0))
+	version := d.FieldU8("version", stpVersionNames)
+	bpduType := d.FieldU8("bpdu_type", stpBPDUTypeNames, scalar.UintHex)
+
+	switch bpduType {
+	case stpBPDUTypeConfiguration, stpBPDUTypeRST:
+		fieldSTPFlags(d)
+		fieldSTPBridgeIdentifier(d, "root_identifier")
+		d.FieldU32("root_path_cost")
+		fieldSTPBridgeIdentifier(d, "bridge_identifier")
+		d.FieldStruct("port_identifier", func(d *decode.D) {
+			d.FieldU4("priority", stpPortPriorityDescription)
+			d.FieldU12("number")
+		})
+		d.FieldU16("message_age", stpTimeDescription)
+		d.FieldU16("max_age", stpTimeDescription)
+		d.FieldU16("hello_time", stpTimeDescription)
+		d.FieldU16("forward_delay", stpTimeDescription)
+
+		if bpduType == stpBPDUTypeRST {
+			d.FieldU8("version_1_length")
+		}
+		if version >= stpVersionMSTP && d.BitsLeft() >= 16 {
+			version3Length := d.FieldU16("version_3_length")
+			d.FramedFn(int64(version3Length)*8, decodeMSTP)
+		}
+	case stpBPDUTypeTopologyChangeNotification:
+	default:
+		d.FieldRawLen("data", d.BitsLeft())
+	}
+
+	return nil
+}
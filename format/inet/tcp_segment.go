@@ -61,9 +61,8 @@ func decodeTCP(d *decode.D) any {
 	d.FieldBool("syn")
 	d.FieldBool("fin")
 	d.FieldU16("window_size")
-	// checksumStart := d.Pos()
+	checksumStart := d.Pos()
 	d.FieldU16("checksum", scalar.UintHex)
-	// checksumEnd := d.Pos()
 	d.FieldU16("urgent_pointer")
 	optionsLen := (int64(dataOffset) - 5) * 8 * 4
 	if optionsLen > 0 {
@@ -108,11 +107,10 @@ func decodeTCP(d *decode.D) any {
 		})
 	}
 
-	// TODO: need to pass ipv4 pseudo header somehow
-	// tcpChecksum := &checksum.IPv4{}
-	// d.MustCopy(tcpChecksum, d.BitBufRange(0, checksumStart))
-	// d.MustCopy(tcpChecksum, d.BitBufRange(checksumEnd, d.Len()-checksumEnd))
-	// _ = d.FieldMustGet("checksum").TryScalarFn(d.UintValidateBytes(tcpChecksum.Sum(nil)), scalar.Hex)
+	if ipi.SourceAddress != nil {
+		tcpChecksum := transportChecksum(d, ipi, checksumStart)
+		_ = d.FieldMustGet("checksum").TryUintScalarFn(d.UintValidateBytes(tcpChecksum), scalar.UintHex)
+	}
 
 	d.FieldRawLen("payload", d.BitsLeft())
 
@@ -31,7 +31,17 @@ func decodeUDP(d *decode.D) any {
 	sourcePort := d.FieldU16("source_port", format.UDPPortMap)
 	destPort := d.FieldU16("destination_port", format.UDPPortMap)
 	length := d.FieldU16("length")
-	d.FieldU16("checksum", scalar.UintHex)
+	checksumStart := d.Pos()
+	checksum := d.FieldU16("checksum", scalar.UintHex)
+	// zero means no checksum, only allowed for IPv4
+	if ipi.SourceAddress != nil && (checksum != 0 || len(ipi.SourceAddress) != 4) {
+		udpChecksum := transportChecksum(d, ipi, checksumStart)
+		// a calculated zero checksum is transmitted as all ones
+		if udpChecksum[0] == 0 && udpChecksum[1] == 0 {
+			udpChecksum = []byte{0xff, 0xff}
+		}
+		_ = d.FieldMustGet("checksum").TryUintScalarFn(d.UintValidateBytes(udpChecksum), scalar.UintHex)
+	}
 
 	payloadLen := int64(length-8) * 8
 	d.FieldFormatOrRawLen(
@@ -44,7 +54,5 @@ func decodeUDP(d *decode.D) any {
 		},
 	)
 
-	// TODO: for checksum need to pass ipv4 pseudo header somehow
-
 	return nil
 }
@@ -0,0 +1,65 @@
+package inet
+
+// https://en.wikipedia.org/wiki/IEEE_802.1Q
+// https://en.wikipedia.org/wiki/IEEE_802.1ad
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+var vlanTagInetPacketGroup decode.Group
+
+func init() {
+	interp.RegisterFormat(
+		format.VLAN_Tag,
+		&decode.Format{
+			Description: "IEEE 802.1Q VLAN tag",
+			Groups:      []*decode.Group{format.INET_Packet},
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.INET_Packet}, Out: &vlanTagInetPacketGroup},
+			},
+			DecodeFn: decodeVLANTag,
+		})
+}
+
+var vlanPriorityNames = scalar.UintMapSymStr{
+	0: "best_effort",
+	1: "background",
+	2: "excellent_effort",
+	3: "critical_applications",
+	4: "video",
+	5: "voice",
+	6: "internetwork_control",
+	7: "network_control",
+}
+
+func decodeVLANTag(d *decode.D) any {
+	var ipi format.INET_Packet_In
+	if d.ArgAs(&ipi) {
+		switch ipi.EtherType {
+		case format.EtherTypeVLAN,
+			format.EtherTypeQinQ,
+			format.EtherTypeQinQLegacy:
+		default:
+			d.Fatalf("incorrect ethertype %d", ipi.EtherType)
+		}
+	}
+
+	d.FieldU3("priority", vlanPriorityNames)
+	d.FieldBool("drop_eligible")
+	d.FieldU12("vlan_id")
+	// inner tag for 802.1ad double tagging
+	etherType := d.FieldU16("ether_type", format.EtherTypeMap, scalar.UintHex)
+
+	d.FieldFormatOrRawLen(
+		"payload",
+		d.BitsLeft(),
+		&vlanTagInetPacketGroup,
+		format.INET_Packet_In{EtherType: int(etherType)},
+	)
+
+	return nil
+}
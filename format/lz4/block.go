@@ -0,0 +1,73 @@
+package lz4
+
+// LZ4 block format
+// https://github.com/lz4/lz4/blob/dev/doc/lz4_Block_format.md
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errCorruptedBlock = errors.New("lz4: corrupted block")
+
+// blockDecode appends decoded block to out. Matches can refer to anything
+// already in out so earlier blocks in a frame are used as history.
+func blockDecode(out []byte, b []byte) ([]byte, error) {
+	readLength := func(l int) (int, error) {
+		if l != 15 {
+			return l, nil
+		}
+		for {
+			if len(b) == 0 {
+				return 0, errCorruptedBlock
+			}
+			v := b[0]
+			b = b[1:]
+			l += int(v)
+			if v != 255 {
+				return l, nil
+			}
+		}
+	}
+
+	for {
+		if len(b) == 0 {
+			return out, errCorruptedBlock
+		}
+		token := b[0]
+		b = b[1:]
+
+		literalLength, err := readLength(int(token >> 4))
+		if err != nil {
+			return out, err
+		}
+		if len(b) < literalLength {
+			return out, errCorruptedBlock
+		}
+		out = append(out, b[:literalLength]...)
+		b = b[literalLength:]
+		// last sequence has only literals
+		if len(b) == 0 {
+			return out, nil
+		}
+
+		if len(b) < 2 {
+			return out, errCorruptedBlock
+		}
+		offset := int(binary.LittleEndian.Uint16(b))
+		b = b[2:]
+		matchLength, err := readLength(int(token & 0xf))
+		if err != nil {
+			return out, err
+		}
+		matchLength += 4
+		if offset == 0 || offset > len(out) {
+			return out, errCorruptedBlock
+		}
+		// byte by byte as match can overlap with itself
+		from := len(out) - offset
+		for i := 0; i < matchLength; i++ {
+			out = append(out, out[from+i])
+		}
+	}
+}
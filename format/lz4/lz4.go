@@ -0,0 +1,193 @@
+package lz4
+
+// https://github.com/lz4/lz4/blob/dev/doc/lz4_Frame_format.md
+
+import (
+	"embed"
+	"encoding/binary"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/internal/xxhash"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed lz4.md
+var lz4FS embed.FS
+
+var probeGroup decode.Group
+
+func init() {
+	interp.RegisterFormat(
+		format.LZ4,
+		&decode.Format{
+			Description: "LZ4 frame compression",
+			Groups:      []*decode.Group{format.Probe},
+			DecodeFn:    lz4Decode,
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.Probe}, Out: &probeGroup},
+			},
+		})
+	interp.RegisterFS(lz4FS)
+}
+
+const (
+	frameMagic         = 0x184d_2204
+	skippableMagicMask = 0xffff_fff0
+	skippableMagic     = 0x184d_2a50
+)
+
+var blockMaxSizes = scalar.UintMapSymUint{
+	4: 64 * 1024,
+	5: 256 * 1024,
+	6: 1024 * 1024,
+	7: 4 * 1024 * 1024,
+}
+
+func isSkippableMagic(m uint64) bool { return m&skippableMagicMask == skippableMagic }
+
+// decodeBlocks returns uncompressed data and false if it could not be decompressed
+func decodeBlocks(d *decode.D, hasBlockChecksum bool, canDecompress bool) ([]byte, bool) {
+	var out []byte
+	ok := canDecompress
+
+	d.FieldArray("blocks", func(d *decode.D) {
+		// block with size zero is the end mark
+		for d.PeekUintBits(32) != 0 {
+			d.FieldStruct("block", func(d *decode.D) {
+				var blockSize uint64
+				var uncompressed bool
+				d.FieldStruct("header", func(d *decode.D) {
+					// little endian with highest bit as flag so block size is not contiguous bits
+					blockSize0 := d.FieldU24("block_size0")
+					uncompressed = d.FieldBool("uncompressed")
+					blockSize1 := d.FieldU7("block_size1")
+					blockSize = blockSize0 | blockSize1<<24
+					d.FieldValueUint("block_size", blockSize)
+				})
+
+				data := d.BytesRange(d.Pos(), int(blockSize))
+				d.FieldRawLen("data", int64(blockSize)*8)
+				if ok {
+					if uncompressed {
+						out = append(out, data...)
+					} else {
+						var err error
+						if out, err = blockDecode(out, data); err != nil {
+							ok = false
+						}
+					}
+				}
+				if hasBlockChecksum {
+					d.FieldU32("checksum", d.UintValidate(uint64(xxhash.Sum32(data, 0))), scalar.UintHex)
+				}
+			})
+		}
+	})
+	d.FieldU32("end_mark", d.UintValidate(0))
+
+	return out, ok
+}
+
+// decodeFrame returns uncompressed data and false if it could not be decompressed
+func decodeFrame(d *decode.D) ([]byte, bool) {
+	d.FieldU32("magic", d.UintAssert(frameMagic), scalar.UintHex)
+
+	var hasBlockChecksum bool
+	var hasContentChecksum bool
+	var hasDictionaryID bool
+	d.FieldStruct("descriptor", func(d *decode.D) {
+		descriptorStart := d.Pos()
+		var hasContentSize bool
+		d.FieldStruct("flags", func(d *decode.D) {
+			d.FieldU2("version", d.UintValidate(1))
+			d.FieldBool("block_independence")
+			hasBlockChecksum = d.FieldBool("block_checksum")
+			hasContentSize = d.FieldBool("content_size")
+			hasContentChecksum = d.FieldBool("content_checksum")
+			d.FieldU1("reserved", d.UintValidate(0))
+			hasDictionaryID = d.FieldBool("dictionary_id")
+		})
+		d.FieldStruct("block_descriptor", func(d *decode.D) {
+			d.FieldU1("reserved0", d.UintValidate(0))
+			d.FieldU3("block_max_size", blockMaxSizes, d.UintValidateRange(4, 7))
+			d.FieldU4("reserved1", d.UintValidate(0))
+		})
+		if hasContentSize {
+			d.FieldU64("content_size")
+		}
+		if hasDictionaryID {
+			d.FieldU32("dictionary_id", scalar.UintHex)
+		}
+		// second byte of xxhash32 of descriptor
+		descriptorHash := xxhash.Sum32(d.BytesRange(descriptorStart, int((d.Pos()-descriptorStart)/8)), 0)
+		d.FieldU8("header_checksum", d.UintValidate(uint64(descriptorHash>>8)&0xff), scalar.UintHex)
+	})
+
+	// blocks can't be decompressed without the dictionary
+	uncompressed, ok := decodeBlocks(d, hasBlockChecksum, !hasDictionaryID)
+
+	if hasContentChecksum {
+		if ok {
+			d.FieldU32("content_checksum", d.UintValidate(uint64(xxhash.Sum32(uncompressed, 0))), scalar.UintHex)
+		} else {
+			d.FieldU32("content_checksum", scalar.UintHex)
+		}
+	}
+
+	return uncompressed, ok
+}
+
+func decodeSkippableFrame(d *decode.D) {
+	d.FieldU32("magic", d.UintValidateRange(skippableMagic, skippableMagic+0xf), scalar.UintHex)
+	size := d.FieldU32("frame_size")
+	d.FieldRawLen("user_data", int64(size)*8)
+}
+
+func lz4Decode(d *decode.D) any {
+	d.Endian = decode.LittleEndian
+
+	var uncompressed []byte
+	frames := 0
+	skippableFrames := 0
+	ok := true
+
+	d.FieldArray("frames", func(d *decode.D) {
+		for frames == 0 || d.BitsLeft() >= 4*8 {
+			magic := uint64(binary.LittleEndian.Uint32(d.PeekBytes(4)))
+			if frames > 0 && magic != frameMagic && !isSkippableMagic(magic) {
+				break
+			}
+			if isSkippableMagic(magic) {
+				d.FieldStruct("frame", decodeSkippableFrame)
+				skippableFrames++
+			} else {
+				var frameUncompressed []byte
+				frameOK := false
+				d.FieldStruct("frame", func(d *decode.D) {
+					frameUncompressed, frameOK = decodeFrame(d)
+				})
+				if !frameOK {
+					ok = false
+				}
+				uncompressed = append(uncompressed, frameUncompressed...)
+			}
+			frames++
+		}
+	})
+	// zstd uses the same skippable frame magic, require at least one lz4 frame
+	if frames == skippableFrames {
+		d.Fatalf("no frames found")
+	}
+
+	if ok && len(uncompressed) > 0 {
+		uncompressedBR := bitio.NewBitReader(uncompressed, -1)
+		if dv, _, _ := d.TryFieldFormatBitBuf("uncompressed", uncompressedBR, &probeGroup, format.Probe_In{}); dv == nil {
+			d.FieldRootBitBuf("uncompressed", uncompressedBR)
+		}
+	}
+
+	return nil
+}
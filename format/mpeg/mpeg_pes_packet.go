@@ -180,7 +180,13 @@ func pesPacketDecode(d *decode.D) any {
 			d.FieldRawLen("header_data", int64(headerDataLength)*8)
 		}
 
-		dataLen := int64(length-headerDataLength-extensionLength) * 8
+		var dataLen int64
+		if length == 0 {
+			// unbounded, allowed for video in transport streams
+			dataLen = d.BitsLeft()
+		} else {
+			dataLen = int64(length-headerDataLength-extensionLength) * 8
+		}
 
 		switch startCode {
 		case privateStream1:
@@ -1,12 +1,29 @@
 package mpeg
 
+// ISO/IEC 13818-1 (ITU-T H.222.0)
+// ETSI EN 300 468 (DVB SI)
+// https://en.wikipedia.org/wiki/MPEG_transport_stream
+
 import (
+	"bytes"
+	"embed"
+
 	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/checksum"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
 	"github.com/wader/fq/pkg/scalar"
 )
 
+//go:embed mpeg_ts.md
+var mpegTsFS embed.FS
+
+var tsPesPacketGroup decode.Group
+var tsAvcAnnexbGroup decode.Group
+var tsHevcAnnexbGroup decode.Group
+var tsAdtsGroup decode.Group
+
 func init() {
 	interp.RegisterFormat(
 		format.MPEG_TS,
@@ -15,20 +32,743 @@ func init() {
 			Description: "MPEG Transport Stream",
 			Groups:      []*decode.Group{format.Probe},
 			DecodeFn:    tsDecode,
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.MPEG_PES_Packet}, Out: &tsPesPacketGroup},
+				{Groups: []*decode.Group{format.AVC_Annexb}, Out: &tsAvcAnnexbGroup},
+				{Groups: []*decode.Group{format.HEVC_Annexb}, Out: &tsHevcAnnexbGroup},
+				{Groups: []*decode.Group{format.ADTS}, Out: &tsAdtsGroup},
+			},
 		})
+	interp.RegisterFS(mpegTsFS)
 }
 
-// TODO: ts_packet
+const (
+	tsSyncByte       = 0x47
+	tsPacketSize     = 188
+	tsM2TSPacketSize = 192 // 4 byte TP_extra_header + ts packet
+)
 
-func tsDecode(d *decode.D) any {
-	d.FieldU8("sync", d.UintAssert(0x47), scalar.UintHex)
+const tsPIDNull = 0x1fff
+
+var tsPIDNames = scalar.UintMap{
+	0x0000: {Sym: "pat", Description: "Program association table"},
+	0x0001: {Sym: "cat", Description: "Conditional access table"},
+	0x0002: {Sym: "tsdt", Description: "Transport stream description table"},
+	0x0003: {Sym: "ipmp", Description: "IPMP control information table"},
+	0x0010: {Sym: "nit", Description: "Network information table"},
+	0x0011: {Sym: "sdt", Description: "Service description table"},
+	0x0012: {Sym: "eit", Description: "Event information table"},
+	0x0013: {Sym: "rst", Description: "Running status table"},
+	0x0014: {Sym: "tdt", Description: "Time and date table"},
+	0x1ffb: {Sym: "atsc_psip", Description: "ATSC program and system information protocol"},
+	0x1fff: {Sym: "null", Description: "Null packet"},
+}
+
+var tsScramblingControlNames = scalar.UintMapSymStr{
+	0b00: "not_scrambled",
+	0b01: "reserved",
+	0b10: "even_key",
+	0b11: "odd_key",
+}
+
+var tsAdaptationFieldControlNames = scalar.UintMapSymStr{
+	0b00: "reserved",
+	0b01: "payload_only",
+	0b10: "adaptation_field_only",
+	0b11: "adaptation_field_and_payload",
+}
+
+const (
+	tsTableIDPAT                      = 0x00
+	tsTableIDPMT                      = 0x02
+	tsTableIDServiceDescriptionActual = 0x42
+	tsTableIDServiceDescriptionOther  = 0x46
+)
+
+var tsTableIDNames = scalar.UintRangeToScalar{
+	{Range: [2]uint64{0x00, 0x00}, S: scalar.Uint{Sym: "program_association"}},
+	{Range: [2]uint64{0x01, 0x01}, S: scalar.Uint{Sym: "conditional_access"}},
+	{Range: [2]uint64{0x02, 0x02}, S: scalar.Uint{Sym: "program_map"}},
+	{Range: [2]uint64{0x03, 0x03}, S: scalar.Uint{Sym: "transport_stream_description"}},
+	{Range: [2]uint64{0x40, 0x40}, S: scalar.Uint{Sym: "network_information_actual"}},
+	{Range: [2]uint64{0x41, 0x41}, S: scalar.Uint{Sym: "network_information_other"}},
+	{Range: [2]uint64{0x42, 0x42}, S: scalar.Uint{Sym: "service_description_actual"}},
+	{Range: [2]uint64{0x46, 0x46}, S: scalar.Uint{Sym: "service_description_other"}},
+	{Range: [2]uint64{0x4a, 0x4a}, S: scalar.Uint{Sym: "bouquet_association"}},
+	{Range: [2]uint64{0x4e, 0x6f}, S: scalar.Uint{Sym: "event_information"}},
+	{Range: [2]uint64{0x70, 0x70}, S: scalar.Uint{Sym: "time_date"}},
+	{Range: [2]uint64{0x71, 0x71}, S: scalar.Uint{Sym: "running_status"}},
+	{Range: [2]uint64{0x72, 0x72}, S: scalar.Uint{Sym: "stuffing"}},
+	{Range: [2]uint64{0x73, 0x73}, S: scalar.Uint{Sym: "time_offset"}},
+	{Range: [2]uint64{0xfc, 0xfc}, S: scalar.Uint{Sym: "splice_info"}},
+	{Range: [2]uint64{0xff, 0xff}, S: scalar.Uint{Sym: "forbidden"}},
+}
+
+const (
+	tsStreamTypeADTS = 0x0f
+	tsStreamTypeAVC  = 0x1b
+	tsStreamTypeHEVC = 0x24
+)
+
+var tsStreamTypeNames = scalar.UintMap{
+	0x01:             {Sym: "mpeg1_video", Description: "ISO/IEC 11172-2 video"},
+	0x02:             {Sym: "mpeg2_video", Description: "ISO/IEC 13818-2 video"},
+	0x03:             {Sym: "mpeg1_audio", Description: "ISO/IEC 11172-3 audio"},
+	0x04:             {Sym: "mpeg2_audio", Description: "ISO/IEC 13818-3 audio"},
+	0x05:             {Sym: "private_sections", Description: "ISO/IEC 13818-1 private sections"},
+	0x06:             {Sym: "private_pes", Description: "ISO/IEC 13818-1 PES packets with private data"},
+	0x0d:             {Sym: "dsm_cc", Description: "ISO/IEC 13818-6 DSM-CC"},
+	tsStreamTypeADTS: {Sym: "adts", Description: "ISO/IEC 13818-7 AAC audio with ADTS transport syntax"},
+	0x10:             {Sym: "mpeg4_video", Description: "ISO/IEC 14496-2 visual"},
+	0x11:             {Sym: "latm", Description: "ISO/IEC 14496-3 AAC audio with LATM transport syntax"},
+	0x15:             {Sym: "metadata", Description: "Metadata carried in PES packets"},
+	tsStreamTypeAVC:  {Sym: "avc", Description: "ITU-T H.264 / ISO/IEC 14496-10 video"},
+	tsStreamTypeHEVC: {Sym: "hevc", Description: "ITU-T H.265 / ISO/IEC 23008-2 video"},
+	0x81:             {Sym: "ac3", Description: "ATSC A/52 AC-3 audio"},
+	0x86:             {Sym: "scte35", Description: "SCTE 35 splice information"},
+	0x87:             {Sym: "eac3", Description: "ATSC A/52 E-AC-3 audio"},
+}
+
+const (
+	tsDescriptorRegistration   = 0x05
+	tsDescriptorCA             = 0x09
+	tsDescriptorISO639Language = 0x0a
+	tsDescriptorService        = 0x48
+	tsDescriptorStreamID       = 0x52
+)
+
+var tsDescriptorTagNames = scalar.UintMapSymStr{
+	0x02:                       "video_stream",
+	0x03:                       "audio_stream",
+	0x04:                       "hierarchy",
+	tsDescriptorRegistration:   "registration",
+	0x06:                       "data_stream_alignment",
+	0x07:                       "target_background_grid",
+	0x08:                       "video_window",
+	tsDescriptorCA:             "ca",
+	tsDescriptorISO639Language: "iso_639_language",
+	0x0b:                       "system_clock",
+	0x0c:                       "multiplex_buffer_utilization",
+	0x0d:                       "copyright",
+	0x0e:                       "maximum_bitrate",
+	0x0f:                       "private_data_indicator",
+	0x10:                       "smoothing_buffer",
+	0x11:                       "std",
+	0x12:                       "ibp",
+	0x1c:                       "mpeg4_audio",
+	0x26:                       "metadata",
+	0x28:                       "avc_video",
+	0x2a:                       "avc_timing_and_hrd",
+	0x2b:                       "mpeg2_aac_audio",
+	0x38:                       "hevc_video",
+	0x40:                       "network_name",
+	0x41:                       "service_list",
+	tsDescriptorService:        "service",
+	0x4d:                       "short_event",
+	0x4e:                       "extended_event",
+	0x50:                       "component",
+	tsDescriptorStreamID:       "stream_identifier",
+	0x56:                       "teletext",
+	0x59:                       "subtitling",
+	0x6a:                       "ac3",
+	0x7a:                       "enhanced_ac3",
+	0x7c:                       "aac",
+	0x7f:                       "extension",
+}
+
+var tsAudioTypeNames = scalar.UintMapSymStr{
+	0x00: "undefined",
+	0x01: "clean_effects",
+	0x02: "hearing_impaired",
+	0x03: "visual_impaired_commentary",
+}
+
+var tsServiceTypeNames = scalar.UintMapSymStr{
+	0x01: "digital_television",
+	0x02: "digital_radio_sound",
+	0x03: "teletext",
+	0x0a: "advanced_codec_digital_radio_sound",
+	0x11: "mpeg2_hd_digital_television",
+	0x16: "advanced_codec_sd_digital_television",
+	0x19: "advanced_codec_hd_digital_television",
+	0x1f: "hevc_digital_television",
+}
+
+var tsRunningStatusNames = scalar.UintMapSymStr{
+	0: "undefined",
+	1: "not_running",
+	2: "starts_in_a_few_seconds",
+	3: "pausing",
+	4: "running",
+	5: "service_off_air",
+}
+
+type tsProgramStream struct {
+	programNumber uint64
+	streamType    uint64
+}
+
+type tsStream struct {
+	pid        uint64
+	pesBuf     []byte
+	pesPackets [][]byte
+}
+
+type tsContext struct {
+	sectionsD *decode.D
+
+	// pid -> program_number for PIDs carrying a PMT
+	pmtPIDs map[uint64]uint64
+	// elementary pid -> program and stream type from PMT
+	programStreams map[uint64]tsProgramStream
+	sectionBufs    map[uint64][]byte
+	streams        map[uint64]*tsStream
+	// keep streams in order of appearance for stable output
+	streamsOrder []*tsStream
+}
+
+func (ctx *tsContext) isPSIPID(pid uint64) bool {
+	if _, ok := ctx.pmtPIDs[pid]; ok {
+		return true
+	}
+	_, ok := tsPIDNames[pid]
+	return ok && pid != tsPIDNull
+}
+
+func (ctx *tsContext) pidMapper() scalar.UintFn {
+	return func(s scalar.Uint) (scalar.Uint, error) {
+		if _, ok := ctx.pmtPIDs[s.Actual]; ok {
+			s.Sym = "pmt"
+			s.Description = "Program map table"
+			return s, nil
+		}
+		if ps, ok := ctx.programStreams[s.Actual]; ok {
+			if st, ok := tsStreamTypeNames[ps.streamType]; ok {
+				s.Sym = st.Sym
+			}
+			return s, nil
+		}
+		return tsPIDNames.MapUint(s)
+	}
+}
+
+// tsSectionCRC is the non-reflected CRC32 with all ones initial value used by PSI sections
+func tsSectionCRC(b []byte) uint64 {
+	crc := &checksum.CRC{Bits: 32, Current: 0xffff_ffff, Table: checksum.Poly04c11db7Table}
+	_, _ = crc.Write(b)
+	return uint64(crc.Current)
+}
+
+func tsDecodeDescriptors(d *decode.D, nBytes int64) {
+	d.FieldArray("descriptors", func(d *decode.D) {
+		d.FramedFn(nBytes*8, func(d *decode.D) {
+			for !d.End() {
+				d.FieldStruct("descriptor", func(d *decode.D) {
+					tag := d.FieldU8("tag", tsDescriptorTagNames, scalar.UintHex)
+					length := d.FieldU8("length")
+					d.FramedFn(int64(length)*8, func(d *decode.D) {
+						switch tag {
+						case tsDescriptorRegistration:
+							d.FieldUTF8("format_identifier", 4)
+						case tsDescriptorCA:
+							d.FieldU16("ca_system_id", scalar.UintHex)
+							d.FieldU3("reserved")
+							d.FieldU13("ca_pid", scalar.UintHex)
+						case tsDescriptorISO639Language:
+							d.FieldArray("languages", func(d *decode.D) {
+								for d.BitsLeft() >= 4*8 {
+									d.FieldStruct("language", func(d *decode.D) {
+										d.FieldUTF8("language_code", 3)
+										d.FieldU8("audio_type", tsAudioTypeNames)
+									})
+								}
+							})
+						case tsDescriptorService:
+							d.FieldU8("service_type", tsServiceTypeNames, scalar.UintHex)
+							providerNameLength := d.FieldU8("service_provider_name_length")
+							d.FieldUTF8("service_provider_name", int(providerNameLength))
+							nameLength := d.FieldU8("service_name_length")
+							d.FieldUTF8("service_name", int(nameLength))
+						case tsDescriptorStreamID:
+							d.FieldU8("component_tag")
+						}
+						if d.BitsLeft() > 0 {
+							d.FieldRawLen("data", d.BitsLeft())
+						}
+					})
+				})
+			}
+		})
+	})
+}
+
+func tsDecodePAT(ctx *tsContext, d *decode.D) {
+	d.FieldArray("programs", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("program", func(d *decode.D) {
+				programNumber := d.FieldU16("program_number")
+				d.FieldU3("reserved")
+				if programNumber == 0 {
+					d.FieldU13("network_pid", scalar.UintHex)
+				} else {
+					pid := d.FieldU13("program_map_pid", scalar.UintHex)
+					ctx.pmtPIDs[pid] = programNumber
+				}
+			})
+		}
+	})
+}
+
+func tsDecodePMT(ctx *tsContext, d *decode.D, programNumber uint64) {
+	d.FieldU3("reserved2")
+	d.FieldU13("pcr_pid", scalar.UintHex)
+	d.FieldU4("reserved3")
+	programInfoLength := d.FieldU12("program_info_length")
+	tsDecodeDescriptors(d, int64(programInfoLength))
+	d.FieldArray("streams", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("stream", func(d *decode.D) {
+				streamType := d.FieldU8("stream_type", tsStreamTypeNames, scalar.UintHex)
+				d.FieldU3("reserved0")
+				pid := d.FieldU13("elementary_pid", scalar.UintHex)
+				d.FieldU4("reserved1")
+				esInfoLength := d.FieldU12("es_info_length")
+				tsDecodeDescriptors(d, int64(esInfoLength))
+
+				ctx.programStreams[pid] = tsProgramStream{
+					programNumber: programNumber,
+					streamType:    streamType,
+				}
+			})
+		}
+	})
+}
+
+func tsDecodeSDT(d *decode.D) {
+	d.FieldU16("original_network_id", scalar.UintHex)
+	d.FieldU8("reserved_future_use")
+	d.FieldArray("services", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("service", func(d *decode.D) {
+				d.FieldU16("service_id", scalar.UintHex)
+				d.FieldU6("reserved_future_use")
+				d.FieldBool("eit_schedule_flag")
+				d.FieldBool("eit_present_following_flag")
+				d.FieldU3("running_status", tsRunningStatusNames)
+				d.FieldBool("free_ca_mode")
+				descriptorsLoopLength := d.FieldU12("descriptors_loop_length")
+				tsDecodeDescriptors(d, int64(descriptorsLoopLength))
+			})
+		}
+	})
+}
+
+func tsDecodeSection(ctx *tsContext, d *decode.D, sectionBytes []byte) {
+	tableID := d.FieldU8("table_id", tsTableIDNames, scalar.UintHex)
+	syntaxIndicator := d.FieldBool("section_syntax_indicator")
+	d.FieldU1("private_indicator")
+	d.FieldU2("reserved0")
+	sectionLength := d.FieldU12("section_length")
+	if !syntaxIndicator {
+		d.FieldRawLen("data", int64(sectionLength)*8)
+		return
+	}
+
+	// section_length counts bytes after the length field including the crc
+	const headerAfterLengthBytes = 5
+	const crcBytes = 4
+	if sectionLength < headerAfterLengthBytes+crcBytes {
+		d.Fatalf("section_length %d too small", sectionLength)
+	}
+
+	var tableIDExtension uint64
+	switch tableID {
+	case tsTableIDPAT:
+		tableIDExtension = d.FieldU16("transport_stream_id", scalar.UintHex)
+	case tsTableIDPMT:
+		tableIDExtension = d.FieldU16("program_number")
+	case tsTableIDServiceDescriptionActual,
+		tsTableIDServiceDescriptionOther:
+		tableIDExtension = d.FieldU16("transport_stream_id", scalar.UintHex)
+	default:
+		tableIDExtension = d.FieldU16("table_id_extension", scalar.UintHex)
+	}
+	d.FieldU2("reserved1")
+	d.FieldU5("version_number")
+	d.FieldBool("current_next_indicator")
+	d.FieldU8("section_number")
+	d.FieldU8("last_section_number")
+
+	bodyLen := int64(sectionLength) - headerAfterLengthBytes - crcBytes
+
+	crcStart := len(sectionBytes) - crcBytes
+	calculatedCRC := tsSectionCRC(sectionBytes[:crcStart])
+	crcValid := calculatedCRC == uint64(sectionBytes[crcStart])<<24|
+		uint64(sectionBytes[crcStart+1])<<16|
+		uint64(sectionBytes[crcStart+2])<<8|
+		uint64(sectionBytes[crcStart+3])
+
+	d.FramedFn(bodyLen*8, func(d *decode.D) {
+		// only trust lengths and update program state from intact sections
+		if !crcValid {
+			d.FieldRawLen("data", d.BitsLeft())
+			return
+		}
+
+		switch tableID {
+		case tsTableIDPAT:
+			tsDecodePAT(ctx, d)
+		case tsTableIDPMT:
+			tsDecodePMT(ctx, d, tableIDExtension)
+		case tsTableIDServiceDescriptionActual,
+			tsTableIDServiceDescriptionOther:
+			tsDecodeSDT(d)
+		default:
+			d.FieldRawLen("data", d.BitsLeft())
+		}
+	})
+	d.FieldU32("crc32", d.UintValidate(calculatedCRC), scalar.UintHex)
+}
+
+// tsProcessSections decodes all complete sections at start of the pid section buffer
+func (ctx *tsContext) tsProcessSections(pid uint64) {
+	const sectionHeaderBytes = 3
+	buf := ctx.sectionBufs[pid]
+	for len(buf) >= sectionHeaderBytes {
+		// rest of payload after a section is stuffed with 0xff
+		if buf[0] == 0xff {
+			buf = nil
+			break
+		}
+		sectionLen := sectionHeaderBytes + (int(buf[1]&0x0f)<<8 | int(buf[2]))
+		if len(buf) < sectionLen {
+			break
+		}
+		sectionBytes := buf[:sectionLen]
+		// a broken section is added as raw data with the error, following sections and packets
+		// are still decoded
+		sectionBR := bitio.NewBitReader(sectionBytes, -1)
+		_, _, err := ctx.sectionsD.TryFieldFormatBitBuf("section", sectionBR, &decode.Group{Formats: []*decode.Format{{
+			Name: "mpeg_ts_section",
+			DecodeFn: func(d *decode.D) any {
+				d.FieldValueUint("pid", pid, scalar.UintHex)
+				tsDecodeSection(ctx, d, sectionBytes)
+				return nil
+			},
+		}}}, nil)
+		if err != nil {
+			ctx.sectionsD.FieldStructRootBitBufFn("section", sectionBR, func(d *decode.D) {
+				d.FieldValueUint("pid", pid, scalar.UintHex)
+				d.FieldValueStr("error", err.Error())
+				d.FieldRawLen("data", d.BitsLeft())
+			})
+		}
+		buf = buf[sectionLen:]
+	}
+	ctx.sectionBufs[pid] = buf
+}
+
+func (ctx *tsContext) tsSectionPayload(pid uint64, payloadUnitStart bool, pointerField uint64, payload []byte) {
+	if payloadUnitStart {
+		pointer := int(pointerField)
+		if pointer > len(payload) {
+			pointer = len(payload)
+		}
+		// bytes before pointer ends a section started in a previous packet
+		if buf, ok := ctx.sectionBufs[pid]; ok && buf != nil {
+			ctx.sectionBufs[pid] = append(buf, payload[:pointer]...)
+			ctx.tsProcessSections(pid)
+		}
+		ctx.sectionBufs[pid] = append([]byte{}, payload[pointer:]...)
+	} else {
+		buf, ok := ctx.sectionBufs[pid]
+		if !ok || buf == nil {
+			// no section start seen yet
+			return
+		}
+		ctx.sectionBufs[pid] = append(buf, payload...)
+	}
+	ctx.tsProcessSections(pid)
+}
+
+func (s *tsStream) flushPES() {
+	if s.pesBuf != nil {
+		s.pesPackets = append(s.pesPackets, s.pesBuf)
+		s.pesBuf = nil
+	}
+}
+
+func (ctx *tsContext) tsPESPayload(pid uint64, payloadUnitStart bool, payload []byte) {
+	s, ok := ctx.streams[pid]
+	if payloadUnitStart {
+		if ok {
+			s.flushPES()
+		}
+		if !bytes.HasPrefix(payload, []byte{0x00, 0x00, 0x01}) {
+			return
+		}
+		if !ok {
+			s = &tsStream{pid: pid}
+			ctx.streams[pid] = s
+			ctx.streamsOrder = append(ctx.streamsOrder, s)
+		}
+		s.pesBuf = append([]byte{}, payload...)
+	} else {
+		if !ok || s.pesBuf == nil {
+			return
+		}
+		s.pesBuf = append(s.pesBuf, payload...)
+	}
+
+	// bounded packet, zero length is allowed for video and ends at next payload unit start
+	const pesHeaderBytes = 6
+	if len(s.pesBuf) >= pesHeaderBytes {
+		pesLength := int(s.pesBuf[4])<<8 | int(s.pesBuf[5])
+		if pesLength != 0 && len(s.pesBuf) >= pesHeaderBytes+pesLength {
+			s.pesBuf = s.pesBuf[:pesHeaderBytes+pesLength]
+			s.flushPES()
+		}
+	}
+}
+
+// tsPESPayloadData returns elementary stream data of a PES packet
+func tsPESPayloadData(b []byte) []byte {
+	const pesHeaderBytes = 6
+	if len(b) < pesHeaderBytes {
+		return nil
+	}
+	streamID := b[3]
+	hasExtension := streamID == privateStream1 || (streamID >= 0xc0 && streamID <= 0xef)
+	if !hasExtension {
+		return b[pesHeaderBytes:]
+	}
+	const extensionBytes = 3
+	if len(b) < pesHeaderBytes+extensionBytes {
+		return nil
+	}
+	start := pesHeaderBytes + extensionBytes + int(b[8])
+	if start > len(b) {
+		return nil
+	}
+	return b[start:]
+}
+
+func tsDecodeAdaptationField(d *decode.D) {
+	length := d.FieldU8("length")
+	if length == 0 {
+		return
+	}
+	d.FramedFn(int64(length)*8, func(d *decode.D) {
+		d.FieldBool("discontinuity_indicator")
+		d.FieldBool("random_access_indicator")
+		d.FieldBool("elementary_stream_priority_indicator")
+		pcrFlag := d.FieldBool("pcr_flag")
+		opcrFlag := d.FieldBool("opcr_flag")
+		splicingPointFlag := d.FieldBool("splicing_point_flag")
+		transportPrivateDataFlag := d.FieldBool("transport_private_data_flag")
+		extensionFlag := d.FieldBool("adaptation_field_extension_flag")
+
+		// 33 bit 90kHz base and 9 bit 27MHz extension
+		decodeClockReference := func(name string) {
+			base := d.FieldU33(name + "_base")
+			d.FieldU6(name + "_reserved")
+			extension := d.FieldU9(name + "_extension")
+			d.FieldValueUint(name, base*300+extension)
+		}
+		if pcrFlag {
+			decodeClockReference("pcr")
+		}
+		if opcrFlag {
+			decodeClockReference("opcr")
+		}
+		if splicingPointFlag {
+			d.FieldS8("splice_countdown")
+		}
+		if transportPrivateDataFlag {
+			privateDataLength := d.FieldU8("transport_private_data_length")
+			d.FieldRawLen("transport_private_data", int64(privateDataLength)*8)
+		}
+		if extensionFlag {
+			d.FieldStruct("extension", func(d *decode.D) {
+				extensionLength := d.FieldU8("length")
+				d.FramedFn(int64(extensionLength)*8, func(d *decode.D) {
+					ltwFlag := d.FieldBool("ltw_flag")
+					piecewiseRateFlag := d.FieldBool("piecewise_rate_flag")
+					seamlessSpliceFlag := d.FieldBool("seamless_splice_flag")
+					d.FieldU5("reserved")
+					if ltwFlag {
+						d.FieldBool("ltw_valid_flag")
+						d.FieldU15("ltw_offset")
+					}
+					if piecewiseRateFlag {
+						d.FieldU2("reserved0")
+						d.FieldU22("piecewise_rate")
+					}
+					if seamlessSpliceFlag {
+						d.FieldU4("splice_type")
+						dts0 := d.FieldU3("dts_next_au0")
+						d.FieldU1("marker_bit0")
+						dts1 := d.FieldU15("dts_next_au1")
+						d.FieldU1("marker_bit1")
+						dts2 := d.FieldU15("dts_next_au2")
+						d.FieldU1("marker_bit2")
+						d.FieldValueUint("dts_next_au", dts0<<30|dts1<<15|dts2)
+					}
+					if d.BitsLeft() > 0 {
+						d.FieldRawLen("reserved_bytes", d.BitsLeft())
+					}
+				})
+			})
+		}
+		if d.BitsLeft() > 0 {
+			d.FieldRawLen("stuffing", d.BitsLeft())
+		}
+	})
+}
+
+func tsDecodePacket(ctx *tsContext, d *decode.D, isM2TS bool) {
+	if isM2TS {
+		d.FieldU2("copy_permission_indicator")
+		d.FieldU30("arrival_time_stamp")
+	}
+	d.FieldU8("sync", d.UintAssert(tsSyncByte), scalar.UintHex)
 	d.FieldBool("transport_error_indicator")
-	d.FieldBool("payload_unit_start")
+	payloadUnitStart := d.FieldBool("payload_unit_start")
 	d.FieldBool("transport_priority")
-	d.FieldU13("pid")
-	d.FieldU2("transport_scrambling_control")
-	d.FieldU2("adaptation_field_control")
+	pid := d.FieldU13("pid", ctx.pidMapper(), scalar.UintHex)
+	scramblingControl := d.FieldU2("transport_scrambling_control", tsScramblingControlNames)
+	adaptationFieldControl := d.FieldU2("adaptation_field_control", tsAdaptationFieldControlNames)
 	d.FieldU4("continuity_counter")
 
+	if adaptationFieldControl&0b10 != 0 {
+		d.FieldStruct("adaptation_field", tsDecodeAdaptationField)
+	}
+	if adaptationFieldControl&0b01 == 0 || d.BitsLeft() == 0 {
+		if d.BitsLeft() > 0 {
+			d.FieldRawLen("stuffing", d.BitsLeft())
+		}
+		return
+	}
+
+	switch {
+	case pid == tsPIDNull:
+		d.FieldRawLen("payload", d.BitsLeft())
+	case ctx.isPSIPID(pid):
+		var pointerField uint64
+		if payloadUnitStart {
+			pointerField = d.FieldU8("pointer_field")
+		}
+		payload := d.ReadAllBits(d.FieldRawLen("payload", d.BitsLeft()))
+		ctx.tsSectionPayload(pid, payloadUnitStart, pointerField, payload)
+	case scramblingControl != 0:
+		d.FieldRawLen("payload", d.BitsLeft())
+	default:
+		payload := d.ReadAllBits(d.FieldRawLen("payload", d.BitsLeft()))
+		ctx.tsPESPayload(pid, payloadUnitStart, payload)
+	}
+}
+
+func tsDecodeStream(ctx *tsContext, d *decode.D, s *tsStream) {
+	d.FieldValueUint("pid", s.pid, scalar.UintHex)
+	ps, psFound := ctx.programStreams[s.pid]
+	if psFound {
+		d.FieldValueUint("program_number", ps.programNumber)
+		d.FieldValueUint("stream_type", ps.streamType, tsStreamTypeNames, scalar.UintHex)
+	}
+
+	var esBuf []byte
+	d.FieldArray("packets", func(d *decode.D) {
+		for _, b := range s.pesPackets {
+			br := bitio.NewBitReader(b, -1)
+			if _, _, err := d.TryFieldFormatBitBuf("packet", br, &tsPesPacketGroup, nil); err != nil {
+				d.FieldRootBitBuf("packet", br)
+			}
+			esBuf = append(esBuf, tsPESPayloadData(b)...)
+		}
+	})
+
+	if !psFound || len(esBuf) == 0 {
+		return
+	}
+	var esGroup *decode.Group
+	switch ps.streamType {
+	case tsStreamTypeAVC:
+		esGroup = &tsAvcAnnexbGroup
+	case tsStreamTypeHEVC:
+		esGroup = &tsHevcAnnexbGroup
+	case tsStreamTypeADTS:
+		esGroup = &tsAdtsGroup
+	default:
+		return
+	}
+	br := bitio.NewBitReader(esBuf, -1)
+	if _, _, err := d.TryFieldFormatBitBuf("elementary_stream", br, esGroup, nil); err != nil {
+		d.FieldRootBitBuf("elementary_stream", br)
+	}
+}
+
+func tsDetectPacketSize(d *decode.D) int {
+	isSync := func(pos int64) bool {
+		b, err := d.TryBytesRange(pos*8, 1)
+		return err == nil && b[0] == tsSyncByte
+	}
+	hasNext := func(pos int64) bool {
+		return d.Len() < (pos+1)*8 || isSync(pos)
+	}
+
+	switch {
+	case isSync(0) && hasNext(tsPacketSize):
+		return tsPacketSize
+	case isSync(4) && hasNext(tsM2TSPacketSize+4):
+		return tsM2TSPacketSize
+	default:
+		return 0
+	}
+}
+
+func tsDecode(d *decode.D) any {
+	packetSize := tsDetectPacketSize(d)
+	if packetSize == 0 {
+		d.Fatalf("no sync byte found")
+	}
+	isM2TS := packetSize == tsM2TSPacketSize
+	syncOffset := int64(packetSize - tsPacketSize)
+
+	ctx := &tsContext{
+		pmtPIDs:        map[uint64]uint64{},
+		programStreams: map[uint64]tsProgramStream{},
+		sectionBufs:    map[uint64][]byte{},
+		streams:        map[uint64]*tsStream{},
+	}
+	ctx.sectionsD = d.FieldArrayValue("sections")
+	streamsD := d.FieldArrayValue("streams")
+
+	validPackets := 0
+	d.FieldArray("packets", func(d *decode.D) {
+		for d.BitsLeft() >= int64(packetSize)*8 {
+			syncByte := d.PeekBytes(int(syncOffset) + 1)[syncOffset]
+			if syncByte != tsSyncByte {
+				break
+			}
+			d.FieldStruct("packet", func(d *decode.D) {
+				d.FramedFn(int64(packetSize)*8, func(d *decode.D) {
+					tsDecodePacket(ctx, d, isM2TS)
+				})
+			})
+			validPackets++
+		}
+	})
+
+	if validPackets == 0 {
+		d.Fatalf("no packets found")
+	}
+
+	for _, s := range ctx.streamsOrder {
+		s.flushPES()
+		streamsD.FieldStruct("stream", func(d *decode.D) {
+			tsDecodeStream(ctx, d, s)
+		})
+	}
+
 	return nil
 }
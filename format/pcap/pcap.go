@@ -5,6 +5,7 @@ package pcap
 
 import (
 	"embed"
+	"time"
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/format/inet/flowsdecoder"
@@ -19,6 +20,8 @@ var pcapFS embed.FS
 var pcapLinkFrameGroup decode.Group
 var pcapTCPStreamGroup decode.Group
 var pcapIPv4PacketGroup decode.Group
+var pcapIPv6PacketGroup decode.Group
+var pcapUDPPayloadGroup decode.Group
 
 // writing application writes 0xa1b2c3d4 in native endian
 const (
@@ -48,6 +51,8 @@ func init() {
 				{Groups: []*decode.Group{format.Link_Frame}, Out: &pcapLinkFrameGroup},
 				{Groups: []*decode.Group{format.TCP_Stream}, Out: &pcapTCPStreamGroup},
 				{Groups: []*decode.Group{format.IPv4Packet}, Out: &pcapIPv4PacketGroup},
+				{Groups: []*decode.Group{format.IPv6Packet}, Out: &pcapIPv6PacketGroup},
+				{Groups: []*decode.Group{format.UDP_Payload}, Out: &pcapUDPPayloadGroup},
 			},
 			DecodeFn: decodePcap,
 		})
@@ -58,6 +63,7 @@ func decodePcap(d *decode.D) any {
 	var endian decode.Endian
 	linkType := 0
 	timestampUNSStr := "ts_usec"
+	timestampUNSScale := int64(time.Microsecond)
 
 	d.FieldStruct("header", func(d *decode.D) {
 		magic := d.FieldU32("magic", d.UintAssert(
@@ -75,9 +81,11 @@ func decodePcap(d *decode.D) any {
 		case bigEndianNS:
 			endian = decode.BigEndian
 			timestampUNSStr = "ts_nsec"
+			timestampUNSScale = int64(time.Nanosecond)
 		case littleEndianNS:
 			endian = decode.LittleEndian
 			timestampUNSStr = "ts_nsec"
+			timestampUNSScale = int64(time.Nanosecond)
 		}
 
 		d.Endian = endian
@@ -96,8 +104,8 @@ func decodePcap(d *decode.D) any {
 	d.FieldArray("packets", func(d *decode.D) {
 		for !d.End() {
 			d.FieldStruct("packet", func(d *decode.D) {
-				d.FieldU32("ts_sec")
-				d.FieldU32(timestampUNSStr)
+				tsSec := d.FieldU32("ts_sec")
+				tsUNS := d.FieldU32(timestampUNSStr)
 				inclLen := d.FieldU32("incl_len")
 				origLen := d.FieldU32("orig_len")
 
@@ -117,7 +125,7 @@ func decodePcap(d *decode.D) any {
 
 				if fn, ok := linkToDecodeFn[linkType]; ok {
 					// TODO: report decode errors
-					_ = fn(fd, bs)
+					_ = fn(fd, bs, time.Unix(int64(tsSec), int64(tsUNS)*timestampUNSScale))
 				}
 
 				d.FieldFormatOrRawLen(
@@ -134,7 +142,7 @@ func decodePcap(d *decode.D) any {
 	})
 	fd.Flush()
 
-	fieldFlows(d, fd, pcapTCPStreamGroup, pcapIPv4PacketGroup)
+	fieldFlows(d, fd, pcapTCPStreamGroup, pcapUDPPayloadGroup, pcapIPv4PacketGroup, pcapIPv6PacketGroup, "")
 
 	return nil
 }
@@ -4,7 +4,10 @@ package pcap
 
 import (
 	"encoding/binary"
+	"math"
 	"net"
+	"strings"
+	"time"
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/format/inet/flowsdecoder"
@@ -16,6 +19,8 @@ import (
 var pcapngLinkFrameGroup decode.Group
 var pcapngTCPStreamGroup decode.Group
 var pcapngIPvPacket4Group decode.Group
+var pcapngIPvPacket6Group decode.Group
+var pcapngUDPPayloadGroup decode.Group
 
 func init() {
 	interp.RegisterFormat(
@@ -28,6 +33,8 @@ func init() {
 				{Groups: []*decode.Group{format.Link_Frame}, Out: &pcapngLinkFrameGroup},
 				{Groups: []*decode.Group{format.TCP_Stream}, Out: &pcapngTCPStreamGroup},
 				{Groups: []*decode.Group{format.IPv4Packet}, Out: &pcapngIPvPacket4Group},
+				{Groups: []*decode.Group{format.IPv6Packet}, Out: &pcapngIPvPacket6Group},
+				{Groups: []*decode.Group{format.UDP_Payload}, Out: &pcapngUDPPayloadGroup},
 			},
 			DecodeFn: decodePcapng,
 		})
@@ -49,6 +56,7 @@ const (
 	blockTypeNameResolution       = 0x00000004
 	blockTypeInterfaceStatistics  = 0x00000005
 	blockTypeEnhancedPacketBlock  = 0x00000006
+	blockTypeDecryptionSecrets    = 0x0000000a
 )
 
 // from https://pcapng.github.io/pcapng/draft-ietf-opsawg-pcapng.html#section_block_code_registry
@@ -62,7 +70,7 @@ var blockTypeMap = scalar.UintMap{
 	0x00000007:                    {Description: "IRIG Timestamp Block"},
 	0x00000008:                    {Description: "ARINC 429 in AFDX Encapsulation Information Block"},
 	0x00000009:                    {Description: "systemd Journal Export Block"},
-	0x0000000a:                    {Description: "Decryption Secrets Block"},
+	blockTypeDecryptionSecrets:    {Sym: "decryption_secrets", Description: "Decryption Secrets Block"},
 	0x00000101:                    {Description: "Hone Project Machine Info Block"},
 	0x00000102:                    {Description: "Hone Project Connection Event Block"},
 	0x00000201:                    {Description: "Sysdig Machine Info Block"},
@@ -186,9 +194,29 @@ var nameResolutionRecordMap = scalar.UintMapSymStr{
 	nameResolutionRecordIpv6: "ipv6",
 }
 
-func decoodeOptions(d *decode.D, opts scalar.UintMap) {
+const (
+	secretsTypeTLSKeyLog = 0x544c534b
+)
+
+var secretsTypeMap = scalar.UintMap{
+	secretsTypeTLSKeyLog: {Sym: "tls_key_log", Description: "TLS Key Log"},
+	0x5353484b:           {Sym: "ssh_key_log", Description: "SSH Key Log"},
+	0x57474b4c:           {Sym: "wireguard_key_log", Description: "WireGuard Key Log"},
+	0x5a4e574b:           {Sym: "zigbee_nwk_key", Description: "ZigBee NWK Key"},
+	0x5a415053:           {Sym: "zigbee_aps_key", Description: "ZigBee APS Key"},
+	0x55414b4c:           {Sym: "opcua_key_log", Description: "OPC UA Key Log"},
+}
+
+var decryptionSecretsOptionsMap = scalar.UintMap{
+	optionEnd:     {Sym: "end", Description: "End of options"},
+	optionComment: {Sym: "comment", Description: "Comment"},
+}
+
+// decoodeOptions returns raw option values by code
+func decoodeOptions(d *decode.D, opts scalar.UintMap) map[uint64][]byte {
+	values := map[uint64][]byte{}
 	if d.BitsLeft() < 32 {
-		return
+		return values
 	}
 	seenEnd := false
 	for !seenEnd {
@@ -199,10 +227,46 @@ func decoodeOptions(d *decode.D, opts scalar.UintMap) {
 				seenEnd = true
 				return
 			}
+			values[code] = d.BytesRange(d.Pos(), int(length))
 			d.FieldUTF8NullFixedLen("value", int(length))
 			d.FieldRawLen("padding", int64(d.AlignBits(32)))
 		})
 	}
+	return values
+}
+
+// default is microseconds
+const defaultTsresol = 6
+
+// timestampTime converts timestamp in units of tsresol to time. If most significant bit of tsresol
+// is set the unit is a negative power of 2 otherwise a negative power of 10.
+func timestampTime(ts uint64, tsresol uint8) time.Time {
+	// 10^19 is the largest power of 10 that fits in uint64, use integers to keep nanosecond precision
+	if tsresol&0x80 == 0 && tsresol <= 19 {
+		unitsPerSecond := uint64(1)
+		for i := uint8(0); i < tsresol; i++ {
+			unitsPerSecond *= 10
+		}
+		// scale remainder to nanoseconds
+		nsec := ts % unitsPerSecond
+		for i := tsresol; i < 9; i++ {
+			nsec *= 10
+		}
+		for i := uint8(9); i < tsresol; i++ {
+			nsec /= 10
+		}
+		return time.Unix(int64(ts/unitsPerSecond), int64(nsec))
+	}
+
+	var unitsPerSecond float64
+	if tsresol&0x80 != 0 {
+		unitsPerSecond = math.Pow(2, float64(tsresol&0x7f))
+	} else {
+		unitsPerSecond = math.Pow(10, float64(tsresol))
+	}
+	sec := math.Floor(float64(ts) / unitsPerSecond)
+	nsec := (float64(ts) - sec*unitsPerSecond) / unitsPerSecond * float64(time.Second)
+	return time.Unix(int64(sec), int64(nsec))
 }
 
 // TODO: share
@@ -220,24 +284,34 @@ var blockFns = map[uint64]func(d *decode.D, dc *decodeContext){
 		typ := d.FieldU16("link_type", format.LinkTypeMap)
 		d.FieldU16("reserved")
 		d.FieldU32("snap_len")
-		d.FieldArray("options", func(d *decode.D) { decoodeOptions(d, interfaceDescriptionOptionsMap) })
+		var options map[uint64][]byte
+		d.FieldArray("options", func(d *decode.D) { options = decoodeOptions(d, interfaceDescriptionOptionsMap) })
 
+		tsresol := uint8(defaultTsresol)
+		if v := options[interfaceDescriptionTsresol]; len(v) > 0 {
+			tsresol = v[0]
+		}
+		dc.interfaceTsresols[len(dc.interfaceTypes)] = tsresol
 		dc.interfaceTypes[len(dc.interfaceTypes)] = int(typ)
 	},
 	blockTypeEnhancedPacketBlock: func(d *decode.D, dc *decodeContext) {
 		interfaceID := d.FieldU32("interface_id")
-		d.FieldU32("timestamp_high")
-		d.FieldU32("timestamp_low")
+		timestampHigh := d.FieldU32("timestamp_high")
+		timestampLow := d.FieldU32("timestamp_low")
 		capturedLength := d.FieldU32("capture_packet_length")
 		d.FieldU32("original_packet_length")
 
 		bs := d.ReadAllBits(d.BitBufRange(d.Pos(), int64(capturedLength)*8))
 
 		linkType := dc.interfaceTypes[int(interfaceID)]
+		tsresol, ok := dc.interfaceTsresols[int(interfaceID)]
+		if !ok {
+			tsresol = defaultTsresol
+		}
 
 		if fn, ok := linkToDecodeFn[linkType]; ok {
 			// TODO: report decode errors
-			_ = fn(dc.flowDecoder, bs)
+			_ = fn(dc.flowDecoder, bs, timestampTime(timestampHigh<<32|timestampLow, tsresol))
 		}
 
 		d.FieldFormatOrRawLen(
@@ -283,6 +357,23 @@ var blockFns = map[uint64]func(d *decode.D, dc *decodeContext){
 		})
 		d.FieldArray("options", func(d *decode.D) { decoodeOptions(d, nameResolutionOptionsMap) })
 	},
+	blockTypeDecryptionSecrets: func(d *decode.D, dc *decodeContext) {
+		typ := d.FieldU32("secrets_type", secretsTypeMap, scalar.UintHex)
+		length := d.FieldU32("secrets_length")
+		switch typ {
+		case secretsTypeTLSKeyLog:
+			// key log in NSS key log format, several blocks are concatenated
+			keylog := d.FieldUTF8("secrets_data", int(length))
+			if !strings.HasSuffix(keylog, "\n") {
+				keylog += "\n"
+			}
+			dc.tlsKeylog.WriteString(keylog)
+		default:
+			d.FieldRawLen("secrets_data", int64(length)*8)
+		}
+		d.FieldRawLen("padding", int64(d.AlignBits(32)))
+		d.FieldArray("options", func(d *decode.D) { decoodeOptions(d, decryptionSecretsOptionsMap) })
+	},
 	blockTypeInterfaceStatistics: func(d *decode.D, _ *decodeContext) {
 		d.FieldU32("interface_id")
 		d.FieldU32("timestamp_high")
@@ -359,7 +450,9 @@ func decodeSection(d *decode.D, dc *decodeContext) {
 type decodeContext struct {
 	sectionHeaderFound bool
 	interfaceTypes     map[int]int
+	interfaceTsresols  map[int]uint8
 	flowDecoder        *flowsdecoder.Decoder
+	tlsKeylog          *strings.Builder
 }
 
 func decodePcapng(d *decode.D) any {
@@ -367,14 +460,16 @@ func decodePcapng(d *decode.D) any {
 	for !d.End() {
 		fd := flowsdecoder.New(flowsdecoder.DecoderOptions{CheckTCPOptions: false})
 		dc := decodeContext{
-			interfaceTypes: map[int]int{},
-			flowDecoder:    fd,
+			interfaceTypes:    map[int]int{},
+			interfaceTsresols: map[int]uint8{},
+			flowDecoder:       fd,
+			tlsKeylog:         &strings.Builder{},
 		}
 
 		d.FieldStruct("section", func(d *decode.D) {
 			decodeSection(d, &dc)
 			fd.Flush()
-			fieldFlows(d, dc.flowDecoder, pcapngTCPStreamGroup, pcapngIPvPacket4Group)
+			fieldFlows(d, dc.flowDecoder, pcapngTCPStreamGroup, pcapngUDPPayloadGroup, pcapngIPvPacket4Group, pcapngIPvPacket6Group, dc.tlsKeylog.String())
 		})
 		if dc.sectionHeaderFound {
 			sectionHeaders++
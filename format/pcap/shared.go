@@ -1,13 +1,16 @@
 package pcap
 
 import (
+	"time"
+
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/format/inet/flowsdecoder"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
 )
 
-var linkToDecodeFn = map[int]func(fd *flowsdecoder.Decoder, bs []byte) error{
+var linkToDecodeFn = map[int]func(fd *flowsdecoder.Decoder, bs []byte, ts time.Time) error{
 	format.LinkTypeETHERNET:   (*flowsdecoder.Decoder).EthernetFrame,
 	format.LinkTypeIPv4:       (*flowsdecoder.Decoder).IPv4Packet,
 	format.LinkTypeIPv6:       (*flowsdecoder.Decoder).IPv6Packet,
@@ -18,7 +21,8 @@ var linkToDecodeFn = map[int]func(fd *flowsdecoder.Decoder, bs []byte) error{
 }
 
 // TODO: make some of this shared if more packet capture formats are added
-func fieldFlows(d *decode.D, fd *flowsdecoder.Decoder, tcpStreamFormat decode.Group, ipv4PacketFormat decode.Group) {
+// keylog is NSS key log content found in the capture passed on to tcp stream decoders
+func fieldFlows(d *decode.D, fd *flowsdecoder.Decoder, tcpStreamFormat decode.Group, udpPayloadFormat decode.Group, ipv4PacketFormat decode.Group, ipv6PacketFormat decode.Group, keylog string) {
 	d.FieldArray("ipv4_reassembled", func(d *decode.D) {
 		for _, p := range fd.IPV4Reassembled {
 			br := bitio.NewBitReader(p.Datagram, -1)
@@ -33,6 +37,20 @@ func fieldFlows(d *decode.D, fd *flowsdecoder.Decoder, tcpStreamFormat decode.Gr
 		}
 	})
 
+	d.FieldArray("ipv6_reassembled", func(d *decode.D) {
+		for _, p := range fd.IPV6Reassembled {
+			br := bitio.NewBitReader(p.Datagram, -1)
+			if dv, _, _ := d.TryFieldFormatBitBuf(
+				"ipv6_packet",
+				br,
+				&ipv6PacketFormat,
+				nil,
+			); dv == nil {
+				d.FieldRootBitBuf("ipv6_packet", br)
+			}
+		}
+	})
+
 	d.FieldArray("tcp_connections", func(d *decode.D) {
 		for _, s := range fd.TCPConnections {
 			d.FieldStruct("tcp_connection", func(d *decode.D) {
@@ -66,6 +84,7 @@ func fieldFlows(d *decode.D, fd *flowsdecoder.Decoder, tcpStreamFormat decode.Gr
 						SkippedBytes:    s.Client.SkippedBytes,
 						SourcePort:      s.Client.Endpoint.Port,
 						DestinationPort: s.Server.Endpoint.Port,
+						Keylog:          keylog,
 					})
 				})
 				d.FieldStruct("server", func(d *decode.D) {
@@ -76,6 +95,7 @@ func fieldFlows(d *decode.D, fd *flowsdecoder.Decoder, tcpStreamFormat decode.Gr
 						SkippedBytes:    s.Server.SkippedBytes,
 						SourcePort:      s.Server.Endpoint.Port,
 						DestinationPort: s.Client.Endpoint.Port,
+						Keylog:          keylog,
 					})
 				})
 
@@ -92,4 +112,48 @@ func fieldFlows(d *decode.D, fd *flowsdecoder.Decoder, tcpStreamFormat decode.Gr
 			})
 		}
 	})
+
+	d.FieldArray("udp_flows", func(d *decode.D) {
+		for _, f := range fd.UDPFlows {
+			d.FieldStruct("udp_flow", func(d *decode.D) {
+				fieldEndpoint := func(d *decode.D, e flowsdecoder.UDPEndpoint) {
+					d.FieldValueStr("ip", e.IP.String())
+					d.FieldValueUint("port", uint64(e.Port), format.UDPPortMap)
+				}
+				d.FieldStruct("client", func(d *decode.D) { fieldEndpoint(d, f.Client) })
+				d.FieldStruct("server", func(d *decode.D) { fieldEndpoint(d, f.Server) })
+
+				d.FieldArray("datagrams", func(d *decode.D) {
+					for _, dg := range f.Datagrams {
+						d.FieldStruct("datagram", func(d *decode.D) {
+							d.FieldValueBool("from_client", dg.FromClient)
+							// float64 seconds can't represent the nanosecond precision of ns pcaps
+							d.FieldValueUint("ts_sec", uint64(dg.Timestamp.Unix()), scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+								s.Description = dg.Timestamp.UTC().Format(time.RFC3339Nano)
+								return s, nil
+							}))
+							d.FieldValueUint("ts_nsec", uint64(dg.Timestamp.Nanosecond()))
+
+							upi := format.UDP_Payload_In{
+								SourcePort:      f.Client.Port,
+								DestinationPort: f.Server.Port,
+							}
+							if !dg.FromClient {
+								upi.SourcePort, upi.DestinationPort = upi.DestinationPort, upi.SourcePort
+							}
+							br := bitio.NewBitReader(dg.Payload, -1)
+							if dv, _, _ := d.TryFieldFormatBitBuf(
+								"payload",
+								br,
+								&udpPayloadFormat,
+								upi,
+							); dv == nil {
+								d.FieldRootBitBuf("payload", br)
+							}
+						})
+					}
+				})
+			})
+		}
+	})
 }
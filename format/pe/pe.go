@@ -0,0 +1,527 @@
+package pe
+
+// https://learn.microsoft.com/en-us/windows/win32/debug/pe-format
+// https://github.com/llvm/llvm-project/blob/main/llvm/include/llvm/BinaryFormat/COFF.h
+
+import (
+	"embed"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed pe.md
+var peFS embed.FS
+
+var xmlGroup decode.Group
+var asn1BerGroup decode.Group
+
+func init() {
+	interp.RegisterFormat(
+		format.PE,
+		&decode.Format{
+			Description: "Portable Executable",
+			Groups:      []*decode.Group{format.Probe},
+			DecodeFn:    peDecode,
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.XML}, Out: &xmlGroup},
+				{Groups: []*decode.Group{format.ASN1_BER}, Out: &asn1BerGroup},
+			},
+		})
+	interp.RegisterFS(peFS)
+}
+
+const (
+	PE32      = 0x10b
+	PE32_PLUS = 0x20b
+)
+
+var optionalHeaderMagicNames = scalar.UintMap{
+	PE32:      {Sym: "pe32", Description: "32-bit"},
+	PE32_PLUS: {Sym: "pe32_plus", Description: "64-bit"},
+	0x107:     {Sym: "rom", Description: "ROM image"},
+}
+
+var machineNames = scalar.UintMapSymStr{
+	0x0000: "unknown",
+	0x0184: "alpha",
+	0x0284: "alpha64",
+	0x01d3: "am33",
+	0x8664: "amd64",
+	0x01c0: "arm",
+	0xaa64: "arm64",
+	0xa641: "arm64ec",
+	0xa64e: "arm64x",
+	0x01c4: "armnt",
+	0x0ebc: "ebc",
+	0x014c: "i386",
+	0x0200: "ia64",
+	0x6232: "loongarch32",
+	0x6264: "loongarch64",
+	0x9041: "m32r",
+	0x0266: "mips16",
+	0x0366: "mipsfpu",
+	0x0466: "mipsfpu16",
+	0x01f0: "powerpc",
+	0x01f1: "powerpcfp",
+	0x0166: "r4000",
+	0x5032: "riscv32",
+	0x5064: "riscv64",
+	0x5128: "riscv128",
+	0x01a2: "sh3",
+	0x01a3: "sh3dsp",
+	0x01a6: "sh4",
+	0x01a8: "sh5",
+	0x01c2: "thumb",
+	0x0169: "wcemipsv2",
+}
+
+var subsystemNames = scalar.UintMapSymStr{
+	0:  "unknown",
+	1:  "native",
+	2:  "windows_gui",
+	3:  "windows_cui",
+	5:  "os2_cui",
+	7:  "posix_cui",
+	8:  "native_windows",
+	9:  "windows_ce_gui",
+	10: "efi_application",
+	11: "efi_boot_service_driver",
+	12: "efi_runtime_driver",
+	13: "efi_rom",
+	14: "xbox",
+	16: "windows_boot_application",
+}
+
+const (
+	IMAGE_DIRECTORY_ENTRY_EXPORT    = 0
+	IMAGE_DIRECTORY_ENTRY_IMPORT    = 1
+	IMAGE_DIRECTORY_ENTRY_RESOURCE  = 2
+	IMAGE_DIRECTORY_ENTRY_SECURITY  = 4
+	IMAGE_DIRECTORY_ENTRY_BASERELOC = 5
+	IMAGE_DIRECTORY_ENTRY_DEBUG     = 6
+)
+
+var dataDirectoryNames = scalar.UintMapSymStr{
+	0:  "export_table",
+	1:  "import_table",
+	2:  "resource_table",
+	3:  "exception_table",
+	4:  "certificate_table",
+	5:  "base_relocation_table",
+	6:  "debug",
+	7:  "architecture",
+	8:  "global_ptr",
+	9:  "tls_table",
+	10: "load_config_table",
+	11: "bound_import",
+	12: "iat",
+	13: "delay_import_descriptor",
+	14: "clr_runtime_header",
+	15: "reserved",
+}
+
+type peSection struct {
+	virtualAddress uint64
+	virtualSize    uint64
+	rawPointer     uint64
+	rawSize        uint64
+}
+
+type peDataDirectory struct {
+	virtualAddress uint64
+	size           uint64
+}
+
+type peContext struct {
+	is64            bool
+	imageBase       uint64
+	sizeOfHeaders   uint64
+	sections        []peSection
+	dataDirectories []peDataDirectory
+}
+
+func (pc *peContext) addrBits() int {
+	if pc.is64 {
+		return 64
+	}
+	return 32
+}
+
+// rvaToOffset maps a relative virtual address to a file offset in bytes
+func (pc *peContext) rvaToOffset(rva uint64) (int64, bool) {
+	if rva < pc.sizeOfHeaders {
+		return int64(rva), true
+	}
+	for _, s := range pc.sections {
+		if rva >= s.virtualAddress && rva < s.virtualAddress+s.rawSize {
+			return int64(s.rawPointer + rva - s.virtualAddress), true
+		}
+	}
+	return 0, false
+}
+
+// seekRVA runs fn at file offset for rva, keeps current position
+func (pc *peContext) seekRVA(d *decode.D, rva uint64, fn func(d *decode.D)) bool {
+	offset, ok := pc.rvaToOffset(rva)
+	if !ok || offset*8 >= d.Len() {
+		return false
+	}
+	d.SeekAbs(offset*8, fn)
+	return true
+}
+
+func (pc *peContext) dataDirectory(i int) (peDataDirectory, bool) {
+	if i >= len(pc.dataDirectories) {
+		return peDataDirectory{}, false
+	}
+	dd := pc.dataDirectories[i]
+	return dd, dd.virtualAddress != 0 && dd.size != 0
+}
+
+// peChecksum is the 16 bit one's complement sum of the file excluding the
+// checksum field plus the file length
+func peChecksum(r io.Reader, checksumOffset int64, size int64) uint64 {
+	var sum uint64
+	var offset int64
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := io.ReadFull(r, buf)
+		for i := 0; i < n; i += 2 {
+			if o := offset + int64(i); o == checksumOffset || o == checksumOffset+2 {
+				continue
+			}
+			w := uint64(buf[i])
+			if i+1 < n {
+				w |= uint64(buf[i+1]) << 8
+			}
+			sum += w
+			sum = (sum & 0xffff) + (sum >> 16)
+		}
+		offset += int64(n)
+		if err != nil {
+			break
+		}
+	}
+	sum = (sum & 0xffff) + (sum >> 16)
+	return sum + uint64(size)
+}
+
+// section names longer than 8 bytes are "/n" where n is a decimal offset into the string table
+type sectionLongName string
+
+func (m sectionLongName) MapStr(s scalar.Str) (scalar.Str, error) {
+	if !strings.HasPrefix(s.Actual, "/") {
+		return s, nil
+	}
+	n, err := strconv.Atoi(s.Actual[1:])
+	if err != nil || n < 0 || n >= len(m) {
+		return s, nil
+	}
+	if i := strings.IndexByte(string(m[n:]), 0); i != -1 {
+		s.Sym = string(m[n : n+i])
+	}
+	return s, nil
+}
+
+func peDecodeDOSHeader(d *decode.D) uint64 {
+	var lfanew uint64
+	d.FieldStruct("dos_header", func(d *decode.D) {
+		d.FieldUTF8("e_magic", 2, d.StrAssert("MZ"))
+		d.FieldU16("e_cblp")
+		d.FieldU16("e_cp")
+		d.FieldU16("e_crlc")
+		d.FieldU16("e_cparhdr")
+		d.FieldU16("e_minalloc")
+		d.FieldU16("e_maxalloc")
+		d.FieldU16("e_ss", scalar.UintHex)
+		d.FieldU16("e_sp", scalar.UintHex)
+		d.FieldU16("e_csum", scalar.UintHex)
+		d.FieldU16("e_ip", scalar.UintHex)
+		d.FieldU16("e_cs", scalar.UintHex)
+		d.FieldU16("e_lfarlc", scalar.UintHex)
+		d.FieldU16("e_ovno")
+		d.FieldRawLen("e_res", 4*16)
+		d.FieldU16("e_oemid")
+		d.FieldU16("e_oeminfo")
+		d.FieldRawLen("e_res2", 10*16)
+		lfanew = d.FieldU32("e_lfanew", scalar.UintHex)
+	})
+	return lfanew
+}
+
+func peDecodeCOFFHeader(d *decode.D) (uint64, uint64, uint64, uint64) {
+	var numberOfSections uint64
+	var symbolTablePointer uint64
+	var numberOfSymbols uint64
+	var optionalHeaderSize uint64
+	d.FieldStruct("coff_header", func(d *decode.D) {
+		d.FieldU16("machine", machineNames, scalar.UintHex)
+		numberOfSections = d.FieldU16("number_of_sections")
+		d.FieldU32("time_date_stamp", scalar.UintActualUnixTime(time.RFC3339))
+		symbolTablePointer = d.FieldU32("pointer_to_symbol_table", scalar.UintHex)
+		numberOfSymbols = d.FieldU32("number_of_symbols")
+		optionalHeaderSize = d.FieldU16("size_of_optional_header")
+		d.FieldStruct("characteristics", func(d *decode.D) {
+			d.FieldBool("bytes_reversed_lo")
+			d.FieldBool("reserved")
+			d.FieldBool("large_address_aware")
+			d.FieldBool("aggressive_ws_trim")
+			d.FieldBool("local_syms_stripped")
+			d.FieldBool("line_nums_stripped")
+			d.FieldBool("executable_image")
+			d.FieldBool("relocs_stripped")
+			d.FieldBool("bytes_reversed_hi")
+			d.FieldBool("up_system_only")
+			d.FieldBool("dll")
+			d.FieldBool("system")
+			d.FieldBool("net_run_from_swap")
+			d.FieldBool("removable_run_from_swap")
+			d.FieldBool("debug_stripped")
+			d.FieldBool("machine_32bit")
+		})
+	})
+	return numberOfSections, symbolTablePointer, numberOfSymbols, optionalHeaderSize
+}
+
+func peDecodeOptionalHeader(d *decode.D, pc *peContext, calculatedChecksum uint64) {
+	magic := d.FieldU16("magic", optionalHeaderMagicNames, scalar.UintHex)
+	switch magic {
+	case PE32:
+	case PE32_PLUS:
+		pc.is64 = true
+	default:
+		d.Fatalf("unsupported optional header magic %x", magic)
+	}
+	addrBits := pc.addrBits()
+
+	d.FieldU8("major_linker_version")
+	d.FieldU8("minor_linker_version")
+	d.FieldU32("size_of_code")
+	d.FieldU32("size_of_initialized_data")
+	d.FieldU32("size_of_uninitialized_data")
+	d.FieldU32("address_of_entry_point", scalar.UintHex)
+	d.FieldU32("base_of_code", scalar.UintHex)
+	if !pc.is64 {
+		d.FieldU32("base_of_data", scalar.UintHex)
+	}
+	pc.imageBase = d.FieldU("image_base", addrBits, scalar.UintHex)
+	d.FieldU32("section_alignment")
+	d.FieldU32("file_alignment")
+	d.FieldU16("major_operating_system_version")
+	d.FieldU16("minor_operating_system_version")
+	d.FieldU16("major_image_version")
+	d.FieldU16("minor_image_version")
+	d.FieldU16("major_subsystem_version")
+	d.FieldU16("minor_subsystem_version")
+	d.FieldU32("win32_version_value")
+	d.FieldU32("size_of_image")
+	pc.sizeOfHeaders = d.FieldU32("size_of_headers")
+	checksum := d.U32()
+	d.SeekRel(-32)
+	if checksum != 0 {
+		d.FieldU32("check_sum", d.UintValidate(calculatedChecksum), scalar.UintHex)
+	} else {
+		d.FieldU32("check_sum", scalar.UintHex)
+	}
+	d.FieldU16("subsystem", subsystemNames)
+	d.FieldStruct("dll_characteristics", func(d *decode.D) {
+		d.FieldBool("force_integrity")
+		d.FieldBool("dynamic_base")
+		d.FieldBool("high_entropy_va")
+		d.FieldU5("reserved")
+		d.FieldBool("terminal_server_aware")
+		d.FieldBool("guard_cf")
+		d.FieldBool("wdm_driver")
+		d.FieldBool("appcontainer")
+		d.FieldBool("no_bind")
+		d.FieldBool("no_seh")
+		d.FieldBool("no_isolation")
+		d.FieldBool("nx_compat")
+	})
+	d.FieldU("size_of_stack_reserve", addrBits)
+	d.FieldU("size_of_stack_commit", addrBits)
+	d.FieldU("size_of_heap_reserve", addrBits)
+	d.FieldU("size_of_heap_commit", addrBits)
+	d.FieldU32("loader_flags", scalar.UintHex)
+	numberOfRvaAndSizes := d.FieldU32("number_of_rva_and_sizes")
+	if numberOfRvaAndSizes*64 > uint64(d.BitsLeft()) {
+		d.Fatalf("number_of_rva_and_sizes %d does not fit optional header", numberOfRvaAndSizes)
+	}
+	d.FieldArray("data_directories", func(d *decode.D) {
+		for i := uint64(0); i < numberOfRvaAndSizes; i++ {
+			d.FieldStruct("data_directory", func(d *decode.D) {
+				d.FieldValueUint("index", i, dataDirectoryNames)
+				rva := d.FieldU32("virtual_address", scalar.UintHex)
+				size := d.FieldU32("size")
+				pc.dataDirectories = append(pc.dataDirectories, peDataDirectory{virtualAddress: rva, size: size})
+			})
+		}
+	})
+}
+
+func peDecodeSectionHeader(d *decode.D, pc *peContext, longNames sectionLongName) {
+	d.FieldUTF8NullFixedLen("name", 8, longNames)
+	virtualSize := d.FieldU32("virtual_size")
+	virtualAddress := d.FieldU32("virtual_address", scalar.UintHex)
+	rawSize := d.FieldU32("size_of_raw_data")
+	rawPointer := d.FieldU32("pointer_to_raw_data", scalar.UintHex)
+	d.FieldU32("pointer_to_relocations", scalar.UintHex)
+	d.FieldU32("pointer_to_linenumbers", scalar.UintHex)
+	d.FieldU16("number_of_relocations")
+	d.FieldU16("number_of_linenumbers")
+	d.FieldStruct("characteristics", func(d *decode.D) {
+		d.FieldBool("cnt_uninitialized_data")
+		d.FieldBool("cnt_initialized_data")
+		d.FieldBool("cnt_code")
+		d.FieldBool("reserved0")
+		d.FieldBool("type_no_pad")
+		d.FieldU3("reserved1")
+		d.FieldBool("gprel")
+		d.FieldU2("reserved2")
+		d.FieldBool("lnk_comdat")
+		d.FieldBool("lnk_remove")
+		d.FieldBool("reserved3")
+		d.FieldBool("lnk_info")
+		d.FieldBool("lnk_other")
+		d.FieldU4("align", scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+			if s.Actual != 0 {
+				s.Sym = uint64(1) << (s.Actual - 1)
+			}
+			return s, nil
+		}))
+		d.FieldBool("mem_preload")
+		d.FieldBool("mem_locked")
+		d.FieldBool("mem_16bit")
+		d.FieldBool("reserved4")
+		d.FieldBool("mem_write")
+		d.FieldBool("mem_read")
+		d.FieldBool("mem_execute")
+		d.FieldBool("mem_shared")
+		d.FieldBool("mem_not_paged")
+		d.FieldBool("mem_not_cached")
+		d.FieldBool("mem_discardable")
+		d.FieldBool("lnk_nreloc_ovfl")
+	})
+
+	pc.sections = append(pc.sections, peSection{
+		virtualAddress: virtualAddress,
+		virtualSize:    virtualSize,
+		rawPointer:     rawPointer,
+		rawSize:        rawSize,
+	})
+}
+
+// sectionHasDirectory reports if a decoded data directory starts in section
+func (pc *peContext) sectionHasDirectory(s peSection) bool {
+	for _, i := range []int{
+		IMAGE_DIRECTORY_ENTRY_EXPORT,
+		IMAGE_DIRECTORY_ENTRY_IMPORT,
+		IMAGE_DIRECTORY_ENTRY_RESOURCE,
+		IMAGE_DIRECTORY_ENTRY_BASERELOC,
+		IMAGE_DIRECTORY_ENTRY_DEBUG,
+	} {
+		dd, ok := pc.dataDirectory(i)
+		if ok && dd.virtualAddress >= s.virtualAddress && dd.virtualAddress < s.virtualAddress+s.rawSize {
+			return true
+		}
+	}
+	return false
+}
+
+func peDecode(d *decode.D) any {
+	var pc peContext
+
+	d.Endian = decode.LittleEndian
+	fileLen := d.Len() / 8
+
+	lfanew := peDecodeDOSHeader(d)
+	if int64(lfanew)+4 > fileLen || lfanew < 64 {
+		d.Fatalf("e_lfanew %d outside file", lfanew)
+	}
+	if stubLen := int64(lfanew)*8 - d.Pos(); stubLen > 0 {
+		d.FieldRawLen("dos_stub", stubLen)
+	}
+	d.SeekAbs(int64(lfanew) * 8)
+	d.FieldRawLen("signature", 4*8, d.AssertBitBuf([]byte("PE\x00\x00")))
+
+	numberOfSections, symbolTablePointer, numberOfSymbols, optionalHeaderSize := peDecodeCOFFHeader(d)
+	optionalHeaderStart := d.Pos()
+	// check_sum is at the same offset for PE32 and PE32+
+	checksumOffset := optionalHeaderStart/8 + 64
+	if optionalHeaderSize > 0 {
+		calculatedChecksum := peChecksum(bitio.NewIOReader(d.BitBufRange(0, fileLen*8)), checksumOffset, fileLen)
+		d.FramedFn(int64(optionalHeaderSize)*8, func(d *decode.D) {
+			d.FieldStruct("optional_header", func(d *decode.D) {
+				peDecodeOptionalHeader(d, &pc, calculatedChecksum)
+			})
+		})
+	}
+
+	var longNames sectionLongName
+	if symbolTablePointer != 0 {
+		stringTableOffset := int64(symbolTablePointer) + int64(numberOfSymbols)*18
+		if bs, err := d.TryBytesRange(stringTableOffset*8, 4); err == nil {
+			size := int(bs[0]) | int(bs[1])<<8 | int(bs[2])<<16 | int(bs[3])<<24
+			if bs, err := d.TryBytesRange(stringTableOffset*8, size); err == nil && size >= 4 {
+				longNames = sectionLongName(bs)
+			}
+		}
+	}
+
+	d.FieldArray("section_headers", func(d *decode.D) {
+		for i := uint64(0); i < numberOfSections; i++ {
+			d.FieldStruct("section_header", func(d *decode.D) {
+				peDecodeSectionHeader(d, &pc, longNames)
+				s := pc.sections[len(pc.sections)-1]
+				if s.rawSize == 0 || pc.sectionHasDirectory(s) {
+					return
+				}
+				size := mathex.Min(int64(s.rawSize), fileLen-int64(s.rawPointer))
+				if size <= 0 {
+					return
+				}
+				d.RangeFn(int64(s.rawPointer)*8, size*8, func(d *decode.D) {
+					d.FieldRawLen("data", d.BitsLeft())
+				})
+			})
+		}
+	})
+
+	if symbolTablePointer != 0 && int64(symbolTablePointer) < fileLen {
+		stringTableOffset := int64(symbolTablePointer) + int64(numberOfSymbols)*18
+		symbolTableSize := mathex.Min(int64(numberOfSymbols)*18, fileLen-int64(symbolTablePointer))
+		d.SeekAbs(int64(symbolTablePointer) * 8)
+		d.FieldRawLen("symbol_table", symbolTableSize*8)
+		if len(longNames) >= 4 {
+			d.SeekAbs(stringTableOffset * 8)
+			d.FieldStruct("string_table", func(d *decode.D) {
+				d.FieldU32("size")
+				d.FieldRawLen("strings", int64(len(longNames)-4)*8)
+			})
+		}
+	}
+
+	peDecodeDirectories(d, &pc, fileLen)
+
+	return nil
+}
+
+func formatGUID(bs []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		uint32(bs[0])|uint32(bs[1])<<8|uint32(bs[2])<<16|uint32(bs[3])<<24,
+		uint16(bs[4])|uint16(bs[5])<<8,
+		uint16(bs[6])|uint16(bs[7])<<8,
+		bs[8:10],
+		bs[10:16],
+	)
+}
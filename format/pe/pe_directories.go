@@ -0,0 +1,483 @@
+package pe
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+const (
+	RT_MANIFEST = 24
+)
+
+var resourceTypeNames = scalar.UintMapSymStr{
+	1:  "cursor",
+	2:  "bitmap",
+	3:  "icon",
+	4:  "menu",
+	5:  "dialog",
+	6:  "string",
+	7:  "fontdir",
+	8:  "font",
+	9:  "accelerator",
+	10: "rcdata",
+	11: "messagetable",
+	12: "group_cursor",
+	14: "group_icon",
+	16: "version",
+	17: "dlginclude",
+	19: "plugplay",
+	20: "vxd",
+	21: "anicursor",
+	22: "aniicon",
+	23: "html",
+	24: "manifest",
+}
+
+const (
+	IMAGE_REL_BASED_ABSOLUTE = 0
+	IMAGE_REL_BASED_HIGHADJ  = 4
+)
+
+var baseRelocationTypeNames = scalar.UintMapSymStr{
+	0:  "absolute",
+	1:  "high",
+	2:  "low",
+	3:  "highlow",
+	4:  "highadj",
+	5:  "machine_specific_5",
+	6:  "reserved",
+	7:  "machine_specific_7",
+	8:  "machine_specific_8",
+	9:  "machine_specific_9",
+	10: "dir64",
+}
+
+const (
+	IMAGE_DEBUG_TYPE_CODEVIEW = 2
+)
+
+var debugTypeNames = scalar.UintMapSymStr{
+	0:  "unknown",
+	1:  "coff",
+	2:  "codeview",
+	3:  "fpo",
+	4:  "misc",
+	5:  "exception",
+	6:  "fixup",
+	7:  "omap_to_src",
+	8:  "omap_from_src",
+	9:  "borland",
+	10: "reserved10",
+	11: "clsid",
+	12: "vc_feature",
+	13: "pogo",
+	14: "iltcg",
+	15: "mpx",
+	16: "repro",
+	20: "ex_dllcharacteristics",
+}
+
+var certificateRevisionNames = scalar.UintMapSymStr{
+	0x0100: "revision_1_0",
+	0x0200: "revision_2_0",
+}
+
+const (
+	WIN_CERT_TYPE_PKCS_SIGNED_DATA = 2
+)
+
+var certificateTypeNames = scalar.UintMapSymStr{
+	1: "x509",
+	2: "pkcs_signed_data",
+	3: "reserved_1",
+	4: "ts_stack_signed",
+}
+
+func symMapper(sym string) scalar.UintMapper {
+	return scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+		s.Sym = sym
+		return s, nil
+	})
+}
+
+func peDecodeExports(d *decode.D, pc *peContext, dd peDataDirectory) {
+	pc.seekRVA(d, dd.virtualAddress, func(d *decode.D) {
+		d.FieldStruct("exports", func(d *decode.D) {
+			d.FieldU32("characteristics", scalar.UintHex)
+			d.FieldU32("time_date_stamp", scalar.UintActualUnixTime(time.RFC3339))
+			d.FieldU16("major_version")
+			d.FieldU16("minor_version")
+			nameRVA := d.FieldU32("name_rva", scalar.UintHex)
+			ordinalBase := d.FieldU32("ordinal_base")
+			numberOfFunctions := d.FieldU32("number_of_functions")
+			numberOfNames := d.FieldU32("number_of_names")
+			addressTableRVA := d.FieldU32("export_address_table_rva", scalar.UintHex)
+			namePointerRVA := d.FieldU32("name_pointer_rva", scalar.UintHex)
+			ordinalTableRVA := d.FieldU32("ordinal_table_rva", scalar.UintHex)
+
+			if numberOfFunctions*4 > uint64(d.Len()/8) || numberOfNames*4 > uint64(d.Len()/8) {
+				d.Fatalf("number_of_functions %d or number_of_names %d too large", numberOfFunctions, numberOfNames)
+			}
+
+			pc.seekRVA(d, nameRVA, func(d *decode.D) {
+				d.FieldUTF8Null("name")
+			})
+
+			names := make([]string, numberOfNames)
+			pc.seekRVA(d, namePointerRVA, func(d *decode.D) {
+				d.FieldArray("name_pointer_table", func(d *decode.D) {
+					for i := range names {
+						d.FieldStruct("entry", func(d *decode.D) {
+							rva := d.FieldU32("name_rva", scalar.UintHex)
+							pc.seekRVA(d, rva, func(d *decode.D) {
+								names[i] = d.FieldUTF8Null("name")
+							})
+						})
+					}
+				})
+			})
+
+			// ordinal table has unbiased indexes into the export address table
+			functionNames := map[uint64]string{}
+			pc.seekRVA(d, ordinalTableRVA, func(d *decode.D) {
+				d.FieldArray("ordinal_table", func(d *decode.D) {
+					for _, name := range names {
+						index := d.FieldU16("index", symMapper(name))
+						functionNames[index] = name
+					}
+				})
+			})
+
+			pc.seekRVA(d, addressTableRVA, func(d *decode.D) {
+				d.FieldArray("export_address_table", func(d *decode.D) {
+					for i := uint64(0); i < numberOfFunctions; i++ {
+						d.FieldStruct("entry", func(d *decode.D) {
+							d.FieldValueUint("ordinal", ordinalBase+i)
+							if name, ok := functionNames[i]; ok {
+								d.FieldValueStr("name", name)
+							}
+							rva := d.FieldU32("address", scalar.UintHex)
+							// addresses inside the export directory are forwarder strings
+							if rva >= dd.virtualAddress && rva < dd.virtualAddress+dd.size {
+								pc.seekRVA(d, rva, func(d *decode.D) {
+									d.FieldUTF8Null("forwarder")
+								})
+							}
+						})
+					}
+				})
+			})
+		})
+	})
+}
+
+func peDecodeImportDescriptor(d *decode.D, pc *peContext) {
+	addrBits := pc.addrBits()
+
+	lookupTableRVA := d.FieldU32("import_lookup_table_rva", scalar.UintHex)
+	d.FieldU32("time_date_stamp", scalar.UintHex)
+	d.FieldU32("forwarder_chain", scalar.UintHex)
+	nameRVA := d.FieldU32("name_rva", scalar.UintHex)
+	addressTableRVA := d.FieldU32("import_address_table_rva", scalar.UintHex)
+
+	pc.seekRVA(d, nameRVA, func(d *decode.D) {
+		d.FieldUTF8Null("name")
+	})
+
+	// some linkers only emit the address table
+	hasAddressTable := lookupTableRVA != 0
+	if !hasAddressTable {
+		lookupTableRVA = addressTableRVA
+	}
+
+	var names []string
+	pc.seekRVA(d, lookupTableRVA, func(d *decode.D) {
+		d.FieldArray("lookup_table", func(d *decode.D) {
+			for {
+				var v uint64
+				d.FieldStruct("entry", func(d *decode.D) {
+					v = d.FieldU("value", addrBits, scalar.UintHex)
+					if v == 0 {
+						return
+					}
+					if v>>(addrBits-1) != 0 {
+						d.FieldValueUint("ordinal", v&0xffff)
+						names = append(names, "")
+						return
+					}
+					name := ""
+					pc.seekRVA(d, v&0x7fff_ffff, func(d *decode.D) {
+						d.FieldU16("hint")
+						name = d.FieldUTF8Null("name")
+					})
+					names = append(names, name)
+				})
+				if v == 0 {
+					break
+				}
+			}
+		})
+	})
+
+	if !hasAddressTable {
+		return
+	}
+	pc.seekRVA(d, addressTableRVA, func(d *decode.D) {
+		d.FieldArray("address_table", func(d *decode.D) {
+			for i := 0; i <= len(names); i++ {
+				if i < len(names) && names[i] != "" {
+					d.FieldU("entry", addrBits, scalar.UintHex, symMapper(names[i]))
+				} else {
+					d.FieldU("entry", addrBits, scalar.UintHex)
+				}
+			}
+		})
+	})
+}
+
+func peDecodeImports(d *decode.D, pc *peContext, dd peDataDirectory) {
+	pc.seekRVA(d, dd.virtualAddress, func(d *decode.D) {
+		d.FieldArray("imports", func(d *decode.D) {
+			// terminated by an all zero descriptor
+			for d.BitsLeft() >= 20*8 {
+				if bytes.Equal(d.PeekBytes(20), make([]byte, 20)) {
+					break
+				}
+				d.FieldStruct("import", func(d *decode.D) {
+					peDecodeImportDescriptor(d, pc)
+				})
+			}
+		})
+	})
+}
+
+func peDecodeResources(d *decode.D, pc *peContext, dd peDataDirectory) {
+	base, ok := pc.rvaToOffset(dd.virtualAddress)
+	if !ok {
+		return
+	}
+	// offsets in the directory tree are relative to the start of the resource directory
+	seekOffset := func(d *decode.D, offset uint64, fn func(d *decode.D)) {
+		d.SeekAbs((base+int64(offset))*8, fn)
+	}
+
+	// directories are not removed from visited after being decoded, a directory shared
+	// between entries is also rejected as decoding them could take exponential time
+	visited := map[uint64]bool{}
+	var decodeDirectory func(d *decode.D, offset uint64, level int, typeID uint64)
+	decodeDirectory = func(d *decode.D, offset uint64, level int, typeID uint64) {
+		if visited[offset] {
+			d.Fatalf("resource directory at offset %d already decoded", offset)
+		}
+		visited[offset] = true
+
+		d.FieldU32("characteristics", scalar.UintHex)
+		d.FieldU32("time_date_stamp", scalar.UintActualUnixTime(time.RFC3339))
+		d.FieldU16("major_version")
+		d.FieldU16("minor_version")
+		numberOfNameEntries := d.FieldU16("number_of_name_entries")
+		numberOfIDEntries := d.FieldU16("number_of_id_entries")
+
+		d.FieldArray("entries", func(d *decode.D) {
+			for i := uint64(0); i < numberOfNameEntries+numberOfIDEntries; i++ {
+				d.FieldStruct("entry", func(d *decode.D) {
+					// high bit set means name string offset, otherwise an integer id
+					nameOrID := d.FieldU32("name_or_id", scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+						switch {
+						case s.Actual&0x8000_0000 != 0:
+							return scalar.UintHex.MapUint(s)
+						case level == 0:
+							return resourceTypeNames.MapUint(s)
+						}
+						return s, nil
+					}))
+					entryTypeID := typeID
+					if nameOrID&0x8000_0000 != 0 {
+						seekOffset(d, nameOrID&0x7fff_ffff, func(d *decode.D) {
+							d.FieldStruct("name", func(d *decode.D) {
+								length := d.FieldU16("length")
+								d.FieldUTF16LE("string", int(length)*2)
+							})
+						})
+					} else if level == 0 {
+						entryTypeID = nameOrID
+					}
+
+					dataOffset := d.FieldU32("offset_to_data", scalar.UintHex)
+					if dataOffset&0x8000_0000 != 0 {
+						seekOffset(d, dataOffset&0x7fff_ffff, func(d *decode.D) {
+							d.FieldStruct("directory", func(d *decode.D) {
+								decodeDirectory(d, dataOffset&0x7fff_ffff, level+1, entryTypeID)
+							})
+						})
+						return
+					}
+					seekOffset(d, dataOffset, func(d *decode.D) {
+						d.FieldStruct("data_entry", func(d *decode.D) {
+							dataRVA := d.FieldU32("data_rva", scalar.UintHex)
+							size := d.FieldU32("size")
+							d.FieldU32("code_page")
+							d.FieldU32("reserved")
+							pc.seekRVA(d, dataRVA, func(d *decode.D) {
+								dataLen := mathex.Min(int64(size)*8, d.BitsLeft())
+								if entryTypeID == RT_MANIFEST {
+									d.FieldFormatOrRawLen("data", dataLen, &xmlGroup, nil)
+								} else {
+									d.FieldRawLen("data", dataLen)
+								}
+							})
+						})
+					})
+				})
+			}
+		})
+	}
+
+	seekOffset(d, 0, func(d *decode.D) {
+		d.FieldStruct("resources", func(d *decode.D) {
+			decodeDirectory(d, 0, 0, 0)
+		})
+	})
+}
+
+func peDecodeBaseRelocations(d *decode.D, pc *peContext, dd peDataDirectory) {
+	pc.seekRVA(d, dd.virtualAddress, func(d *decode.D) {
+		end := mathex.Min(d.Pos()+int64(dd.size)*8, d.Len())
+		d.FieldArray("base_relocations", func(d *decode.D) {
+			for d.Pos()+8*8 <= end {
+				d.FieldStruct("block", func(d *decode.D) {
+					pageRVA := d.FieldU32("page_rva", scalar.UintHex)
+					blockSize := d.FieldU32("block_size")
+					if blockSize < 8 {
+						d.Fatalf("invalid block_size %d", blockSize)
+					}
+					entriesEnd := mathex.Min(d.Pos()+int64(blockSize-8)*8, end)
+					d.FieldArray("entries", func(d *decode.D) {
+						for d.Pos()+16 <= entriesEnd {
+							d.FieldStruct("entry", func(d *decode.D) {
+								v := d.FieldU16("value", scalar.UintHex)
+								typ := v >> 12
+								d.FieldValueUint("type", typ, baseRelocationTypeNames)
+								d.FieldValueUint("offset", v&0xfff, scalar.UintHex)
+								if typ == IMAGE_REL_BASED_ABSOLUTE {
+									return
+								}
+								d.FieldValueUint("rva", pageRVA+v&0xfff, scalar.UintHex)
+								// highadj uses the next slot for the low 16 bits of the adjusted address
+								if typ == IMAGE_REL_BASED_HIGHADJ && d.Pos()+16 <= entriesEnd {
+									d.FieldU16("adjustment", scalar.UintHex)
+								}
+							})
+						}
+					})
+					if d.Pos() < entriesEnd {
+						d.FieldRawLen("padding", entriesEnd-d.Pos())
+					}
+				})
+			}
+		})
+	})
+}
+
+func peDecodeCodeView(d *decode.D) {
+	signature := d.FieldUTF8("signature", 4)
+	switch signature {
+	case "RSDS":
+		d.FieldStrFn("guid", func(d *decode.D) string { return formatGUID(d.BytesLen(16)) })
+		d.FieldU32("age")
+		d.FieldUTF8NullFixedLen("pdb_path", int(d.BitsLeft()/8))
+	case "NB10":
+		d.FieldU32("offset")
+		d.FieldU32("pdb_signature", scalar.UintHex)
+		d.FieldU32("age")
+		d.FieldUTF8NullFixedLen("pdb_path", int(d.BitsLeft()/8))
+	default:
+		d.FieldRawLen("data", d.BitsLeft())
+	}
+}
+
+func peDecodeDebugDirectory(d *decode.D, pc *peContext, dd peDataDirectory, fileLen int64) {
+	pc.seekRVA(d, dd.virtualAddress, func(d *decode.D) {
+		d.FieldArray("debug_directory", func(d *decode.D) {
+			for i := uint64(0); i < dd.size/28; i++ {
+				d.FieldStruct("entry", func(d *decode.D) {
+					d.FieldU32("characteristics", scalar.UintHex)
+					d.FieldU32("time_date_stamp", scalar.UintHex)
+					d.FieldU16("major_version")
+					d.FieldU16("minor_version")
+					typ := d.FieldU32("type", debugTypeNames)
+					size := d.FieldU32("size_of_data")
+					d.FieldU32("address_of_raw_data", scalar.UintHex)
+					pointer := d.FieldU32("pointer_to_raw_data", scalar.UintHex)
+					if pointer == 0 || size == 0 || int64(pointer+size) > fileLen {
+						return
+					}
+					d.RangeFn(int64(pointer)*8, int64(size)*8, func(d *decode.D) {
+						switch {
+						case typ == IMAGE_DEBUG_TYPE_CODEVIEW && size > 4:
+							d.FieldStruct("codeview", peDecodeCodeView)
+						default:
+							d.FieldRawLen("data", d.BitsLeft())
+						}
+					})
+				})
+			}
+		})
+	})
+}
+
+// certificate table address is a file offset and not a RVA
+func peDecodeCertificateTable(d *decode.D, dd peDataDirectory, fileLen int64) {
+	if int64(dd.virtualAddress) >= fileLen {
+		return
+	}
+	end := mathex.Min(int64(dd.virtualAddress+dd.size), fileLen) * 8
+	d.SeekAbs(int64(dd.virtualAddress) * 8)
+	d.FieldArray("certificate_table", func(d *decode.D) {
+		for d.Pos()+8*8 <= end {
+			d.FieldStruct("certificate", func(d *decode.D) {
+				length := d.FieldU32("length")
+				if length < 8 || d.Pos()+int64(length-4)*8 > end {
+					d.Fatalf("invalid certificate length %d", length)
+				}
+				d.FieldU16("revision", certificateRevisionNames, scalar.UintHex)
+				typ := d.FieldU16("certificate_type", certificateTypeNames)
+				certificateLen := int64(length-8) * 8
+				if typ == WIN_CERT_TYPE_PKCS_SIGNED_DATA {
+					d.FieldFormatOrRawLen("certificate", certificateLen, &asn1BerGroup, nil)
+				} else {
+					d.FieldRawLen("certificate", certificateLen)
+				}
+				// entries are 8 byte aligned
+				if padding := int64((8-length%8)%8) * 8; padding > 0 && d.Pos()+padding <= end {
+					d.FieldRawLen("padding", padding)
+				}
+			})
+		}
+	})
+}
+
+func peDecodeDirectories(d *decode.D, pc *peContext, fileLen int64) {
+	if dd, ok := pc.dataDirectory(IMAGE_DIRECTORY_ENTRY_EXPORT); ok {
+		peDecodeExports(d, pc, dd)
+	}
+	if dd, ok := pc.dataDirectory(IMAGE_DIRECTORY_ENTRY_IMPORT); ok {
+		peDecodeImports(d, pc, dd)
+	}
+	if dd, ok := pc.dataDirectory(IMAGE_DIRECTORY_ENTRY_RESOURCE); ok {
+		peDecodeResources(d, pc, dd)
+	}
+	if dd, ok := pc.dataDirectory(IMAGE_DIRECTORY_ENTRY_BASERELOC); ok {
+		peDecodeBaseRelocations(d, pc, dd)
+	}
+	if dd, ok := pc.dataDirectory(IMAGE_DIRECTORY_ENTRY_DEBUG); ok {
+		peDecodeDebugDirectory(d, pc, dd, fileLen)
+	}
+	if dd, ok := pc.dataDirectory(IMAGE_DIRECTORY_ENTRY_SECURITY); ok {
+		peDecodeCertificateTable(d, dd, fileLen)
+	}
+}
@@ -5,8 +5,11 @@ package png
 // https://wiki.mozilla.org/APNG_Specification
 
 import (
+	"bytes"
 	"compress/zlib"
+	"embed"
 	"hash/crc32"
+	"io"
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/pkg/bitio"
@@ -15,6 +18,9 @@ import (
 	"github.com/wader/fq/pkg/scalar"
 )
 
+//go:embed png.md
+var pngFS embed.FS
+
 var iccProfileGroup decode.Group
 var exifGroup decode.Group
 
@@ -25,11 +31,15 @@ func init() {
 			Description: "Portable Network Graphics file",
 			Groups:      []*decode.Group{format.Probe, format.Image},
 			DecodeFn:    pngDecode,
+			DefaultInArg: format.PNG_In{
+				DecodeImageData: false,
+			},
 			Dependencies: []decode.Dependency{
 				{Groups: []*decode.Group{format.ICC_Profile}, Out: &iccProfileGroup},
 				{Groups: []*decode.Group{format.Exif}, Out: &exifGroup},
 			},
 		})
+	interp.RegisterFS(pngFS)
 }
 
 const (
@@ -78,9 +88,108 @@ var colorTypeMap = scalar.UintMapSymStr{
 	colorTypeRGBA:               "rgba",
 }
 
+var colorTypeChannels = map[uint64]int{
+	colorTypeGrayscale:          1,
+	colorTypeRGB:                3,
+	colorTypePalette:            1,
+	colorTypeGrayscaleWithAlpha: 2,
+	colorTypeRGBA:               4,
+}
+
+const (
+	interlaceNone  = 0
+	interlaceAdam7 = 1
+)
+
+var interlaceNames = scalar.UintMapSymStr{
+	interlaceNone:  "none",
+	interlaceAdam7: "adam7",
+}
+
+var filterTypeNames = scalar.UintMapSymStr{
+	0: "none",
+	1: "sub",
+	2: "up",
+	3: "average",
+	4: "paeth",
+}
+
+// x, y start and x, y step for each adam7 pass
+var adam7Passes = [...][4]int{
+	{0, 0, 8, 8},
+	{4, 0, 8, 8},
+	{0, 4, 4, 8},
+	{2, 0, 4, 4},
+	{0, 2, 2, 4},
+	{1, 0, 2, 2},
+	{0, 1, 1, 2},
+}
+
+type frameControl struct {
+	sequenceNumber uint64
+	xOffset        uint64
+	yOffset        uint64
+}
+
+// image data from IDAT or fdAT chunks and fcTL chunk if any
+type frame struct {
+	control      *frameControl
+	defaultImage bool
+	width        uint64
+	height       uint64
+	data         []byte
+}
+
+func decodeScanlines(d *decode.D, width uint64, height uint64, bitsPerPixel uint64) {
+	rowLen := int64((width*bitsPerPixel + 7) / 8)
+	d.FieldArray("scanlines", func(d *decode.D) {
+		for y := uint64(0); y < height && d.BitsLeft() >= (1+rowLen)*8; y++ {
+			d.FieldStruct("scanline", func(d *decode.D) {
+				d.FieldU8("filter_type", filterTypeNames, d.UintValidateRange(0, 4))
+				d.FieldRawLen("data", rowLen*8)
+			})
+		}
+	})
+}
+
+func decodeImageData(d *decode.D, width uint64, height uint64, bitsPerPixel uint64, interlaceMethod uint64) {
+	switch interlaceMethod {
+	case interlaceAdam7:
+		d.FieldArray("passes", func(d *decode.D) {
+			for _, p := range adam7Passes {
+				passWidth := (width + uint64(p[2]-p[0]) - 1) / uint64(p[2])
+				passHeight := (height + uint64(p[3]-p[1]) - 1) / uint64(p[3])
+				// empty passes have no scanlines, not even filter type bytes
+				if passWidth == 0 || passHeight == 0 {
+					continue
+				}
+				d.FieldStruct("pass", func(d *decode.D) {
+					d.FieldValueUint("width", passWidth)
+					d.FieldValueUint("height", passHeight)
+					decodeScanlines(d, passWidth, passHeight, bitsPerPixel)
+				})
+			}
+		})
+	default:
+		decodeScanlines(d, width, height, bitsPerPixel)
+	}
+	if d.BitsLeft() > 0 {
+		d.FieldRawLen("unknown", d.BitsLeft())
+	}
+}
+
 func pngDecode(d *decode.D) any {
+	var pi format.PNG_In
+	d.ArgAs(&pi)
+
 	iEndFound := false
 	var colorType uint64
+	var width uint64
+	var height uint64
+	var bitDepth uint64
+	var interlaceMethod uint64
+	var frames []*frame
+	var currentFrame *frame
 
 	d.FieldRawLen("signature", 8*8, d.AssertBitBuf([]byte("\x89PNG\r\n\x1a\n")))
 	d.FieldStructArrayLoop("chunks", "chunk", func() bool { return d.NotEnd() && !iEndFound }, func(d *decode.D) {
@@ -102,18 +211,15 @@ func pngDecode(d *decode.D) any {
 		d.FramedFn(int64(chunkLength)*8, func(d *decode.D) {
 			switch chunkType {
 			case "IHDR":
-				d.FieldU32("width")
-				d.FieldU32("height")
-				d.FieldU8("bit_depth")
+				width = d.FieldU32("width")
+				height = d.FieldU32("height")
+				bitDepth = d.FieldU8("bit_depth")
 				colorType = d.FieldU8("color_type", colorTypeMap)
 				d.FieldU8("compression_method", compressionNames)
 				d.FieldU8("filter_method", scalar.UintMapSymStr{
 					0: "adaptive_filtering",
 				})
-				d.FieldU8("interlace_method", scalar.UintMapSymStr{
-					0: "none",
-					1: "adam7",
-				})
+				interlaceMethod = d.FieldU8("interlace_method", interlaceNames)
 			case "tEXt":
 				d.FieldUTF8Null("keyword")
 				d.FieldUTF8("text", int(d.BitsLeft())/8)
@@ -182,18 +288,36 @@ func pngDecode(d *decode.D) any {
 				d.FieldU32("num_frames")
 				d.FieldU32("num_plays")
 			case "fcTL":
-				d.FieldU32("sequence_number")
-				d.FieldU32("width")
-				d.FieldU32("height")
-				d.FieldU32("x_offset")
-				d.FieldU32("y_offset")
+				fc := &frameControl{}
+				fc.sequenceNumber = d.FieldU32("sequence_number")
+				frameWidth := d.FieldU32("width")
+				frameHeight := d.FieldU32("height")
+				fc.xOffset = d.FieldU32("x_offset")
+				fc.yOffset = d.FieldU32("y_offset")
 				d.FieldU16("delay_num")
 				d.FieldU16("delay_sep")
 				d.FieldU8("dispose_op", disposeOpNames)
 				d.FieldU8("blend_op", blendOpNames)
+
+				currentFrame = &frame{control: fc, width: frameWidth, height: frameHeight}
+				frames = append(frames, currentFrame)
+			case "IDAT":
+				// fcTL before first IDAT means default image is also first animation frame
+				if currentFrame == nil || (currentFrame.control == nil && !currentFrame.defaultImage) {
+					currentFrame = &frame{width: width, height: height}
+					frames = append(frames, currentFrame)
+				}
+				currentFrame.defaultImage = true
+				if pi.DecodeImageData {
+					currentFrame.data = append(currentFrame.data, d.BytesRange(d.Pos(), int(d.BitsLeft()/8))...)
+				}
+				d.FieldRawLen("data", d.BitsLeft())
 			case "fdAT":
 				d.FieldU32("sequence_number")
-				d.FieldRawLen("data", d.BitsLeft()-32)
+				if pi.DecodeImageData && currentFrame != nil {
+					currentFrame.data = append(currentFrame.data, d.BytesRange(d.Pos(), int(d.BitsLeft()/8))...)
+				}
+				d.FieldRawLen("data", d.BitsLeft())
 			case "PLTE":
 				d.FieldArray("palette", func(d *decode.D) {
 					for !d.End() {
@@ -233,5 +357,37 @@ func pngDecode(d *decode.D) any {
 		d.FieldU32("crc", d.UintValidateBytes(chunkCRC.Sum(nil)), scalar.UintHex)
 	})
 
+	if pi.DecodeImageData {
+		bitsPerPixel := uint64(colorTypeChannels[colorType]) * bitDepth
+
+		d.FieldArray("frames", func(d *decode.D) {
+			for _, f := range frames {
+				d.FieldStruct("frame", func(d *decode.D) {
+					d.FieldValueBool("default_image", f.defaultImage)
+					if f.control != nil {
+						d.FieldValueUint("sequence_number", f.control.sequenceNumber)
+						d.FieldValueUint("x_offset", f.control.xOffset)
+						d.FieldValueUint("y_offset", f.control.yOffset)
+					}
+					d.FieldValueUint("width", f.width)
+					d.FieldValueUint("height", f.height)
+
+					zr, err := zlib.NewReader(bytes.NewReader(f.data))
+					if err != nil {
+						return
+					}
+					// decode as much as possible of broken image data
+					uncompressed, _ := io.ReadAll(zr)
+					if len(uncompressed) == 0 {
+						return
+					}
+					d.FieldStructRootBitBufFn("uncompressed", bitio.NewBitReader(uncompressed, -1), func(d *decode.D) {
+						decodeImageData(d, f.width, f.height, bitsPerPixel, interlaceMethod)
+					})
+				})
+			}
+		})
+	}
+
 	return nil
 }
@@ -0,0 +1,264 @@
+package protobuf
+
+// FileDescriptorSet is decoded using the protobuf decoder and a subset of descriptor.proto
+// https://github.com/protocolbuffers/protobuf/blob/main/src/google/protobuf/descriptor.proto
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"strings"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/format/protobuf/protoschema"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed descriptor.proto
+var descriptorProto string
+
+var descriptorSetMessage = func() format.ProtoBufMessage {
+	s, err := protoschema.ParseProto(descriptorProto)
+	if err != nil {
+		panic(err)
+	}
+	pbm, err := s.ProtoBufMessage("google.protobuf.FileDescriptorSet")
+	if err != nil {
+		panic(err)
+	}
+	return pbm
+}()
+
+// FieldDescriptorProto.Type
+var descriptorTypes = map[uint64]int{
+	1:  format.ProtoBufTypeDouble,
+	2:  format.ProtoBufTypeFloat,
+	3:  format.ProtoBufTypeInt64,
+	4:  format.ProtoBufTypeUInt64,
+	5:  format.ProtoBufTypeInt32,
+	6:  format.ProtoBufTypeFixed64,
+	7:  format.ProtoBufTypeFixed32,
+	8:  format.ProtoBufTypeBool,
+	9:  format.ProtoBufTypeString,
+	10: format.ProtoBufTypeMessage, // group
+	11: format.ProtoBufTypeMessage,
+	12: format.ProtoBufTypeBytes,
+	13: format.ProtoBufTypeUInt32,
+	14: format.ProtoBufTypeEnum,
+	15: format.ProtoBufTypeSFixed32,
+	16: format.ProtoBufTypeSFixed64,
+	17: format.ProtoBufTypeSInt32,
+	18: format.ProtoBufTypeSInt64,
+}
+
+// descriptorMessage is a decoded descriptor message as field name to values in wire order,
+// message values are descriptorMessage and scalar values are the actual value
+type descriptorMessage map[string][]any
+
+func newDescriptorMessage(fieldsV *decode.Value) descriptorMessage {
+	m := descriptorMessage{}
+	fields, ok := fieldsV.V.(*decode.Compound)
+	if !ok {
+		return m
+	}
+	for _, fv := range fields.Children {
+		f, ok := fv.V.(*decode.Compound)
+		if !ok {
+			continue
+		}
+		// fields not in descriptor.proto subset have no name and are skipped
+		nameV, nameOk := f.ByName["name"]
+		valueV, valueOk := f.ByName["value"]
+		if !nameOk || !valueOk {
+			continue
+		}
+		name, ok := nameV.V.(*scalar.Str)
+		if !ok {
+			continue
+		}
+		var v any
+		switch vv := valueV.V.(type) {
+		case *decode.Compound:
+			v = newDescriptorMessage(vv.ByName["fields"])
+		case *scalar.Str:
+			v = vv.Actual
+		case *scalar.Uint:
+			v = vv.Actual
+		case *scalar.Any:
+			v = vv.Actual
+		default:
+			continue
+		}
+		m[name.Actual] = append(m[name.Actual], v)
+	}
+	return m
+}
+
+// last value is used for non-repeated fields
+func (m descriptorMessage) last(name string) any {
+	vs := m[name]
+	if len(vs) == 0 {
+		return nil
+	}
+	return vs[len(vs)-1]
+}
+
+func (m descriptorMessage) str(name string) string {
+	s, _ := m.last(name).(string)
+	return s
+}
+
+// int32 values are int64 and negative values end up as 64 bit two's complement
+func (m descriptorMessage) uint(name string) uint64 {
+	switch v := m.last(name).(type) {
+	case uint64:
+		return v
+	case int64:
+		return uint64(v)
+	default:
+		return 0
+	}
+}
+
+func (m descriptorMessage) bool(name string) bool {
+	b, _ := m.last(name).(bool)
+	return b
+}
+
+func (m descriptorMessage) messages(name string) []descriptorMessage {
+	var ms []descriptorMessage
+	for _, v := range m[name] {
+		if vm, ok := v.(descriptorMessage); ok {
+			ms = append(ms, vm)
+		}
+	}
+	return ms
+}
+
+type descriptorParser struct {
+	schema *protoschema.Schema
+	// type names in descriptors are fully qualified so can be resolved at the end
+	fields []*protoschema.Field
+}
+
+func joinName(scope string, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+// EnumDescriptorProto
+func (p *descriptorParser) enum(scope string, m descriptorMessage) {
+	e := &protoschema.Enum{Name: joinName(scope, m.str("name")), Values: map[uint64]string{}}
+	for _, vm := range m.messages("value") {
+		// first name is used for aliases
+		if _, ok := e.Values[vm.uint("number")]; !ok {
+			e.Values[vm.uint("number")] = vm.str("name")
+		}
+	}
+	p.schema.Enums[e.Name] = e
+}
+
+// DescriptorProto
+func (p *descriptorParser) message(scope string, m descriptorMessage) error {
+	msg := &protoschema.Message{Name: joinName(scope, m.str("name"))}
+	for _, fm := range m.messages("field") {
+		fd := protoschema.Field{
+			Name:     fm.str("name"),
+			Number:   int(fm.uint("number")),
+			TypeName: strings.TrimPrefix(fm.str("type_name"), "."),
+		}
+		typ, ok := descriptorTypes[fm.uint("type")]
+		if !ok {
+			return fmt.Errorf("field %q has unknown type %d", fd.Name, fm.uint("type"))
+		}
+		fd.Type = typ
+		msg.Fields = append(msg.Fields, fd)
+	}
+	p.schema.Messages[msg.Name] = msg
+	for i := range msg.Fields {
+		p.fields = append(p.fields, &msg.Fields[i])
+	}
+
+	for _, nm := range m.messages("nested_type") {
+		if err := p.message(msg.Name, nm); err != nil {
+			return err
+		}
+	}
+	for _, em := range m.messages("enum_type") {
+		p.enum(msg.Name, em)
+	}
+
+	return nil
+}
+
+// ServiceDescriptorProto
+func (p *descriptorParser) service(scope string, m descriptorMessage) {
+	s := &protoschema.Service{Name: joinName(scope, m.str("name"))}
+	for _, mm := range m.messages("method") {
+		s.Methods = append(s.Methods, protoschema.Method{
+			Name:            mm.str("name"),
+			InputType:       strings.TrimPrefix(mm.str("input_type"), "."),
+			OutputType:      strings.TrimPrefix(mm.str("output_type"), "."),
+			ClientStreaming: mm.bool("client_streaming"),
+			ServerStreaming: mm.bool("server_streaming"),
+		})
+	}
+	p.schema.Services[s.Name] = s
+}
+
+// FileDescriptorProto
+func (p *descriptorParser) file(m descriptorMessage) error {
+	pkg := m.str("package")
+	for _, mm := range m.messages("message_type") {
+		if err := p.message(pkg, mm); err != nil {
+			return err
+		}
+	}
+	for _, em := range m.messages("enum_type") {
+		p.enum(pkg, em)
+	}
+	for _, sm := range m.messages("service") {
+		p.service(pkg, sm)
+	}
+	return nil
+}
+
+// decodeDescriptorSet decodes a serialized FileDescriptorSet, ex: output from protoc -o
+func decodeDescriptorSet(ctx context.Context, bs []byte) (*protoschema.Schema, error) {
+	dv, _, err := decode.Decode(ctx, bitio.NewBitReader(bs, -1), format.Protobuf, decode.Options{
+		InArg: format.Protobuf_In{Message: descriptorSetMessage},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("descriptor set: %w", err)
+	}
+	root, ok := dv.V.(*decode.Compound)
+	if !ok {
+		return nil, fmt.Errorf("descriptor set: not a message")
+	}
+
+	p := &descriptorParser{schema: protoschema.New()}
+	for _, fm := range newDescriptorMessage(root.ByName["fields"]).messages("file") {
+		if err := p.file(fm); err != nil {
+			return nil, fmt.Errorf("descriptor set: %w", err)
+		}
+	}
+
+	for _, f := range p.fields {
+		switch f.Type {
+		case format.ProtoBufTypeMessage:
+			if _, ok := p.schema.Messages[f.TypeName]; !ok {
+				return nil, fmt.Errorf("descriptor set: field %q has unknown message type %q", f.Name, f.TypeName)
+			}
+		case format.ProtoBufTypeEnum:
+			if _, ok := p.schema.Enums[f.TypeName]; !ok {
+				return nil, fmt.Errorf("descriptor set: field %q has unknown enum type %q", f.Name, f.TypeName)
+			}
+		}
+	}
+
+	return p.schema, nil
+}
@@ -0,0 +1,46 @@
+package protobuf
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/wader/fq/format/protobuf/protoschema"
+)
+
+// descriptor sets in testdata are made from proto files using protoc -o so should
+// end up as same schema
+func TestDecodeDescriptorSet(t *testing.T) {
+	for _, name := range []string{"node", "greeter"} {
+		t.Run(name, func(t *testing.T) {
+			protoBs, err := os.ReadFile("testdata/" + name + ".proto")
+			if err != nil {
+				t.Fatal(err)
+			}
+			fdsBs, err := os.ReadFile("testdata/" + name + ".fds")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected, err := protoschema.ParseProto(string(protoBs))
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := decodeDescriptorSet(context.Background(), fdsBs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(expected, actual) {
+				t.Errorf("expected %+#v, got %+#v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestDecodeDescriptorSetErr(t *testing.T) {
+	if _, err := decodeDescriptorSet(context.Background(), []byte{0x0a, 0x10}); err == nil {
+		t.Errorf("expected error for truncated descriptor set")
+	}
+}
@@ -0,0 +1,101 @@
+package protobuf
+
+// https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md
+
+import (
+	"compress/gzip"
+	"embed"
+	"fmt"
+	"io"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed grpc.md
+var grpcFS embed.FS
+
+func init() {
+	interp.RegisterFormat(
+		format.GRPC,
+		&decode.Format{
+			Description:  "gRPC length-prefixed messages",
+			DecodeFn:     grpcDecode,
+			DefaultInArg: format.GRPC_In{},
+		})
+	interp.RegisterFS(grpcFS)
+}
+
+// limit uncompressed size of a message to not exhaust memory on decompression bombs
+const maxUncompressedLength = 64 * 1024 * 1024
+
+var compressedFlagNames = scalar.UintMapSymStr{
+	0: "uncompressed",
+	1: "compressed",
+}
+
+func grpcDecode(d *decode.D) any {
+	var gi format.GRPC_In
+	d.ArgAs(&gi)
+
+	// messages are decoded using protobufDecodeFields directly instead of using the protobuf
+	// format as protobuf options would otherwise override the message for the method
+	var pbm format.ProtoBufMessage
+	if s := decodeSchema(d, gi.Proto, gi.DescriptorSet); s != nil {
+		if gi.Method == "" {
+			d.Fatalf("method is required when using a schema")
+		}
+		m, err := s.Method(gi.Method)
+		if err != nil {
+			d.Fatalf("%s", err)
+		}
+		messageName := m.InputType
+		if gi.IsResponse {
+			messageName = m.OutputType
+		}
+		pbm, err = s.ProtoBufMessage("." + messageName)
+		if err != nil {
+			d.Fatalf("%s", err)
+		}
+	}
+
+	d.FieldArray("messages", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("message", func(d *decode.D) {
+				compressed := d.FieldU8("compressed_flag", d.UintAssert(0, 1), compressedFlagNames)
+				length := d.FieldU32("length")
+				if compressed == 0 {
+					d.FramedFn(int64(length)*8, func(d *decode.D) {
+						protobufDecodeFields(d, &pbm, false)
+					})
+					return
+				}
+
+				// compression is negotiated using grpc-encoding header, gzip is most common
+				compressedBR := d.FieldRawLen("compressed", int64(length)*8)
+				zr, err := gzip.NewReader(bitio.NewIOReader(d.CloneReadSeeker(compressedBR)))
+				if err != nil {
+					d.FieldValueStr("uncompressed_error", err.Error())
+					return
+				}
+				uncompressed, err := io.ReadAll(io.LimitReader(zr, maxUncompressedLength+1))
+				if err != nil {
+					d.FieldValueStr("uncompressed_error", err.Error())
+					return
+				}
+				if len(uncompressed) > maxUncompressedLength {
+					d.FieldValueStr("uncompressed_error", fmt.Sprintf("uncompressed length larger than %d bytes", maxUncompressedLength))
+					return
+				}
+				d.FieldStructRootBitBufFn("uncompressed", bitio.NewBitReader(uncompressed, -1), func(d *decode.D) {
+					protobufDecodeFields(d, &pbm, false)
+				})
+			})
+		}
+	})
+
+	return nil
+}
@@ -4,8 +4,10 @@ package protobuf
 
 import (
 	"embed"
+	"math"
 
 	"github.com/wader/fq/format"
+	"github.com/wader/fq/format/protobuf/protoschema"
 	"github.com/wader/fq/internal/mathex"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
@@ -20,8 +22,9 @@ func init() {
 	interp.RegisterFormat(
 		format.Protobuf,
 		&decode.Format{
-			Description: "Protobuf",
-			DecodeFn:    protobufDecode,
+			Description:  "Protobuf",
+			DecodeFn:     protobufDecode,
+			DefaultInArg: format.Protobuf_In{},
 		})
 	interp.RegisterFS(protobufFS)
 }
@@ -30,6 +33,8 @@ const (
 	wireTypeVarint          = 0
 	wireType64Bit           = 1
 	wireTypeLengthDelimited = 2
+	wireTypeStartGroup      = 3
+	wireTypeEndGroup        = 4
 	wireType32Bit           = 5
 )
 
@@ -37,17 +42,81 @@ var wireTypeNames = scalar.UintMapSymStr{
 	0: "varint",
 	1: "64bit",
 	2: "length_delimited",
+	3: "start_group",
+	4: "end_group",
 	5: "32bit",
 }
 
-func protobufDecodeField(d *decode.D, pbm *format.ProtoBufMessage) {
+// wire type for packed repeated values of type, false if type can't be packed
+func packedWireType(typ int) (uint64, bool) {
+	switch typ {
+	case format.ProtoBufTypeInt32, format.ProtoBufTypeInt64,
+		format.ProtoBufTypeUInt32, format.ProtoBufTypeUInt64,
+		format.ProtoBufTypeSInt32, format.ProtoBufTypeSInt64,
+		format.ProtoBufTypeBool, format.ProtoBufTypeEnum:
+		return wireTypeVarint, true
+	case format.ProtoBufTypeFixed64, format.ProtoBufTypeSFixed64, format.ProtoBufTypeDouble:
+		return wireType64Bit, true
+	case format.ProtoBufTypeFixed32, format.ProtoBufTypeSFixed32, format.ProtoBufTypeFloat:
+		return wireType32Bit, true
+	default:
+		return 0, false
+	}
+}
+
+func readWireValue(d *decode.D, wireType uint64) uint64 {
+	switch wireType {
+	case wireTypeVarint:
+		return d.ULEB128()
+	case wireType64Bit:
+		return d.U64LE()
+	case wireType32Bit:
+		return d.U32LE()
+	default:
+		panic("unreachable")
+	}
+}
+
+// typed value of a varint, 64bit or 32bit wire value
+func scalarValue(typ int, value uint64) any {
+	switch typ {
+	case format.ProtoBufTypeInt32:
+		return int64(int32(value))
+	case format.ProtoBufTypeInt64, format.ProtoBufTypeSFixed64:
+		return int64(value)
+	case format.ProtoBufTypeUInt32, format.ProtoBufTypeFixed32:
+		return uint64(uint32(value))
+	case format.ProtoBufTypeSInt32, format.ProtoBufTypeSInt64:
+		return mathex.ZigZag[uint64, int64](value)
+	case format.ProtoBufTypeBool:
+		return value != 0
+	case format.ProtoBufTypeSFixed32:
+		return int64(int32(uint32(value)))
+	case format.ProtoBufTypeDouble:
+		return math.Float64frombits(value)
+	case format.ProtoBufTypeFloat:
+		return float64(math.Float32frombits(uint32(value)))
+	default:
+		return value
+	}
+}
+
+// returns wire type of decoded field
+func protobufDecodeField(d *decode.D, pbm *format.ProtoBufMessage) uint64 {
+	var wireType uint64
 	d.FieldStruct("field", func(d *decode.D) {
 		keyN := d.FieldULEB128("key_n")
 		fieldNumber := keyN >> 3
-		wireType := keyN & 0x7
+		wireType = keyN & 0x7
 		d.FieldValueUint("field_number", fieldNumber)
 		d.FieldValueUint("wire_type", wireType, scalar.UintSym(wireTypeNames[wireType]))
 
+		var pbf format.ProtoBufField
+		hasPbf := false
+		if pbm != nil {
+			pbf, hasPbf = (*pbm)[int(fieldNumber)]
+		}
+
 		var value uint64
 		var length uint64
 		var valueStart int64
@@ -55,85 +124,135 @@ func protobufDecodeField(d *decode.D, pbm *format.ProtoBufMessage) {
 		case wireTypeVarint:
 			value = d.FieldULEB128("wire_value")
 		case wireType64Bit:
-			value = d.FieldU64("wire_value")
+			value = d.FieldU64LE("wire_value")
 		case wireTypeLengthDelimited:
 			length = d.FieldULEB128("length")
 			valueStart = d.Pos()
-			d.FieldRawLen("wire_value", int64(length)*8)
-		case wireType32Bit:
-			value = d.FieldU32("wire_value")
-		}
 
-		if pbm != nil {
-			if pbf, ok := (*pbm)[int(fieldNumber)]; ok {
+			packedWireType, isPacked := packedWireType(pbf.Type)
+			if hasPbf && (pbf.Type == format.ProtoBufTypeMessage || isPacked) {
 				d.FieldValueStr("name", pbf.Name)
 				d.FieldValueStr("type", format.ProtoBufTypeNames[uint64(pbf.Type)])
-
-				switch pbf.Type {
-				case format.ProtoBufTypeInt32, format.ProtoBufTypeInt64:
-					v := mathex.ZigZag[uint64, int64](value)
-					d.FieldValueSint("value", v)
-					if len(pbf.Enums) > 0 {
-						d.FieldValueStr("enum", pbf.Enums[uint64(v)])
+				d.FramedFn(int64(length)*8, func(d *decode.D) {
+					if pbf.Type == format.ProtoBufTypeMessage {
+						d.FieldStruct("value", func(d *decode.D) {
+							protobufDecodeFields(d, &pbf.Message, false)
+						})
+						return
 					}
-				case format.ProtoBufTypeUInt32, format.ProtoBufTypeUInt64:
-					d.FieldValueUint("value", value)
-					if len(pbf.Enums) > 0 {
-						d.FieldValueStr("enum", pbf.Enums[value])
-					}
-				case format.ProtoBufTypeSInt32, format.ProtoBufTypeSInt64:
-					// TODO: correct? 32 different?
-					v := mathex.TwosComplement(64, value)
-					d.FieldValueSint("value", v)
-					if len(pbf.Enums) > 0 {
-						d.FieldValueStr("enum", pbf.Enums[uint64(v)])
-					}
-				case format.ProtoBufTypeBool:
-					d.FieldValueBool("value", value != 0)
-				case format.ProtoBufTypeEnum:
-					d.FieldValueStr("enum", pbf.Enums[value])
-				case format.ProtoBufTypeFixed64:
-					// TODO:
-				case format.ProtoBufTypeSFixed64:
-					// TODO:
-				case format.ProtoBufTypeDouble:
-					// TODO:
-				case format.ProtoBufTypeString:
-					d.FieldValueStr("value", string(d.BytesRange(valueStart, int(length))))
-				case format.ProtoBufTypeBytes:
-					d.FieldValueBitBuf("value", bitio.NewBitReader(d.BytesRange(valueStart, int(length)), -1))
-				case format.ProtoBufTypeMessage:
-					// TODO: test
-					d.FramedFn(int64(length)*8, func(d *decode.D) {
-						protobufDecodeFields(d, &pbf.Message)
+					d.FieldArray("values", func(d *decode.D) {
+						for !d.End() {
+							if pbf.Type == format.ProtoBufTypeEnum {
+								d.FieldUintFn("value", func(d *decode.D) uint64 {
+									return readWireValue(d, packedWireType)
+								}, scalar.UintMapSymStr(pbf.Enums))
+								continue
+							}
+							d.FieldAnyFn("value", func(d *decode.D) any {
+								return scalarValue(pbf.Type, readWireValue(d, packedWireType))
+							})
+						}
 					})
-				case format.ProtoBufTypePackedRepeated:
-					// TODO:
-				case format.ProtoBufTypeFixed32:
-					// TODO:
-				case format.ProtoBufTypeSFixed32:
-					// TODO:
-				case format.ProtoBufTypeFloat:
-					// TODO:
+				})
+				return
+			}
+
+			d.FieldRawLen("wire_value", int64(length)*8)
+		case wireTypeStartGroup:
+			// deprecated group, fields until end group
+			if hasPbf && pbf.Type == format.ProtoBufTypeMessage {
+				d.FieldValueStr("name", pbf.Name)
+				d.FieldValueStr("type", format.ProtoBufTypeNames[uint64(pbf.Type)])
+				d.FieldStruct("value", func(d *decode.D) {
+					protobufDecodeFields(d, &pbf.Message, true)
+				})
+			} else {
+				d.FieldStruct("wire_value", func(d *decode.D) {
+					protobufDecodeFields(d, nil, true)
+				})
+			}
+			return
+		case wireTypeEndGroup:
+			return
+		case wireType32Bit:
+			value = d.FieldU32LE("wire_value")
+		}
+
+		if !hasPbf {
+			return
+		}
+
+		d.FieldValueStr("name", pbf.Name)
+		d.FieldValueStr("type", format.ProtoBufTypeNames[uint64(pbf.Type)])
+
+		switch pbf.Type {
+		case format.ProtoBufTypeString:
+			d.FieldValueStr("value", string(d.BytesRange(valueStart, int(length))))
+		case format.ProtoBufTypeBytes:
+			d.FieldValueBitBuf("value", bitio.NewBitReader(d.BytesRange(valueStart, int(length)), -1))
+		case format.ProtoBufTypeEnum:
+			// unknown enum numbers are valid and are kept as is
+			d.FieldValueUint("value", value, scalar.UintMapSymStr(pbf.Enums))
+		default:
+			v := scalarValue(pbf.Type, value)
+			d.FieldValueAny("value", v)
+			if len(pbf.Enums) > 0 {
+				if i, ok := v.(int64); ok {
+					value = uint64(i)
+				}
+				if e, ok := pbf.Enums[value]; ok {
+					d.FieldValueStr("enum", e)
 				}
 			}
 		}
 	})
+
+	return wireType
 }
 
-func protobufDecodeFields(d *decode.D, pbm *format.ProtoBufMessage) {
+// decode fields until end, or until and including end group if inGroup
+func protobufDecodeFields(d *decode.D, pbm *format.ProtoBufMessage, inGroup bool) {
 	d.FieldArray("fields", func(d *decode.D) {
 		for d.BitsLeft() > 0 {
-			protobufDecodeField(d, pbm)
+			if wireType := protobufDecodeField(d, pbm); inGroup && wireType == wireTypeEndGroup {
+				return
+			}
 		}
 	})
 }
 
+// decodeSchema parses descriptor set or proto source, returns nil if there is none
+func decodeSchema(d *decode.D, proto string, descriptorSet string) *protoschema.Schema {
+	var s *protoschema.Schema
+	var err error
+	switch {
+	case descriptorSet != "":
+		s, err = decodeDescriptorSet(d.Ctx, []byte(descriptorSet))
+	case proto != "":
+		s, err = protoschema.ParseProto(proto)
+	default:
+		return nil
+	}
+	if err != nil {
+		d.Fatalf("failed to parse schema: %s", err)
+	}
+	return s
+}
+
 func protobufDecode(d *decode.D) any {
 	var pbi format.Protobuf_In
 	d.ArgAs(&pbi)
 
-	protobufDecodeFields(d, &pbi.Message)
+	pbm := pbi.Message
+	if s := decodeSchema(d, pbi.Proto, pbi.DescriptorSet); s != nil {
+		var err error
+		pbm, err = s.ProtoBufMessage(pbi.MessageName)
+		if err != nil {
+			d.Fatalf("%s", err)
+		}
+	}
+
+	protobufDecodeFields(d, &pbm, false)
 
 	return nil
 }
@@ -0,0 +1,484 @@
+package protoschema
+
+// https://protobuf.dev/reference/protobuf/proto3-spec/
+// https://protobuf.dev/reference/protobuf/proto2-spec/
+
+// TODO: imports, only types in the source itself can be used
+// TODO: extensions
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/wader/fq/format"
+)
+
+type tokenKind int
+
+const (
+	tokenIdent tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenSymbol
+	tokenEOF
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+func isIdentRune(r byte) bool {
+	return r == '_' || r == '.' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	line := 1
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case unicode.IsSpace(rune(c)):
+			i++
+		case strings.HasPrefix(s[i:], "//"):
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end == -1 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(s[i:i+2+end], "\n")
+			i += 2 + end + 2
+		case c == '"' || c == '\'':
+			start := i
+			i++
+			for i < len(s) && s[i] != c {
+				if s[i] == '\\' {
+					i++
+				} else if s[i] == '\n' {
+					return nil, fmt.Errorf("line %d: unterminated string", line)
+				}
+				i++
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			i++
+			tokens = append(tokens, token{kind: tokenString, text: s[start:i], line: line})
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(s) && (isIdentRune(s[i]) ||
+				((s[i] == '+' || s[i] == '-') && (s[i-1] == 'e' || s[i-1] == 'E'))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: s[start:i], line: line})
+		case isIdentRune(c):
+			start := i
+			for i < len(s) && isIdentRune(s[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: s[start:i], line: line})
+		default:
+			tokens = append(tokens, token{kind: tokenSymbol, text: string(c), line: line})
+			i++
+		}
+	}
+	tokens = append(tokens, token{kind: tokenEOF, line: line})
+
+	return tokens, nil
+}
+
+type typeRef struct {
+	message *Message
+	index   int
+	ref     string
+	scope   string
+}
+
+type methodRef struct {
+	service *Service
+	index   int
+	input   string
+	output  string
+	scope   string
+}
+
+type parser struct {
+	tokens     []token
+	pos        int
+	schema     *Schema
+	typeRefs   []typeRef
+	methodRefs []methodRef
+}
+
+type parseError struct {
+	line int
+	msg  string
+}
+
+func (e parseError) Error() string { return fmt.Sprintf("line %d: %s", e.line, e.msg) }
+
+func (p *parser) fatalf(format string, a ...any) {
+	panic(parseError{line: p.peek().line, msg: fmt.Sprintf(format, a...)})
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind == tokenEOF {
+		p.fatalf("unexpected end of input")
+	}
+	p.pos++
+	return t
+}
+
+func (p *parser) accept(s string) bool {
+	if t := p.peek(); t.kind != tokenString && t.text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) {
+	if !p.accept(s) {
+		p.fatalf("expected %q found %q", s, p.peek().text)
+	}
+}
+
+func (p *parser) ident() string {
+	t := p.peek()
+	if t.kind != tokenIdent {
+		p.fatalf("expected identifier found %q", t.text)
+	}
+	p.pos++
+	return t.text
+}
+
+func (p *parser) number() int64 {
+	neg := p.accept("-")
+	t := p.peek()
+	if t.kind != tokenNumber {
+		p.fatalf("expected number found %q", t.text)
+	}
+	p.pos++
+	n, err := strconv.ParseInt(t.text, 0, 64)
+	if err != nil {
+		p.fatalf("invalid number %q", t.text)
+	}
+	if neg {
+		n = -n
+	}
+	return n
+}
+
+// skip tokens until and including ";" or a block
+func (p *parser) skipStatement() {
+	depth := 0
+	for {
+		t := p.next()
+		if t.kind != tokenSymbol {
+			continue
+		}
+		switch t.text {
+		case "{", "[", "(", "<":
+			depth++
+		case "}", "]", ")", ">":
+			depth--
+			if depth == 0 && t.text == "}" {
+				p.accept(";")
+				return
+			}
+		case ";":
+			if depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+// skip field options like [packed = true, default = 1]
+func (p *parser) skipFieldOptions() {
+	if !p.accept("[") {
+		return
+	}
+	depth := 1
+	for depth > 0 {
+		switch p.next().text {
+		case "[":
+			depth++
+		case "]":
+			depth--
+		}
+	}
+}
+
+func (p *parser) parseFile() {
+	pkg := ""
+	for p.peek().kind != tokenEOF {
+		switch t := p.next(); t.text {
+		case "syntax", "edition":
+			p.expect("=")
+			p.next()
+			p.expect(";")
+		case "package":
+			pkg = p.ident()
+			p.expect(";")
+		case "import", "option", "extend":
+			p.skipStatement()
+		case "message":
+			p.parseMessage(pkg, p.ident())
+		case "enum":
+			p.parseEnum(pkg)
+		case "service":
+			p.parseService(pkg)
+		case ";":
+		default:
+			p.pos--
+			p.fatalf("unexpected %q", t.text)
+		}
+	}
+}
+
+// add field, non-scalar types are resolved when all types are known
+func (p *parser) addField(m *Message, name string, number int64, typ string) {
+	f := Field{Name: name, Number: int(number)}
+	if t, ok := scalarTypes[typ]; ok {
+		f.Type = t
+	} else {
+		p.typeRefs = append(p.typeRefs, typeRef{message: m, index: len(m.Fields), ref: typ, scope: m.Name})
+	}
+	m.Fields = append(m.Fields, f)
+}
+
+func (p *parser) parseField(m *Message, typ string) {
+	if typ == "group" {
+		// proto2 group, is a message type and field with lower case name
+		groupName := p.ident()
+		p.expect("=")
+		number := p.number()
+		p.skipFieldOptions()
+		p.parseMessage(m.Name, groupName)
+		p.addField(m, strings.ToLower(groupName), number, groupName)
+		return
+	}
+
+	name := p.ident()
+	p.expect("=")
+	number := p.number()
+	p.skipFieldOptions()
+	p.expect(";")
+	p.addField(m, name, number, typ)
+}
+
+func (p *parser) parseMessage(scope string, name string) {
+	m := &Message{Name: joinName(scope, name)}
+	if _, ok := p.schema.Messages[m.Name]; ok {
+		p.fatalf("message %q redefined", m.Name)
+	}
+	p.schema.Messages[m.Name] = m
+	p.expect("{")
+
+	for !p.accept("}") {
+		switch t := p.next(); {
+		case t.text == "message":
+			p.parseMessage(m.Name, p.ident())
+		case t.text == "enum":
+			p.parseEnum(m.Name)
+		case t.text == "option", t.text == "reserved", t.text == "extensions", t.text == "extend":
+			p.skipStatement()
+		case t.text == ";":
+		case t.text == "oneof":
+			p.ident()
+			p.expect("{")
+			for !p.accept("}") {
+				if p.accept("option") {
+					p.skipStatement()
+					continue
+				}
+				p.parseField(m, p.ident())
+			}
+		case t.text == "map" && p.peek().text == "<":
+			p.expect("<")
+			keyType := p.ident()
+			p.expect(",")
+			valueType := p.ident()
+			p.expect(">")
+			name := p.ident()
+			p.expect("=")
+			number := p.number()
+			p.skipFieldOptions()
+			p.expect(";")
+
+			// map<K, V> is same as repeated NameEntry message with key and value fields
+			entry := &Message{Name: joinName(m.Name, mapEntryName(name))}
+			p.schema.Messages[entry.Name] = entry
+			p.addField(entry, "key", 1, keyType)
+			p.addField(entry, "value", 2, valueType)
+			p.addField(m, name, number, "."+entry.Name)
+		case t.kind == tokenIdent:
+			typ := t.text
+			switch typ {
+			case "optional", "required", "repeated":
+				typ = p.ident()
+			}
+			p.parseField(m, typ)
+		default:
+			p.pos--
+			p.fatalf("unexpected %q", t.text)
+		}
+	}
+}
+
+func mapEntryName(name string) string {
+	var sb strings.Builder
+	upper := true
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		sb.WriteRune(r)
+	}
+	sb.WriteString("Entry")
+	return sb.String()
+}
+
+func (p *parser) parseEnum(scope string) {
+	e := &Enum{Name: joinName(scope, p.ident()), Values: map[uint64]string{}}
+	p.schema.Enums[e.Name] = e
+	p.expect("{")
+	for !p.accept("}") {
+		switch t := p.next(); t.text {
+		case "option", "reserved":
+			p.skipStatement()
+		case ";":
+		default:
+			if t.kind != tokenIdent {
+				p.pos--
+				p.fatalf("unexpected %q", t.text)
+			}
+			p.expect("=")
+			// negative values are encoded as 64 bit two's complement varint
+			v := uint64(p.number())
+			p.skipFieldOptions()
+			p.expect(";")
+			// first name wins for aliases
+			if _, ok := e.Values[v]; !ok {
+				e.Values[v] = t.text
+			}
+		}
+	}
+}
+
+// "stream" can also be a message name
+func (p *parser) acceptStream() bool {
+	if p.peek().text == "stream" && p.tokens[p.pos+1].kind == tokenIdent {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseService(scope string) {
+	s := &Service{Name: joinName(scope, p.ident())}
+	p.schema.Services[s.Name] = s
+	p.expect("{")
+	for !p.accept("}") {
+		switch t := p.next(); t.text {
+		case "option":
+			p.skipStatement()
+		case ";":
+		case "rpc":
+			m := Method{Name: p.ident()}
+			p.expect("(")
+			m.ClientStreaming = p.acceptStream()
+			input := p.ident()
+			p.expect(")")
+			p.expect("returns")
+			p.expect("(")
+			m.ServerStreaming = p.acceptStream()
+			output := p.ident()
+			p.expect(")")
+			if p.peek().text == "{" {
+				p.skipStatement()
+			} else {
+				p.expect(";")
+			}
+			p.methodRefs = append(p.methodRefs, methodRef{service: s, index: len(s.Methods), input: input, output: output, scope: scope})
+			s.Methods = append(s.Methods, m)
+		default:
+			p.pos--
+			p.fatalf("unexpected %q", t.text)
+		}
+	}
+}
+
+func (p *parser) resolve() error {
+	for _, r := range p.typeRefs {
+		f := &r.message.Fields[r.index]
+		name, typ, ok := p.schema.resolve(r.ref, r.scope)
+		if !ok {
+			return fmt.Errorf("field %s.%s has unknown type %q", r.message.Name, f.Name, r.ref)
+		}
+		f.Type = typ
+		f.TypeName = name
+	}
+
+	resolveMessage := func(ref string, scope string) (string, bool) {
+		name, typ, ok := p.schema.resolve(ref, scope)
+		return name, ok && typ == format.ProtoBufTypeMessage
+	}
+	for _, r := range p.methodRefs {
+		m := &r.service.Methods[r.index]
+		var inputOk, outputOk bool
+		m.InputType, inputOk = resolveMessage(r.input, r.scope)
+		m.OutputType, outputOk = resolveMessage(r.output, r.scope)
+		if !inputOk || !outputOk {
+			return fmt.Errorf("method %s.%s has unknown message type", r.service.Name, m.Name)
+		}
+	}
+
+	return nil
+}
+
+// ParseProto parses proto2 or proto3 source
+func ParseProto(src string) (s *Schema, err error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, schema: New()}
+	defer func() {
+		if r := recover(); r != nil {
+			pe, ok := r.(parseError)
+			if !ok {
+				panic(r)
+			}
+			s = nil
+			err = pe
+		}
+	}()
+	p.parseFile()
+
+	if err := p.resolve(); err != nil {
+		return nil, err
+	}
+
+	return p.schema, nil
+}
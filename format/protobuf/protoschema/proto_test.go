@@ -0,0 +1,141 @@
+package protoschema_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/format/protobuf/protoschema"
+)
+
+const testProto = `
+syntax = "proto3";
+package a.b;
+
+option go_package = "test";
+
+enum E {
+  E_UNSPECIFIED = 0;
+  E_NEG = -1;
+}
+
+message Outer {
+  message Inner {
+    E e = 1;
+    Outer outer = 2 [deprecated = true];
+  }
+  Inner inner = 1;
+  map<string, Inner> inner_by_name = 2;
+  oneof o {
+    string s = 3;
+    .a.b.Outer.Inner other = 4;
+  }
+  reserved 5 to 10;
+}
+
+service S {
+  rpc Call(stream Outer) returns (Outer.Inner) {}
+}
+`
+
+func TestParseProto(t *testing.T) {
+	s, err := protoschema.ParseProto(testProto)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedMessages := map[string]*protoschema.Message{
+		"a.b.Outer": {Name: "a.b.Outer", Fields: []protoschema.Field{
+			{Name: "inner", Number: 1, Type: format.ProtoBufTypeMessage, TypeName: "a.b.Outer.Inner"},
+			{Name: "inner_by_name", Number: 2, Type: format.ProtoBufTypeMessage, TypeName: "a.b.Outer.InnerByNameEntry"},
+			{Name: "s", Number: 3, Type: format.ProtoBufTypeString},
+			{Name: "other", Number: 4, Type: format.ProtoBufTypeMessage, TypeName: "a.b.Outer.Inner"},
+		}},
+		"a.b.Outer.Inner": {Name: "a.b.Outer.Inner", Fields: []protoschema.Field{
+			{Name: "e", Number: 1, Type: format.ProtoBufTypeEnum, TypeName: "a.b.E"},
+			{Name: "outer", Number: 2, Type: format.ProtoBufTypeMessage, TypeName: "a.b.Outer"},
+		}},
+		"a.b.Outer.InnerByNameEntry": {Name: "a.b.Outer.InnerByNameEntry", Fields: []protoschema.Field{
+			{Name: "key", Number: 1, Type: format.ProtoBufTypeString},
+			{Name: "value", Number: 2, Type: format.ProtoBufTypeMessage, TypeName: "a.b.Outer.Inner"},
+		}},
+	}
+	if !reflect.DeepEqual(expectedMessages, s.Messages) {
+		t.Errorf("expected %+#v, got %+#v", expectedMessages, s.Messages)
+	}
+
+	expectedEnums := map[string]*protoschema.Enum{
+		"a.b.E": {Name: "a.b.E", Values: map[uint64]string{0: "E_UNSPECIFIED", 0xffff_ffff_ffff_ffff: "E_NEG"}},
+	}
+	if !reflect.DeepEqual(expectedEnums, s.Enums) {
+		t.Errorf("expected %+#v, got %+#v", expectedEnums, s.Enums)
+	}
+
+	expectedServices := map[string]*protoschema.Service{
+		"a.b.S": {Name: "a.b.S", Methods: []protoschema.Method{
+			{Name: "Call", InputType: "a.b.Outer", OutputType: "a.b.Outer.Inner", ClientStreaming: true},
+		}},
+	}
+	if !reflect.DeepEqual(expectedServices, s.Services) {
+		t.Errorf("expected %+#v, got %+#v", expectedServices, s.Services)
+	}
+
+	pbm, err := s.ProtoBufMessage("Inner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// recursive messages share the same map
+	if reflect.ValueOf(pbm).Pointer() != reflect.ValueOf(pbm[2].Message[1].Message).Pointer() {
+		t.Errorf("expected recursive message to be same map")
+	}
+}
+
+func TestParseProtoErr(t *testing.T) {
+	testCases := []struct {
+		src      string
+		expected string
+	}{
+		{`message A { B b = 1; }`, `field A.b has unknown type "B"`},
+		{`message A { int32 a = ; }`, `line 1: expected number found ";"`},
+		{"message A {\n int32 a = 1;\n", `line 3: unexpected end of input`},
+		{`message A {} message A {}`, `line 1: message "A" redefined`},
+		{`/* comment`, `line 1: unterminated comment`},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.src, func(t *testing.T) {
+			_, err := protoschema.ParseProto(tC.src)
+			if err == nil || err.Error() != tC.expected {
+				t.Errorf("expected error %q, got %v", tC.expected, err)
+			}
+		})
+	}
+}
+
+func TestProtoBufMessageName(t *testing.T) {
+	s, err := protoschema.ParseProto(`package p; message A { message C {} } message B { message C {} }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		name     string
+		expected string
+	}{
+		{"", "schema has 2 top level messages, a message name is required"},
+		{"C", `message "C" is ambiguous: p.A.C, p.B.C`},
+		{"D", `message "D" not found`},
+		{"A.C", ""},
+		{".p.B.C", ""},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			_, err := s.ProtoBufMessage(tC.name)
+			actual := ""
+			if err != nil {
+				actual = err.Error()
+			}
+			if actual != tC.expected {
+				t.Errorf("expected %q, got %q", tC.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// Package protoschema has a protobuf schema model that can be built from proto source
+// and turned into format.ProtoBufMessage trees used by the protobuf decoder
+package protoschema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/wader/fq/format"
+)
+
+type Field struct {
+	Name     string
+	Number   int
+	Type     int    // format.ProtoBufType*
+	TypeName string // full name of message or enum type
+}
+
+type Message struct {
+	Name   string // full name, ex: package.Outer.Inner
+	Fields []Field
+}
+
+type Enum struct {
+	Name   string
+	Values map[uint64]string
+}
+
+type Method struct {
+	Name            string
+	InputType       string
+	OutputType      string
+	ClientStreaming bool
+	ServerStreaming bool
+}
+
+type Service struct {
+	Name    string
+	Methods []Method
+}
+
+type Schema struct {
+	Messages map[string]*Message
+	Enums    map[string]*Enum
+	Services map[string]*Service
+}
+
+func New() *Schema {
+	return &Schema{
+		Messages: map[string]*Message{},
+		Enums:    map[string]*Enum{},
+		Services: map[string]*Service{},
+	}
+}
+
+var scalarTypes = map[string]int{
+	"double":   format.ProtoBufTypeDouble,
+	"float":    format.ProtoBufTypeFloat,
+	"int32":    format.ProtoBufTypeInt32,
+	"int64":    format.ProtoBufTypeInt64,
+	"uint32":   format.ProtoBufTypeUInt32,
+	"uint64":   format.ProtoBufTypeUInt64,
+	"sint32":   format.ProtoBufTypeSInt32,
+	"sint64":   format.ProtoBufTypeSInt64,
+	"fixed32":  format.ProtoBufTypeFixed32,
+	"fixed64":  format.ProtoBufTypeFixed64,
+	"sfixed32": format.ProtoBufTypeSFixed32,
+	"sfixed64": format.ProtoBufTypeSFixed64,
+	"bool":     format.ProtoBufTypeBool,
+	"string":   format.ProtoBufTypeString,
+	"bytes":    format.ProtoBufTypeBytes,
+}
+
+// resolve type reference relative to scope using innermost scope first
+func (s *Schema) resolve(ref string, scope string) (string, int, bool) {
+	lookup := func(name string) (string, int, bool) {
+		if _, ok := s.Messages[name]; ok {
+			return name, format.ProtoBufTypeMessage, true
+		}
+		if _, ok := s.Enums[name]; ok {
+			return name, format.ProtoBufTypeEnum, true
+		}
+		return "", 0, false
+	}
+
+	if strings.HasPrefix(ref, ".") {
+		return lookup(ref[1:])
+	}
+	for {
+		if name, typ, ok := lookup(joinName(scope, ref)); ok {
+			return name, typ, true
+		}
+		if scope == "" {
+			return "", 0, false
+		}
+		scope = parentName(scope)
+	}
+}
+
+// FindName finds full name of message, service etc using full or unique partial name
+func FindName[T any](m map[string]T, name string) (string, error) {
+	name = strings.TrimPrefix(name, ".")
+	if _, ok := m[name]; ok {
+		return name, nil
+	}
+	var found []string
+	for n := range m {
+		if strings.HasSuffix(n, "."+name) {
+			found = append(found, n)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("%q not found", name)
+	case 1:
+		return found[0], nil
+	default:
+		sort.Strings(found)
+		return "", fmt.Errorf("%q is ambiguous: %s", name, strings.Join(found, ", "))
+	}
+}
+
+// ProtoBufMessage returns message tree for message with name. Empty name is allowed if
+// there is only one message that is not nested.
+func (s *Schema) ProtoBufMessage(name string) (format.ProtoBufMessage, error) {
+	if name == "" {
+		var topLevel []string
+		for n := range s.Messages {
+			if _, ok := s.Messages[parentName(n)]; !ok {
+				topLevel = append(topLevel, n)
+			}
+		}
+		if len(topLevel) != 1 {
+			return nil, fmt.Errorf("schema has %d top level messages, a message name is required", len(topLevel))
+		}
+		name = topLevel[0]
+	}
+
+	fullName, err := FindName(s.Messages, name)
+	if err != nil {
+		return nil, fmt.Errorf("message %w", err)
+	}
+
+	return s.protoBufMessage(fullName, map[string]format.ProtoBufMessage{}), nil
+}
+
+// Method finds method using a path like /package.Service/Method, Service/Method or Service.Method
+func (s *Schema) Method(path string) (Method, error) {
+	path = strings.TrimPrefix(path, "/")
+	i := strings.LastIndexAny(path, "/.")
+	if i == -1 {
+		return Method{}, fmt.Errorf("method %q has no service", path)
+	}
+	serviceName, methodName := path[0:i], path[i+1:]
+
+	fullName, err := FindName(s.Services, serviceName)
+	if err != nil {
+		return Method{}, fmt.Errorf("service %w", err)
+	}
+	for _, m := range s.Services[fullName].Methods {
+		if m.Name == methodName {
+			return m, nil
+		}
+	}
+
+	return Method{}, fmt.Errorf("method %q not found in service %q", methodName, fullName)
+}
+
+// messages are shared by name so recursive messages end up as recursive maps
+func (s *Schema) protoBufMessage(name string, seen map[string]format.ProtoBufMessage) format.ProtoBufMessage {
+	if pbm, ok := seen[name]; ok {
+		return pbm
+	}
+	pbm := format.ProtoBufMessage{}
+	seen[name] = pbm
+
+	for _, f := range s.Messages[name].Fields {
+		pbf := format.ProtoBufField{Type: f.Type, Name: f.Name}
+		switch f.Type {
+		case format.ProtoBufTypeMessage:
+			pbf.Message = s.protoBufMessage(f.TypeName, seen)
+		case format.ProtoBufTypeEnum:
+			pbf.Enums = s.Enums[f.TypeName].Values
+		}
+		pbm[f.Number] = pbf
+	}
+
+	return pbm
+}
+
+func joinName(scope string, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+func parentName(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i != -1 {
+		return name[0:i]
+	}
+	return ""
+}
@@ -0,0 +1,137 @@
+package snappy
+
+// https://github.com/google/snappy/blob/main/framing_format.txt
+
+import (
+	"embed"
+	"hash/crc32"
+
+	"github.com/golang/snappy"
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed snappy.md
+var snappyFS embed.FS
+
+var probeGroup decode.Group
+
+func init() {
+	interp.RegisterFormat(
+		format.Snappy,
+		&decode.Format{
+			Description: "Snappy framing format",
+			Groups:      []*decode.Group{format.Probe},
+			DecodeFn:    snappyDecode,
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.Probe}, Out: &probeGroup},
+			},
+		})
+	interp.RegisterFS(snappyFS)
+}
+
+const (
+	chunkCompressedData   = 0x00
+	chunkUncompressedData = 0x01
+	chunkPadding          = 0xfe
+	chunkStreamIdentifier = 0xff
+)
+
+var chunkTypeNames = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	switch {
+	case s.Actual == chunkCompressedData:
+		s.Sym = "compressed_data"
+	case s.Actual == chunkUncompressedData:
+		s.Sym = "uncompressed_data"
+	case s.Actual == chunkPadding:
+		s.Sym = "padding"
+	case s.Actual == chunkStreamIdentifier:
+		s.Sym = "stream_identifier"
+	case s.Actual <= 0x7f:
+		s.Sym = "reserved_unskippable"
+	default:
+		s.Sym = "reserved_skippable"
+	}
+	return s, nil
+})
+
+const streamIdentifier = "sNaPpY"
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksums are masked as checksumming data containing checksums is problematic
+func maskedCRC32C(b []byte) uint32 {
+	c := crc32.Checksum(b, crc32cTable)
+	return (c>>15 | c<<17) + 0xa282_ead8
+}
+
+func snappyDecode(d *decode.D) any {
+	d.Endian = decode.LittleEndian
+
+	var uncompressed []byte
+	chunks := 0
+	ok := true
+
+	d.FieldArray("chunks", func(d *decode.D) {
+		for chunks == 0 || !d.End() {
+			d.FieldStruct("chunk", func(d *decode.D) {
+				var chunkType uint64
+				if chunks == 0 {
+					// stream has to start with a stream identifier
+					chunkType = d.FieldU8("type", d.UintAssert(chunkStreamIdentifier), chunkTypeNames, scalar.UintHex)
+				} else {
+					chunkType = d.FieldU8("type", chunkTypeNames, scalar.UintHex)
+				}
+				length := d.FieldU24("length")
+
+				d.FramedFn(int64(length)*8, func(d *decode.D) {
+					switch chunkType {
+					case chunkStreamIdentifier:
+						d.FieldUTF8("identifier", len(streamIdentifier), d.StrAssert(streamIdentifier))
+					case chunkCompressedData, chunkUncompressedData:
+						if length < 4 {
+							d.Fatalf("chunk length %d shorter than checksum", length)
+						}
+						// checksum is of uncompressed data
+						data := d.BytesRange(d.Pos()+32, int(d.BitsLeft()/8)-4)
+						if chunkType == chunkCompressedData {
+							var err error
+							if data, err = snappy.Decode(nil, data); err != nil {
+								data = nil
+								ok = false
+							}
+						}
+						if data != nil {
+							d.FieldU32("checksum", d.UintValidate(uint64(maskedCRC32C(data))), scalar.UintHex)
+							uncompressed = append(uncompressed, data...)
+						} else {
+							d.FieldU32("checksum", scalar.UintHex)
+						}
+						if chunkType == chunkCompressedData {
+							d.FieldRawLen("compressed", d.BitsLeft())
+						} else {
+							d.FieldRawLen("data", d.BitsLeft())
+						}
+					case chunkPadding:
+						d.FieldRawLen("padding", d.BitsLeft())
+					default:
+						d.FieldRawLen("data", d.BitsLeft())
+					}
+				})
+			})
+			chunks++
+		}
+	})
+
+	if ok && len(uncompressed) > 0 {
+		uncompressedBR := bitio.NewBitReader(uncompressed, -1)
+		if dv, _, _ := d.TryFieldFormatBitBuf("uncompressed", uncompressedBR, &probeGroup, format.Probe_In{}); dv == nil {
+			d.FieldRootBitBuf("uncompressed", uncompressedBR)
+		}
+	}
+
+	return nil
+}
@@ -1,10 +1,14 @@
 package tar
 
 // https://www.gnu.org/software/tar/manual/html_node/Standard.html
-// TODO: extensions?
+// https://pubs.opengroup.org/onlinepubs/9699919799/utilities/pax.html#tag_20_92_13_03
+// https://www.gnu.org/software/tar/manual/html_node/Sparse-Formats.html
 
 import (
 	"bytes"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/wader/fq/format"
@@ -28,13 +32,261 @@ func init() {
 		})
 }
 
+const (
+	blockBytes = 512
+	blockBits  = blockBytes * 8
+)
+
+const (
+	typeFlagGNUSparse        = "S"
+	typeFlagGNULongName      = "L"
+	typeFlagGNULongLinkName  = "K"
+	typeFlagPAXExtended      = "x"
+	typeFlagPAXGlobal        = "g"
+	gnuMagic                 = "ustar  \x00"
+	gnuSparseHeaderEntries   = 4
+	gnuSparseExtendedEntries = 21
+)
+
+var typeFlagNames = scalar.StrMapDescription{
+	"0":                     "Regular file",
+	"1":                     "Hard link",
+	"2":                     "Symbolic link",
+	"3":                     "Character device",
+	"4":                     "Block device",
+	"5":                     "Directory",
+	"6":                     "FIFO",
+	"7":                     "Contiguous file",
+	typeFlagPAXExtended:     "PAX extended header",
+	typeFlagPAXGlobal:       "PAX global extended header",
+	typeFlagGNULongName:     "GNU long name",
+	typeFlagGNULongLinkName: "GNU long link name",
+	typeFlagGNUSparse:       "GNU sparse file",
+	"D":                     "GNU directory dump",
+	"M":                     "GNU multi-volume continuation",
+	"V":                     "GNU volume header",
+}
+
 var unixTimeEpochDate = time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
 
-func tarDecode(d *decode.D) any {
-	const blockBytes = 512
-	const blockBits = blockBytes * 8
+var mapTrimSpaceNull = scalar.StrActualTrim(" \x00")
+
+// TODO: string might not be a number, move to scalar?
+var mapStrUnixTimeDescription = scalar.StrFn(func(s scalar.Str) (scalar.Str, error) {
+	if v, ok := s.TrySymUint(); ok {
+		s.Description = unixTimeEpochDate.Add(time.Duration(v) * time.Second).Format(time.RFC3339)
+	} else if v, ok := s.Sym.(float64); ok {
+		sec, frac := math.Modf(v)
+		s.Description = unixTimeEpochDate.Add(time.Duration(sec)*time.Second + time.Duration(frac*float64(time.Second))).Format(time.RFC3339Nano)
+	}
+	return s, nil
+})
+
+func fieldNumberFn(d *decode.D, name string, nBytes int, strSms []scalar.StrMapper, uintSms []scalar.UintMapper) (uint64, bool) {
+	switch d.PeekUintBits(2) {
+	case 0b11:
+		// negative base-256 is two's complement including the marker bit, not valid as uint
+		d.FieldSintFn(name, func(d *decode.D) int64 {
+			nBits := nBytes * 8
+			if nBits > 64 {
+				// assume value fits in 64 bit
+				d.U(nBits - 64)
+				nBits = 64
+			}
+			return d.S(nBits)
+		}, scalar.SintDescription("base-256"))
+		return 0, false
+	case 0b10:
+		return d.FieldUintFn(name, func(d *decode.D) uint64 {
+			d.U1() // base-256 marker
+			nBits := nBytes*8 - 1
+			if nBits > 64 {
+				// assume value fits in 64 bit
+				d.U(nBits - 64)
+				nBits = 64
+			}
+			return d.U(nBits)
+		}, uintSms...), true
+	}
+
+	return d.FieldScalarUTF8NullFixedLen(name, nBytes, append([]scalar.StrMapper{scalar.TryStrSymParseUint(8)}, strSms...)...).TrySymUint()
+}
+
+// fieldNumber decodes a octal number or if the high bit of the first byte is set a GNU base-256 number
+// negative base-256 numbers are decoded as signed and returns false
+func fieldNumber(d *decode.D, name string, nBytes int) (uint64, bool) {
+	return fieldNumberFn(d, name, nBytes, nil, []scalar.UintMapper{scalar.UintDescription("base-256")})
+}
+
+// fieldTime decodes a octal or base-256 unix time
+func fieldTime(d *decode.D, name string) (uint64, bool) {
+	return fieldNumberFn(d, name, 12, []scalar.StrMapper{mapStrUnixTimeDescription}, []scalar.UintMapper{scalar.UintActualUnixTime(time.RFC3339)})
+}
+
+type paxRecord struct {
+	key   string
+	value string
+}
+
+type paxRecords []paxRecord
+
+func (rs paxRecords) get(key string) (string, bool) {
+	for _, r := range rs {
+		if r.key == key {
+			return r.value, true
+		}
+	}
+	return "", false
+}
 
-	mapTrimSpaceNull := scalar.StrActualTrim(" \x00")
+// merge returns records with records in o replacing or appended
+func (rs paxRecords) merge(o paxRecords) paxRecords {
+	var m paxRecords
+	m = append(m, rs...)
+	for _, r := range o {
+		found := false
+		for i := range m {
+			if m[i].key == r.key {
+				m[i].value = r.value
+				found = true
+				break
+			}
+		}
+		if !found {
+			m = append(m, r)
+		}
+	}
+	return m
+}
+
+func decodePAXRecords(d *decode.D) paxRecords {
+	var rs paxRecords
+	d.FieldArray("records", func(d *decode.D) {
+		for d.BitsLeft() >= 8 {
+			// "%d %s=%s\n", length includes itself and the newline
+			peekLen := int(d.BitsLeft() / 8)
+			if peekLen > 32 {
+				peekLen = 32
+			}
+			spaceIndex := bytes.IndexByte(d.PeekBytes(peekLen), ' ')
+			if spaceIndex <= 0 {
+				break
+			}
+			recordLen, err := strconv.Atoi(string(d.PeekBytes(spaceIndex)))
+			if err != nil || recordLen <= spaceIndex+1 || int64(recordLen)*8 > d.BitsLeft() {
+				break
+			}
+			record := d.PeekBytes(recordLen)
+			keyValue := record[spaceIndex+1:]
+			equalIndex := bytes.IndexByte(keyValue, '=')
+			if equalIndex < 0 {
+				break
+			}
+
+			d.FieldStruct("record", func(d *decode.D) {
+				d.FieldUTF8("length", spaceIndex+1, scalar.ActualTrimSpace, scalar.TryStrSymParseUint(10))
+				key := d.FieldUTF8("key", equalIndex+1, scalar.StrActualFn(func(s string) string { return strings.TrimSuffix(s, "=") }))
+				value := d.FieldUTF8("value", len(keyValue)-equalIndex-1, scalar.StrActualFn(func(s string) string { return strings.TrimSuffix(s, "\n") }))
+				rs = append(rs, paxRecord{key: key, value: value})
+			})
+		}
+	})
+	if d.BitsLeft() > 0 {
+		d.FieldRawLen("unknown", d.BitsLeft())
+	}
+
+	return rs
+}
+
+const paxXattrPrefix = "SCHILY.xattr."
+
+// decodePAXOverrides adds synthetic fields for records that overrides or extends the header
+func decodePAXOverrides(d *decode.D, rs paxRecords) {
+	var known paxRecords
+	var xattrs paxRecords
+	for _, r := range rs {
+		switch {
+		case strings.HasPrefix(r.key, paxXattrPrefix):
+			xattrs = append(xattrs, r)
+		case r.key == "path", r.key == "linkpath",
+			r.key == "uname", r.key == "gname",
+			r.key == "size", r.key == "uid", r.key == "gid",
+			r.key == "mtime", r.key == "atime", r.key == "ctime",
+			r.key == "comment", r.key == "charset", r.key == "hdrcharset":
+			known = append(known, r)
+		}
+	}
+	if len(known) == 0 && len(xattrs) == 0 {
+		return
+	}
+
+	d.FieldStruct("pax", func(d *decode.D) {
+		for _, r := range known {
+			switch r.key {
+			case "size", "uid", "gid":
+				d.FieldValueStr(r.key, r.value, scalar.TryStrSymParseUint(10))
+			case "mtime", "atime", "ctime":
+				d.FieldValueStr(r.key, r.value, scalar.TryStrSymParseFloat(64), mapStrUnixTimeDescription)
+			default:
+				d.FieldValueStr(r.key, r.value)
+			}
+		}
+		if len(xattrs) > 0 {
+			d.FieldArray("xattrs", func(d *decode.D) {
+				for _, r := range xattrs {
+					d.FieldStruct("xattr", func(d *decode.D) {
+						d.FieldValueStr("name", strings.TrimPrefix(r.key, paxXattrPrefix))
+						d.FieldValueStr("value", r.value)
+					})
+				}
+			})
+		}
+	})
+}
+
+func decodeSparseEntries(d *decode.D, n int) {
+	d.FieldArray("sparse", func(d *decode.D) {
+		for i := 0; i < n; i++ {
+			d.FieldStruct("entry", func(d *decode.D) {
+				fieldNumber(d, "offset", 12)
+				fieldNumber(d, "numbytes", 12)
+			})
+		}
+	})
+}
+
+// decodeSparseMap decodes PAX sparse format 1.0 map stored as decimal numbers at start of data
+func decodeSparseMap(d *decode.D) {
+	fieldDecimalLine := func(d *decode.D, name string) (uint64, bool) {
+		peekLen := int(d.BitsLeft() / 8)
+		if peekLen > 32 {
+			peekLen = 32
+		}
+		newlineIndex := bytes.IndexByte(d.PeekBytes(peekLen), '\n')
+		if newlineIndex < 0 {
+			d.Fatalf("sparse map number not found")
+		}
+		return d.FieldScalarUTF8(name, newlineIndex+1, scalar.ActualTrimSpace, scalar.TryStrSymParseUint(10)).TrySymUint()
+	}
+
+	d.FieldStruct("sparse_map", func(d *decode.D) {
+		count, ok := fieldDecimalLine(d, "count")
+		if !ok {
+			d.Fatalf("invalid sparse map count")
+		}
+		d.FieldArray("entries", func(d *decode.D) {
+			for i := uint64(0); i < count; i++ {
+				d.FieldStruct("entry", func(d *decode.D) {
+					fieldDecimalLine(d, "offset")
+					fieldDecimalLine(d, "numbytes")
+				})
+			}
+		})
+		d.FieldRawLen("padding", (blockBits-(d.Pos()%blockBits))%blockBits, d.BitBufIsZero())
+	})
+}
+
+func tarDecode(d *decode.D) any {
 	blockPadding := func(d *decode.D) int64 {
 		return (blockBits - (d.Pos() % blockBits)) % blockBits
 	}
@@ -45,38 +297,145 @@ func tarDecode(d *decode.D) any {
 	var endMarkerEnd int64
 	filesCount := 0
 
+	// extended header state that applies to following entries
+	var globalRecords paxRecords
+	var nextRecords paxRecords
+	var nextLongName string
+	var nextLongLinkName string
+
 	d.FieldArray("files", func(d *decode.D) {
 		for !d.End() {
 			d.FieldStruct("file", func(d *decode.D) {
-				d.FieldUTF8("name", 100, mapTrimSpaceNull)
+				name := d.FieldUTF8("name", 100, mapTrimSpaceNull)
 				d.FieldUTF8NullFixedLen("mode", 8, scalar.TryStrSymParseUint(8))
-				d.FieldUTF8NullFixedLen("uid", 8, scalar.TryStrSymParseUint(8))
-				d.FieldUTF8NullFixedLen("gid", 8, scalar.TryStrSymParseUint(8))
-				size, sizeOk := d.FieldScalarUTF8NullFixedLen("size", 12, scalar.TryStrSymParseUint(8)).TrySymUint()
+				fieldNumber(d, "uid", 8)
+				fieldNumber(d, "gid", 8)
+				size, sizeOk := fieldNumber(d, "size", 12)
 				if !sizeOk {
 					d.Fatalf("could not decode size")
 				}
-				size *= 8
-				d.FieldUTF8NullFixedLen("mtime", 12, scalar.TryStrSymParseUint(8), scalar.StrFn(func(s scalar.Str) (scalar.Str, error) {
-					// TODO: string might not be a number, move to scalar?
-					if v, ok := s.TrySymUint(); ok {
-						s.Description = unixTimeEpochDate.Add(time.Duration(v) * time.Second).Format(time.RFC3339)
-					}
-					return s, nil
-				}))
+				fieldTime(d, "mtime")
 				d.FieldUTF8NullFixedLen("chksum", 8, scalar.TryStrSymParseUint(8))
-				d.FieldUTF8("typeflag", 1, mapTrimSpaceNull)
-				d.FieldUTF8("linkname", 100, mapTrimSpaceNull)
+				typeFlag := d.FieldUTF8("typeflag", 1, mapTrimSpaceNull, typeFlagNames)
+				linkName := d.FieldUTF8("linkname", 100, mapTrimSpaceNull)
+				isGNU := bytes.Equal(d.PeekBytes(len(gnuMagic)), []byte(gnuMagic))
 				d.FieldUTF8("magic", 6, mapTrimSpaceNull, d.StrAssert("ustar"))
 				d.FieldUTF8NullFixedLen("version", 2, scalar.TryStrSymParseUint(8))
 				d.FieldUTF8("uname", 32, mapTrimSpaceNull)
 				d.FieldUTF8("gname", 32, mapTrimSpaceNull)
-				d.FieldUTF8NullFixedLen("devmajor", 8, scalar.TryStrSymParseUint(8))
-				d.FieldUTF8NullFixedLen("devminor", 8, scalar.TryStrSymParseUint(8))
-				d.FieldUTF8("prefix", 155, mapTrimSpaceNull)
+				fieldNumber(d, "devmajor", 8)
+				fieldNumber(d, "devminor", 8)
+
+				path := name
+				gnuSparseExtended := false
+				if isGNU {
+					// old GNU format uses prefix area for times and sparse map
+					fieldTime(d, "atime")
+					fieldTime(d, "ctime")
+					fieldNumber(d, "offset", 12)
+					d.FieldRawLen("longnames", 4*8)
+					d.FieldRawLen("unused", 1*8)
+					decodeSparseEntries(d, gnuSparseHeaderEntries)
+					gnuSparseExtended = d.FieldU8("is_extended") != 0
+					fieldNumber(d, "real_size", 12)
+				} else {
+					prefix := d.FieldUTF8("prefix", 155, mapTrimSpaceNull)
+					if prefix != "" {
+						path = prefix + "/" + name
+					}
+				}
 				d.FieldRawLen("header_block_padding", blockPadding(d), d.BitBufIsZero())
 
-				d.FieldFormatOrRawLen("data", int64(size), &probeGroup, format.Probe_In{})
+				if typeFlag == typeFlagGNUSparse && gnuSparseExtended {
+					d.FieldArray("sparse_headers", func(d *decode.D) {
+						for gnuSparseExtended {
+							d.FieldStruct("sparse_header", func(d *decode.D) {
+								decodeSparseEntries(d, gnuSparseExtendedEntries)
+								gnuSparseExtended = d.FieldU8("is_extended") != 0
+								d.FieldRawLen("padding", blockPadding(d), d.BitBufIsZero())
+							})
+						}
+					})
+				}
+
+				var records paxRecords
+				switch typeFlag {
+				case typeFlagPAXExtended, typeFlagPAXGlobal,
+					typeFlagGNULongName, typeFlagGNULongLinkName:
+					// meta entries applies to following entries
+				default:
+					records = globalRecords.merge(nextRecords)
+					if nextLongName != "" {
+						path = nextLongName
+					}
+					if v, ok := records.get("GNU.sparse.name"); ok {
+						path = v
+					}
+					if v, ok := records.get("path"); ok {
+						path = v
+					}
+					d.FieldValueStr("path", path)
+
+					linkPath := linkName
+					if nextLongLinkName != "" {
+						linkPath = nextLongLinkName
+					}
+					if v, ok := records.get("linkpath"); ok {
+						linkPath = v
+					}
+					if linkPath != linkName {
+						d.FieldValueStr("linkpath", linkPath)
+					}
+
+					if v, ok := records.get("size"); ok {
+						if n, err := strconv.ParseUint(v, 10, 64); err == nil {
+							size = n
+						}
+					}
+					decodePAXOverrides(d, records)
+
+					nextRecords = nil
+					nextLongName = ""
+					nextLongLinkName = ""
+				}
+
+				// compare in bytes as size*8 can overflow
+				if size > uint64(d.BitsLeft()/8) {
+					d.Fatalf("size %d larger than remaining data", size)
+				}
+				dataLen := int64(size) * 8
+				switch typeFlag {
+				case typeFlagPAXExtended:
+					d.FieldStruct("data", func(d *decode.D) {
+						d.FramedFn(dataLen, func(d *decode.D) {
+							nextRecords = decodePAXRecords(d)
+						})
+					})
+				case typeFlagPAXGlobal:
+					d.FieldStruct("data", func(d *decode.D) {
+						d.FramedFn(dataLen, func(d *decode.D) {
+							globalRecords = globalRecords.merge(decodePAXRecords(d))
+						})
+					})
+				case typeFlagGNULongName:
+					nextLongName = d.FieldUTF8NullFixedLen("long_name", int(size))
+				case typeFlagGNULongLinkName:
+					nextLongLinkName = d.FieldUTF8NullFixedLen("long_linkname", int(size))
+				case typeFlagGNUSparse:
+					// data is the non-hole segments stored after each other
+					d.FieldRawLen("data", dataLen)
+				default:
+					if v, ok := records.get("GNU.sparse.major"); ok && v == "1" {
+						d.FieldStruct("data", func(d *decode.D) {
+							d.FramedFn(dataLen, func(d *decode.D) {
+								decodeSparseMap(d)
+								d.FieldRawLen("data", d.BitsLeft())
+							})
+						})
+					} else {
+						d.FieldFormatOrRawLen("data", dataLen, &probeGroup, format.Probe_In{})
+					}
+				}
 
 				d.FieldRawLen("data_block_padding", blockPadding(d), d.BitBufIsZero())
 			})
@@ -6,9 +6,8 @@ package tls
 //
 // TODO: key exchange alg, decode key exchange parameters
 // TODO: renegotiation, client/server hello again etc, uses current cipher state, keep track of key change
-// TODO: tls 1.3, ssl? combine or own format?
-// TODO: ALPN
-// TODO: pcapng keylog
+// TODO: ssl? combine or own format?
+// TODO: tls 1.3 early data, 0-RTT and pre shared keys
 // TODO: add fields for seq, calculated things? prf result and decode key/iv?
 // TODO: warnings to stderr decode api support?
 //
@@ -40,6 +39,8 @@ import (
 var tlsFS embed.FS
 
 var asn1BerGroup decode.Group
+var httpGroup decode.Group
+var http2Group decode.Group
 
 func init() {
 	interp.RegisterFormat(
@@ -51,6 +52,8 @@ func init() {
 			DefaultInArg: format.TLS_In{},
 			Dependencies: []decode.Dependency{
 				{Groups: []*decode.Group{format.ASN1_BER}, Out: &asn1BerGroup},
+				{Groups: []*decode.Group{format.HTTP}, Out: &httpGroup},
+				{Groups: []*decode.Group{format.HTTP2}, Out: &http2Group},
 			},
 		})
 	interp.RegisterFS(tlsFS)
@@ -106,6 +109,8 @@ const (
 	handshakeMsgTypeClientHello        = 1
 	handshakeMsgTypeServerHello        = 2
 	handshakeMsgTypeNewSessionTicket   = 4
+	handshakeMsgTypeEndOfEarlyData     = 5
+	handshakeMsgTypeEncryptedExtension = 8
 	handshakeMsgTypeCertificate        = 11
 	handshakeMsgTypeServerKeyExchange  = 12
 	handshakeMsgTypeCertificateRequest = 13
@@ -113,6 +118,7 @@ const (
 	handshakeMsgTypeCertificateVerify  = 15
 	handshakeMsgTypeClientKeyExchange  = 16
 	handshakeMsgTypeFinished           = 20
+	handshakeMsgTypeKeyUpdate          = 24
 )
 
 var handshakeMsgTypeNames = scalar.UintMapSymStr{
@@ -120,6 +126,8 @@ var handshakeMsgTypeNames = scalar.UintMapSymStr{
 	handshakeMsgTypeClientHello:        "client_hello",
 	handshakeMsgTypeServerHello:        "server_hello",
 	handshakeMsgTypeNewSessionTicket:   "new_session_ticket",
+	handshakeMsgTypeEndOfEarlyData:     "end_of_early_data",
+	handshakeMsgTypeEncryptedExtension: "encrypted_extensions",
 	handshakeMsgTypeCertificate:        "certificate",
 	handshakeMsgTypeServerKeyExchange:  "server_key_exchange",
 	handshakeMsgTypeCertificateRequest: "certificate_request",
@@ -127,6 +135,18 @@ var handshakeMsgTypeNames = scalar.UintMapSymStr{
 	handshakeMsgTypeCertificateVerify:  "certificate_verify",
 	handshakeMsgTypeClientKeyExchange:  "client_key_exchange",
 	handshakeMsgTypeFinished:           "finished",
+	handshakeMsgTypeKeyUpdate:          "key_update",
+}
+
+var keyUpdateRequestNames = scalar.UintMapSymStr{
+	0: "update_not_requested",
+	1: "update_requested",
+}
+
+// SHA-256 of "HelloRetryRequest", server hello random used for hello retry request
+var helloRetryRequestRandom = [32]byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
 }
 
 const (
@@ -222,6 +242,8 @@ type tlsCtx struct {
 		currentCipherSuit uint64
 		nextCipherSuit    uint64
 		compressionMethod uint64
+		// ALPN protocol selected by server
+		applicationProtocol string
 	}
 
 	// cipher has been decided
@@ -233,7 +255,15 @@ type tlsCtx struct {
 	clientCtx *tlsCtx
 }
 
-func decodeTLSExtension(d *decode.D) {
+// isTLS13 is true if server selected TLS 1.3, for client it's only known when decoding post
+func (tc *tlsCtx) isTLS13() bool {
+	if tc.serverCtx != nil {
+		return tc.serverCtx.version == versionTLS_1_3
+	}
+	return tc.version == versionTLS_1_3
+}
+
+func decodeTLSExtension(d *decode.D, tc *tlsCtx, msgType uint64) {
 	typ := d.FieldU16("type", extensionNames)
 	length := d.FieldU16("length")
 	// server sometimes use empty extension to indicate things, ex: accept SNI
@@ -262,7 +292,12 @@ func decodeTLSExtension(d *decode.D) {
 					for !d.End() {
 						d.FieldStruct("protocol", func(d *decode.D) {
 							length := d.FieldU8("length")
-							d.FieldUTF8("name", int(length))
+							name := d.FieldUTF8("name", int(length))
+							// TLS 1.3 has it in encrypted extensions
+							if msgType == handshakeMsgTypeServerHello ||
+								msgType == handshakeMsgTypeEncryptedExtension {
+								tc.server.applicationProtocol = name
+							}
 						})
 					}
 				})
@@ -297,12 +332,62 @@ func decodeTLSExtension(d *decode.D) {
 					}
 				})
 			})
+		case extensionSupportedVersions:
+			if msgType == handshakeMsgTypeServerHello {
+				// server selects version using extension and sets legacy version to TLS 1.2
+				tc.version = d.FieldU16("selected_version", versionNames, scalar.UintHex)
+				return
+			}
+			versionsLength := d.FieldU8("supported_versions_length")
+			d.FieldArray("supported_versions", func(d *decode.D) {
+				d.FramedFn(int64(versionsLength)*8, func(d *decode.D) {
+					for !d.End() {
+						d.FieldU16("supported_version", versionNames, scalar.UintHex)
+					}
+				})
+			})
+		case extensionKeyShare:
+			decodeKeyShareEntry := func(d *decode.D) {
+				d.FieldU16("group", scalar.UintHex) // TODO: names
+				keyExchangeLength := d.FieldU16("key_exchange_length")
+				d.FieldRawLen("key_exchange", int64(keyExchangeLength)*8)
+			}
+			switch msgType {
+			case handshakeMsgTypeServerHello:
+				if tc.random == helloRetryRequestRandom {
+					d.FieldU16("selected_group", scalar.UintHex)
+					return
+				}
+				d.FieldStruct("server_share", decodeKeyShareEntry)
+			default:
+				sharesLength := d.FieldU16("client_shares_length")
+				d.FieldArray("client_shares", func(d *decode.D) {
+					d.FramedFn(int64(sharesLength)*8, func(d *decode.D) {
+						for !d.End() {
+							d.FieldStruct("client_share", decodeKeyShareEntry)
+						}
+					})
+				})
+			}
 		default:
 			d.FieldRawLen("data", int64(length)*8)
 		}
 	})
 }
 
+func decodeTLSExtensions(d *decode.D, tc *tlsCtx, msgType uint64) {
+	extensionsLength := d.FieldU16("extensions_length")
+	d.FramedFn(int64(extensionsLength)*8, func(d *decode.D) {
+		d.FieldArray("extensions", func(d *decode.D) {
+			for !d.End() {
+				d.FieldStruct("extension", func(d *decode.D) {
+					decodeTLSExtension(d, tc, msgType)
+				})
+			}
+		})
+	})
+}
+
 func decodeTLSHandshake(d *decode.D, tc *tlsCtx) {
 	msgType := d.FieldU8("type", handshakeMsgTypeNames)
 	length := d.FieldU24("length")
@@ -351,16 +436,24 @@ func decodeTLSHandshake(d *decode.D, tc *tlsCtx) {
 
 			// SSL v3 should have no extensions but we decode if there are bytes
 			if d.BitsLeft() > 0 {
-				extensionsLength := d.FieldU16("extensions_length")
-				d.FramedFn(int64(extensionsLength)*8, func(d *decode.D) {
-					d.FieldArray("extensions", func(d *decode.D) {
-						for !d.End() {
-							d.FieldStruct("extension", decodeTLSExtension)
-						}
-					})
-				})
+				decodeTLSExtensions(d, tc, msgType)
 			}
+
+			// TLS 1.3 encrypts everything after server hello, except hello retry request
+			if msgType == handshakeMsgTypeServerHello &&
+				tc.version == versionTLS_1_3 &&
+				tc.random != helloRetryRequestRandom {
+				tc.server.currentCipherSuit = tc.server.nextCipherSuit
+				tc.isEncrypted = true
+			}
+		case handshakeMsgTypeEncryptedExtension:
+			decodeTLSExtensions(d, tc, msgType)
 		case handshakeMsgTypeCertificate:
+			isTLS13 := tc.isTLS13()
+			if isTLS13 {
+				contextLength := d.FieldU8("certificate_request_context_length")
+				d.FieldRawLen("certificate_request_context", int64(contextLength)*8)
+			}
 			certificatesLength := d.FieldU24("certificates_length")
 			d.FramedFn(int64(certificatesLength)*8, func(d *decode.D) {
 				d.FieldArray("certificates", func(d *decode.D) {
@@ -368,10 +461,24 @@ func decodeTLSHandshake(d *decode.D, tc *tlsCtx) {
 						d.FieldStruct("certificate", func(d *decode.D) {
 							length := d.FieldU24("length")
 							d.FieldFormatLen("data", int64(length)*8, &asn1BerGroup, nil)
+							if isTLS13 {
+								decodeTLSExtensions(d, tc, msgType)
+							}
 						})
 					}
 				})
 			})
+		case handshakeMsgTypeCertificateVerify:
+			if !tc.isTLS13() {
+				d.FieldRawLen("data", d.BitsLeft())
+				return
+			}
+			d.FieldStruct("signature_algorithm", func(d *decode.D) {
+				d.FieldU8("hash", hashAlgorithmNames)
+				d.FieldU8("signature", signatureAlgorithmNames)
+			})
+			signatureLength := d.FieldU16("signature_length")
+			d.FieldRawLen("signature", int64(signatureLength)*8)
 		case handshakeMsgTypeClientKeyExchange,
 			handshakeMsgTypeServerKeyExchange:
 			// is decoded later in decodeTLSPostKeyExchange
@@ -387,9 +494,21 @@ func decodeTLSHandshake(d *decode.D, tc *tlsCtx) {
 		case handshakeMsgTypeFinished:
 			d.FieldRawLen("verify_data", d.BitsLeft())
 		case handshakeMsgTypeNewSessionTicket:
-			d.FieldU32("lifetime_hint")
+			if !tc.isTLS13() {
+				d.FieldU32("lifetime_hint")
+				ticketLength := d.FieldU16("ticket_length")
+				d.FieldRawLen("ticket", int64(ticketLength)*8)
+				return
+			}
+			d.FieldU32("lifetime")
+			d.FieldU32("age_add")
+			nonceLength := d.FieldU8("nonce_length")
+			d.FieldRawLen("nonce", int64(nonceLength)*8)
 			ticketLength := d.FieldU16("ticket_length")
 			d.FieldRawLen("ticket", int64(ticketLength)*8)
+			decodeTLSExtensions(d, tc, msgType)
+		case handshakeMsgTypeKeyUpdate:
+			d.FieldU8("request_update", keyUpdateRequestNames)
 		default:
 			d.FieldRawLen("data", d.BitsLeft())
 		}
@@ -402,6 +521,11 @@ func decodeTLSRecord(d *decode.D, tc *tlsCtx, isEncrypted bool) {
 	recordType := d.FieldU8("type", recordTypeNames, d.UintAssert(recordTypeValid...))
 	d.FieldU16("version", versionNames, scalar.UintHex, d.UintAssert(versionValid...))
 	length := d.FieldU16("length")
+	// TLS 1.3 client might not send a compatibility change cipher spec before
+	// encrypted records but they will always be application data records
+	if recordType == recordTypeApplicationData {
+		isEncrypted = true
+	}
 	d.FramedFn(int64(length)*8, func(d *decode.D) {
 		if isEncrypted {
 			d.FieldRawLen("encrypted_data", d.BitsLeft())
@@ -438,24 +562,56 @@ func decodeTLSRecordMessage(d *decode.D, tc *tlsCtx, recordType uint64) {
 	}
 }
 
-func decodeTLSPostEncryptedRecords(rootD *decode.D, tc *tlsCtx, kl keylog.Map) {
+func newDecryptor(tc *tlsCtx, kl keylog.Map) *tlsdecrypt.Decryptor {
+	isClient := tc == tc.clientCtx
+
+	if tc.isTLS13() {
+		// to decrypt tls 1.3 we need:
+		//  - client random to look up handshake and application traffic secrets for one direction
+		handshakeLabel, applicationLabel := keylog.ServerHandshakeTrafficSecret, keylog.ServerTrafficSecret0
+		if isClient {
+			handshakeLabel, applicationLabel = keylog.ClientHandshakeTrafficSecret, keylog.ClientTrafficSecret0
+		}
+		handshakeSecret, _ := kl.Lookup(handshakeLabel, tc.clientCtx.random)
+		applicationSecret, _ := kl.Lookup(applicationLabel, tc.clientCtx.random)
+		if handshakeSecret == nil && applicationSecret == nil {
+			return nil
+		}
+
+		return &tlsdecrypt.Decryptor{
+			IsClient:                 isClient,
+			Version:                  versionTLS_1_3,
+			CipherSuite:              int(tc.serverCtx.server.currentCipherSuit),
+			HandshakeTrafficSecret:   handshakeSecret,
+			ApplicationTrafficSecret: applicationSecret,
+		}
+	}
+
 	// to decrypt tls we need:
 	//  - client random to look up shared master secret
 	//  - client and server random to generate cipher iv/key in both directions
 	masterSecret, _ := kl.Lookup(keylog.ClientRandom, tc.clientCtx.random)
 	if masterSecret == nil {
-		// TODO: info/warn?
-		return
+		return nil
 	}
 
-	td := tlsdecrypt.Decryptor{
-		IsClient:     tc == tc.clientCtx,
+	return &tlsdecrypt.Decryptor{
+		IsClient:     isClient,
 		Version:      int(tc.serverCtx.version),
 		CipherSuite:  int(tc.serverCtx.server.currentCipherSuit),
 		MasterSecret: masterSecret,
 		ClientRandom: tc.clientCtx.random[:],
 		ServerRandom: tc.serverCtx.random[:],
 	}
+}
+
+// decodeTLSPostEncryptedRecords decrypts and decodes records and returns application data stream if any
+func decodeTLSPostEncryptedRecords(rootD *decode.D, tc *tlsCtx, kl keylog.Map) []byte {
+	td := newDecryptor(tc, kl)
+	if td == nil {
+		// TODO: info/warn?
+		return nil
+	}
 
 	applicationStream := &bytes.Buffer{}
 	plainBuf := &bytes.Buffer{}
@@ -464,12 +620,16 @@ func decodeTLSPostEncryptedRecords(rootD *decode.D, tc *tlsCtx, kl keylog.Map) {
 
 	for _, r := range tc.encryptedRecords {
 		encryptedRecord := r.d.ReadAllBits(rootD.BitBufRange(r.r.Start, r.r.Len))
-		plain, decryptErr := td.Decrypt(encryptedRecord)
+		plain, plainType, decryptErr := td.Decrypt(encryptedRecord)
 		if decryptErr != nil {
 			// TODO: warn
 			// log.Printf("err: %#+v\n", decryptErr)
 			continue
 		}
+		recordType := uint64(plainType)
+		if td.Version == versionTLS_1_3 && r.recordType == recordTypeApplicationData {
+			r.d.FieldValueUint("content_type", recordType, recordTypeNames)
+		}
 
 		plainBuf.Write(plain)
 
@@ -499,20 +659,42 @@ func decodeTLSPostEncryptedRecords(rootD *decode.D, tc *tlsCtx, kl keylog.Map) {
 
 		bbr := bitio.NewBitReader(plainUncomp, -1)
 		// application data handled differently to get data as .message
-		if r.recordType == recordTypeApplicationData {
+		if recordType == recordTypeApplicationData {
 			applicationStream.Write(plainUncomp)
 			hasApplicationStream = true
 			r.d.FieldRootBitBuf("message", bbr)
 		} else {
 			r.d.FieldStructRootBitBufFn("message", bbr, func(d *decode.D) {
-				decodeTLSRecordMessage(d, tc, r.recordType)
+				decodeTLSRecordMessage(d, tc, recordType)
 			})
 		}
 	}
 
-	if hasApplicationStream {
-		applicationBytes := applicationStream.Bytes()
-		rootD.FieldRootBitBuf("stream", bitio.NewBitReader(applicationBytes, -1))
+	if !hasApplicationStream {
+		return nil
+	}
+	return applicationStream.Bytes()
+}
+
+// decodeTLSPostApplicationStream is done after both directions has been decrypted as
+// for TLS 1.3 the ALPN protocol is in the encrypted server handshake
+func decodeTLSPostApplicationStream(rootD *decode.D, tc *tlsCtx, applicationStream []byte) {
+	if applicationStream == nil {
+		return
+	}
+
+	applicationBR := bitio.NewBitReader(applicationStream, -1)
+	streamGroup := &httpGroup
+	if tc.serverCtx.server.applicationProtocol == "h2" {
+		streamGroup = &http2Group
+	}
+	if dv, _, _ := rootD.TryFieldFormatBitBuf(
+		"stream",
+		applicationBR,
+		streamGroup,
+		format.TCP_Stream_In{IsClient: tc == tc.clientCtx, HasStart: true},
+	); dv == nil {
+		rootD.FieldRootBitBuf("stream", applicationBR)
 	}
 }
 
@@ -680,6 +862,7 @@ func decodeTLS(d *decode.D) any {
 	d.ArgAs(&ti)
 
 	isClient := false
+	keylogStr := ti.Keylog
 
 	var tsi format.TCP_Stream_In
 	if d.ArgAs(&tsi) {
@@ -687,6 +870,10 @@ func decodeTLS(d *decode.D) any {
 			d.Fatalf("tls requires start of byte stream")
 		}
 		isClient = tsi.IsClient
+		// use both keylog option and keylog from capture file
+		if tsi.Keylog != "" {
+			keylogStr += "\n" + tsi.Keylog
+		}
 	}
 
 	tc := &tlsCtx{
@@ -737,17 +924,19 @@ func decodeTLS(d *decode.D) any {
 			decodeTLSPostKeyExchange(clientTc)
 			decodeTLSPostKeyExchange(serverTc)
 
-			if ti.Keylog == "" {
+			if keylogStr == "" {
 				return
 			}
 
-			km, err := keylog.Parse(ti.Keylog)
+			km, err := keylog.Parse(keylogStr)
 			if err != nil {
 				d.Fatalf("failed to parse keylog: %s", err)
 			}
 
-			decodeTLSPostEncryptedRecords(clientTc.rootD, clientTc, km)
-			decodeTLSPostEncryptedRecords(serverTc.rootD, serverTc, km)
+			clientStream := decodeTLSPostEncryptedRecords(clientTc.rootD, clientTc, km)
+			serverStream := decodeTLSPostEncryptedRecords(serverTc.rootD, serverTc, km)
+			decodeTLSPostApplicationStream(clientTc.rootD, clientTc, clientStream)
+			decodeTLSPostApplicationStream(serverTc.rootD, serverTc, serverStream)
 		},
 		InArg: tc,
 	}
@@ -0,0 +1,137 @@
+package tlsdecrypt
+
+// AES-CCM as used by TLS 1.3 CCM cipher suites, not part of golang crypto
+// https://datatracker.ietf.org/doc/html/rfc3610
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+var errCCMOpen = errors.New("ccm: message authentication failed")
+
+type ccm struct {
+	block     cipher.Block
+	nonceSize int
+	tagSize   int
+}
+
+func newCCM(block cipher.Block, nonceSize int, tagSize int) *ccm {
+	return &ccm{block: block, nonceSize: nonceSize, tagSize: tagSize}
+}
+
+func (c *ccm) NonceSize() int { return c.nonceSize }
+func (c *ccm) Overhead() int  { return c.tagSize }
+
+// xorBytes sets dst[i] ^= src[i] for length of dst
+func xorBytes(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// counter block A_i
+func (c *ccm) counterBlock(nonce []byte, i uint64) []byte {
+	l := aes.BlockSize - 1 - c.nonceSize
+	a := make([]byte, aes.BlockSize)
+	a[0] = byte(l - 1)
+	copy(a[1:], nonce)
+	var ib [8]byte
+	binary.BigEndian.PutUint64(ib[:], i)
+	copy(a[aes.BlockSize-l:], ib[8-l:])
+	return a
+}
+
+func (c *ccm) mac(nonce, plaintext, additionalData []byte) []byte {
+	l := aes.BlockSize - 1 - c.nonceSize
+	b := make([]byte, aes.BlockSize)
+	if len(additionalData) > 0 {
+		b[0] |= 0x40
+	}
+	b[0] |= byte((c.tagSize-2)/2) << 3
+	b[0] |= byte(l - 1)
+	copy(b[1:], nonce)
+	var lb [8]byte
+	binary.BigEndian.PutUint64(lb[:], uint64(len(plaintext)))
+	copy(b[aes.BlockSize-l:], lb[8-l:])
+
+	x := make([]byte, aes.BlockSize)
+	c.block.Encrypt(x, b)
+	macBlocks := func(bs []byte) {
+		for len(bs) > 0 {
+			n := copy(b, bs)
+			for i := n; i < aes.BlockSize; i++ {
+				b[i] = 0
+			}
+			xorBytes(x, b)
+			c.block.Encrypt(x, x)
+			bs = bs[n:]
+		}
+	}
+
+	if len(additionalData) > 0 {
+		// additional data is at most a record header so use short length encoding
+		ad := make([]byte, 2, 2+len(additionalData))
+		binary.BigEndian.PutUint16(ad, uint16(len(additionalData)))
+		macBlocks(append(ad, additionalData...))
+	}
+	macBlocks(plaintext)
+
+	return x[:c.tagSize]
+}
+
+func (c *ccm) ctr(dst, src, nonce []byte) {
+	ctr := cipher.NewCTR(c.block, c.counterBlock(nonce, 1))
+	ctr.XORKeyStream(dst, src)
+}
+
+func (c *ccm) tag(nonce, plaintext, additionalData []byte) []byte {
+	t := c.mac(nonce, plaintext, additionalData)
+	s0 := make([]byte, aes.BlockSize)
+	c.block.Encrypt(s0, c.counterBlock(nonce, 0))
+	xorBytes(t, s0)
+	return t
+}
+
+func (c *ccm) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	t := c.tag(nonce, plaintext, additionalData)
+	out := make([]byte, len(plaintext))
+	c.ctr(out, plaintext, nonce)
+	dst = append(dst, out...)
+	return append(dst, t...)
+}
+
+func (c *ccm) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < c.tagSize {
+		return nil, errCCMOpen
+	}
+	tagged := ciphertext[len(ciphertext)-c.tagSize:]
+	ciphertext = ciphertext[:len(ciphertext)-c.tagSize]
+
+	plaintext := make([]byte, len(ciphertext))
+	c.ctr(plaintext, ciphertext, nonce)
+	if subtle.ConstantTimeCompare(c.tag(nonce, plaintext, additionalData), tagged) != 1 {
+		return nil, errCCMOpen
+	}
+
+	return append(dst, plaintext...), nil
+}
+
+func aeadAESCCMTLS13(tagSize int) func(key, nonceMask []byte) aead {
+	return func(key, nonceMask []byte) aead {
+		if len(nonceMask) != aeadNonceLength {
+			panic("tls: internal error: wrong nonce length")
+		}
+		aes, err := aes.NewCipher(key)
+		if err != nil {
+			panic(err)
+		}
+
+		ret := &xorNonceAEAD{aead: newCCM(aes, aeadNonceLength, tagSize)}
+		copy(ret.nonceMask[:], nonceMask)
+		return ret
+	}
+}
@@ -0,0 +1,75 @@
+package tlsdecrypt
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	typeFinished  = 20
+	typeKeyUpdate = 24
+)
+
+func (d *Decryptor) setTrafficSecretTLS13(trafficSecret []byte) {
+	key, iv := d.suiteTLS13.trafficKey(trafficSecret)
+	d.halfConn = &halfConn{
+		version:       VersionTLS13,
+		cipher:        d.suiteTLS13.aead(key, iv),
+		seq:           [8]byte{}, // zero
+		trafficSecret: trafficSecret,
+	}
+}
+
+// decryptTLS13 starts with handshake traffic keys and switches to application traffic keys
+// after Finished. KeyUpdate messages will update application traffic keys.
+func (d *Decryptor) decryptTLS13(record []byte) ([]byte, int, error) {
+	if d.halfConn == nil {
+		d.suiteTLS13 = cipherSuiteTLS13ByID(uint16(d.CipherSuite))
+		if d.suiteTLS13 == nil {
+			return nil, 0, fmt.Errorf("unsupported cipher suit %x", d.CipherSuite)
+		}
+
+		switch {
+		case d.HandshakeTrafficSecret != nil:
+			d.setTrafficSecretTLS13(d.HandshakeTrafficSecret)
+		case d.ApplicationTrafficSecret != nil:
+			// no handshake secret, try to decrypt application traffic
+			d.isApplicationTraffic = true
+			d.setTrafficSecretTLS13(d.ApplicationTrafficSecret)
+		default:
+			return nil, 0, errors.New("no traffic secret")
+		}
+	}
+
+	plain, typ, err := d.halfConn.decrypt(record)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ != recordTypeHandshake {
+		return plain, int(typ), nil
+	}
+
+	// handshake messages can be split into multiple records so buffer until complete
+	d.handshakeBuf = append(d.handshakeBuf, plain...)
+	for len(d.handshakeBuf) >= 4 {
+		msgType := d.handshakeBuf[0]
+		msgLen := 4 + (int(d.handshakeBuf[1])<<16 | int(d.handshakeBuf[2])<<8 | int(d.handshakeBuf[3]))
+		if len(d.handshakeBuf) < msgLen {
+			break
+		}
+		d.handshakeBuf = d.handshakeBuf[msgLen:]
+
+		switch {
+		case msgType == typeFinished && !d.isApplicationTraffic:
+			d.isApplicationTraffic = true
+			// without secret following records will fail to decrypt
+			if d.ApplicationTrafficSecret != nil {
+				d.setTrafficSecretTLS13(d.ApplicationTrafficSecret)
+			}
+		case msgType == typeKeyUpdate && d.isApplicationTraffic:
+			d.setTrafficSecretTLS13(d.suiteTLS13.nextTrafficSecret(d.halfConn.trafficSecret))
+		}
+	}
+
+	return plain, int(typ), nil
+}
@@ -1,7 +1,7 @@
 package tlsdecrypt
 
 // TODO: SSLv3 MAC
-// TODO: TLS 1.3
+// TODO: TLS 1.3 early data
 
 import (
 	"fmt"
@@ -15,8 +15,16 @@ type Decryptor struct {
 	MasterSecret []byte
 	ClientRandom []byte
 	ServerRandom []byte
+	// TLS 1.3 traffic secrets for the direction being decrypted
+	HandshakeTrafficSecret   []byte
+	ApplicationTrafficSecret []byte
 
 	halfConn *halfConn
+
+	// TLS 1.3 state
+	suiteTLS13           *cipherSuiteTLS13
+	handshakeBuf         []byte
+	isApplicationTraffic bool
 }
 
 type keys struct {
@@ -58,11 +66,17 @@ func establishKeys(
 	}
 }
 
-func (d *Decryptor) Decrypt(record []byte) ([]byte, error) {
+// Decrypt decrypts a record and returns plaintext and content type, for TLS 1.3
+// this is the inner content type
+func (d *Decryptor) Decrypt(record []byte) ([]byte, int, error) {
+	if d.Version == VersionTLS13 {
+		return d.decryptTLS13(record)
+	}
+
 	if d.halfConn == nil {
 		cipherSuite := cipherSuiteByID(uint16(d.CipherSuite))
 		if cipherSuite == nil {
-			return nil, fmt.Errorf("unsupported cipher suit %x", d.CipherSuite)
+			return nil, 0, fmt.Errorf("unsupported cipher suit %x", d.CipherSuite)
 		}
 
 		keys := establishKeys(
@@ -91,6 +105,6 @@ func (d *Decryptor) Decrypt(record []byte) ([]byte, error) {
 		}
 	}
 
-	plain, _, err := d.halfConn.decrypt(record)
-	return plain, err
+	plain, typ, err := d.halfConn.decrypt(record)
+	return plain, int(typ), err
 }
@@ -0,0 +1,421 @@
+package webp
+
+// https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+const vp8lSignature = 0x2f
+
+const (
+	vp8lTransformPredictor     = 0
+	vp8lTransformColor         = 1
+	vp8lTransformSubtractGreen = 2
+	vp8lTransformColorIndexing = 3
+)
+
+var vp8lTransformTypeNames = scalar.UintMapSymStr{
+	vp8lTransformPredictor:     "predictor",
+	vp8lTransformColor:         "color",
+	vp8lTransformSubtractGreen: "subtract_green",
+	vp8lTransformColorIndexing: "color_indexing",
+}
+
+var errVP8LEOF = errors.New("unexpected end of bitstream")
+
+// lsbReader reads bits LSB first as is used by the VP8L bitstream
+type lsbReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *lsbReader) bits(n int) (uint64, error) {
+	var v uint64
+	for i := 0; i < n; i++ {
+		if r.pos>>3 >= len(r.buf) {
+			return 0, errVP8LEOF
+		}
+		v |= uint64(r.buf[r.pos>>3]>>(r.pos&7)&1) << i
+		r.pos++
+	}
+	return v, nil
+}
+
+// canonical prefix code, symbols sorted by code length
+type vp8lPrefixCode struct {
+	counts  [16]int
+	symbols []int
+	single  int
+}
+
+func newVP8LPrefixCode(lengths []int) (vp8lPrefixCode, error) {
+	var c vp8lPrefixCode
+	n := 0
+	for s, l := range lengths {
+		if l > 0 {
+			c.counts[l]++
+			c.single = s
+			n++
+		}
+	}
+	if n == 0 {
+		return c, errors.New("empty prefix code")
+	}
+	if n == 1 {
+		// single symbol takes zero bits to read
+		c.counts = [16]int{}
+		return c, nil
+	}
+	for l := 1; l < len(c.counts); l++ {
+		for s, sl := range lengths {
+			if sl == l {
+				c.symbols = append(c.symbols, s)
+			}
+		}
+	}
+	return c, nil
+}
+
+func (c vp8lPrefixCode) read(r *lsbReader) (int, error) {
+	if c.symbols == nil {
+		return c.single, nil
+	}
+	code, first, index := 0, 0, 0
+	for l := 1; l < len(c.counts); l++ {
+		b, err := r.bits(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= int(b)
+		count := c.counts[l]
+		if code-first < count {
+			return c.symbols[index+code-first], nil
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	return 0, errors.New("invalid prefix code")
+}
+
+var vp8lCodeLengthCodeOrder = [...]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+func vp8lReadPrefixCode(r *lsbReader, alphabetSize int) (vp8lPrefixCode, error) {
+	lengths := make([]int, alphabetSize)
+
+	simple, err := r.bits(1)
+	if err != nil {
+		return vp8lPrefixCode{}, err
+	}
+	if simple == 1 {
+		numSymbols, err := r.bits(1)
+		if err != nil {
+			return vp8lPrefixCode{}, err
+		}
+		isFirst8Bits, err := r.bits(1)
+		if err != nil {
+			return vp8lPrefixCode{}, err
+		}
+		s0, err := r.bits(1 + 7*int(isFirst8Bits))
+		if err != nil {
+			return vp8lPrefixCode{}, err
+		}
+		if int(s0) >= alphabetSize {
+			return vp8lPrefixCode{}, fmt.Errorf("symbol %d outside alphabet", s0)
+		}
+		lengths[s0] = 1
+		if numSymbols == 1 {
+			s1, err := r.bits(8)
+			if err != nil {
+				return vp8lPrefixCode{}, err
+			}
+			if int(s1) >= alphabetSize {
+				return vp8lPrefixCode{}, fmt.Errorf("symbol %d outside alphabet", s1)
+			}
+			lengths[s1] = 1
+		}
+		return newVP8LPrefixCode(lengths)
+	}
+
+	var codeLengthCodeLengths [len(vp8lCodeLengthCodeOrder)]int
+	numCodeLengths, err := r.bits(4)
+	if err != nil {
+		return vp8lPrefixCode{}, err
+	}
+	for i := 0; i < int(numCodeLengths)+4; i++ {
+		l, err := r.bits(3)
+		if err != nil {
+			return vp8lPrefixCode{}, err
+		}
+		codeLengthCodeLengths[vp8lCodeLengthCodeOrder[i]] = int(l)
+	}
+	codeLengthCode, err := newVP8LPrefixCode(codeLengthCodeLengths[:])
+	if err != nil {
+		return vp8lPrefixCode{}, err
+	}
+
+	maxSymbol := alphabetSize
+	useMaxSymbol, err := r.bits(1)
+	if err != nil {
+		return vp8lPrefixCode{}, err
+	}
+	if useMaxSymbol == 1 {
+		lengthNBits, err := r.bits(3)
+		if err != nil {
+			return vp8lPrefixCode{}, err
+		}
+		ms, err := r.bits(2 + 2*int(lengthNBits))
+		if err != nil {
+			return vp8lPrefixCode{}, err
+		}
+		maxSymbol = 2 + int(ms)
+		if maxSymbol > alphabetSize {
+			return vp8lPrefixCode{}, fmt.Errorf("max symbol %d larger than alphabet", maxSymbol)
+		}
+	}
+
+	prevCodeLen := 8
+	for symbol := 0; symbol < alphabetSize; {
+		if maxSymbol == 0 {
+			break
+		}
+		maxSymbol--
+		codeLen, err := codeLengthCode.read(r)
+		if err != nil {
+			return vp8lPrefixCode{}, err
+		}
+		if codeLen < 16 {
+			lengths[symbol] = codeLen
+			symbol++
+			if codeLen != 0 {
+				prevCodeLen = codeLen
+			}
+			continue
+		}
+
+		repeatLen := 0
+		var extraBits, repeatOffset int
+		switch codeLen {
+		case 16:
+			extraBits, repeatOffset = 2, 3
+			repeatLen = prevCodeLen
+		case 17:
+			extraBits, repeatOffset = 3, 3
+		default:
+			extraBits, repeatOffset = 7, 11
+		}
+		n, err := r.bits(extraBits)
+		if err != nil {
+			return vp8lPrefixCode{}, err
+		}
+		repeat := int(n) + repeatOffset
+		if symbol+repeat > alphabetSize {
+			return vp8lPrefixCode{}, errors.New("code length repeat outside alphabet")
+		}
+		for i := 0; i < repeat; i++ {
+			lengths[symbol] = repeatLen
+			symbol++
+		}
+	}
+
+	return newVP8LPrefixCode(lengths)
+}
+
+func vp8lReadLZ77Value(r *lsbReader, prefix int) (int, error) {
+	if prefix < 4 {
+		return prefix + 1, nil
+	}
+	extraBits := (prefix - 2) >> 1
+	offset := (2 + (prefix & 1)) << extraBits
+	v, err := r.bits(extraBits)
+	if err != nil {
+		return 0, err
+	}
+	return offset + int(v) + 1, nil
+}
+
+func vp8lReadColorCacheBits(r *lsbReader) (int, bool, error) {
+	hasColorCache, err := r.bits(1)
+	if err != nil || hasColorCache == 0 {
+		return 0, false, err
+	}
+	bits, err := r.bits(4)
+	if err != nil {
+		return 0, false, err
+	}
+	if bits < 1 || bits > 11 {
+		return 0, false, fmt.Errorf("invalid color cache bits %d", bits)
+	}
+	return int(bits), true, nil
+}
+
+// vp8lSkipEntropyImage reads past a entropy coded sub image (transform data,
+// color table or meta prefix image), only symbols are read, no pixels are produced
+func vp8lSkipEntropyImage(r *lsbReader, width int, height int) error {
+	colorCacheBits, _, err := vp8lReadColorCacheBits(r)
+	if err != nil {
+		return err
+	}
+	colorCacheSize := 0
+	if colorCacheBits > 0 {
+		colorCacheSize = 1 << colorCacheBits
+	}
+
+	var codes [5]vp8lPrefixCode
+	for i, alphabetSize := range [5]int{256 + 24 + colorCacheSize, 256, 256, 256, 40} {
+		if codes[i], err = vp8lReadPrefixCode(r, alphabetSize); err != nil {
+			return err
+		}
+	}
+
+	numPixels := width * height
+	for i := 0; i < numPixels; {
+		s, err := codes[0].read(r)
+		if err != nil {
+			return err
+		}
+		switch {
+		case s < 256:
+			for _, c := range codes[1:4] {
+				if _, err := c.read(r); err != nil {
+					return err
+				}
+			}
+			i++
+		case s < 256+24:
+			length, err := vp8lReadLZ77Value(r, s-256)
+			if err != nil {
+				return err
+			}
+			distSymbol, err := codes[4].read(r)
+			if err != nil {
+				return err
+			}
+			if _, err := vp8lReadLZ77Value(r, distSymbol); err != nil {
+				return err
+			}
+			i += length
+		default:
+			i++
+		}
+	}
+
+	return nil
+}
+
+func vp8lSubSampleSize(size int, bits int) int {
+	return (size + (1 << bits) - 1) >> bits
+}
+
+// decodeVP8LImageStream decodes transforms and main image header. Fields are
+// synthetic as the bitstream is LSB first and fields are not byte aligned.
+func decodeVP8LImageStream(d *decode.D, width int, height int) {
+	r := &lsbReader{buf: d.ReadAllBits(d.BitBufRange(d.Pos(), d.BitsLeft()))}
+
+	var err error
+	d.FieldArray("transforms", func(d *decode.D) {
+		seen := map[uint64]bool{}
+		for {
+			var hasTransform uint64
+			if hasTransform, err = r.bits(1); err != nil || hasTransform == 0 {
+				return
+			}
+			var transformType uint64
+			if transformType, err = r.bits(2); err != nil {
+				return
+			}
+			if seen[transformType] {
+				err = fmt.Errorf("transform %d used more than once", transformType)
+				return
+			}
+			seen[transformType] = true
+
+			d.FieldStruct("transform", func(d *decode.D) {
+				d.FieldValueUint("type", transformType, vp8lTransformTypeNames)
+				switch transformType {
+				case vp8lTransformPredictor, vp8lTransformColor:
+					var sizeBits uint64
+					if sizeBits, err = r.bits(3); err != nil {
+						return
+					}
+					sizeBits += 2
+					d.FieldValueUint("size_bits", sizeBits)
+					blockWidth := vp8lSubSampleSize(width, int(sizeBits))
+					blockHeight := vp8lSubSampleSize(height, int(sizeBits))
+					d.FieldValueUint("block_width", uint64(blockWidth))
+					d.FieldValueUint("block_height", uint64(blockHeight))
+					err = vp8lSkipEntropyImage(r, blockWidth, blockHeight)
+				case vp8lTransformColorIndexing:
+					var colorTableSize uint64
+					if colorTableSize, err = r.bits(8); err != nil {
+						return
+					}
+					colorTableSize++
+					d.FieldValueUint("color_table_size", colorTableSize)
+					widthBits := 0
+					switch {
+					case colorTableSize <= 2:
+						widthBits = 3
+					case colorTableSize <= 4:
+						widthBits = 2
+					case colorTableSize <= 16:
+						widthBits = 1
+					}
+					d.FieldValueUint("width_bits", uint64(widthBits))
+					width = vp8lSubSampleSize(width, widthBits)
+					err = vp8lSkipEntropyImage(r, int(colorTableSize), 1)
+				}
+			})
+			if err != nil {
+				return
+			}
+		}
+	})
+	if err == nil {
+		var colorCacheBits int
+		var hasColorCache bool
+		colorCacheBits, hasColorCache, err = vp8lReadColorCacheBits(r)
+		if err == nil {
+			d.FieldValueBool("color_cache", hasColorCache)
+			if hasColorCache {
+				d.FieldValueUint("color_cache_bits", uint64(colorCacheBits))
+			}
+			var metaPrefixCodes uint64
+			metaPrefixCodes, err = r.bits(1)
+			if err == nil {
+				d.FieldValueBool("meta_prefix_codes", metaPrefixCodes == 1)
+				if metaPrefixCodes == 1 {
+					var prefixBits uint64
+					if prefixBits, err = r.bits(3); err == nil {
+						d.FieldValueUint("prefix_bits", prefixBits+2)
+					}
+				}
+			}
+		}
+	}
+	if err != nil {
+		d.FieldValueStr("error", err.Error())
+	}
+
+	d.FieldRawLen("data", d.BitsLeft())
+}
+
+func decodeVP8L(d *decode.D) {
+	d.FieldU8("signature", d.UintAssert(vp8lSignature), scalar.UintHex)
+
+	// 14 bit width, 14 bit height, 1 bit alpha and 3 bit version packed LSB first
+	header := d.FieldU32("header", scalar.UintHex)
+	width := int(header&0x3fff) + 1
+	height := int((header>>14)&0x3fff) + 1
+	d.FieldValueUint("width", uint64(width))
+	d.FieldValueUint("height", uint64(height))
+	d.FieldValueBool("alpha_is_used", (header>>28)&1 == 1)
+	d.FieldValueUint("version", header>>29, d.UintValidate(0))
+
+	decodeVP8LImageStream(d, width, height)
+}
@@ -12,6 +12,9 @@ import (
 )
 
 var vp8FrameGroup decode.Group
+var iccProfileGroup decode.Group
+var exifGroup decode.Group
+var xmlGroup decode.Group
 
 func init() {
 	interp.RegisterFormat(
@@ -22,24 +25,142 @@ func init() {
 			DecodeFn:    webpDecode,
 			Dependencies: []decode.Dependency{
 				{Groups: []*decode.Group{format.VP8_Frame}, Out: &vp8FrameGroup},
+				{Groups: []*decode.Group{format.ICC_Profile}, Out: &iccProfileGroup},
+				{Groups: []*decode.Group{format.Exif}, Out: &exifGroup},
+				{Groups: []*decode.Group{format.XML}, Out: &xmlGroup},
 			},
 		})
 }
 
-func decodeChunk(d *decode.D, expectedChunkID string, fn func(d *decode.D)) {
-	trimChunkID := d.FieldUTF8("id", 4, scalar.ActualTrimSpace)
-	if expectedChunkID != "" && trimChunkID != expectedChunkID {
-		return
-	}
+var chunkIDDescriptions = scalar.StrMapDescription{
+	"VP8":  "Lossy image",
+	"VP8L": "Lossless image",
+	"VP8X": "Extended format",
+	"ALPH": "Alpha",
+	"ANIM": "Animation",
+	"ANMF": "Animation frame",
+	"ICCP": "Color profile",
+	"EXIF": "Exif metadata",
+	"XMP":  "XMP metadata",
+}
+
+var alphaCompressionNames = scalar.UintMapSymStr{
+	0: "none",
+	1: "lossless",
+}
+
+var alphaFilteringNames = scalar.UintMapSymStr{
+	0: "none",
+	1: "horizontal",
+	2: "vertical",
+	3: "gradient",
+}
+
+var alphaPreprocessingNames = scalar.UintMapSymStr{
+	0: "none",
+	1: "level_reduction",
+}
+
+var blendingMethodNames = scalar.UintMapSymStr{
+	0: "alpha_blending",
+	1: "no_blending",
+}
+
+var disposalMethodNames = scalar.UintMapSymStr{
+	0: "none",
+	1: "background",
+}
+
+var exifPrefix = []byte("Exif\x00\x00")
+
+// canvas or frame size, needed to decode lossless alpha
+type imageSize struct {
+	width  int
+	height int
+}
+
+func decodeChunk(d *decode.D, size *imageSize) {
+	id := d.FieldUTF8("id", 4, scalar.ActualTrimSpace, chunkIDDescriptions)
 	chunkLen := int64(d.FieldU32("size"))
 
-	if fn != nil {
-		d.FramedFn(chunkLen*8, fn)
-	} else {
-		d.FieldRawLen("data", chunkLen*8)
+	d.FramedFn(chunkLen*8, func(d *decode.D) {
+		switch id {
+		case "VP8":
+			d.Format(&vp8FrameGroup, nil)
+		case "VP8L":
+			decodeVP8L(d)
+		case "VP8X":
+			d.FieldStruct("flags", func(d *decode.D) {
+				d.FieldU2("reserved0")
+				d.FieldBool("icc_profile")
+				d.FieldBool("alpha")
+				d.FieldBool("exif")
+				d.FieldBool("xmp")
+				d.FieldBool("animation")
+				d.FieldU1("reserved1")
+			})
+			d.FieldU24("reserved")
+			size.width = int(d.FieldU24("canvas_width", scalar.UintActualAdd(1)))
+			size.height = int(d.FieldU24("canvas_height", scalar.UintActualAdd(1)))
+		case "ALPH":
+			var compression uint64
+			d.FieldStruct("flags", func(d *decode.D) {
+				d.FieldU2("reserved")
+				d.FieldU2("preprocessing", alphaPreprocessingNames)
+				d.FieldU2("filtering", alphaFilteringNames)
+				compression = d.FieldU2("compression", alphaCompressionNames)
+			})
+			if compression == 1 && size.width > 0 && size.height > 0 {
+				// VP8L image stream without header
+				decodeVP8LImageStream(d, size.width, size.height)
+			} else {
+				d.FieldRawLen("data", d.BitsLeft())
+			}
+		case "ANIM":
+			d.FieldU32("background_color", scalar.UintHex)
+			d.FieldU16("loop_count")
+		case "ANMF":
+			d.FieldU24("x", scalar.UintActualFn(func(a uint64) uint64 { return a * 2 }))
+			d.FieldU24("y", scalar.UintActualFn(func(a uint64) uint64 { return a * 2 }))
+			width := d.FieldU24("width", scalar.UintActualAdd(1))
+			height := d.FieldU24("height", scalar.UintActualAdd(1))
+			d.FieldU24("duration")
+			d.FieldStruct("flags", func(d *decode.D) {
+				d.FieldU6("reserved")
+				d.FieldU1("blending_method", blendingMethodNames)
+				d.FieldU1("disposal_method", disposalMethodNames)
+			})
+			decodeChunks(d, &imageSize{width: int(width), height: int(height)})
+		case "ICCP":
+			d.FieldFormatOrRawLen("icc_profile", d.BitsLeft(), &iccProfileGroup, nil)
+		case "EXIF":
+			// spec says raw TIFF but some writers include the JPEG APP1 prefix
+			if bytes.Equal(d.PeekBytes(len(exifPrefix)), exifPrefix) {
+				d.FieldUTF8("exif_prefix", len(exifPrefix))
+			}
+			d.FieldFormatOrRawLen("exif", d.BitsLeft(), &exifGroup, nil)
+		case "XMP":
+			d.FieldFormatOrRawLen("xmp", d.BitsLeft(), &xmlGroup, nil)
+		default:
+			d.FieldRawLen("data", d.BitsLeft())
+		}
+	})
+
+	if chunkLen%2 != 0 && d.BitsLeft() >= 8 {
+		d.FieldU8("padding")
 	}
 }
 
+func decodeChunks(d *decode.D, size *imageSize) {
+	d.FieldArray("chunks", func(d *decode.D) {
+		for d.BitsLeft() >= 8*8 {
+			d.FieldStruct("chunk", func(d *decode.D) {
+				decodeChunk(d, size)
+			})
+		}
+	})
+}
+
 func webpDecode(d *decode.D) any {
 	d.Endian = decode.LittleEndian
 
@@ -49,25 +170,14 @@ func webpDecode(d *decode.D) any {
 
 	d.FramedFn(int64(riffLength-4)*8, func(d *decode.D) {
 		p := d.PeekBytes(4)
-
-		// TODO: VP8X
-
-		switch {
-		case bytes.Equal(p, []byte("VP8 ")):
-			d.FieldStruct("image", func(d *decode.D) {
-				decodeChunk(d, "VP8", func(d *decode.D) {
-					d.Format(&vp8FrameGroup, nil)
-				})
-			})
-		case bytes.Equal(p, []byte("VP8L")):
-			d.FieldStruct("image", func(d *decode.D) {
-				decodeChunk(d, "VP8L", func(d *decode.D) {
-					// TODO
-				})
-			})
-		default:
-			d.Fatalf("could not find VP8 or VP8L chunk")
+		if !bytes.Equal(p, []byte("VP8 ")) &&
+			!bytes.Equal(p, []byte("VP8L")) &&
+			!bytes.Equal(p, []byte("VP8X")) {
+			d.Fatalf("could not find VP8, VP8L or VP8X chunk")
 		}
+
+		// canvas size from VP8X is needed to decode lossless alpha
+		decodeChunks(d, &imageSize{})
 	})
 
 	return nil
@@ -0,0 +1,73 @@
+package xz
+
+// Decoders for the non-compression filters that can precede LZMA2 in a filter chain.
+// Whole block is decoded in memory so no state is kept between calls.
+
+// https://github.com/tukaani-project/xz/blob/master/src/liblzma/delta/delta_decoder.c
+func deltaDecode(buf []byte, distance int) {
+	for i := distance; i < len(buf); i++ {
+		buf[i] += buf[i-distance]
+	}
+}
+
+func x86TestMSByte(b byte) bool { return b == 0x00 || b == 0xff }
+
+// x86 BCJ converts absolute CALL and JMP addresses back to relative
+// https://github.com/tukaani-project/xz/blob/master/src/liblzma/simple/x86.c
+func x86Decode(buf []byte, startOffset uint32) {
+	maskToAllowed := [8]bool{true, true, true, false, true, false, false, false}
+	maskToBitNum := [8]uint32{0, 1, 2, 2, 3, 3, 3, 3}
+
+	if len(buf) <= 4 {
+		return
+	}
+	prevPos := -1
+	prevMask := uint32(0)
+	size := len(buf) - 4
+	for i := 0; i < size; i++ {
+		if buf[i]&0xfe != 0xe8 {
+			continue
+		}
+		prevPos = i - prevPos
+		if prevPos > 3 {
+			prevMask = 0
+		} else {
+			prevMask = (prevMask << (prevPos - 1)) & 7
+			if prevMask != 0 {
+				b := buf[i+4-int(maskToBitNum[prevMask])]
+				if !maskToAllowed[prevMask] || x86TestMSByte(b) {
+					prevPos = i
+					prevMask = (prevMask << 1) | 1
+					continue
+				}
+			}
+		}
+		prevPos = i
+
+		if !x86TestMSByte(buf[i+4]) {
+			prevMask = (prevMask << 1) | 1
+			continue
+		}
+
+		src := uint32(buf[i+1]) | uint32(buf[i+2])<<8 | uint32(buf[i+3])<<16 | uint32(buf[i+4])<<24
+		var dest uint32
+		for {
+			dest = src - (startOffset + uint32(i) + 5)
+			if prevMask == 0 {
+				break
+			}
+			j := maskToBitNum[prevMask] * 8
+			if !x86TestMSByte(byte(dest >> (24 - j))) {
+				break
+			}
+			src = dest ^ (1<<(32-j) - 1)
+		}
+		dest &= 0x01ff_ffff
+		dest |= 0 - (dest & 0x0100_0000)
+		buf[i+1] = byte(dest)
+		buf[i+2] = byte(dest >> 8)
+		buf[i+3] = byte(dest >> 16)
+		buf[i+4] = byte(dest >> 24)
+		i += 4
+	}
+}
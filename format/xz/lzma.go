@@ -0,0 +1,92 @@
+package xz
+
+// Legacy .lzma format, also known as LZMA_Alone
+// https://github.com/tukaani-project/xz/blob/master/doc/lzma-file-format.txt
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/internal/lzma"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+func init() {
+	interp.RegisterFormat(
+		format.LZMA,
+		&decode.Format{
+			Description: "LZMA compression",
+			Groups:      []*decode.Group{format.Probe},
+			ProbeOrder:  format.ProbeOrderBinFuzzy, // header has no magic
+			DecodeFn:    lzmaDecode,
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.Probe}, Out: &probeGroup},
+			},
+		})
+}
+
+const lzmaUnknownSize = 0xffff_ffff_ffff_ffff
+
+var lzmaPropertiesDescription = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	p, err := lzma.PropertiesFromByte(byte(s.Actual))
+	if err != nil {
+		return s, err
+	}
+	s.Description = fmt.Sprintf("lc=%d lp=%d pb=%d", p.LC, p.LP, p.PB)
+	return s, nil
+})
+
+// same heuristics as xz uses to detect .lzma files, dictionary size is 2^n or 2^n+2^(n-1)
+func lzmaIsLikelyDictSize(v uint32) bool {
+	if v == 0xffff_ffff {
+		return true
+	}
+	n := v - 1
+	n |= n >> 2
+	n |= n >> 3
+	n |= n >> 4
+	n |= n >> 8
+	n |= n >> 16
+	n++
+	return n == v
+}
+
+func lzmaDecode(d *decode.D) any {
+	d.Endian = decode.LittleEndian
+
+	propsByte := d.FieldU8("properties", lzmaPropertiesDescription)
+	dictSize := d.FieldU32("dictionary_size")
+	if !lzmaIsLikelyDictSize(uint32(dictSize)) {
+		d.Fatalf("unlikely dictionary size %d", dictSize)
+	}
+	uncompressedSize := d.FieldU64("uncompressed_size", scalar.UintMapDescription{lzmaUnknownSize: "Unknown, end marker is used"})
+	if uncompressedSize != lzmaUnknownSize && uncompressedSize >= 1<<38 {
+		d.Fatalf("unlikely uncompressed size %d", uncompressedSize)
+	}
+
+	props, err := lzma.PropertiesFromByte(byte(propsByte))
+	if err != nil {
+		d.Fatalf("%s", err)
+	}
+	unpackSize := int64(uncompressedSize)
+	if uncompressedSize == lzmaUnknownSize {
+		unpackSize = -1
+	}
+
+	readCompressedSize, uncompressedBR, dv, _, err :=
+		d.TryFieldReaderRangeFormat("uncompressed", d.Pos(), d.BitsLeft(), func(r io.Reader) io.Reader {
+			return lzma.NewReader(r, props, uint32(dictSize), unpackSize)
+		}, &probeGroup, format.Probe_In{})
+	if uncompressedBR == nil {
+		d.Fatalf("failed to decompress: %s", err)
+	}
+	if dv == nil {
+		d.FieldRootBitBuf("uncompressed", uncompressedBR)
+	}
+	d.FieldRawLen("compressed", readCompressedSize)
+
+	return nil
+}
@@ -0,0 +1,391 @@
+package xz
+
+// https://tukaani.org/xz/xz-file-format.txt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/internal/lzma"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed xz.md
+var xzFS embed.FS
+
+var probeGroup decode.Group
+
+func init() {
+	interp.RegisterFormat(
+		format.XZ,
+		&decode.Format{
+			Description: "XZ compression",
+			Groups:      []*decode.Group{format.Probe},
+			DecodeFn:    xzDecode,
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.Probe}, Out: &probeGroup},
+			},
+		})
+	interp.RegisterFS(xzFS)
+}
+
+var headerMagic = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+var footerMagic = []byte("YZ")
+var streamPadding = []byte{0, 0, 0, 0}
+
+const (
+	checkNone   = 0x00
+	checkCRC32  = 0x01
+	checkCRC64  = 0x04
+	checkSHA256 = 0x0a
+)
+
+var checkNames = scalar.UintMapSymStr{
+	checkNone:   "none",
+	checkCRC32:  "crc32",
+	checkCRC64:  "crc64",
+	checkSHA256: "sha256",
+}
+
+// check size in bytes is given by check type, also for unknown types
+var checkSizes = [16]int{0, 4, 4, 4, 8, 8, 8, 16, 16, 16, 32, 32, 32, 64, 64, 64}
+
+const (
+	filterDelta    = 0x03
+	filterX86      = 0x04
+	filterPowerPC  = 0x05
+	filterIA64     = 0x06
+	filterARM      = 0x07
+	filterARMThumb = 0x08
+	filterSPARC    = 0x09
+	filterARM64    = 0x0a
+	filterRISCV    = 0x0b
+	filterLZMA2    = 0x21
+)
+
+var filterNames = scalar.UintMapSymStr{
+	filterDelta:    "delta",
+	filterX86:      "x86",
+	filterPowerPC:  "powerpc",
+	filterIA64:     "ia64",
+	filterARM:      "arm",
+	filterARMThumb: "arm_thumb",
+	filterSPARC:    "sparc",
+	filterARM64:    "arm64",
+	filterRISCV:    "riscv",
+	filterLZMA2:    "lzma2",
+}
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+type filter struct {
+	id          uint64
+	dictSize    uint32
+	distance    int
+	startOffset uint32
+}
+
+type blockInfo struct {
+	unpaddedSize     int64
+	uncompressedSize int64
+}
+
+func decodeStreamFlags(d *decode.D) uint64 {
+	var checkType uint64
+	d.FieldStruct("flags", func(d *decode.D) {
+		d.FieldU8("reserved0", d.UintValidate(0))
+		d.FieldU4("reserved1", d.UintValidate(0))
+		checkType = d.FieldU4("check_type", checkNames)
+	})
+	return checkType
+}
+
+func decodeBlockHeader(d *decode.D) ([]filter, int64) {
+	headerStart := d.Pos()
+	headerSize := int64(d.FieldU8("size", scalar.UintActualFn(func(a uint64) uint64 { return (a + 1) * 4 })))
+
+	var numFilters uint64
+	var hasCompressedSize bool
+	var hasUncompressedSize bool
+	d.FieldStruct("flags", func(d *decode.D) {
+		hasUncompressedSize = d.FieldBool("uncompressed_size_present")
+		hasCompressedSize = d.FieldBool("compressed_size_present")
+		d.FieldU4("reserved", d.UintValidate(0))
+		numFilters = d.FieldU2("number_of_filters", scalar.UintActualAdd(1))
+	})
+	compressedSize := int64(-1)
+	if hasCompressedSize {
+		compressedSize = int64(d.FieldULEB128("compressed_size"))
+	}
+	if hasUncompressedSize {
+		d.FieldULEB128("uncompressed_size")
+	}
+
+	var filters []filter
+	d.FieldArray("filters", func(d *decode.D) {
+		for i := uint64(0); i < numFilters; i++ {
+			d.FieldStruct("filter", func(d *decode.D) {
+				f := filter{id: d.FieldULEB128("id", filterNames, scalar.UintHex)}
+				propertiesSize := d.FieldULEB128("properties_size")
+				d.FramedFn(int64(propertiesSize)*8, func(d *decode.D) {
+					switch {
+					case f.id == filterLZMA2 && propertiesSize == 1:
+						d.FieldStruct("properties", func(d *decode.D) {
+							d.FieldU2("reserved")
+							dictSizeByte := d.FieldU6("dictionary_size", scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+								dictSize, err := lzma.DictSizeFromLZMA2Byte(byte(s.Actual))
+								if err != nil {
+									return s, err
+								}
+								s.Sym = uint64(dictSize)
+								return s, nil
+							}))
+							f.dictSize, _ = lzma.DictSizeFromLZMA2Byte(byte(dictSizeByte))
+						})
+					case f.id == filterDelta && propertiesSize == 1:
+						f.distance = int(d.FieldU8("distance", scalar.UintActualAdd(1)))
+					case f.id >= filterX86 && f.id <= filterRISCV && propertiesSize == 4:
+						f.startOffset = uint32(d.FieldU32("start_offset"))
+					default:
+						if propertiesSize > 0 {
+							d.FieldRawLen("properties", d.BitsLeft())
+						}
+					}
+				})
+				filters = append(filters, f)
+			})
+		}
+	})
+
+	paddingLen := headerSize - 4 - (d.Pos()-headerStart)/8
+	if paddingLen > 0 {
+		d.FieldRawLen("padding", paddingLen*8, d.BitBufValidateIsZero())
+	}
+	headerCRC := crc32.ChecksumIEEE(d.BytesRange(headerStart, int(headerSize-4)))
+	d.FieldU32("crc32", d.UintValidate(uint64(headerCRC)), scalar.UintHex)
+
+	return filters, compressedSize
+}
+
+// decompressBlock returns uncompressed data and compressed size in bytes,
+// uncompressed is nil if the filter chain is not supported.
+func decompressBlock(d *decode.D, filters []filter, compressedSize int64) ([]byte, int64, error) {
+	if len(filters) == 0 || filters[len(filters)-1].id != filterLZMA2 {
+		return nil, compressedSize, nil
+	}
+
+	nBits := d.BitsLeft()
+	if compressedSize >= 0 {
+		nBits = compressedSize * 8
+	}
+	compressedBR, err := d.TryBitBufRange(d.Pos(), nBits)
+	if err != nil {
+		return nil, compressedSize, err
+	}
+	compressedR := bitio.NewIOReadSeeker(compressedBR)
+	uncompressed, err := io.ReadAll(lzma.NewReader2(compressedR, filters[len(filters)-1].dictSize))
+	if err != nil {
+		return nil, compressedSize, err
+	}
+	readSize, err := compressedR.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, compressedSize, err
+	}
+	if compressedSize < 0 {
+		compressedSize = readSize
+	}
+
+	// filters are applied in reverse order when decoding
+	for i := len(filters) - 2; i >= 0; i-- {
+		f := filters[i]
+		switch f.id {
+		case filterDelta:
+			deltaDecode(uncompressed, f.distance)
+		case filterX86:
+			x86Decode(uncompressed, f.startOffset)
+		default:
+			return nil, compressedSize, nil
+		}
+	}
+
+	return uncompressed, compressedSize, nil
+}
+
+func decodeCheck(d *decode.D, checkType uint64, uncompressed []byte) {
+	checkSize := checkSizes[checkType&0xf]
+	if checkSize == 0 {
+		return
+	}
+	if uncompressed == nil {
+		d.FieldRawLen("check", int64(checkSize)*8)
+		return
+	}
+
+	switch checkType {
+	case checkCRC32:
+		d.FieldU32("check", d.UintValidate(uint64(crc32.ChecksumIEEE(uncompressed))), scalar.UintHex)
+	case checkCRC64:
+		d.FieldU64("check", d.UintValidate(crc64.Checksum(uncompressed, crc64Table)), scalar.UintHex)
+	case checkSHA256:
+		sum := sha256.Sum256(uncompressed)
+		d.FieldRawLen("check", int64(checkSize)*8, d.ValidateBitBuf(sum[:]))
+	default:
+		d.FieldRawLen("check", int64(checkSize)*8)
+	}
+}
+
+func decodeIndex(d *decode.D, blocks []blockInfo) int64 {
+	indexStart := d.Pos()
+
+	d.FieldU8("indicator", d.UintAssert(0))
+	numRecords := d.FieldULEB128("number_of_records", d.UintValidate(uint64(len(blocks))))
+	d.FieldArray("records", func(d *decode.D) {
+		for i := uint64(0); i < numRecords; i++ {
+			d.FieldStruct("record", func(d *decode.D) {
+				if i < uint64(len(blocks)) && blocks[i].uncompressedSize >= 0 {
+					d.FieldULEB128("unpadded_size", d.UintValidate(uint64(blocks[i].unpaddedSize)))
+					d.FieldULEB128("uncompressed_size", d.UintValidate(uint64(blocks[i].uncompressedSize)))
+				} else {
+					d.FieldULEB128("unpadded_size")
+					d.FieldULEB128("uncompressed_size")
+				}
+			})
+		}
+	})
+	if paddingLen := (4 - (d.Pos()-indexStart)/8%4) % 4; paddingLen > 0 {
+		d.FieldRawLen("padding", paddingLen*8, d.BitBufValidateIsZero())
+	}
+	indexLen := (d.Pos() - indexStart) / 8
+	indexCRC := crc32.ChecksumIEEE(d.BytesRange(indexStart, int(indexLen)))
+	d.FieldU32("crc32", d.UintValidate(uint64(indexCRC)), scalar.UintHex)
+
+	return indexLen + 4
+}
+
+// decodeStream returns uncompressed data or false if decoding of a block failed
+// and the end of the stream is unknown
+func decodeStream(d *decode.D) ([]byte, bool) {
+	var checkType uint64
+	d.FieldStruct("header", func(d *decode.D) {
+		d.FieldRawLen("magic", int64(len(headerMagic))*8, d.AssertBitBuf(headerMagic))
+		flagsStart := d.Pos()
+		checkType = decodeStreamFlags(d)
+		d.FieldU32("crc32", d.UintValidate(uint64(crc32.ChecksumIEEE(d.BytesRange(flagsStart, 2)))), scalar.UintHex)
+	})
+
+	var uncompressed []byte
+	var blocks []blockInfo
+	ok := true
+	d.FieldArray("blocks", func(d *decode.D) {
+		// index starts with a zero byte where a block header would have its size
+		for d.PeekUintBits(8) != 0 {
+			d.FieldStruct("block", func(d *decode.D) {
+				blockStart := d.Pos()
+				var filters []filter
+				var compressedSize int64
+				d.FieldStruct("header", func(d *decode.D) {
+					filters, compressedSize = decodeBlockHeader(d)
+				})
+				headerSize := (d.Pos() - blockStart) / 8
+
+				blockUncompressed, compressedSize, err := decompressBlock(d, filters, compressedSize)
+				if compressedSize < 0 {
+					// can't know where block ends
+					d.FieldRawLen("compressed", d.BitsLeft())
+					ok = false
+					return
+				}
+				d.FieldRawLen("compressed", compressedSize*8)
+				if paddingLen := (4 - compressedSize%4) % 4; paddingLen > 0 {
+					d.FieldRawLen("padding", paddingLen*8, d.BitBufValidateIsZero())
+				}
+				if err != nil {
+					blockUncompressed = nil
+				}
+				decodeCheck(d, checkType, blockUncompressed)
+
+				b := blockInfo{
+					unpaddedSize:     headerSize + compressedSize + int64(checkSizes[checkType&0xf]),
+					uncompressedSize: -1,
+				}
+				if blockUncompressed != nil {
+					b.uncompressedSize = int64(len(blockUncompressed))
+					uncompressed = append(uncompressed, blockUncompressed...)
+				}
+				blocks = append(blocks, b)
+			})
+			if !ok {
+				return
+			}
+		}
+	})
+	if !ok {
+		return uncompressed, false
+	}
+
+	var indexSize int64
+	d.FieldStruct("index", func(d *decode.D) {
+		indexSize = decodeIndex(d, blocks)
+	})
+
+	d.FieldStruct("footer", func(d *decode.D) {
+		crcStart := d.Pos() + 32
+		d.FieldU32("crc32", d.UintValidate(uint64(crc32.ChecksumIEEE(d.BytesRange(crcStart, 6)))), scalar.UintHex)
+		d.FieldU32("backward_size", scalar.UintActualFn(func(a uint64) uint64 { return (a + 1) * 4 }), d.UintValidate(uint64(indexSize)))
+		decodeStreamFlags(d)
+		d.FieldRawLen("magic", int64(len(footerMagic))*8, d.AssertBitBuf(footerMagic))
+	})
+
+	return uncompressed, true
+}
+
+func xzDecode(d *decode.D) any {
+	d.Endian = decode.LittleEndian
+
+	var uncompressed []byte
+	streams := 0
+
+	d.FieldArray("streams", func(d *decode.D) {
+		// first stream is required, identification is asserted by stream decode
+		for streams == 0 || (d.BitsLeft() >= int64(len(headerMagic))*8 && bytes.Equal(d.PeekBytes(len(headerMagic)), headerMagic)) {
+			var streamUncompressed []byte
+			ok := true
+			d.FieldStruct("stream", func(d *decode.D) {
+				streamUncompressed, ok = decodeStream(d)
+				if !ok {
+					return
+				}
+				// stream padding is null bytes in multiple of 4
+				paddingLen := int64(0)
+				for d.BitsLeft() >= (paddingLen+4)*8 && bytes.Equal(d.BytesRange(d.Pos()+paddingLen*8, 4), streamPadding) {
+					paddingLen += 4
+				}
+				if paddingLen > 0 {
+					d.FieldRawLen("padding", paddingLen*8)
+				}
+			})
+			uncompressed = append(uncompressed, streamUncompressed...)
+			streams++
+			if !ok {
+				break
+			}
+		}
+	})
+
+	if len(uncompressed) > 0 {
+		uncompressedBR := bitio.NewBitReader(uncompressed, -1)
+		if dv, _, _ := d.TryFieldFormatBitBuf("uncompressed", uncompressedBR, &probeGroup, format.Probe_In{}); dv == nil {
+			d.FieldRootBitBuf("uncompressed", uncompressedBR)
+		}
+	}
+
+	return nil
+}
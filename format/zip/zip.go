@@ -5,11 +5,15 @@ package zip
 
 import (
 	"bytes"
+	"compress/bzip2"
 	"compress/flate"
 	"embed"
+	"fmt"
 	"io"
+	"time"
 
 	"github.com/wader/fq/format"
+	"github.com/wader/fq/internal/lzma"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
 	"github.com/wader/fq/pkg/scalar"
@@ -53,6 +57,7 @@ const (
 	compressionMethodIBMTERSE                  = 18
 	compressionMethodIBMLZ77z                  = 19
 	compressionMethodPPMd                      = 98
+	compressionMethodAEXEncryption             = 99
 )
 
 var compressionMethodMap = scalar.UintMapSymStr{
@@ -71,6 +76,7 @@ var compressionMethodMap = scalar.UintMapSymStr{
 	compressionMethodIBMTERSE:                  "ibmterse",
 	compressionMethodIBMLZ77z:                  "ibmlz77z",
 	compressionMethodPPMd:                      "pp_md",
+	compressionMethodAEXEncryption:             "aex_encryption",
 }
 
 var (
@@ -85,14 +91,18 @@ var (
 )
 
 const (
-	headerIDZip64ExtendedInformation = 0x001
+	headerIDZip64ExtendedInformation = 0x0001
+	headerIDNTFS                     = 0x000a
+	headerIDExtendedTimestamp        = 0x5455
+	headerIDUnixUIDGID               = 0x7875
+	headerIDWinZipAES                = 0x9901
 )
 
 var headerIDMap = scalar.UintMapDescription{
 	headerIDZip64ExtendedInformation: "ZIP64 extended information extra field",
 	0x0007:                           "AV Info",
 	0x0009:                           "OS/2 extended attributes",
-	0x000a:                           "NTFS (Win9x/WinNT FileTimes)",
+	headerIDNTFS:                     "NTFS (Win9x/WinNT FileTimes)",
 	0x000c:                           "OpenVMS",
 	0x000d:                           "Unix",
 	0x000f:                           "Patch Descriptor",
@@ -127,6 +137,7 @@ var headerIDMap = scalar.UintMapDescription{
 	0x756e: "ASi Unix",
 	0x7855: "Info-ZIP Unix (new)",
 	0x7875: "UNIX UID/GID",
+	0x9901: "WinZip AES encryption",
 	0xfb4a: "SMS/QDOS",
 }
 
@@ -143,6 +154,202 @@ func fieldMSDOSDate(d *decode.D) {
 	d.FieldU5("day")
 }
 
+var aesVendorVersionNames = scalar.UintMapSymStr{
+	1: "ae_1",
+	2: "ae_2",
+}
+
+var aesStrengthNames = scalar.UintMapDescription{
+	1: "AES-128",
+	2: "AES-192",
+	3: "AES-256",
+}
+
+// salt length in bytes for each AES strength
+var aesSaltLengths = map[uint64]int64{
+	1: 8,
+	2: 12,
+	3: 16,
+}
+
+const (
+	aesPasswordVerificationLength = 2
+	aesAuthenticationCodeLength   = 10
+	traditionalEncryptionLength   = 12
+)
+
+var ntfsAttributeTagNames = scalar.UintMapDescription{
+	0x0001: "File times",
+}
+
+// NTFS FILETIME, 100ns intervals since 1601-01-01
+var ntfsTimeDescription = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	const unixEpochDiff = 11644473600
+	s.Description = time.Unix(int64(s.Actual/10_000_000)-unixEpochDiff, int64(s.Actual%10_000_000)*100).UTC().Format(time.RFC3339Nano)
+	return s, nil
+})
+
+var lzmaPropertiesDescription = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	p, err := lzma.PropertiesFromByte(byte(s.Actual))
+	if err != nil {
+		return s, err
+	}
+	s.Description = fmt.Sprintf("lc=%d lp=%d pb=%d", p.LC, p.LP, p.PB)
+	return s, nil
+})
+
+type zipFlags struct {
+	strongEncryption bool
+	dataDescriptor   bool
+	compression1     bool
+	encrypted        bool
+}
+
+func fieldFlags(d *decode.D) zipFlags {
+	var f zipFlags
+	d.FieldStruct("flags", func(d *decode.D) {
+		// TODO: 16LE, should have some kind of native endian flag reader helper?
+		d.FieldU1("unused0")
+		f.strongEncryption = d.FieldBool("strong_encryption")
+		d.FieldBool("compressed_patched_data")
+		d.FieldBool("enhanced_deflation")
+		f.dataDescriptor = d.FieldBool("data_descriptor")
+		d.FieldBool("compression0")
+		f.compression1 = d.FieldBool("compression1")
+		f.encrypted = d.FieldBool("encrypted")
+
+		d.FieldU2("reserved0")
+		d.FieldBool("mask_header_values")
+		d.FieldBool("reserved1")
+		d.FieldBool("language_encoding")
+		d.FieldU3("unused1")
+	})
+	return f
+}
+
+// fileInfo are header values that can be overridden by extra fields
+type fileInfo struct {
+	compressedSize   uint64
+	uncompressedSize uint64
+	localFileOffset  uint64
+	diskNrStart      uint64
+	zip64            bool
+	aes              bool
+	aesStrength      uint64
+	aesMethod        uint64
+}
+
+func fieldExtraFields(d *decode.D, length uint64, central bool, fi *fileInfo) {
+	d.FieldArray("extra_fields", func(d *decode.D) {
+		d.FramedFn(int64(length)*8, func(d *decode.D) {
+			for !d.End() {
+				d.FieldStruct("extra_field", func(d *decode.D) {
+					headerID := d.FieldU16("header_id", headerIDMap, scalar.UintHex)
+					dataSize := d.FieldU16("data_size")
+					d.FramedFn(int64(dataSize)*8, func(d *decode.D) {
+						switch headerID {
+						case headerIDZip64ExtendedInformation:
+							fi.zip64 = true
+							// in the central directory a field is only present if the corresponding
+							// header field is 0xffffffff (0xffff for disk number), local header should
+							// always have both sizes. Some writers always include all fields so fallback
+							// to in-order if the size does not add up.
+							inOrder := !central
+							if central {
+								n := uint64(0)
+								for _, v := range []uint64{fi.uncompressedSize, fi.compressedSize, fi.localFileOffset} {
+									if v == 0xffff_ffff {
+										n += 8
+									}
+								}
+								if fi.diskNrStart == 0xffff {
+									n += 4
+								}
+								inOrder = n != dataSize
+							}
+							if !d.End() && (inOrder || fi.uncompressedSize == 0xffff_ffff) {
+								fi.uncompressedSize = d.FieldU64("uncompressed_size")
+							}
+							if !d.End() && (inOrder || fi.compressedSize == 0xffff_ffff) {
+								fi.compressedSize = d.FieldU64("compressed_size")
+							}
+							if !d.End() && (inOrder || fi.localFileOffset == 0xffff_ffff) {
+								fi.localFileOffset = d.FieldU64("relative_offset_of_local_file_header")
+							}
+							if !d.End() && (inOrder || fi.diskNrStart == 0xffff) {
+								fi.diskNrStart = d.FieldU32("disk_number_where_file_starts")
+							}
+						case headerIDNTFS:
+							d.FieldU32("reserved")
+							d.FieldArray("attributes", func(d *decode.D) {
+								for !d.End() {
+									d.FieldStruct("attribute", func(d *decode.D) {
+										tag := d.FieldU16("tag", ntfsAttributeTagNames, scalar.UintHex)
+										size := d.FieldU16("size")
+										d.FramedFn(int64(size)*8, func(d *decode.D) {
+											switch tag {
+											case 0x0001:
+												d.FieldU64("modification_time", ntfsTimeDescription)
+												d.FieldU64("access_time", ntfsTimeDescription)
+												d.FieldU64("creation_time", ntfsTimeDescription)
+											default:
+												d.FieldRawLen("data", d.BitsLeft())
+											}
+										})
+									})
+								}
+							})
+						case headerIDExtendedTimestamp:
+							var hasMTime, hasATime, hasCTime bool
+							d.FieldStruct("flags", func(d *decode.D) {
+								d.FieldU5("unused")
+								hasCTime = d.FieldBool("creation_time")
+								hasATime = d.FieldBool("access_time")
+								hasMTime = d.FieldBool("modification_time")
+							})
+							// central directory only has modification time but flags are same as local header
+							if hasMTime && !d.End() {
+								d.FieldU32("modification_time", scalar.UintActualUnixTime(time.RFC3339))
+							}
+							if hasATime && !d.End() {
+								d.FieldU32("access_time", scalar.UintActualUnixTime(time.RFC3339))
+							}
+							if hasCTime && !d.End() {
+								d.FieldU32("creation_time", scalar.UintActualUnixTime(time.RFC3339))
+							}
+						case headerIDUnixUIDGID:
+							d.FieldU8("version")
+							uidSize := d.FieldU8("uid_size")
+							if uidSize > 8 {
+								d.FieldRawLen("uid", int64(uidSize)*8)
+							} else {
+								d.FieldU("uid", int(uidSize)*8)
+							}
+							gidSize := d.FieldU8("gid_size")
+							if gidSize > 8 {
+								d.FieldRawLen("gid", int64(gidSize)*8)
+							} else {
+								d.FieldU("gid", int(gidSize)*8)
+							}
+						case headerIDWinZipAES:
+							fi.aes = true
+							d.FieldU16("vendor_version", aesVendorVersionNames)
+							d.FieldUTF8("vendor_id", 2)
+							fi.aesStrength = d.FieldU8("strength", aesStrengthNames)
+							fi.aesMethod = d.FieldU16("compression_method", compressionMethodMap)
+						default:
+							d.FieldRawLen("data", int64(dataSize)*8)
+						}
+						if !d.End() {
+							d.FieldRawLen("unknown", d.BitsLeft())
+						}
+					})
+				})
+			}
+		})
+	})
+}
+
 func zipDecode(d *decode.D) any {
 	var zi format.Zip_In
 	d.ArgAs(&zi)
@@ -217,79 +424,37 @@ func zipDecode(d *decode.D) any {
 		})
 	}
 
-	var localFileOffsets []uint64
+	var centralFiles []fileInfo
 
 	d.SeekAbs(int64(offsetCD) * 8)
 	d.FieldArray("central_directories", func(d *decode.D) {
 		d.FramedFn(int64(sizeCD)*8, func(d *decode.D) {
 			for !d.End() {
 				d.FieldStruct("central_directory", func(d *decode.D) {
+					var fi fileInfo
 					d.FieldRawLen("signature", 4*8, d.AssertBitBuf(centralDirectorySignature))
 					d.FieldU16("version_made_by")
 					d.FieldU16("version_needed")
-					d.FieldStruct("flags", func(d *decode.D) {
-						// TODO: 16LE, should have some kind of native endian flag reader helper?
-						d.FieldU1("unused0")
-						d.FieldBool("strong_encryption")
-						d.FieldBool("compressed_patched_data")
-						d.FieldBool("enhanced_deflation")
-						d.FieldBool("data_descriptor")
-						d.FieldBool("compression0")
-						d.FieldBool("compression1")
-						d.FieldBool("encrypted")
-
-						d.FieldU2("reserved0")
-						d.FieldBool("mask_header_values")
-						d.FieldBool("reserved1")
-						d.FieldBool("language_encoding")
-						d.FieldU3("unused1")
-					})
+					fieldFlags(d)
 					d.FieldU16("compression_method", compressionMethodMap)
 					d.FieldStruct("last_modification_date", fieldMSDOSTime)
 					d.FieldStruct("last_modification_time", fieldMSDOSDate)
 					d.FieldU32("crc32_uncompressed", scalar.UintHex)
-					d.FieldU32("compressed_size")
-					d.FieldU32("uncompressed_size")
+					fi.compressedSize = d.FieldU32("compressed_size")
+					fi.uncompressedSize = d.FieldU32("uncompressed_size")
 					fileNameLength := d.FieldU16("file_name_length")
 					extraFieldLength := d.FieldU16("extra_field_length")
 					fileCommentLength := d.FieldU16("file_comment_length")
-					diskNrStart := d.FieldU16("disk_number_where_file_starts")
+					fi.diskNrStart = d.FieldU16("disk_number_where_file_starts")
 					d.FieldU16("internal_file_attributes")
 					d.FieldU32("external_file_attributes")
-					localFileOffset := d.FieldU32("relative_offset_of_local_file_header")
+					fi.localFileOffset = d.FieldU32("relative_offset_of_local_file_header")
 					d.FieldUTF8("file_name", int(fileNameLength))
-					d.FieldArray("extra_fields", func(d *decode.D) {
-						d.FramedFn(int64(extraFieldLength)*8, func(d *decode.D) {
-							for !d.End() {
-								d.FieldStruct("extra_field", func(d *decode.D) {
-									headerID := d.FieldU16("header_id", headerIDMap, scalar.UintHex)
-									dataSize := d.FieldU16("data_size")
-									d.FramedFn(int64(dataSize)*8, func(d *decode.D) {
-										switch headerID {
-										case headerIDZip64ExtendedInformation:
-											d.FieldU64("uncompressed_size")
-											// TODO: spec says these should be here but real zip64 seems to not have them? optional?
-											if !d.End() {
-												d.FieldU64("compressed_size")
-											}
-											if !d.End() {
-												localFileOffset = d.FieldU64("relative_offset_of_local_file_header")
-											}
-											if !d.End() {
-												d.FieldU32("disk_number_where_file_starts")
-											}
-										default:
-											d.FieldRawLen("data", int64(dataSize)*8)
-										}
-									})
-								})
-							}
-						})
-					})
+					fieldExtraFields(d, extraFieldLength, true, &fi)
 					d.FieldUTF8("file_comment", int(fileCommentLength))
 
-					if diskNrStart == diskNr {
-						localFileOffsets = append(localFileOffsets, localFileOffset)
+					if fi.diskNrStart == diskNr {
+						centralFiles = append(centralFiles, fi)
 					}
 				})
 			}
@@ -297,61 +462,31 @@ func zipDecode(d *decode.D) any {
 	})
 
 	d.FieldArray("local_files", func(d *decode.D) {
-		for _, o := range localFileOffsets {
-			d.SeekAbs(int64(o) * 8)
+		for _, cfi := range centralFiles {
+			d.SeekAbs(int64(cfi.localFileOffset) * 8)
 			d.FieldStruct("local_file", func(d *decode.D) {
-				var hasDataDescriptor bool
+				var fi fileInfo
 				d.FieldRawLen("signature", 4*8, d.AssertBitBuf(localFileSignature))
 				d.FieldU16("version_needed")
-				d.FieldStruct("flags", func(d *decode.D) {
-					// TODO: 16LE, should have some kind of native endian flag reader helper?
-					d.FieldU1("unused0")
-					d.FieldBool("strong_encryption")
-					d.FieldBool("compressed_patched_data")
-					d.FieldBool("enhanced_deflation")
-					hasDataDescriptor = d.FieldBool("data_descriptor")
-					d.FieldBool("compression0")
-					d.FieldBool("compression1")
-					d.FieldBool("encrypted")
-
-					d.FieldU2("reserved0")
-					d.FieldBool("mask_header_values")
-					d.FieldBool("reserved1")
-					d.FieldBool("language_encoding")
-					d.FieldU3("unused1")
-				})
+				flags := fieldFlags(d)
 				compressionMethod := d.FieldU16("compression_method", compressionMethodMap)
 				d.FieldStruct("last_modification_date", fieldMSDOSTime)
 				d.FieldStruct("last_modification_time", fieldMSDOSDate)
 				d.FieldU32("crc32_uncompressed", scalar.UintHex)
-				compressedSizeBytes := d.FieldU32("compressed_size")
-				d.FieldU32("uncompressed_size")
+				fi.compressedSize = d.FieldU32("compressed_size")
+				fi.uncompressedSize = d.FieldU32("uncompressed_size")
 				fileNameLength := d.FieldU16("file_name_length")
 				extraFieldLength := d.FieldU16("extra_field_length")
 				d.FieldUTF8("file_name", int(fileNameLength))
-				d.FieldArray("extra_fields", func(d *decode.D) {
-					d.FramedFn(int64(extraFieldLength)*8, func(d *decode.D) {
-						for !d.End() {
-							d.FieldStruct("extra_field", func(d *decode.D) {
-								headerID := d.FieldU16("header_id", headerIDMap, scalar.UintHex)
-								dataSize := d.FieldU16("data_size")
-								d.FramedFn(int64(dataSize)*8, func(d *decode.D) {
-									switch headerID {
-									case headerIDZip64ExtendedInformation:
-										d.FieldU64("uncompressed_size")
-										// TODO: spec says these should be here but real zip64 seems to not have them? optional?
-										if !d.End() {
-											compressedSizeBytes = d.FieldU64("compressed_size")
-										}
-									default:
-										d.FieldRawLen("data", int64(dataSize)*8)
-									}
-								})
-							})
-						}
-					})
-				})
-				compressedSize := int64(compressedSizeBytes) * 8
+				fieldExtraFields(d, extraFieldLength, false, &fi)
+
+				// with a data descriptor sizes in local header are zero, use central directory sizes
+				if flags.dataDescriptor && fi.compressedSize == 0 {
+					fi.compressedSize = cfi.compressedSize
+					fi.uncompressedSize = cfi.uncompressedSize
+				}
+
+				compressedSize := int64(fi.compressedSize) * 8
 				compressedStart := d.Pos()
 
 				compressedLimit := compressedSize
@@ -359,9 +494,29 @@ func zipDecode(d *decode.D) any {
 					compressedLimit = d.BitsLeft()
 				}
 
-				if compressionMethod == compressionMethodNone {
+				switch {
+				case compressionMethod == compressionMethodAEXEncryption && fi.aes:
+					saltLength := aesSaltLengths[fi.aesStrength]
+					d.FieldRawLen("salt", saltLength*8)
+					d.FieldU16("password_verification", scalar.UintHex)
+					encryptedLength := compressedSize - (saltLength+aesPasswordVerificationLength+aesAuthenticationCodeLength)*8
+					if encryptedLength >= 0 {
+						d.FieldRawLen("compressed", encryptedLength)
+						d.FieldRawLen("authentication_code", aesAuthenticationCodeLength*8)
+					}
+				case flags.encrypted && !flags.strongEncryption:
+					// traditional PKWARE encryption, 12 byte encrypted header followed by encrypted data
+					d.FieldRawLen("encryption_header", traditionalEncryptionLength*8)
+					if compressedSize >= traditionalEncryptionLength*8 {
+						d.FieldRawLen("compressed", compressedSize-traditionalEncryptionLength*8)
+					}
+				case flags.encrypted:
+					if compressedSize != 0 {
+						d.FieldRawLen("compressed", compressedSize)
+					}
+				case compressionMethod == compressionMethodNone:
 					d.FieldFormatOrRawLen("uncompressed", compressedSize, &probeGroup, format.Probe_In{})
-				} else {
+				default:
 					var rFn func(r io.Reader) io.Reader
 					if zi.Uncompress {
 						switch compressionMethod {
@@ -369,6 +524,36 @@ func zipDecode(d *decode.D) any {
 							// bitio.NewIOReadSeeker implements io.ByteReader so that deflate don't do own
 							// buffering and might read more than needed messing up knowing compressed size
 							rFn = func(r io.Reader) io.Reader { return flate.NewReader(r) }
+						case compressionMethodBzip2:
+							rFn = func(r io.Reader) io.Reader { return bzip2.NewReader(r) }
+						case compressionMethodLZMA:
+							var propsByte uint64
+							var dictSize uint64
+							d.FieldStruct("lzma_header", func(d *decode.D) {
+								d.FieldU8("major_version")
+								d.FieldU8("minor_version")
+								propertiesSize := d.FieldU16("properties_size")
+								d.FramedFn(int64(propertiesSize)*8, func(d *decode.D) {
+									propsByte = d.FieldU8("properties", lzmaPropertiesDescription)
+									dictSize = d.FieldU32("dictionary_size")
+								})
+							})
+							headerSize := d.Pos() - compressedStart
+							compressedLimit -= headerSize
+							if compressedSize != 0 {
+								compressedSize -= headerSize
+							}
+
+							props, err := lzma.PropertiesFromByte(byte(propsByte))
+							if err != nil {
+								break
+							}
+							// compression1 flag (bit 1) means that data ends with an end marker
+							unpackSize := int64(fi.uncompressedSize)
+							if flags.compression1 {
+								unpackSize = -1
+							}
+							rFn = func(r io.Reader) io.Reader { return lzma.NewReader(r, props, uint32(dictSize), unpackSize) }
 						}
 					}
 
@@ -382,24 +567,31 @@ func zipDecode(d *decode.D) any {
 							compressedSize = readCompressedSize
 						}
 						d.FieldRawLen("compressed", compressedSize)
-
 					} else {
 						if compressedSize != 0 {
 							d.FieldRawLen("compressed", compressedSize)
 						}
 					}
+					// include possible lzma header
+					compressedSize = d.Pos() - compressedStart
 				}
 
 				d.SeekAbs(compressedStart + compressedSize)
 
-				if hasDataDescriptor {
+				if flags.dataDescriptor {
 					d.FieldStruct("data_indicator", func(d *decode.D) {
 						if bytes.Equal(d.PeekBytes(4), dataIndicatorSignature) {
 							d.FieldRawLen("signature", 4*8, d.AssertBitBuf(dataIndicatorSignature))
 						}
 						d.FieldU32("crc32_uncompressed", scalar.UintHex)
-						d.FieldU32("compressed_size")
-						d.FieldU32("uncompressed_size")
+						// sizes are 8 bytes if the entry uses zip64
+						if fi.zip64 {
+							d.FieldU64("compressed_size")
+							d.FieldU64("uncompressed_size")
+						} else {
+							d.FieldU32("compressed_size")
+							d.FieldU32("uncompressed_size")
+						}
 					})
 				}
 			})
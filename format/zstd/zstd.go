@@ -0,0 +1,364 @@
+package zstd
+
+// https://www.rfc-editor.org/rfc/rfc8878
+
+import (
+	"embed"
+	"encoding/binary"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/internal/xxhash"
+	"github.com/wader/fq/internal/zstd"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed zstd.md
+var zstdFS embed.FS
+
+var probeGroup decode.Group
+
+func init() {
+	interp.RegisterFormat(
+		format.Zstd,
+		&decode.Format{
+			Description: "Zstandard compression",
+			Groups:      []*decode.Group{format.Probe},
+			DecodeFn:    zstdDecode,
+			Dependencies: []decode.Dependency{
+				{Groups: []*decode.Group{format.Probe}, Out: &probeGroup},
+			},
+		})
+	interp.RegisterFS(zstdFS)
+}
+
+var blockTypeNames = scalar.UintMapSymStr{
+	zstd.BlockRaw:        "raw",
+	zstd.BlockRLE:        "rle",
+	zstd.BlockCompressed: "compressed",
+	zstd.BlockReserved:   "reserved",
+}
+
+var literalsBlockTypeNames = scalar.UintMapSymStr{
+	zstd.LiteralsRaw:        "raw",
+	zstd.LiteralsRLE:        "rle",
+	zstd.LiteralsCompressed: "compressed",
+	zstd.LiteralsTreeless:   "treeless",
+}
+
+var compressionModeNames = scalar.UintMapSymStr{
+	zstd.ModePredefined: "predefined",
+	zstd.ModeRLE:        "rle",
+	zstd.ModeFSE:        "fse_compressed",
+	zstd.ModeRepeat:     "repeat",
+}
+
+var sequenceKindNames = [3]string{
+	zstd.LiteralLengths: "literal_lengths",
+	zstd.Offsets:        "offsets",
+	zstd.MatchLengths:   "match_lengths",
+}
+
+func isSkippableMagic(m uint64) bool { return m&zstd.SkippableMagicMask == zstd.SkippableMagic }
+
+func decodeLiteralsSection(d *decode.D, li zstd.LiteralsInfo) {
+	d.FieldStruct("header", func(d *decode.D) {
+		// sizes are little endian bit fields so first byte has lower bits
+		if li.Type == zstd.LiteralsRaw || li.Type == zstd.LiteralsRLE {
+			if li.HeaderSize == 1 {
+				d.FieldU5("regenerated_size")
+				d.FieldU1("size_format")
+				d.FieldU2("literals_block_type", literalsBlockTypeNames)
+				return
+			}
+			regeneratedSize0 := d.FieldU4("regenerated_size0")
+			d.FieldU2("size_format")
+			d.FieldU2("literals_block_type", literalsBlockTypeNames)
+			regeneratedSize1 := d.FieldU("regenerated_size1", (li.HeaderSize-1)*8)
+			d.FieldValueUint("regenerated_size", regeneratedSize0|regeneratedSize1<<4)
+			return
+		}
+		d.FieldU4("regenerated_size0")
+		d.FieldU2("size_format")
+		d.FieldU2("literals_block_type", literalsBlockTypeNames)
+		d.FieldU("sizes", (li.HeaderSize-1)*8)
+		d.FieldValueUint("regenerated_size", uint64(li.RegeneratedSize))
+		d.FieldValueUint("compressed_size", uint64(li.CompressedSize))
+		d.FieldValueUint("number_of_streams", uint64(li.Streams))
+	})
+
+	switch li.Type {
+	case zstd.LiteralsRaw:
+		d.FieldRawLen("literals", int64(li.RegeneratedSize)*8)
+		return
+	case zstd.LiteralsRLE:
+		d.FieldU8("byte", scalar.UintHex)
+		return
+	}
+
+	streamsSize := int64(li.CompressedSize)
+	if li.Type == zstd.LiteralsCompressed {
+		d.FieldStruct("huffman_tree", func(d *decode.D) {
+			header := d.FieldU8("header")
+			if header < 128 {
+				d.FieldRawLen("compressed_weights", int64(header)*8)
+			} else {
+				d.FieldValueUint("number_of_weights", header-127)
+				d.FieldRawLen("weights", int64(li.HuffmanTreeSize-1)*8)
+			}
+		})
+		streamsSize -= int64(li.HuffmanTreeSize)
+	}
+
+	if li.Streams == 1 {
+		d.FieldRawLen("stream", streamsSize*8)
+		return
+	}
+	var sizes [4]int64
+	d.FieldStruct("jump_table", func(d *decode.D) {
+		sizes[0] = int64(d.FieldU16("stream1_size"))
+		sizes[1] = int64(d.FieldU16("stream2_size"))
+		sizes[2] = int64(d.FieldU16("stream3_size"))
+	})
+	sizes[3] = streamsSize - 6 - sizes[0] - sizes[1] - sizes[2]
+	d.FieldArray("streams", func(d *decode.D) {
+		for _, s := range sizes {
+			d.FieldRawLen("stream", s*8)
+		}
+	})
+}
+
+func decodeSequencesSection(d *decode.D, si zstd.SequencesInfo) {
+	d.FieldStruct("header", func(d *decode.D) {
+		switch b0 := d.PeekUintBits(8); {
+		case b0 < 128:
+			d.FieldU8("number_of_sequences")
+		case b0 < 255:
+			d.FieldU16BE("number_of_sequences", scalar.UintActualFn(func(a uint64) uint64 { return a - 0x8000 }))
+		default:
+			d.FieldU24("number_of_sequences", scalar.UintActualFn(func(a uint64) uint64 { return a>>8 + 0x7f00 }))
+		}
+		if si.Count == 0 {
+			return
+		}
+		d.FieldStruct("compression_modes", func(d *decode.D) {
+			d.FieldU2("literal_lengths_mode", compressionModeNames)
+			d.FieldU2("offsets_mode", compressionModeNames)
+			d.FieldU2("match_lengths_mode", compressionModeNames)
+			d.FieldU2("reserved", d.UintValidate(0))
+		})
+	})
+	if si.Count == 0 {
+		return
+	}
+
+	for kind, mode := range si.Modes {
+		switch mode {
+		case zstd.ModeRLE:
+			d.FieldU8(sequenceKindNames[kind] + "_code")
+		case zstd.ModeFSE:
+			d.FieldRawLen(sequenceKindNames[kind]+"_table", int64(si.TableSizes[kind])*8)
+		}
+	}
+	d.FieldRawLen("bitstream", int64(si.BitstreamSize)*8)
+}
+
+func decodeBlock(d *decode.D, dec *zstd.Decoder) (bool, bool) {
+	var blockType uint64
+	var blockSize uint64
+	var lastBlock bool
+	d.FieldStruct("header", func(d *decode.D) {
+		// block size is not contiguous bits
+		blockSize0 := d.FieldU5("block_size0")
+		blockType = d.FieldU2("block_type", blockTypeNames)
+		lastBlock = d.FieldBool("last_block")
+		blockSize1 := d.FieldU16("block_size1")
+		blockSize = blockSize0 | blockSize1<<5
+		d.FieldValueUint("block_size", blockSize)
+	})
+	if blockSize > zstd.MaxBlockSize {
+		d.Fatalf("block size %d larger than maximum %d", blockSize, zstd.MaxBlockSize)
+	}
+
+	outStart := 0
+	if dec != nil {
+		outStart = len(dec.Bytes())
+	}
+	var bi zstd.BlockInfo
+	ok := dec != nil
+
+	switch blockType {
+	case zstd.BlockRaw:
+		if ok {
+			ok = dec.RawBlock(d.BytesRange(d.Pos(), int(blockSize))) == nil
+		}
+		d.FieldRawLen("data", int64(blockSize)*8)
+	case zstd.BlockRLE:
+		b := d.FieldU8("byte", scalar.UintHex)
+		if ok {
+			ok = dec.RLEBlock(byte(b), int(blockSize)) == nil
+		}
+	case zstd.BlockCompressed:
+		if ok {
+			var err error
+			bi, err = dec.CompressedBlock(d.BytesRange(d.Pos(), int(blockSize)))
+			ok = err == nil
+		}
+		if !ok {
+			d.FieldRawLen("data", int64(blockSize)*8)
+			break
+		}
+		d.FramedFn(int64(blockSize)*8, func(d *decode.D) {
+			d.FieldStruct("literals_section", func(d *decode.D) { decodeLiteralsSection(d, bi.Literals) })
+			d.FieldStruct("sequences_section", func(d *decode.D) { decodeSequencesSection(d, bi.Sequences) })
+		})
+	default:
+		d.Fatalf("reserved block type")
+	}
+
+	if ok {
+		d.FieldStruct("statistics", func(d *decode.D) {
+			d.FieldValueUint("decompressed_size", uint64(len(dec.Bytes())-outStart))
+			if blockType == zstd.BlockCompressed {
+				d.FieldValueUint("literal_bytes", uint64(bi.LiteralBytes))
+				d.FieldValueUint("match_bytes", uint64(bi.MatchBytes))
+				d.FieldValueUint("max_offset", uint64(bi.MaxOffset))
+			}
+		})
+	}
+
+	return lastBlock, ok
+}
+
+// decodeFrame returns uncompressed data and false if it could not be decompressed
+func decodeFrame(d *decode.D) ([]byte, bool) {
+	d.FieldU32("magic", d.UintAssert(zstd.FrameMagic), scalar.UintHex)
+
+	var hasChecksum bool
+	var dictionaryID uint64
+	d.FieldStruct("header", func(d *decode.D) {
+		var fcsFlag uint64
+		var singleSegment bool
+		var dictIDFlag uint64
+		d.FieldStruct("descriptor", func(d *decode.D) {
+			fcsFlag = d.FieldU2("frame_content_size_flag")
+			singleSegment = d.FieldBool("single_segment")
+			d.FieldU1("unused")
+			d.FieldU1("reserved", d.UintValidate(0))
+			hasChecksum = d.FieldBool("content_checksum")
+			dictIDFlag = d.FieldU2("dictionary_id_flag")
+		})
+		if !singleSegment {
+			d.FieldStruct("window_descriptor", func(d *decode.D) {
+				exponent := d.FieldU5("exponent")
+				mantissa := d.FieldU3("mantissa")
+				windowBase := uint64(1) << (10 + exponent)
+				d.FieldValueUint("window_size", windowBase+windowBase/8*mantissa)
+			})
+		}
+		if dictIDFlag != 0 {
+			dictionaryID = d.FieldU("dictionary_id", [4]int{0, 8, 16, 32}[dictIDFlag])
+		}
+		switch {
+		case fcsFlag == 0 && singleSegment:
+			d.FieldU8("frame_content_size")
+		case fcsFlag == 1:
+			d.FieldU16("frame_content_size", scalar.UintActualAdd(256))
+		case fcsFlag == 2:
+			d.FieldU32("frame_content_size")
+		case fcsFlag == 3:
+			d.FieldU64("frame_content_size")
+		}
+	})
+
+	// dictionaries are not supported
+	var dec *zstd.Decoder
+	if dictionaryID == 0 {
+		dec = zstd.NewDecoder()
+	}
+	d.FieldArray("blocks", func(d *decode.D) {
+		for {
+			var lastBlock, ok bool
+			d.FieldStruct("block", func(d *decode.D) {
+				lastBlock, ok = decodeBlock(d, dec)
+			})
+			if !ok {
+				dec = nil
+			}
+			if lastBlock {
+				break
+			}
+		}
+	})
+
+	if hasChecksum {
+		if dec != nil {
+			// lower 32 bits of xxhash64
+			d.FieldU32("content_checksum", d.UintValidate(xxhash.Sum64(dec.Bytes(), 0)&0xffff_ffff), scalar.UintHex)
+		} else {
+			d.FieldU32("content_checksum", scalar.UintHex)
+		}
+	}
+
+	if dec == nil {
+		return nil, false
+	}
+	return dec.Bytes(), true
+}
+
+func decodeSkippableFrame(d *decode.D) {
+	d.FieldU32("magic", d.UintValidateRange(zstd.SkippableMagic, zstd.SkippableMagic+0xf), scalar.UintHex)
+	size := d.FieldU32("frame_size")
+	d.FieldRawLen("user_data", int64(size)*8)
+}
+
+func zstdDecode(d *decode.D) any {
+	d.Endian = decode.LittleEndian
+
+	var uncompressed []byte
+	frames := 0
+	skippableFrames := 0
+	ok := true
+
+	d.FieldArray("frames", func(d *decode.D) {
+		for frames == 0 || d.BitsLeft() >= 4*8 {
+			magic := uint64(binary.LittleEndian.Uint32(d.PeekBytes(4)))
+			if frames > 0 && magic != zstd.FrameMagic && !isSkippableMagic(magic) {
+				break
+			}
+			if isSkippableMagic(magic) {
+				d.FieldStruct("frame", decodeSkippableFrame)
+				skippableFrames++
+			} else {
+				var frameUncompressed []byte
+				frameOK := false
+				d.FieldStruct("frame", func(d *decode.D) {
+					frameUncompressed, frameOK = decodeFrame(d)
+				})
+				// each frame is limited, also limit all frames together
+				if !frameOK || len(uncompressed)+len(frameUncompressed) > zstd.MaxOutputSize {
+					ok = false
+				}
+				if ok {
+					uncompressed = append(uncompressed, frameUncompressed...)
+				}
+			}
+			frames++
+		}
+	})
+	// skippable frames alone are not zstd, lz4 uses the same magic range
+	if frames == skippableFrames {
+		d.Fatalf("no frames found")
+	}
+
+	if ok && len(uncompressed) > 0 {
+		uncompressedBR := bitio.NewBitReader(uncompressed, -1)
+		if dv, _, _ := d.TryFieldFormatBitBuf("uncompressed", uncompressedBR, &probeGroup, format.Probe_In{}); dv == nil {
+			d.FieldRootBitBuf("uncompressed", uncompressedBR)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,461 @@
+// Package lzma implements a LZMA decoder
+//
+// Based on the reference decoder in the LZMA SDK (DOC/lzma-specification.txt)
+// https://www.7-zip.org/sdk.html
+//
+// Whole output is kept in memory so the "dictionary" is just the output
+// buffer, dictionary size is only used to validate distances.
+package lzma
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrCorrupted = errors.New("lzma: corrupted data")
+
+const (
+	numBitModelTotalBits = 11
+	bitModelTotal        = 1 << numBitModelTotalBits
+	numMoveBits          = 5
+	probInit             = bitModelTotal / 2
+
+	numStates          = 12
+	numPosBitsMax      = 4
+	numLenToPosStates  = 4
+	numAlignBits       = 4
+	startPosModelIndex = 4
+	endPosModelIndex   = 14
+	numFullDistances   = 1 << (endPosModelIndex >> 1)
+	matchMinLen        = 2
+)
+
+// Properties are the lc/lp/pb literal and position parameters.
+type Properties struct {
+	LC int
+	LP int
+	PB int
+}
+
+// PropertiesFromByte decodes a (pb * 5 + lp) * 9 + lc properties byte.
+func PropertiesFromByte(b byte) (Properties, error) {
+	if b >= 9*5*5 {
+		return Properties{}, fmt.Errorf("lzma: invalid properties byte %d", b)
+	}
+	v := int(b)
+	return Properties{LC: v % 9, LP: (v / 9) % 5, PB: v / 45}, nil
+}
+
+type rangeDecoder struct {
+	br   io.ByteReader
+	rng  uint32
+	code uint32
+	err  error
+}
+
+func (rd *rangeDecoder) readByte() byte {
+	b, err := rd.br.ReadByte()
+	if err != nil && rd.err == nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		rd.err = err
+	}
+	return b
+}
+
+func (rd *rangeDecoder) init(br io.ByteReader) error {
+	rd.br = br
+	rd.rng = 0xffff_ffff
+	rd.code = 0
+	rd.err = nil
+	if rd.readByte() != 0 {
+		return ErrCorrupted
+	}
+	for i := 0; i < 4; i++ {
+		rd.code = rd.code<<8 | uint32(rd.readByte())
+	}
+	if rd.code == rd.rng {
+		return ErrCorrupted
+	}
+	return rd.err
+}
+
+func (rd *rangeDecoder) finishedOK() bool { return rd.code == 0 }
+
+func (rd *rangeDecoder) normalize() {
+	if rd.rng < 1<<24 {
+		rd.rng <<= 8
+		rd.code = rd.code<<8 | uint32(rd.readByte())
+	}
+}
+
+func (rd *rangeDecoder) decodeBit(p *uint16) uint32 {
+	v := uint32(*p)
+	bound := (rd.rng >> numBitModelTotalBits) * v
+	var symbol uint32
+	if rd.code < bound {
+		v += (bitModelTotal - v) >> numMoveBits
+		rd.rng = bound
+		symbol = 0
+	} else {
+		v -= v >> numMoveBits
+		rd.code -= bound
+		rd.rng -= bound
+		symbol = 1
+	}
+	*p = uint16(v)
+	rd.normalize()
+	return symbol
+}
+
+func (rd *rangeDecoder) decodeDirectBits(numBits int) uint32 {
+	var res uint32
+	for ; numBits > 0; numBits-- {
+		rd.rng >>= 1
+		rd.code -= rd.rng
+		t := 0 - (rd.code >> 31)
+		rd.code += rd.rng & t
+		rd.normalize()
+		res = res<<1 + t + 1
+	}
+	return res
+}
+
+func initProbs(ps []uint16) {
+	for i := range ps {
+		ps[i] = probInit
+	}
+}
+
+func bitTreeDecode(probs []uint16, numBits int, rd *rangeDecoder) uint32 {
+	m := uint32(1)
+	for i := 0; i < numBits; i++ {
+		m = m<<1 + rd.decodeBit(&probs[m])
+	}
+	return m - (1 << numBits)
+}
+
+func bitTreeReverseDecode(probs []uint16, numBits int, rd *rangeDecoder) uint32 {
+	m := uint32(1)
+	var symbol uint32
+	for i := 0; i < numBits; i++ {
+		bit := rd.decodeBit(&probs[m])
+		m = m<<1 + bit
+		symbol |= bit << i
+	}
+	return symbol
+}
+
+type lenDecoder struct {
+	choice  uint16
+	choice2 uint16
+	low     [1 << numPosBitsMax][1 << 3]uint16
+	mid     [1 << numPosBitsMax][1 << 3]uint16
+	high    [1 << 8]uint16
+}
+
+func (ld *lenDecoder) init() {
+	ld.choice = probInit
+	ld.choice2 = probInit
+	initProbs(ld.high[:])
+	for i := range ld.low {
+		initProbs(ld.low[i][:])
+		initProbs(ld.mid[i][:])
+	}
+}
+
+func (ld *lenDecoder) decode(rd *rangeDecoder, posState uint32) uint32 {
+	if rd.decodeBit(&ld.choice) == 0 {
+		return bitTreeDecode(ld.low[posState][:], 3, rd)
+	}
+	if rd.decodeBit(&ld.choice2) == 0 {
+		return 8 + bitTreeDecode(ld.mid[posState][:], 3, rd)
+	}
+	return 16 + bitTreeDecode(ld.high[:], 8, rd)
+}
+
+// decoder is the LZMA state that can be kept between LZMA2 chunks
+type decoder struct {
+	props    Properties
+	dictSize uint32
+	out      []byte
+	// start of current dictionary in out, reset by LZMA2
+	dictStart int
+
+	litProbs    []uint16
+	posSlot     [numLenToPosStates][1 << 6]uint16
+	posDecoders [1 + numFullDistances - endPosModelIndex]uint16
+	align       [1 << numAlignBits]uint16
+	isMatch     [numStates << numPosBitsMax]uint16
+	isRep       [numStates]uint16
+	isRepG0     [numStates]uint16
+	isRepG1     [numStates]uint16
+	isRepG2     [numStates]uint16
+	isRep0Long  [numStates << numPosBitsMax]uint16
+	lenDecoder  lenDecoder
+	repLenDec   lenDecoder
+
+	state                  uint32
+	rep0, rep1, rep2, rep3 uint32
+}
+
+func (d *decoder) resetState(props Properties) {
+	d.props = props
+	n := 0x300 << (props.LC + props.LP)
+	if cap(d.litProbs) >= n {
+		d.litProbs = d.litProbs[:n]
+	} else {
+		d.litProbs = make([]uint16, n)
+	}
+	initProbs(d.litProbs)
+	for i := range d.posSlot {
+		initProbs(d.posSlot[i][:])
+	}
+	initProbs(d.posDecoders[:])
+	initProbs(d.align[:])
+	initProbs(d.isMatch[:])
+	initProbs(d.isRep[:])
+	initProbs(d.isRepG0[:])
+	initProbs(d.isRepG1[:])
+	initProbs(d.isRepG2[:])
+	initProbs(d.isRep0Long[:])
+	d.lenDecoder.init()
+	d.repLenDec.init()
+	d.state = 0
+	d.rep0, d.rep1, d.rep2, d.rep3 = 0, 0, 0, 0
+}
+
+func (d *decoder) dictLen() int { return len(d.out) - d.dictStart }
+
+func (d *decoder) getByte(dist uint32) byte {
+	return d.out[len(d.out)-int(dist)]
+}
+
+func (d *decoder) decodeLiteral(rd *rangeDecoder) {
+	var prevByte uint32
+	if d.dictLen() > 0 {
+		prevByte = uint32(d.getByte(1))
+	}
+	totalPos := uint32(d.dictLen())
+	litState := ((totalPos & ((1 << d.props.LP) - 1)) << d.props.LC) + (prevByte >> (8 - d.props.LC))
+	probs := d.litProbs[0x300*litState:]
+
+	symbol := uint32(1)
+	if d.state >= 7 {
+		matchByte := uint32(d.getByte(d.rep0 + 1))
+		for symbol < 0x100 {
+			matchBit := (matchByte >> 7) & 1
+			matchByte <<= 1
+			bit := rd.decodeBit(&probs[((1+matchBit)<<8)+symbol])
+			symbol = symbol<<1 | bit
+			if matchBit != bit {
+				break
+			}
+		}
+	}
+	for symbol < 0x100 {
+		symbol = symbol<<1 | rd.decodeBit(&probs[symbol])
+	}
+	d.out = append(d.out, byte(symbol-0x100))
+}
+
+func (d *decoder) decodeDistance(rd *rangeDecoder, l uint32) uint32 {
+	lenState := l
+	if lenState > numLenToPosStates-1 {
+		lenState = numLenToPosStates - 1
+	}
+	posSlot := bitTreeDecode(d.posSlot[lenState][:], 6, rd)
+	if posSlot < startPosModelIndex {
+		return posSlot
+	}
+	numDirectBits := int(posSlot>>1) - 1
+	dist := (2 | (posSlot & 1)) << numDirectBits
+	if posSlot < endPosModelIndex {
+		dist += bitTreeReverseDecode(d.posDecoders[dist-posSlot:], numDirectBits, rd)
+	} else {
+		dist += rd.decodeDirectBits(numDirectBits-numAlignBits) << numAlignBits
+		dist += bitTreeReverseDecode(d.align[:], numAlignBits, rd)
+	}
+	return dist
+}
+
+func stateUpdateLiteral(s uint32) uint32 {
+	switch {
+	case s < 4:
+		return 0
+	case s < 10:
+		return s - 3
+	default:
+		return s - 6
+	}
+}
+
+func stateUpdate(s uint32, lt7 uint32, ge7 uint32) uint32 {
+	if s < 7 {
+		return lt7
+	}
+	return ge7
+}
+
+// decode decodes until unpackSize bytes has been produced or, if unpackSize is -1
+// or allowEOS is true, an end marker is found.
+func (d *decoder) decode(rd *rangeDecoder, unpackSize int64, allowEOS bool) error {
+	sizeDefined := unpackSize >= 0
+	pbMask := uint32(1)<<d.props.PB - 1
+
+	for {
+		if rd.err != nil {
+			return rd.err
+		}
+		if sizeDefined && unpackSize == 0 && (!allowEOS || rd.finishedOK()) {
+			if !rd.finishedOK() {
+				return ErrCorrupted
+			}
+			return nil
+		}
+
+		posState := uint32(d.dictLen()) & pbMask
+		if rd.decodeBit(&d.isMatch[d.state<<numPosBitsMax+posState]) == 0 {
+			if sizeDefined && unpackSize == 0 {
+				return ErrCorrupted
+			}
+			d.decodeLiteral(rd)
+			d.state = stateUpdateLiteral(d.state)
+			unpackSize--
+			continue
+		}
+
+		var l uint32
+		if rd.decodeBit(&d.isRep[d.state]) != 0 {
+			if sizeDefined && unpackSize == 0 {
+				return ErrCorrupted
+			}
+			if d.dictLen() == 0 {
+				return ErrCorrupted
+			}
+			if rd.decodeBit(&d.isRepG0[d.state]) == 0 {
+				if rd.decodeBit(&d.isRep0Long[d.state<<numPosBitsMax+posState]) == 0 {
+					d.state = stateUpdate(d.state, 9, 11)
+					d.out = append(d.out, d.getByte(d.rep0+1))
+					unpackSize--
+					continue
+				}
+			} else {
+				var dist uint32
+				if rd.decodeBit(&d.isRepG1[d.state]) == 0 {
+					dist = d.rep1
+				} else {
+					if rd.decodeBit(&d.isRepG2[d.state]) == 0 {
+						dist = d.rep2
+					} else {
+						dist = d.rep3
+						d.rep3 = d.rep2
+					}
+					d.rep2 = d.rep1
+				}
+				d.rep1 = d.rep0
+				d.rep0 = dist
+			}
+			l = d.repLenDec.decode(rd, posState)
+			d.state = stateUpdate(d.state, 8, 11)
+		} else {
+			d.rep3 = d.rep2
+			d.rep2 = d.rep1
+			d.rep1 = d.rep0
+			l = d.lenDecoder.decode(rd, posState)
+			d.state = stateUpdate(d.state, 7, 10)
+			d.rep0 = d.decodeDistance(rd, l)
+			if d.rep0 == 0xffff_ffff {
+				if !allowEOS || (sizeDefined && unpackSize != 0) || !rd.finishedOK() {
+					return ErrCorrupted
+				}
+				return rd.err
+			}
+			if sizeDefined && unpackSize == 0 {
+				return ErrCorrupted
+			}
+			if d.rep0 >= d.dictSize || int(d.rep0) >= d.dictLen() {
+				return ErrCorrupted
+			}
+		}
+
+		l += matchMinLen
+		truncated := false
+		if sizeDefined && unpackSize < int64(l) {
+			l = uint32(unpackSize)
+			truncated = true
+		}
+		// byte by byte as source and destination can overlap
+		src := len(d.out) - int(d.rep0) - 1
+		for i := 0; i < int(l); i++ {
+			d.out = append(d.out, d.out[src+i])
+		}
+		unpackSize -= int64(l)
+		if truncated {
+			return ErrCorrupted
+		}
+	}
+}
+
+type byteReader struct {
+	r   io.Reader
+	buf [1]byte
+}
+
+func (br *byteReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(br.r, br.buf[:])
+	return br.buf[0], err
+}
+
+// asByteReader makes sure reads are done one byte at a time if r is not
+// already a io.ByteReader so that nothing after the compressed data is consumed
+func asByteReader(r io.Reader) io.ByteReader {
+	if br, ok := r.(io.ByteReader); ok {
+		return br
+	}
+	return &byteReader{r: r}
+}
+
+type reader struct {
+	fn  func() ([]byte, error)
+	buf []byte
+	err error
+	ran bool
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if !r.ran {
+		r.ran = true
+		r.buf, r.err = r.fn()
+		if r.err == nil {
+			r.err = io.EOF
+		}
+	}
+	if len(r.buf) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// NewReader returns a reader decompressing LZMA data from r.
+// unpackSize is the uncompressed size or -1 if unknown in which case an end
+// marker is required. An end marker is also accepted when size is known.
+func NewReader(r io.Reader, props Properties, dictSize uint32, unpackSize int64) io.Reader {
+	return &reader{fn: func() ([]byte, error) {
+		d := &decoder{dictSize: dictSize}
+		if d.dictSize < 1<<12 {
+			d.dictSize = 1 << 12
+		}
+		d.resetState(props)
+		var rd rangeDecoder
+		if err := rd.init(asByteReader(r)); err != nil {
+			return nil, err
+		}
+		err := d.decode(&rd, unpackSize, true)
+		return d.out, err
+	}}
+}
@@ -0,0 +1,139 @@
+package lzma
+
+// LZMA2 is a chunked container for LZMA data used by xz, each chunk can reset
+// dictionary, state and properties or be stored uncompressed.
+// https://github.com/tukaani-project/xz/blob/master/src/liblzma/lzma/lzma2_decoder.c
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// DictSizeFromLZMA2Byte decodes the dictionary size property byte used by xz
+// for the LZMA2 filter.
+func DictSizeFromLZMA2Byte(b byte) (uint32, error) {
+	switch {
+	case b > 40:
+		return 0, fmt.Errorf("lzma: invalid lzma2 dictionary size byte %d", b)
+	case b == 40:
+		return 0xffff_ffff, nil
+	default:
+		return (2 | uint32(b)&1) << (b/2 + 11), nil
+	}
+}
+
+func readLZMA2(r io.Reader, dictSize uint32) ([]byte, error) {
+	br := asByteReader(r)
+	d := &decoder{dictSize: dictSize}
+	if d.dictSize < 1<<12 {
+		d.dictSize = 1 << 12
+	}
+
+	readU16 := func() (int, error) {
+		hi, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		lo, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		return int(hi)<<8 | int(lo), nil
+	}
+
+	needDictReset := true
+	needProps := true
+	for {
+		control, err := br.ReadByte()
+		if err != nil {
+			return d.out, io.ErrUnexpectedEOF
+		}
+
+		switch {
+		case control == 0x00:
+			return d.out, nil
+		case control == 0x01 || control == 0x02:
+			// uncompressed chunk, 0x01 also resets dictionary
+			if control == 0x01 {
+				d.dictStart = len(d.out)
+				needDictReset = false
+			} else if needDictReset {
+				return d.out, ErrCorrupted
+			}
+			size, err := readU16()
+			if err != nil {
+				return d.out, io.ErrUnexpectedEOF
+			}
+			for i := 0; i < size+1; i++ {
+				b, err := br.ReadByte()
+				if err != nil {
+					return d.out, io.ErrUnexpectedEOF
+				}
+				d.out = append(d.out, b)
+			}
+		case control >= 0x80:
+			// 0 nothing, 1 state, 2 state and properties, 3 everything reset
+			reset := (control >> 5) & 0x3
+			unpackSize, err := readU16()
+			if err != nil {
+				return d.out, io.ErrUnexpectedEOF
+			}
+			unpackSize |= int(control&0x1f) << 16
+			packSize, err := readU16()
+			if err != nil {
+				return d.out, io.ErrUnexpectedEOF
+			}
+
+			if reset == 3 {
+				d.dictStart = len(d.out)
+				needDictReset = false
+			} else if needDictReset {
+				return d.out, ErrCorrupted
+			}
+			props := d.props
+			if reset >= 2 {
+				b, err := br.ReadByte()
+				if err != nil {
+					return d.out, io.ErrUnexpectedEOF
+				}
+				if props, err = PropertiesFromByte(b); err != nil {
+					return d.out, err
+				}
+				if props.LC+props.LP > 4 {
+					return d.out, ErrCorrupted
+				}
+				needProps = false
+			} else if needProps {
+				return d.out, ErrCorrupted
+			}
+			if reset >= 1 {
+				d.resetState(props)
+			}
+
+			packed := make([]byte, packSize+1)
+			for i := range packed {
+				if packed[i], err = br.ReadByte(); err != nil {
+					return d.out, io.ErrUnexpectedEOF
+				}
+			}
+			var rd rangeDecoder
+			if err := rd.init(bytes.NewReader(packed)); err != nil {
+				return d.out, err
+			}
+			if err := d.decode(&rd, int64(unpackSize)+1, false); err != nil {
+				return d.out, err
+			}
+		default:
+			return d.out, ErrCorrupted
+		}
+	}
+}
+
+// NewReader2 returns a reader decompressing LZMA2 data from r. Reading stops
+// at the LZMA2 end marker so nothing after the compressed data is consumed.
+func NewReader2(r io.Reader, dictSize uint32) io.Reader {
+	return &reader{fn: func() ([]byte, error) {
+		return readLZMA2(r, dictSize)
+	}}
+}
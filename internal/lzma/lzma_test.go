@@ -0,0 +1,105 @@
+package lzma_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/wader/fq/internal/lzma"
+)
+
+func TestNewReader(t *testing.T) {
+	expected, err := os.ReadFile("testdata/test.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{
+		"testdata/test.txt.lzma",
+		"testdata/test_lc0_lp2.txt.lzma",
+	} {
+		name := name
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// legacy .lzma header: properties, dictionary size and uncompressed size
+		props, err := lzma.PropertiesFromByte(b[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		dictSize := binary.LittleEndian.Uint32(b[1:5])
+
+		for _, unpackSize := range []int64{-1, int64(len(expected))} {
+			unpackSize := unpackSize
+			t.Run(name, func(t *testing.T) {
+				actual, err := io.ReadAll(lzma.NewReader(bytes.NewReader(b[13:]), props, dictSize, unpackSize))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(expected, actual) {
+					t.Errorf("expected %q got %q", expected, actual)
+				}
+			})
+		}
+	}
+}
+
+func TestNewReaderCorrupted(t *testing.T) {
+	b, err := os.ReadFile("testdata/test.txt.lzma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	props, _ := lzma.PropertiesFromByte(b[0])
+	truncated := b[13 : len(b)-10]
+	if _, err := io.ReadAll(lzma.NewReader(bytes.NewReader(truncated), props, 1<<16, -1)); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestNewReader2(t *testing.T) {
+	expected, err := os.ReadFile("testdata/test.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// raw LZMA2 stream with end marker and trailing data that should not be consumed
+	b, err := os.ReadFile("testdata/test.txt.lzma2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(append(b, "trailing"...))
+	actual, err := io.ReadAll(lzma.NewReader2(r, 1<<16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("expected %q got %q", expected, actual)
+	}
+	if r.Len() != len("trailing") {
+		t.Errorf("expected %d bytes left got %d", len("trailing"), r.Len())
+	}
+}
+
+func TestDictSizeFromLZMA2Byte(t *testing.T) {
+	for _, tc := range []struct {
+		b        byte
+		expected uint32
+	}{
+		{0, 4 << 10},
+		{1, 6 << 10},
+		{18, 2 << 20},
+		{19, 3 << 20},
+		{40, 0xffff_ffff},
+	} {
+		actual, err := lzma.DictSizeFromLZMA2Byte(tc.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != tc.expected {
+			t.Errorf("%d: expected %d got %d", tc.b, tc.expected, actual)
+		}
+	}
+}
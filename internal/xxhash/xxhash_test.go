@@ -0,0 +1,41 @@
+package xxhash_test
+
+import (
+	"testing"
+
+	"github.com/wader/fq/internal/xxhash"
+)
+
+func TestSum32(t *testing.T) {
+	for _, tc := range []struct {
+		s        string
+		expected uint32
+	}{
+		{"", 0x02cc5d05},
+		{"a", 0x550d7456},
+		{"abc", 0x32d153ff},
+		{"message digest", 0x7c948494},
+		{"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", 0x794b91c3},
+	} {
+		if actual := xxhash.Sum32([]byte(tc.s), 0); actual != tc.expected {
+			t.Errorf("%q: expected %x got %x", tc.s, tc.expected, actual)
+		}
+	}
+}
+
+func TestSum64(t *testing.T) {
+	for _, tc := range []struct {
+		s        string
+		expected uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"message digest", 0x066ed728fceeb3be},
+		{"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", 0xfd5e2ce9520872dd},
+	} {
+		if actual := xxhash.Sum64([]byte(tc.s), 0); actual != tc.expected {
+			t.Errorf("%q: expected %x got %x", tc.s, tc.expected, actual)
+		}
+	}
+}
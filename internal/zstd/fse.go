@@ -0,0 +1,405 @@
+package zstd
+
+// Bit readers, FSE and Huffman tables
+// https://www.rfc-editor.org/rfc/rfc8878#section-4
+
+import (
+	"math/bits"
+)
+
+// forwardBitReader reads bits least significant first, used for FSE table descriptions
+type forwardBitReader struct {
+	data []byte
+	pos  int // in bits
+}
+
+func (r *forwardBitReader) peek(nb int) uint32 {
+	var v uint32
+	for i := 0; i < nb; i++ {
+		p := r.pos + i
+		if p>>3 >= len(r.data) {
+			break
+		}
+		v |= uint32(r.data[p>>3]>>(p&7)&1) << i
+	}
+	return v
+}
+
+func (r *forwardBitReader) skip(nb int) error {
+	r.pos += nb
+	if r.pos > len(r.data)*8 {
+		return ErrCorrupted
+	}
+	return nil
+}
+
+func (r *forwardBitReader) read(nb int) (uint32, error) {
+	v := r.peek(nb)
+	return v, r.skip(nb)
+}
+
+// bytesUsed is number of whole bytes used, last byte might be partially used
+func (r *forwardBitReader) bytesUsed() int { return (r.pos + 7) / 8 }
+
+// reverseBitReader reads bits from the end of a bitstream, last byte has a
+// 1 bit marking where the stream starts
+type reverseBitReader struct {
+	data []byte
+	pos  int // next byte to load is data[pos-1]
+	bits uint64
+	n    uint
+}
+
+func newReverseBitReader(data []byte) (*reverseBitReader, error) {
+	if len(data) == 0 || data[len(data)-1] == 0 {
+		return nil, ErrCorrupted
+	}
+	last := data[len(data)-1]
+	n := uint(7 - bits.LeadingZeros8(last))
+	return &reverseBitReader{
+		data: data,
+		pos:  len(data) - 1,
+		bits: uint64(last) & (1<<n - 1),
+		n:    n,
+	}, nil
+}
+
+func (r *reverseBitReader) fill(nb uint) {
+	for r.n < nb && r.pos > 0 {
+		r.pos--
+		r.bits = r.bits<<8 | uint64(r.data[r.pos])
+		r.n += 8
+	}
+}
+
+// peek returns next nb bits, missing bits at the end of the stream are zero
+func (r *reverseBitReader) peek(nb uint) uint64 {
+	r.fill(nb)
+	if r.n >= nb {
+		return (r.bits >> (r.n - nb)) & (1<<nb - 1)
+	}
+	return (r.bits << (nb - r.n)) & (1<<nb - 1)
+}
+
+func (r *reverseBitReader) skip(nb uint) error {
+	r.fill(nb)
+	if r.n < nb {
+		return ErrCorrupted
+	}
+	r.n -= nb
+	r.bits &= 1<<r.n - 1
+	return nil
+}
+
+func (r *reverseBitReader) read(nb uint) (uint64, error) {
+	v := r.peek(nb)
+	return v, r.skip(nb)
+}
+
+func (r *reverseBitReader) bitsLeft() int { return int(r.n) + r.pos*8 }
+
+func (r *reverseBitReader) finished() bool { return r.bitsLeft() == 0 }
+
+type fseEntry struct {
+	sym    uint8
+	nbBits uint8
+	base   uint16
+}
+
+type fseTable struct {
+	accuracyLog int
+	entries     []fseEntry
+}
+
+// readFSEDistribution reads a FSE table description and returns normalized
+// counts, accuracy log and number of bytes used
+func readFSEDistribution(data []byte, maxSym int, maxLog int) ([]int16, int, int, error) {
+	r := &forwardBitReader{data: data}
+	v, err := r.read(4)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	accuracyLog := int(v) + 5
+	if accuracyLog > maxLog {
+		return nil, 0, 0, ErrCorrupted
+	}
+
+	remaining := 1<<accuracyLog + 1
+	threshold := 1 << accuracyLog
+	nbBits := accuracyLog + 1
+	var norm []int16
+	prev0 := false
+	for remaining > 1 && len(norm) <= maxSym {
+		if prev0 {
+			// 2 bit repeat flags for more zero probabilities, 3 means another flag follows
+			for {
+				rep, err := r.read(2)
+				if err != nil {
+					return nil, 0, 0, err
+				}
+				for i := 0; i < int(rep); i++ {
+					norm = append(norm, 0)
+				}
+				if rep != 3 {
+					break
+				}
+			}
+			if len(norm) > maxSym+1 {
+				return nil, 0, 0, ErrCorrupted
+			}
+			prev0 = false
+			continue
+		}
+
+		max := 2*threshold - 1 - remaining
+		var count int
+		if low := int(r.peek(nbBits - 1)); low < max {
+			count = low
+			if err := r.skip(nbBits - 1); err != nil {
+				return nil, 0, 0, err
+			}
+		} else {
+			count = int(r.peek(nbBits))
+			if count >= threshold {
+				count -= max
+			}
+			if err := r.skip(nbBits); err != nil {
+				return nil, 0, 0, err
+			}
+		}
+		// -1 is a "less than 1" probability
+		count--
+		if count < 0 {
+			remaining--
+		} else {
+			remaining -= count
+		}
+		norm = append(norm, int16(count))
+		prev0 = count == 0
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if remaining != 1 || len(norm) > maxSym+1 {
+		return nil, 0, 0, ErrCorrupted
+	}
+
+	return norm, accuracyLog, r.bytesUsed(), nil
+}
+
+func buildFSETable(norm []int16, accuracyLog int) (fseTable, error) {
+	size := 1 << accuracyLog
+	entries := make([]fseEntry, size)
+	next := make([]int, len(norm))
+
+	high := size - 1
+	for s, n := range norm {
+		if n == -1 {
+			entries[high].sym = uint8(s)
+			high--
+			next[s] = 1
+		} else {
+			next[s] = int(n)
+		}
+	}
+
+	pos := 0
+	step := size>>1 + size>>3 + 3
+	mask := size - 1
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			entries[pos].sym = uint8(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+	if pos != 0 {
+		return fseTable{}, ErrCorrupted
+	}
+
+	for i := range entries {
+		s := entries[i].sym
+		x := next[s]
+		next[s]++
+		if x == 0 {
+			return fseTable{}, ErrCorrupted
+		}
+		nb := accuracyLog - (bits.Len(uint(x)) - 1)
+		entries[i].nbBits = uint8(nb)
+		entries[i].base = uint16(x<<nb - size)
+	}
+
+	return fseTable{accuracyLog: accuracyLog, entries: entries}, nil
+}
+
+// readFSETable reads and builds a FSE table, returns table and number of bytes used
+func readFSETable(data []byte, maxSym int, maxLog int) (fseTable, int, error) {
+	norm, accuracyLog, n, err := readFSEDistribution(data, maxSym, maxLog)
+	if err != nil {
+		return fseTable{}, 0, err
+	}
+	t, err := buildFSETable(norm, accuracyLog)
+	return t, n, err
+}
+
+const maxHuffmanBits = 11
+
+type huffmanEntry struct {
+	sym    uint8
+	nbBits uint8
+}
+
+type huffmanTable struct {
+	maxBits int
+	entries []huffmanEntry
+}
+
+// readHuffmanWeights reads huffman tree description, returns weights for all
+// but the last symbol and number of bytes used
+func readHuffmanWeights(data []byte) ([]uint8, int, error) {
+	if len(data) < 1 {
+		return nil, 0, ErrCorrupted
+	}
+	header := int(data[0])
+
+	if header >= 128 {
+		// 4 bit weights directly
+		count := header - 127
+		n := 1 + (count+1)/2
+		if len(data) < n {
+			return nil, 0, ErrCorrupted
+		}
+		weights := make([]uint8, count)
+		for i := range weights {
+			b := data[1+i/2]
+			if i&1 == 0 {
+				weights[i] = b >> 4
+			} else {
+				weights[i] = b & 0xf
+			}
+		}
+		return weights, n, nil
+	}
+
+	// weights compressed using FSE with two interleaved states
+	n := 1 + header
+	if len(data) < n {
+		return nil, 0, ErrCorrupted
+	}
+	compressed := data[1:n]
+	t, tn, err := readFSETable(compressed, 255, 6)
+	if err != nil {
+		return nil, 0, err
+	}
+	br, err := newReverseBitReader(compressed[tn:])
+	if err != nil {
+		return nil, 0, err
+	}
+	readState := func() (int, error) {
+		v, err := br.read(uint(t.accuracyLog))
+		return int(v), err
+	}
+	state1, err := readState()
+	if err != nil {
+		return nil, 0, err
+	}
+	state2, err := readState()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var weights []uint8
+	states := [2]*int{&state1, &state2}
+	for i := 0; ; i++ {
+		s := states[i&1]
+		e := t.entries[*s]
+		weights = append(weights, e.sym)
+		if br.bitsLeft() < int(e.nbBits) {
+			// stream ended, last symbol is from the other state
+			weights = append(weights, t.entries[*states[(i+1)&1]].sym)
+			break
+		}
+		v, _ := br.read(uint(e.nbBits))
+		*s = int(e.base) + int(v)
+		if len(weights) > 255 {
+			return nil, 0, ErrCorrupted
+		}
+	}
+	if len(weights) > 255 {
+		return nil, 0, ErrCorrupted
+	}
+
+	return weights, n, nil
+}
+
+func buildHuffmanTable(weights []uint8) (huffmanTable, error) {
+	total := 0
+	for _, w := range weights {
+		if w > maxHuffmanBits {
+			return huffmanTable{}, ErrCorrupted
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 {
+		return huffmanTable{}, ErrCorrupted
+	}
+	// last weight is implied by total being a power of two
+	maxBits := bits.Len(uint(total))
+	if maxBits > maxHuffmanBits {
+		return huffmanTable{}, ErrCorrupted
+	}
+	left := 1<<maxBits - total
+	if left&(left-1) != 0 {
+		return huffmanTable{}, ErrCorrupted
+	}
+	weights = append(append([]uint8{}, weights...), uint8(bits.Len(uint(left))))
+
+	// codes are assigned by increasing weight and then symbol
+	var rankStart [maxHuffmanBits + 2]int
+	next := 0
+	for w := 1; w <= maxBits; w++ {
+		rankStart[w] = next
+		for _, sw := range weights {
+			if int(sw) == w {
+				next += 1 << (w - 1)
+			}
+		}
+	}
+
+	entries := make([]huffmanEntry, 1<<maxBits)
+	for s, w := range weights {
+		if w == 0 {
+			continue
+		}
+		e := huffmanEntry{sym: uint8(s), nbBits: uint8(maxBits + 1 - int(w))}
+		for i := 0; i < 1<<(w-1); i++ {
+			entries[rankStart[w]+i] = e
+		}
+		rankStart[w] += 1 << (w - 1)
+	}
+
+	return huffmanTable{maxBits: maxBits, entries: entries}, nil
+}
+
+func (t huffmanTable) decodeStream(data []byte, n int, out []byte) ([]byte, error) {
+	br, err := newReverseBitReader(data)
+	if err != nil {
+		return out, err
+	}
+	for i := 0; i < n; i++ {
+		e := t.entries[br.peek(uint(t.maxBits))]
+		if err := br.skip(uint(e.nbBits)); err != nil {
+			return out, err
+		}
+		out = append(out, e.sym)
+	}
+	if !br.finished() {
+		return out, ErrCorrupted
+	}
+	return out, nil
+}
@@ -0,0 +1,598 @@
+// Package zstd implements a Zstandard decoder
+//
+// https://www.rfc-editor.org/rfc/rfc8878
+//
+// Whole frame output is kept in memory so the window is just the output
+// buffer. Blocks are decoded one at a time so that callers can inspect
+// each block, see Decoder. Frame headers are parsed by the caller.
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var ErrCorrupted = errors.New("zstd: corrupted data")
+var ErrTooLarge = errors.New("zstd: output larger than maximum size")
+
+const (
+	FrameMagic         = 0xfd2f_b528
+	SkippableMagicMask = 0xffff_fff0
+	SkippableMagic     = 0x184d_2a50
+
+	MaxBlockSize = 128 * 1024
+	// MaxOutputSize limits output of a frame, a RLE block is a few bytes but can expand to MaxBlockSize
+	MaxOutputSize = 64 * 1024 * 1024
+)
+
+// Block types
+const (
+	BlockRaw        = 0
+	BlockRLE        = 1
+	BlockCompressed = 2
+	BlockReserved   = 3
+)
+
+// Literals block types
+const (
+	LiteralsRaw        = 0
+	LiteralsRLE        = 1
+	LiteralsCompressed = 2
+	LiteralsTreeless   = 3
+)
+
+// Sequence symbol compression modes
+const (
+	ModePredefined = 0
+	ModeRLE        = 1
+	ModeFSE        = 2
+	ModeRepeat     = 3
+)
+
+// Sequence symbol kinds, index into Sequences.Modes and Sequences.TableSizes
+const (
+	LiteralLengths = 0
+	Offsets        = 1
+	MatchLengths   = 2
+)
+
+// LiteralsInfo describes a literals section
+type LiteralsInfo struct {
+	Type            int
+	HeaderSize      int
+	RegeneratedSize int
+	CompressedSize  int // size after header, includes huffman tree and jump table
+	Streams         int
+	HuffmanTreeSize int
+}
+
+// SequencesInfo describes a sequences section
+type SequencesInfo struct {
+	HeaderSize    int // number of sequences and modes byte
+	Count         int
+	Modes         [3]int
+	TableSizes    [3]int
+	BitstreamSize int
+}
+
+// BlockInfo describes a decoded compressed block
+type BlockInfo struct {
+	Literals     LiteralsInfo
+	Sequences    SequencesInfo
+	LiteralBytes int // bytes copied from literals
+	MatchBytes   int // bytes copied from earlier output
+	MaxOffset    int
+}
+
+type baselineBits struct {
+	baseline uint32
+	bits     uint8
+}
+
+var literalLengthCodes = func() []baselineBits {
+	bs := make([]baselineBits, 36)
+	for i := 0; i < 16; i++ {
+		bs[i] = baselineBits{uint32(i), 0}
+	}
+	copy(bs[16:], []baselineBits{
+		{16, 1}, {18, 1}, {20, 1}, {22, 1}, {24, 2}, {28, 2}, {32, 3}, {40, 3},
+		{48, 4}, {64, 6}, {128, 7}, {256, 8}, {512, 9}, {1024, 10}, {2048, 11}, {4096, 12},
+		{8192, 13}, {16384, 14}, {32768, 15}, {65536, 16},
+	})
+	return bs
+}()
+
+var matchLengthCodes = func() []baselineBits {
+	bs := make([]baselineBits, 53)
+	for i := 0; i < 32; i++ {
+		bs[i] = baselineBits{uint32(i + 3), 0}
+	}
+	copy(bs[32:], []baselineBits{
+		{35, 1}, {37, 1}, {39, 1}, {41, 1}, {43, 2}, {47, 2}, {51, 3}, {59, 3},
+		{67, 4}, {83, 4}, {99, 5}, {131, 7}, {259, 8}, {515, 9}, {1027, 10}, {2051, 11},
+		{4099, 12}, {8195, 13}, {16387, 14}, {32771, 15}, {65539, 16},
+	})
+	return bs
+}()
+
+type sequenceKind struct {
+	maxSym     int
+	maxLog     int
+	predefined fseTable
+}
+
+func mustBuildFSETable(norm []int16, accuracyLog int) fseTable {
+	t, err := buildFSETable(norm, accuracyLog)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// predefined distributions from RFC 8878 3.1.1.3.2.2
+var sequenceKinds = [3]sequenceKind{
+	LiteralLengths: {
+		maxSym: 35,
+		maxLog: 9,
+		predefined: mustBuildFSETable([]int16{
+			4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+			2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+			-1, -1, -1, -1,
+		}, 6),
+	},
+	Offsets: {
+		maxSym: 31,
+		maxLog: 8,
+		predefined: mustBuildFSETable([]int16{
+			1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+			1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+		}, 5),
+	},
+	MatchLengths: {
+		maxSym: 52,
+		maxLog: 9,
+		predefined: mustBuildFSETable([]int16{
+			1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+			1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+			1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+			-1, -1, -1, -1, -1,
+		}, 6),
+	},
+}
+
+// Decoder decodes the blocks of one frame. Output from earlier blocks is used
+// as window and tables and repeat offsets are kept between blocks.
+type Decoder struct {
+	out           []byte
+	repeatOffsets [3]int
+	huffman       *huffmanTable
+	tables        [3]*fseTable
+}
+
+// NewDecoder returns a decoder for a new frame.
+func NewDecoder() *Decoder {
+	return &Decoder{repeatOffsets: [3]int{1, 4, 8}}
+}
+
+// Bytes returns all output so far.
+func (d *Decoder) Bytes() []byte { return d.out }
+
+// RawBlock appends a raw block.
+func (d *Decoder) RawBlock(b []byte) error {
+	if err := d.checkSize(len(d.out), len(b)); err != nil {
+		return err
+	}
+	d.out = append(d.out, b...)
+	return nil
+}
+
+// RLEBlock appends a RLE block, b repeated size times.
+func (d *Decoder) RLEBlock(b byte, size int) error {
+	if err := d.checkSize(len(d.out), size); err != nil {
+		return err
+	}
+	for i := 0; i < size; i++ {
+		d.out = append(d.out, b)
+	}
+	return nil
+}
+
+// checkSize checks that n more bytes fits in the block starting at blockStart and in the output
+func (d *Decoder) checkSize(blockStart int, n int) error {
+	if len(d.out)-blockStart+n > MaxBlockSize {
+		return ErrCorrupted
+	}
+	if len(d.out)+n > MaxOutputSize {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// CompressedBlock decodes and appends a compressed block. Info decoded
+// so far is returned also on error.
+func (d *Decoder) CompressedBlock(b []byte) (BlockInfo, error) {
+	var bi BlockInfo
+
+	literals, n, err := d.decodeLiterals(b, &bi.Literals)
+	if err != nil {
+		return bi, err
+	}
+	b = b[n:]
+	n, err = d.decodeSequencesHeader(b, &bi.Sequences)
+	if err != nil {
+		return bi, err
+	}
+	b = b[n:]
+	bi.Sequences.BitstreamSize = len(b)
+
+	if bi.Sequences.Count == 0 {
+		if len(b) != 0 {
+			return bi, ErrCorrupted
+		}
+		if err := d.checkSize(len(d.out), len(literals)); err != nil {
+			return bi, err
+		}
+		d.out = append(d.out, literals...)
+		bi.LiteralBytes = len(literals)
+		return bi, nil
+	}
+	if err := d.executeSequences(b, literals, &bi); err != nil {
+		return bi, err
+	}
+
+	return bi, nil
+}
+
+func (d *Decoder) decodeLiterals(b []byte, li *LiteralsInfo) ([]byte, int, error) {
+	if len(b) < 1 {
+		return nil, 0, ErrCorrupted
+	}
+	li.Type = int(b[0] & 0x3)
+	sizeFormat := (b[0] >> 2) & 0x3
+
+	switch li.Type {
+	case LiteralsRaw, LiteralsRLE:
+		switch sizeFormat {
+		case 0, 2:
+			li.HeaderSize = 1
+		case 1:
+			li.HeaderSize = 2
+		case 3:
+			li.HeaderSize = 3
+		}
+		if len(b) < li.HeaderSize {
+			return nil, 0, ErrCorrupted
+		}
+		h := leUint(b[:li.HeaderSize])
+		if li.HeaderSize == 1 {
+			li.RegeneratedSize = int(h >> 3)
+		} else {
+			li.RegeneratedSize = int(h >> 4)
+		}
+		b = b[li.HeaderSize:]
+
+		if li.Type == LiteralsRaw {
+			li.CompressedSize = li.RegeneratedSize
+			if len(b) < li.RegeneratedSize {
+				return nil, 0, ErrCorrupted
+			}
+			return b[:li.RegeneratedSize], li.HeaderSize + li.RegeneratedSize, nil
+		}
+		li.CompressedSize = 1
+		if len(b) < 1 {
+			return nil, 0, ErrCorrupted
+		}
+		literals := make([]byte, li.RegeneratedSize)
+		for i := range literals {
+			literals[i] = b[0]
+		}
+		return literals, li.HeaderSize + 1, nil
+	}
+
+	var sizeBits uint
+	switch sizeFormat {
+	case 0, 1:
+		li.HeaderSize = 3
+		sizeBits = 10
+	case 2:
+		li.HeaderSize = 4
+		sizeBits = 14
+	case 3:
+		li.HeaderSize = 5
+		sizeBits = 18
+	}
+	li.Streams = 4
+	if sizeFormat == 0 {
+		li.Streams = 1
+	}
+	if len(b) < li.HeaderSize {
+		return nil, 0, ErrCorrupted
+	}
+	h := leUint(b[:li.HeaderSize])
+	li.RegeneratedSize = int((h >> 4) & (1<<sizeBits - 1))
+	li.CompressedSize = int((h >> (4 + sizeBits)) & (1<<sizeBits - 1))
+	if li.RegeneratedSize > MaxBlockSize {
+		return nil, 0, ErrCorrupted
+	}
+	b = b[li.HeaderSize:]
+	if len(b) < li.CompressedSize {
+		return nil, 0, ErrCorrupted
+	}
+	b = b[:li.CompressedSize]
+
+	if li.Type == LiteralsCompressed {
+		weights, n, err := readHuffmanWeights(b)
+		if err != nil {
+			return nil, 0, err
+		}
+		t, err := buildHuffmanTable(weights)
+		if err != nil {
+			return nil, 0, err
+		}
+		d.huffman = &t
+		li.HuffmanTreeSize = n
+		b = b[n:]
+	} else if d.huffman == nil {
+		return nil, 0, ErrCorrupted
+	}
+
+	literals := make([]byte, 0, li.RegeneratedSize)
+	if li.Streams == 1 {
+		var err error
+		if literals, err = d.huffman.decodeStream(b, li.RegeneratedSize, literals); err != nil {
+			return nil, 0, err
+		}
+	} else {
+		streams, err := splitStreams(b)
+		if err != nil {
+			return nil, 0, err
+		}
+		segmentSize := (li.RegeneratedSize + 3) / 4
+		for i, s := range streams {
+			n := segmentSize
+			if i == 3 {
+				n = li.RegeneratedSize - 3*segmentSize
+			}
+			if n < 0 {
+				return nil, 0, ErrCorrupted
+			}
+			if literals, err = d.huffman.decodeStream(s, n, literals); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	return literals, li.HeaderSize + li.CompressedSize, nil
+}
+
+// splitStreams uses the 6 byte jump table to split into 4 huffman streams
+func splitStreams(b []byte) ([4][]byte, error) {
+	var streams [4][]byte
+	if len(b) < 6 {
+		return streams, ErrCorrupted
+	}
+	sizes := [3]int{
+		int(binary.LittleEndian.Uint16(b[0:])),
+		int(binary.LittleEndian.Uint16(b[2:])),
+		int(binary.LittleEndian.Uint16(b[4:])),
+	}
+	b = b[6:]
+	for i, s := range sizes {
+		if len(b) < s {
+			return streams, ErrCorrupted
+		}
+		streams[i] = b[:s]
+		b = b[s:]
+	}
+	streams[3] = b
+	return streams, nil
+}
+
+func (d *Decoder) decodeSequencesHeader(b []byte, si *SequencesInfo) (int, error) {
+	if len(b) < 1 {
+		return 0, ErrCorrupted
+	}
+	switch {
+	case b[0] == 0:
+		si.HeaderSize = 1
+		return 1, nil
+	case b[0] < 128:
+		si.Count = int(b[0])
+		si.HeaderSize = 1
+	case b[0] < 255:
+		if len(b) < 2 {
+			return 0, ErrCorrupted
+		}
+		si.Count = int(b[0]-128)<<8 + int(b[1])
+		si.HeaderSize = 2
+	default:
+		if len(b) < 3 {
+			return 0, ErrCorrupted
+		}
+		si.Count = int(binary.LittleEndian.Uint16(b[1:])) + 0x7f00
+		si.HeaderSize = 3
+	}
+	if len(b) < si.HeaderSize+1 {
+		return 0, ErrCorrupted
+	}
+	modes := b[si.HeaderSize]
+	si.HeaderSize++
+	if modes&0x3 != 0 {
+		return 0, ErrCorrupted
+	}
+	si.Modes = [3]int{
+		LiteralLengths: int(modes>>6) & 0x3,
+		Offsets:        int(modes>>4) & 0x3,
+		MatchLengths:   int(modes>>2) & 0x3,
+	}
+
+	n := si.HeaderSize
+	for kind, mode := range si.Modes {
+		sk := &sequenceKinds[kind]
+		switch mode {
+		case ModePredefined:
+			d.tables[kind] = &sk.predefined
+		case ModeRLE:
+			if len(b) < n+1 {
+				return 0, ErrCorrupted
+			}
+			if int(b[n]) > sk.maxSym {
+				return 0, ErrCorrupted
+			}
+			d.tables[kind] = &fseTable{entries: []fseEntry{{sym: b[n]}}}
+			si.TableSizes[kind] = 1
+		case ModeFSE:
+			t, tn, err := readFSETable(b[n:], sk.maxSym, sk.maxLog)
+			if err != nil {
+				return 0, err
+			}
+			d.tables[kind] = &t
+			si.TableSizes[kind] = tn
+		case ModeRepeat:
+			if d.tables[kind] == nil {
+				return 0, ErrCorrupted
+			}
+		}
+		n += si.TableSizes[kind]
+	}
+
+	return n, nil
+}
+
+func (d *Decoder) executeSequences(b []byte, literals []byte, bi *BlockInfo) error {
+	start := len(d.out)
+	br, err := newReverseBitReader(b)
+	if err != nil {
+		return err
+	}
+	llT, ofT, mlT := d.tables[LiteralLengths], d.tables[Offsets], d.tables[MatchLengths]
+	readState := func(t *fseTable) (int, error) {
+		v, err := br.read(uint(t.accuracyLog))
+		return int(v), err
+	}
+	llState, err := readState(llT)
+	if err != nil {
+		return err
+	}
+	ofState, err := readState(ofT)
+	if err != nil {
+		return err
+	}
+	mlState, err := readState(mlT)
+	if err != nil {
+		return err
+	}
+
+	readBaseline := func(bb baselineBits) (int, error) {
+		v, err := br.read(uint(bb.bits))
+		return int(bb.baseline) + int(v), err
+	}
+	updateState := func(t *fseTable, state *int) error {
+		e := t.entries[*state]
+		v, err := br.read(uint(e.nbBits))
+		*state = int(e.base) + int(v)
+		return err
+	}
+
+	for i := 0; i < bi.Sequences.Count; i++ {
+		ofCode := ofT.entries[ofState].sym
+		mlCode := mlT.entries[mlState].sym
+		llCode := llT.entries[llState].sym
+		if ofCode > 31 || int(mlCode) >= len(matchLengthCodes) || int(llCode) >= len(literalLengthCodes) {
+			return ErrCorrupted
+		}
+
+		offsetValue, err := readBaseline(baselineBits{baseline: 1 << ofCode, bits: ofCode})
+		if err != nil {
+			return err
+		}
+		matchLength, err := readBaseline(matchLengthCodes[mlCode])
+		if err != nil {
+			return err
+		}
+		literalLength, err := readBaseline(literalLengthCodes[llCode])
+		if err != nil {
+			return err
+		}
+
+		var offset int
+		ro := &d.repeatOffsets
+		if offsetValue > 3 {
+			offset = offsetValue - 3
+			ro[2], ro[1], ro[0] = ro[1], ro[0], offset
+		} else {
+			idx := offsetValue
+			if literalLength == 0 {
+				idx++
+			}
+			switch idx {
+			case 1:
+				offset = ro[0]
+			case 2:
+				offset = ro[1]
+				ro[1], ro[0] = ro[0], offset
+			case 3:
+				offset = ro[2]
+				ro[2], ro[1], ro[0] = ro[1], ro[0], offset
+			case 4:
+				offset = ro[0] - 1
+				ro[2], ro[1], ro[0] = ro[1], ro[0], offset
+			}
+		}
+
+		if i < bi.Sequences.Count-1 {
+			if err := updateState(llT, &llState); err != nil {
+				return err
+			}
+			if err := updateState(mlT, &mlState); err != nil {
+				return err
+			}
+			if err := updateState(ofT, &ofState); err != nil {
+				return err
+			}
+		}
+
+		if literalLength > len(literals) {
+			return ErrCorrupted
+		}
+		// check before appending as a block can have many sequences with long matches
+		if err := d.checkSize(start, literalLength+matchLength); err != nil {
+			return err
+		}
+		d.out = append(d.out, literals[:literalLength]...)
+		literals = literals[literalLength:]
+		bi.LiteralBytes += literalLength
+
+		if offset <= 0 || offset > len(d.out) {
+			return ErrCorrupted
+		}
+		// byte by byte as match can overlap with itself
+		from := len(d.out) - offset
+		for j := 0; j < matchLength; j++ {
+			d.out = append(d.out, d.out[from+j])
+		}
+		bi.MatchBytes += matchLength
+		if offset > bi.MaxOffset {
+			bi.MaxOffset = offset
+		}
+	}
+	if !br.finished() {
+		return ErrCorrupted
+	}
+
+	if err := d.checkSize(start, len(literals)); err != nil {
+		return err
+	}
+	d.out = append(d.out, literals...)
+	bi.LiteralBytes += len(literals)
+
+	return nil
+}
+
+func leUint(b []byte) uint64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v
+}
@@ -0,0 +1,58 @@
+package zstd_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/wader/fq/internal/zstd"
+)
+
+func TestBlocks(t *testing.T) {
+	d := zstd.NewDecoder()
+	if err := d.RawBlock([]byte("ab")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RLEBlock('c', 3); err != nil {
+		t.Fatal(err)
+	}
+	// RLE literals "dd" and no sequences
+	if _, err := d.CompressedBlock([]byte{0x11, 'd', 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := []byte("abcccdd"), d.Bytes(); !bytes.Equal(expected, actual) {
+		t.Errorf("expected %q got %q", expected, actual)
+	}
+}
+
+func TestCompressedBlockMaxBlockSize(t *testing.T) {
+	b := []byte{
+		// RLE literals, regenerated size 2
+		0x11, 'a',
+		// 2 sequences, all RLE modes with literal length code 1, offset code 0 (repeat offset)
+		// and match length code 52 (65539 + 16 bits)
+		0x02, 0x54, 1, 0, 52,
+		// all extra bits zero and padding marker
+		0, 0, 0, 0, 0x01,
+	}
+
+	d := zstd.NewDecoder()
+	if _, err := d.CompressedBlock(b); !errors.Is(err, zstd.ErrCorrupted) {
+		t.Fatalf("expected %v got %v", zstd.ErrCorrupted, err)
+	}
+	if n := len(d.Bytes()); n > zstd.MaxBlockSize {
+		t.Errorf("expected at most %d bytes got %d", zstd.MaxBlockSize, n)
+	}
+}
+
+func TestRLEBlockMaxOutputSize(t *testing.T) {
+	d := zstd.NewDecoder()
+	for i := 0; i < zstd.MaxOutputSize/zstd.MaxBlockSize; i++ {
+		if err := d.RLEBlock(0, zstd.MaxBlockSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.RLEBlock(0, 1); !errors.Is(err, zstd.ErrTooLarge) {
+		t.Fatalf("expected %v got %v", zstd.ErrTooLarge, err)
+	}
+}
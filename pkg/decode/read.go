@@ -262,8 +262,9 @@ func (d *D) tryULEB128() (uint64, error) {
 
 	for {
 		b := d.U8()
-		if shift >= 63 && b != 0 {
-			return 0, fmt.Errorf("overflow when reading unsigned leb128, shift %d >= 63", shift)
+		// 10th byte can only have the lowest bit of a 64 bit value
+		if (shift == 63 && b > 1) || (shift > 63 && b != 0) {
+			return 0, fmt.Errorf("overflow when reading unsigned leb128, value does not fit in 64 bits at shift %d", shift)
 		}
 		result |= (b & 0x7f) << shift
 		if b&0x80 == 0 {
@@ -288,7 +289,7 @@ func (d *D) trySLEB128() (int64, error) {
 	for {
 		b = byte(d.U8())
 		if shift == 63 && b != 0 && b != 0x7f {
-			return 0, fmt.Errorf("overflow when reading signed leb128, shift %d >= 63", shift)
+			return 0, fmt.Errorf("overflow when reading signed leb128, value does not fit in 64 bits at shift %d", shift)
 		}
 
 		result |= int64(b&0x7f) << shift
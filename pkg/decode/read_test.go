@@ -0,0 +1,79 @@
+package decode_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+)
+
+func decodeBytes(bs []byte, fn func(d *decode.D) any) (any, error) {
+	_, v, err := decode.Decode(context.Background(), bitio.NewBitReader(bs, -1), decode.FormatFn(fn), decode.Options{})
+	return v, err
+}
+
+func TestULEB128(t *testing.T) {
+	testCases := []struct {
+		bs          []byte
+		expected    uint64
+		expectedErr bool
+	}{
+		{bs: []byte{0x00}, expected: 0},
+		{bs: []byte{0x7f}, expected: 127},
+		{bs: []byte{0xe5, 0x8e, 0x26}, expected: 624485},
+		{bs: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}, expected: 1<<63 - 1},
+		// 10th byte can only have the lowest bit set
+		{bs: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, expected: 1<<64 - 1},
+		{bs: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02}, expectedErr: true},
+		{bs: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x81, 0x00}, expectedErr: true},
+	}
+	for _, tC := range testCases {
+		v, err := decodeBytes(tC.bs, func(d *decode.D) any { return d.ULEB128() })
+		if tC.expectedErr {
+			if err == nil {
+				t.Errorf("%x: expected error, got %v", tC.bs, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%x: %s", tC.bs, err)
+			continue
+		}
+		if v != tC.expected {
+			t.Errorf("%x: expected %d, got %d", tC.bs, tC.expected, v)
+		}
+	}
+}
+
+func TestSLEB128(t *testing.T) {
+	testCases := []struct {
+		bs          []byte
+		expected    int64
+		expectedErr bool
+	}{
+		{bs: []byte{0x00}, expected: 0},
+		{bs: []byte{0x3f}, expected: 63},
+		{bs: []byte{0x40}, expected: -64},
+		{bs: []byte{0xc0, 0xbb, 0x78}, expected: -123456},
+		{bs: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00}, expected: 1<<63 - 1},
+		{bs: []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x7f}, expected: -1 << 63},
+		{bs: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, expectedErr: true},
+	}
+	for _, tC := range testCases {
+		v, err := decodeBytes(tC.bs, func(d *decode.D) any { return d.SLEB128() })
+		if tC.expectedErr {
+			if err == nil {
+				t.Errorf("%x: expected error, got %v", tC.bs, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%x: %s", tC.bs, err)
+			continue
+		}
+		if v != tC.expected {
+			t.Errorf("%x: expected %d, got %d", tC.bs, tC.expected, v)
+		}
+	}
+}